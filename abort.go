@@ -0,0 +1,65 @@
+package gatewayfile
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// contextReader fails reads as soon as its context is done, so a download stops reading its source
+// when the client disconnects, instead of reading one more chunk the failed Send would discard.
+type contextReader struct {
+	ctx     context.Context
+	content io.ReadSeeker
+}
+
+func newContextReader(ctx context.Context, content io.ReadSeeker) io.ReadSeeker {
+	if ctx.Done() == nil {
+		// never canceled.
+		return content
+	}
+	return &contextReader{ctx: ctx, content: content}
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.content.Read(p)
+}
+
+func (r *contextReader) Seek(offset int64, whence int) (int64, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.content.Seek(offset, whence)
+}
+
+// closeOnDone closes content as soon as ctx is done, to unblock a pending read of a slow source
+// and free its resources (connections to an object store...) without waiting for the read to return.
+// The returned closer closes content once, and stops watching ctx; it must be called when serving returns.
+func closeOnDone(ctx context.Context, content io.Closer) io.Closer {
+	c := &onceCloser{closer: content}
+	stop := context.AfterFunc(ctx, func() { _ = c.Close() })
+	return closerFunc(func() error {
+		stop()
+		return c.Close()
+	})
+}
+
+type onceCloser struct {
+	once   sync.Once
+	closer io.Closer
+	err    error
+}
+
+func (c *onceCloser) Close() error {
+	c.once.Do(func() { c.err = c.closer.Close() })
+	return c.err
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
@@ -0,0 +1,195 @@
+package gatewayfile
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// TransferDirection is the direction of a transfer.
+type TransferDirection string
+
+const (
+	TransferUpload   TransferDirection = "upload"   // TransferUpload - the client sends a file
+	TransferDownload TransferDirection = "download" // TransferDownload - the client receives a file
+)
+
+// TransferRecord describes a finished upload or download.
+type TransferRecord struct {
+	Direction TransferDirection
+	Method    string        // Method is the full gRPC method name, e.g. "/pkg.Service/Download".
+	Object    string        // Object names what was transferred, e.g. the file name(s).
+	Bytes     int64         // Bytes is the number of body bytes transferred.
+	Start     time.Time     // Start is the time the transfer started.
+	Duration  time.Duration // Duration of the transfer.
+	Status    int           // Status is the HTTP status code of the response, 0 if it's unknown.
+	Err       error         // Err is the error of the transfer, nil on success.
+	ClientIP  string        // ClientIP is the client address, from X-Forwarded-For if the gateway forwarded it.
+	RequestID string        // RequestID correlates the transfer across systems, see RequestID.
+}
+
+// AuditEvent is a structured audit record of a finished transfer, suitable for SIEM ingestion.
+type AuditEvent struct {
+	TransferRecord
+	Principal string // Principal is who made the transfer, see Auditor.Principal.
+}
+
+// Auditor emits an AuditEvent on every upload/download completion or failure, see WithAudit and WithUploadAudit.
+// It's separate from debug logging on purpose: every transfer produces exactly one event.
+type Auditor struct {
+	// Hook receives the events, it must not block for long.
+	Hook func(ctx context.Context, event AuditEvent)
+	// Principal returns who makes the request, e.g. from the authenticated user in ctx. May be nil.
+	Principal func(ctx context.Context) string
+}
+
+func (a *Auditor) emit(ctx context.Context, record TransferRecord) {
+	event := AuditEvent{TransferRecord: record}
+	if a.Principal != nil {
+		event.Principal = a.Principal(ctx)
+	}
+	a.Hook(ctx, event)
+}
+
+// WithAudit emits an audit event when the download finishes, see Auditor.
+func WithAudit(auditor *Auditor) ServeOption {
+	return func(o *serveOptions) {
+		o.onFinish = append(o.onFinish, auditor.emit)
+	}
+}
+
+// WithUploadAudit emits an audit event when the upload finishes, see Auditor.
+func WithUploadAudit(auditor *Auditor) FormDataOption {
+	return func(o *formDataOptions) {
+		o.onFinish = append(o.onFinish, auditor.emit)
+	}
+}
+
+// clientIP returns the client address of the request.
+func clientIP(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if forwarded := pick(md, "x-forwarded-for"); forwarded != "" {
+		ip, _, _ := strings.Cut(forwarded, ",")
+		return strings.TrimSpace(ip)
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return host
+		}
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// transferRecorder records the status code, the header and the body size sent through a downloadServer.
+type transferRecorder struct {
+	downloadServer
+	start  time.Time
+	status int
+	header metadata.MD // header is the header sent, nil until it's sent.
+	bytes  atomic.Int64
+}
+
+func newTransferRecorder(server downloadServer) *transferRecorder {
+	return &transferRecorder{downloadServer: server, start: time.Now()}
+}
+
+func (r *transferRecorder) SendHeader(md metadata.MD) error {
+	if code, err := strconv.Atoi(pick(md, headerCode)); err == nil {
+		r.status = code
+	} else {
+		r.status = http.StatusOK
+	}
+	r.header = md
+	return r.downloadServer.SendHeader(md)
+}
+
+func (r *transferRecorder) Send(body *httpbody.HttpBody) error {
+	if err := r.downloadServer.Send(body); err != nil {
+		return err
+	}
+	r.bytes.Add(int64(len(body.GetData())))
+	return nil
+}
+
+func (r *transferRecorder) record(direction TransferDirection, object string, err error) TransferRecord {
+	ctx := r.Context()
+	method, _ := grpc.Method(ctx)
+	return TransferRecord{
+		Direction: direction,
+		Method:    method,
+		Object:    object,
+		Bytes:     r.bytes.Load(),
+		Start:     r.start,
+		Duration:  time.Since(r.start),
+		Status:    r.status,
+		Err:       err,
+		ClientIP:  clientIP(ctx),
+		RequestID: RequestID(ctx),
+	}
+}
+
+// uploadRecorder records the body size read from an uploadServer.
+type uploadRecorder struct {
+	reader io.Reader
+	start  time.Time
+	bytes  int64
+}
+
+func (r *uploadRecorder) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+func (r *uploadRecorder) record(ctx context.Context, object string, err error) TransferRecord {
+	method, _ := grpc.Method(ctx)
+	record := TransferRecord{
+		Direction: TransferUpload,
+		Method:    method,
+		Object:    object,
+		Bytes:     r.bytes,
+		Start:     r.start,
+		Duration:  time.Since(r.start),
+		Status:    http.StatusOK,
+		Err:       err,
+		ClientIP:  clientIP(ctx),
+		RequestID: RequestID(ctx),
+	}
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrSizeLimitExceeded):
+		record.Status = http.StatusRequestEntityTooLarge
+	default:
+		record.Status = http.StatusBadRequest
+	}
+	return record
+}
+
+// formFileNames returns the comma separated file names of the form.
+func formFileNames(form *multipart.Form) string {
+	if form == nil {
+		return ""
+	}
+	var names []string
+	for _, headers := range form.File {
+		for _, header := range headers {
+			names = append(names, header.Filename)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
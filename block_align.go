@@ -0,0 +1,51 @@
+package gatewayfile
+
+import (
+	"strconv"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// headerXBlockSize is the block size the served ranges are aligned to, see WithBlockAlignment.
+const headerXBlockSize = "x-block-size"
+
+// WithBlockAlignment aligns the served ranges to blocks of blockSize bytes: the start of each range is rounded
+// down and its end is rounded up to a block boundary, or to the end of the content. Backends storing contents in
+// blocks, e.g. erasure-coded or encrypted chunk stores, then serve ranges without partial block reads.
+// The Content-Range header tells the client the range actually served, and X-Block-Size the block size.
+// Overlapping ranges are merged once aligned.
+func WithBlockAlignment(blockSize int64) ServeOption {
+	return func(o *serveOptions) {
+		o.blockSize = max(blockSize, 0)
+	}
+}
+
+func setBlockSize(outgoing metadata.MD, blockSize int64) {
+	if blockSize > 0 {
+		outgoing.Set(headerXBlockSize, strconv.FormatInt(blockSize, 10))
+	}
+}
+
+// alignRanges aligns ranges to blockSize within a content of size bytes, and merges the ranges which overlap
+// the previous one once aligned.
+func alignRanges(ranges []httpRange, blockSize, size int64) []httpRange {
+	if blockSize <= 0 {
+		return ranges
+	}
+	aligned := make([]httpRange, 0, len(ranges))
+	for _, ra := range ranges {
+		start := ra.start / blockSize * blockSize
+		end := min((ra.start+ra.length+blockSize-1)/blockSize*blockSize, size)
+		if n := len(aligned); n > 0 {
+			prev := &aligned[n-1]
+			if start <= prev.start+prev.length && end >= prev.start {
+				prevEnd := prev.start + prev.length
+				prev.start = min(prev.start, start)
+				prev.length = max(prevEnd, end) - prev.start
+				continue
+			}
+		}
+		aligned = append(aligned, httpRange{start: start, length: end - start})
+	}
+	return aligned
+}
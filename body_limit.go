@@ -0,0 +1,142 @@
+package gatewayfile
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+)
+
+// ErrRequestBodyTooLarge is returned when a request body is larger than the limit of WithMaxRequestBodySize.
+// It's answered with 413 Content Too Large, see HTTPErrorHandler.
+var ErrRequestBodyTooLarge = newError(codes.ResourceExhausted, "REQUEST_BODY_TOO_LARGE", "request body too large")
+
+// WithMaxRequestBodySize returns a ServeMuxOption which limits the request bodies to n bytes in the gateway,
+// before they're decoded into messages of the gRPC stream, like http.MaxBytesReader.
+// A request whose Content-Length is larger is answered with 413 without calling the backend at all.
+// A chunked request is canceled once it goes past n bytes, so the backend sees a canceled upload rather than
+// a truncated one, and it's answered with 413 unless the response already started.
+// Config.MaxRequestBodySize installs it.
+func WithMaxRequestBodySize(n int64) runtime.ServeMuxOption {
+	return func(mux *runtime.ServeMux) {
+		if n <= 0 {
+			return
+		}
+		runtime.WithMiddlewares(func(next runtime.HandlerFunc) runtime.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+				limitRequestBody(mux, next, n, w, r, pathParams)
+			}
+		})(mux)
+	}
+}
+
+func limitRequestBody(
+	mux *runtime.ServeMux, next runtime.HandlerFunc, n int64,
+	w http.ResponseWriter, r *http.Request, pathParams map[string]string,
+) {
+	if r.ContentLength > n {
+		tooLarge(mux, w, r)
+		return
+	}
+	if r.Body == nil || r.Body == http.NoBody {
+		next(w, r, pathParams)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	response := &bodyLimitWriter{ResponseWriter: w, header: w.Header().Clone()}
+	r = r.WithContext(ctx)
+	r.Body = &bodyLimitReader{ReadCloser: http.MaxBytesReader(w, r.Body, n), exceeded: func() {
+		response.exceed()
+		cancel()
+	}}
+	next(response, r, pathParams)
+	if response.discarding() {
+		// the error of the canceled request was discarded.
+		clear(w.Header())
+		for key, values := range response.header {
+			w.Header()[key] = values
+		}
+		tooLarge(mux, w, r)
+	}
+}
+
+// tooLarge answers ErrRequestBodyTooLarge with the error handler of mux, with 413 whatever the error handler.
+func tooLarge(mux *runtime.ServeMux, w http.ResponseWriter, r *http.Request) {
+	_, marshaler := runtime.MarshalerForRequest(mux, r)
+	w = &statusWriter{ResponseWriter: w, code: http.StatusRequestEntityTooLarge}
+	// the error handlers expect the metadata of the gRPC response, there is none.
+	ctx := runtime.NewServerMetadataContext(r.Context(), runtime.ServerMetadata{})
+	runtime.HTTPError(ctx, mux, marshaler, w, r, ErrRequestBodyTooLarge)
+}
+
+// bodyLimitReader calls exceeded once its body went past the limit of http.MaxBytesReader.
+type bodyLimitReader struct {
+	io.ReadCloser
+	exceeded func()
+	done     bool
+}
+
+func (r *bodyLimitReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) && !r.done {
+		r.done = true
+		r.exceeded()
+	}
+	return n, err
+}
+
+// bodyLimitWriter discards what is written once the request body went past the limit,
+// so the request is answered with 413 rather than the error of its cancellation.
+// The body is read by the goroutine sending the messages of the stream, concurrently with the handler.
+type bodyLimitWriter struct {
+	http.ResponseWriter
+	header http.Header // header is the header before the handler, restored before answering 413.
+
+	mu       sync.Mutex
+	wrote    bool // wrote reports whether the status code or the body was written before the limit.
+	exceeded bool
+}
+
+// exceed starts discarding what is written, unless the response already started.
+func (w *bodyLimitWriter) exceed() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.exceeded = !w.wrote
+}
+
+func (w *bodyLimitWriter) discarding() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.exceeded
+}
+
+func (w *bodyLimitWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.exceeded {
+		return
+	}
+	w.wrote = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *bodyLimitWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.exceeded {
+		return len(p), nil
+	}
+	w.wrote = true
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *bodyLimitWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,125 @@
+package gatewayfile
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"time"
+)
+
+// bookmarkKeyPrefix prefixes the Store keys of download bookmarks.
+const bookmarkKeyPrefix = "download-bookmark:"
+
+// Bookmark is the progress of a client downloading an object, see Bookmarks.
+type Bookmark struct {
+	Object string `json:"object"`
+	// Offset is the number of leading bytes of the object the client confirmed receiving,
+	// a download resumes with the range "bytes=<Offset>-".
+	Offset int64 `json:"offset"`
+	// Size and ETag identify the version of the object, the bookmark is stale once they changed.
+	Size    int64     `json:"size"`
+	ETag    string    `json:"etag,omitempty"`
+	Updated time.Time `json:"updated"`
+}
+
+// Complete reports whether the whole object was received.
+func (b Bookmark) Complete() bool {
+	return b.Offset >= b.Size
+}
+
+// Bookmarks records the download progress of each client in a Store, so a client can ask where it left off,
+// e.g. to offer "resume where you left off" even after losing its partial file. Only the bytes the gateway
+// handed to the client are counted, leading bytes served by ranges extend the progress only if they're
+// contiguous to it. Compressed responses are counted once complete. Concurrent downloads of the same object
+// by the same client are best effort, the last to finish wins.
+type Bookmarks struct {
+	store Store
+	ttl   time.Duration
+}
+
+// NewBookmarks returns Bookmarks kept in store, each one expires ttl after its last update (0 = never).
+func NewBookmarks(store Store, ttl time.Duration) *Bookmarks {
+	return &Bookmarks{store: store, ttl: ttl}
+}
+
+// ServeOption records the progress of client downloading object, once the body was sent or failed.
+// client identifies the client, e.g. an authenticated user ID, object identifies the object, e.g. its path.
+// Multi-range responses are not recorded.
+func (b *Bookmarks) ServeOption(client, object string) ServeOption {
+	return func(o *serveOptions) {
+		var start, length, size int64 = -1, 0, 0
+		o.onRanges = append(o.onRanges, func(ranges []httpRange, contentSize int64) {
+			switch len(ranges) {
+			case 0:
+				start, length, size = 0, contentSize, contentSize
+			case 1:
+				start, length, size = ranges[0].start, ranges[0].length, contentSize
+			}
+		})
+		o.onDone = append(o.onDone, func(server downloadServer, err error) {
+			recorder, ok := server.(*transferRecorder)
+			if !ok || start < 0 {
+				return
+			}
+			received := length
+			if err != nil {
+				if pick(recorder.header, headerContentEncoding) != "" {
+					return
+				}
+				received = min(recorder.bytes.Load(), length)
+			}
+			// the client is likely gone, the bookmark is recorded anyway.
+			ctx := context.WithoutCancel(server.Context())
+			_ = b.advance(ctx, client, Bookmark{
+				Object:  object,
+				Offset:  start + received,
+				Size:    size,
+				ETag:    pick(recorder.header, headerETag),
+				Updated: time.Now(),
+			}, start)
+		})
+	}
+}
+
+// advance records bookmark, whose bytes were received from start. It only extends a bookmark of the same version
+// if the bytes are contiguous to it.
+func (b *Bookmarks) advance(ctx context.Context, client string, bookmark Bookmark, start int64) error {
+	last, ok, err := b.Get(ctx, client, bookmark.Object)
+	if err != nil {
+		return err
+	}
+	if ok && last.Size == bookmark.Size && last.ETag == bookmark.ETag {
+		if start > last.Offset || bookmark.Offset <= last.Offset {
+			return nil
+		}
+	} else if start > 0 {
+		return nil
+	}
+	data, err := json.Marshal(bookmark)
+	if err != nil {
+		return err
+	}
+	return b.store.Set(ctx, b.key(client, bookmark.Object), data, b.ttl)
+}
+
+// Get returns the bookmark of client for object, ok is false if it never downloaded it or the bookmark expired.
+// Compare its Size and ETag to the current ones of the object before resuming.
+func (b *Bookmarks) Get(ctx context.Context, client, object string) (bookmark Bookmark, ok bool, err error) {
+	data, ok, err := b.store.Get(ctx, b.key(client, object))
+	if err != nil || !ok {
+		return bookmark, false, err
+	}
+	if err = json.Unmarshal(data, &bookmark); err != nil {
+		return bookmark, false, err
+	}
+	return bookmark, true, nil
+}
+
+// Delete deletes the bookmark of client for object, e.g. once the client saved the object.
+func (b *Bookmarks) Delete(ctx context.Context, client, object string) error {
+	return b.store.Delete(ctx, b.key(client, object))
+}
+
+func (b *Bookmarks) key(client, object string) string {
+	return bookmarkKeyPrefix + url.PathEscape(client) + "/" + url.PathEscape(object)
+}
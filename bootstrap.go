@@ -0,0 +1,176 @@
+package gatewayfile
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+)
+
+// defaultMaxMsgSize is the default maximum size of the messages between the gateway and the gRPC server.
+// The gateway sends HttpBody messages of up to 1 MB, the downloads send chunks of the chunk size.
+const defaultMaxMsgSize = 16 << 20 // 16 MB
+
+// BootstrapConfig is the configuration of Bootstrap.
+type BootstrapConfig struct {
+	// SocketPath is the path of the unix socket connecting the gateway to the gRPC server,
+	// a stale socket file is removed. The gateway and the gRPC server are connected by an in-memory pipe if empty.
+	SocketPath string
+	// Mux is the configuration of the gateway mux, see NewServeMux.
+	Mux Config
+	// MaxMsgSize is the maximum size of the messages between the gateway and the gRPC server, defaults to 16 MB.
+	MaxMsgSize int
+	// ServerOptions are extra options of the gRPC server, applied after the defaults.
+	ServerOptions []grpc.ServerOption
+	// DialOptions are extra options of the gateway connection, applied after the defaults.
+	DialOptions []grpc.DialOption
+	// MuxOptions are extra options of the gateway mux.
+	MuxOptions []runtime.ServeMuxOption
+}
+
+// Gateway is a gRPC server and the gateway mux connected to it, see Bootstrap.
+type Gateway struct {
+	// Server is the gRPC server, services are registered on it before Serve.
+	Server *grpc.Server
+	// Mux is the gateway mux, the handlers are registered on it with Conn.
+	Mux *runtime.ServeMux
+	// Conn is the connection of the gateway to Server.
+	Conn *grpc.ClientConn
+
+	listener   net.Listener
+	socketPath string
+}
+
+// Bootstrap sets up a gRPC server and a gateway mux connected to it over a unix socket or an in-memory pipe,
+// with defaults tuned for file transfer: larger messages, and keepalives which detect a dead peer
+// without the server rejecting the pings of an idle gateway. It replaces the boilerplate of a gateway in the
+// same process as its gRPC service:
+//
+//	gw, err := gatewayfile.Bootstrap(gatewayfile.BootstrapConfig{})
+//	pb.RegisterServiceServer(gw.Server, &Service{})
+//	err = pb.RegisterServiceHandler(ctx, gw.Mux, gw.Conn)
+//	go gw.Serve()
+//	defer gw.Close()
+//	http.ListenAndServe(":8080", gw.Mux)
+func Bootstrap(cfg BootstrapConfig) (*Gateway, error) {
+	maxMsgSize := cfg.MaxMsgSize
+	if maxMsgSize <= 0 {
+		maxMsgSize = defaultMaxMsgSize
+	}
+
+	var listener net.Listener
+	var target string
+	dialOpts := []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxMsgSize), grpc.MaxCallSendMsgSize(maxMsgSize)),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                30 * time.Second,
+			Timeout:             10 * time.Second,
+			PermitWithoutStream: true,
+		}),
+	}
+	if cfg.SocketPath != "" {
+		if err := os.Remove(cfg.SocketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		var err error
+		if listener, err = net.Listen("unix", cfg.SocketPath); err != nil {
+			return nil, err
+		}
+		target = "unix://" + cfg.SocketPath
+	} else {
+		pipe := newPipeListener()
+		listener, target = pipe, "passthrough:///pipe"
+		dialOpts = append(dialOpts, grpc.WithContextDialer(pipe.dial))
+	}
+
+	conn, err := grpc.NewClient(target, append(dialOpts, cfg.DialOptions...)...)
+	if err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	serverOpts := append([]grpc.ServerOption{
+		grpc.MaxRecvMsgSize(maxMsgSize),
+		grpc.MaxSendMsgSize(maxMsgSize),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             15 * time.Second,
+			PermitWithoutStream: true,
+		}),
+	}, cfg.ServerOptions...)
+	return &Gateway{
+		Server:     grpc.NewServer(serverOpts...),
+		Mux:        NewServeMux(cfg.Mux, cfg.MuxOptions...),
+		Conn:       conn,
+		listener:   listener,
+		socketPath: cfg.SocketPath,
+	}, nil
+}
+
+// Serve serves the gRPC server until Close, once the services are registered.
+func (g *Gateway) Serve() error {
+	return g.Server.Serve(g.listener)
+}
+
+// Close closes the connection of the gateway, stops the gRPC server and removes the unix socket.
+func (g *Gateway) Close() error {
+	err := g.Conn.Close()
+	g.Server.Stop()
+	_ = g.listener.Close()
+	if g.socketPath != "" {
+		_ = os.Remove(g.socketPath)
+	}
+	return err
+}
+
+// pipeListener is a net.Listener of in-memory connections, see net.Pipe.
+type pipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *pipeListener) dial(ctx context.Context, _ string) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
@@ -0,0 +1,210 @@
+package gatewayfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// ErrCircuitOpen is returned by the providers and sinks wrapped by a CircuitBreaker while it's open.
+// Its gRPC status carries a RetryInfo detail, so it's answered with 503 and a Retry-After header,
+// see HTTPErrorHandler.
+var ErrCircuitOpen = newError(codes.Unavailable, "CIRCUIT_OPEN", "storage unavailable")
+
+const (
+	defaultBreakerThreshold   = 5
+	defaultBreakerOpenTimeout = 30 * time.Second
+)
+
+// BreakerConfig is the configuration of a CircuitBreaker.
+type BreakerConfig struct {
+	// Threshold is the number of consecutive failures which opens the circuit, defaults to 5.
+	Threshold int
+	// OpenTimeout is how long the circuit stays open before a probe is let through, defaults to 30s.
+	// It's the Retry-After of the rejected requests.
+	OpenTimeout time.Duration
+	// IsFailure reports whether an error is a failure of the backend, defaults to any error
+	// but context cancellation and fs.ErrNotExist. The other errors neither count as failures nor close the circuit,
+	// only a success does.
+	IsFailure func(err error) bool
+}
+
+// CircuitBreaker fails fast with ErrCircuitOpen once a storage backend failed Threshold times in a row,
+// instead of tying up streams in long timeouts. After OpenTimeout, it's half-open: one probe call is let through,
+// which closes the circuit if it succeeds, or opens it again if it fails. If it ends with an error which is not
+// a failure, e.g. it's canceled, the next call is the probe. It's safe for concurrent use.
+// Use it with BreakerProvider and BreakerSink.
+type CircuitBreaker struct {
+	cfg BreakerConfig
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time // zero if closed
+	probing  bool      // a probe is in flight
+}
+
+// NewCircuitBreaker returns a new CircuitBreaker.
+func NewCircuitBreaker(cfg BreakerConfig) *CircuitBreaker {
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = defaultBreakerThreshold
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = defaultBreakerOpenTimeout
+	}
+	if cfg.IsFailure == nil {
+		cfg.IsFailure = func(err error) bool {
+			return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) &&
+				!errors.Is(err, fs.ErrNotExist)
+		}
+	}
+	return &CircuitBreaker{cfg: cfg}
+}
+
+// Do calls f if the circuit allows it, and records its outcome. It returns ErrCircuitOpen without calling f
+// if the circuit is open. A panic of f is recorded as a failure.
+func (b *CircuitBreaker) Do(f func() error) (err error) {
+	if err = b.allow(); err != nil {
+		return err
+	}
+	success, failure := false, true // unless f returns, i.e. it panics.
+	defer func() { b.record(success, failure) }()
+	err = f()
+	success, failure = err == nil, err != nil && b.cfg.IsFailure(err)
+	return err
+}
+
+// Open reports whether the circuit is open, i.e. calls are rejected.
+func (b *CircuitBreaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.openedAt.IsZero() && (b.probing || time.Since(b.openedAt) < b.cfg.OpenTimeout)
+}
+
+func (b *CircuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openedAt.IsZero() {
+		return nil
+	}
+	if wait := b.cfg.OpenTimeout - time.Since(b.openedAt); wait > 0 || b.probing {
+		return &circuitOpenError{retryAfter: max(wait, time.Second)}
+	}
+	// half-open.
+	b.probing = true
+	return nil
+}
+
+// record records the outcome of a call: a success, a failure, or neither, e.g. an error of the client.
+func (b *CircuitBreaker) record(success, failure bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	probing := b.probing
+	b.probing = false
+	switch {
+	case success:
+		b.failures = 0
+		b.openedAt = time.Time{}
+	case !failure:
+		// e.g. the client went away, which proves nothing about the backend: the consecutive failures go on,
+		// or the next call probes again.
+	case probing:
+		b.openedAt = time.Now()
+	default:
+		if b.failures++; b.failures >= b.cfg.Threshold {
+			b.openedAt = time.Now()
+		}
+	}
+}
+
+// circuitOpenError is ErrCircuitOpen with the time until the next probe.
+type circuitOpenError struct {
+	retryAfter time.Duration
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrCircuitOpen.text, e.retryAfter.Round(time.Second))
+}
+
+func (e *circuitOpenError) Unwrap() error {
+	return ErrCircuitOpen
+}
+
+// GRPCStatus returns the status of ErrCircuitOpen with a RetryInfo detail.
+func (e *circuitOpenError) GRPCStatus() *status.Status {
+	st := ErrCircuitOpen.GRPCStatus()
+	if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(e.retryAfter)}); err == nil {
+		return detailed
+	}
+	return st
+}
+
+// BreakerProvider returns a ContentProvider which opens the content of provider through breaker.
+// ServeProvider answers 503 with a Retry-After header while the circuit is open.
+func BreakerProvider(provider ContentProvider, breaker *CircuitBreaker) ContentProvider {
+	return ContentProviderFunc(func(ctx context.Context) (content io.ReadSeekCloser, info ContentInfo, err error) {
+		err = breaker.Do(func() error {
+			content, info, err = provider.Open(ctx)
+			return err
+		})
+		return content, info, err
+	})
+}
+
+// BreakerSink returns a PartSink which creates the destinations of sink through breaker.
+func BreakerSink(sink PartSink, breaker *CircuitBreaker) PartSink {
+	return &breakerSink{sink: sink, breaker: breaker}
+}
+
+type breakerSink struct {
+	sink    PartSink
+	breaker *CircuitBreaker
+}
+
+func (s *breakerSink) Create(ctx context.Context, part *multipart.Part) (w io.WriteCloser, dst string, err error) {
+	err = s.breaker.Do(func() error {
+		w, dst, err = s.sink.Create(ctx, part)
+		return err
+	})
+	return w, dst, err
+}
+
+func (s *breakerSink) Remove(ctx context.Context, destination string) error {
+	return s.sink.Remove(ctx, destination)
+}
+
+// retryAfter returns the Retry-After header value of err in seconds, from its RetryInfo detail.
+func retryAfter(err error) (string, bool) {
+	st, ok := status.FromError(err)
+	if !ok {
+		return "", false
+	}
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok && info.GetRetryDelay() != nil {
+			seconds := (info.GetRetryDelay().AsDuration() + time.Second - 1) / time.Second
+			return strconv.FormatInt(int64(seconds), 10), true
+		}
+	}
+	return "", false
+}
+
+// serveCircuitOpen answers 503 with a Retry-After header to a download rejected by an open circuit.
+func serveCircuitOpen(server downloadServer, err error) error {
+	outgoing := make(metadata.MD)
+	if after, ok := retryAfter(err); ok {
+		outgoing.Set(headerRetryAfter, after)
+	}
+	return serveError(server, outgoing, err.Error(), http.StatusServiceUnavailable)
+}
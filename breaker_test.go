@@ -0,0 +1,96 @@
+package gatewayfile
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	errBackend := errors.New("backend down")
+	// outcomes of calls: nil, an error, or "wait" for the open timeout to elapse.
+	const wait = "wait"
+	tests := []struct {
+		name     string
+		calls    []any
+		wantOpen bool
+	}{
+		{name: "below threshold", calls: []any{errBackend, errBackend}},
+		{name: "threshold", calls: []any{errBackend, errBackend, errBackend}, wantOpen: true},
+		{name: "success resets", calls: []any{errBackend, errBackend, nil, errBackend, errBackend}},
+		{
+			name:     "cancellations don't reset",
+			calls:    []any{errBackend, context.Canceled, errBackend, context.Canceled, errBackend},
+			wantOpen: true,
+		},
+		{name: "not found is not a failure", calls: []any{fs.ErrNotExist, fs.ErrNotExist, fs.ErrNotExist}},
+		{name: "successful probe closes", calls: []any{errBackend, errBackend, errBackend, wait, nil}},
+		{
+			name:     "failed probe opens again",
+			calls:    []any{errBackend, errBackend, errBackend, wait, errBackend},
+			wantOpen: true,
+		},
+		{
+			name:  "canceled probe keeps it half-open",
+			calls: []any{errBackend, errBackend, errBackend, wait, context.Canceled, nil},
+		},
+		{
+			name:     "canceled probe doesn't close",
+			calls:    []any{errBackend, errBackend, errBackend, wait, context.Canceled, errBackend},
+			wantOpen: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewCircuitBreaker(BreakerConfig{Threshold: 3, OpenTimeout: 10 * time.Millisecond})
+			for i, call := range tt.calls {
+				if call == wait {
+					time.Sleep(15 * time.Millisecond)
+					continue
+				}
+				err, _ := call.(error)
+				if got := b.Do(func() error { return err }); !errors.Is(got, err) {
+					t.Fatalf("call %d: got %v, want %v", i, got, err)
+				}
+			}
+			if got := b.Open(); got != tt.wantOpen {
+				t.Fatalf("got open %v, want %v", got, tt.wantOpen)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerRejectsWhileOpen(t *testing.T) {
+	b := NewCircuitBreaker(BreakerConfig{Threshold: 1, OpenTimeout: time.Hour})
+	_ = b.Do(func() error { return errors.New("backend down") })
+	called := false
+	err := b.Do(func() error {
+		called = true
+		return nil
+	})
+	if called || !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got %v, called %v, want %v without call", err, called, ErrCircuitOpen)
+	}
+	if after, ok := retryAfter(err); !ok || after != "3600" {
+		t.Fatalf("got Retry-After %q, %v, want 3600", after, ok)
+	}
+}
+
+func TestCircuitBreakerPanicIsFailure(t *testing.T) {
+	b := NewCircuitBreaker(BreakerConfig{Threshold: 1, OpenTimeout: 10 * time.Millisecond})
+	_ = b.Do(func() error { return errors.New("backend down") })
+	time.Sleep(15 * time.Millisecond)
+	func() {
+		defer func() { _ = recover() }()
+		_ = b.Do(func() error { panic("probe") })
+	}()
+	if !b.Open() {
+		t.Fatal("the circuit is closed after a panicking probe")
+	}
+	time.Sleep(15 * time.Millisecond)
+	if err := b.Do(func() error { return nil }); err != nil || b.Open() {
+		t.Fatalf("got %v, open %v, want the next probe to close it", err, b.Open())
+	}
+}
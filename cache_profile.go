@@ -0,0 +1,74 @@
+package gatewayfile
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// CacheProfile describes how a response may be cached by browsers and CDNs.
+type CacheProfile struct {
+	Public    bool // the response may be stored by any cache
+	Private   bool // the response may be stored by the browser only
+	NoCache   bool // caches must revalidate before using a stored response
+	NoStore   bool // caches must not store the response
+	Immutable bool // the response will not change while it's fresh
+
+	MaxAge               time.Duration // max-age, 0 = not set
+	SMaxAge              time.Duration // s-maxage for shared caches, 0 = not set
+	StaleWhileRevalidate time.Duration // stale-while-revalidate, 0 = not set
+	StaleIfError         time.Duration // stale-if-error, 0 = not set
+
+	// SurrogateKeys are emitted as the Surrogate-Key header (space separated, e.g. Fastly),
+	// allowing the CDN to purge the response by key.
+	SurrogateKeys []string
+	// CacheTags are emitted as the Cache-Tag header (comma separated, e.g. Cloudflare, Akamai),
+	// allowing the CDN to purge the response by tag.
+	CacheTags []string
+}
+
+// CacheControl returns the Cache-Control header value of the profile.
+func (p CacheProfile) CacheControl() string {
+	var directives []string
+	add := func(ok bool, directive string) {
+		if ok {
+			directives = append(directives, directive)
+		}
+	}
+	addSeconds := func(d time.Duration, directive string) {
+		if d > 0 {
+			directives = append(directives, directive+"="+strconv.FormatInt(int64(d/time.Second), 10))
+		}
+	}
+	add(p.Public, "public")
+	add(p.Private, "private")
+	add(p.NoCache, "no-cache")
+	add(p.NoStore, "no-store")
+	addSeconds(p.MaxAge, "max-age")
+	addSeconds(p.SMaxAge, "s-maxage")
+	addSeconds(p.StaleWhileRevalidate, "stale-while-revalidate")
+	addSeconds(p.StaleIfError, "stale-if-error")
+	add(p.Immutable, "immutable")
+	return strings.Join(directives, ", ")
+}
+
+func (p CacheProfile) apply(outgoing metadata.MD) {
+	if cc := p.CacheControl(); cc != "" {
+		outgoing.Set(headerCacheControl, cc)
+	}
+	if len(p.SurrogateKeys) > 0 {
+		outgoing.Set(headerSurrogateKey, strings.Join(p.SurrogateKeys, " "))
+	}
+	if len(p.CacheTags) > 0 {
+		outgoing.Set(headerCacheTag, strings.Join(p.CacheTags, ","))
+	}
+}
+
+// WithCacheProfile emits the cache headers of the profile, see CacheProfile.
+func WithCacheProfile(profile CacheProfile) ServeOption {
+	return func(o *serveOptions) {
+		o.cacheProfile = &profile
+	}
+}
@@ -0,0 +1,100 @@
+package gatewayfile
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// ErrChecksumMismatch is returned when a content doesn't match the checksums declared for it,
+// e.g. by the x-goog-hash header of an upstream proxied by ServeURL.
+var ErrChecksumMismatch = newError(codes.DataLoss, "CHECKSUM_MISMATCH", "checksum mismatch")
+
+// WithChecksums emits the checksums of the whole content in the formats of the cloud SDKs:
+// "x-goog-hash: crc32c=...,md5=..." (GCS) and "x-amz-checksum-crc32c" (S3), so clients can verify downloads.
+// They're emitted for full responses only, i.e. not for partial or compressed responses.
+// Only the DigestCRC32C and DigestMD5 digests have a cloud header format, others are ignored.
+func WithChecksums(checksums Digests) ServeOption {
+	return func(o *serveOptions) {
+		o.checksums = checksums
+	}
+}
+
+func setChecksumHeaders(outgoing metadata.MD, checksums Digests) {
+	var googHash []string
+	if sum, ok := checksums[DigestCRC32C]; ok {
+		encoded := base64.StdEncoding.EncodeToString(sum)
+		googHash = append(googHash, "crc32c="+encoded)
+		outgoing.Set(headerAmzChecksumCRC32C, encoded)
+	}
+	if sum, ok := checksums[DigestMD5]; ok {
+		googHash = append(googHash, "md5="+base64.StdEncoding.EncodeToString(sum))
+	}
+	if len(googHash) > 0 {
+		outgoing.Set(headerGoogHash, strings.Join(googHash, ","))
+	}
+}
+
+// ParseChecksumHeaders parses the x-goog-hash and x-amz-checksum-crc32c headers of a GCS or S3 response.
+// Malformed values are ignored.
+func ParseChecksumHeaders(header http.Header) Digests {
+	checksums := make(Digests)
+	for _, value := range header.Values(headerGoogHash) {
+		for _, field := range strings.Split(value, ",") {
+			name, encoded, _ := strings.Cut(strings.TrimSpace(field), "=")
+			sum, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				continue
+			}
+			switch name {
+			case "crc32c":
+				checksums[DigestCRC32C] = sum
+			case "md5":
+				checksums[DigestMD5] = sum
+			}
+		}
+	}
+	if v := header.Get(headerAmzChecksumCRC32C); v != "" {
+		if sum, err := base64.StdEncoding.DecodeString(v); err == nil {
+			checksums[DigestCRC32C] = sum
+		}
+	}
+	return checksums
+}
+
+// checksumVerifier verifies the checksums of the content read through it,
+// it returns ErrChecksumMismatch instead of io.EOF if they don't match.
+type checksumVerifier struct {
+	*HashingReader
+	expected Digests
+}
+
+func newChecksumVerifier(r io.Reader, expected Digests) io.Reader {
+	if len(expected) == 0 {
+		return r
+	}
+	algorithms := make([]DigestAlgorithm, 0, len(expected))
+	for algorithm := range expected {
+		algorithms = append(algorithms, algorithm)
+	}
+	return &checksumVerifier{HashingReader: NewHashingReader(r, algorithms...), expected: expected}
+}
+
+func (v *checksumVerifier) Read(p []byte) (int, error) {
+	n, err := v.HashingReader.Read(p)
+	if err == io.EOF {
+		actual := v.Digests()
+		for algorithm, sum := range v.expected {
+			if !bytes.Equal(actual[algorithm], sum) {
+				return n, fmt.Errorf("%w: %s", ErrChecksumMismatch, algorithm)
+			}
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,232 @@
+package gatewayfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// The headers of the chunked upload protocol, see AppendChunk.
+const (
+	headerXUploadID     = "X-Upload-Id"
+	headerXUploadOffset = "X-Upload-Offset"
+	headerXUploadLength = "X-Upload-Length"
+	// headerUploadOffset is the committed offset in responses.
+	headerUploadOffset = "x-upload-offset"
+)
+
+var (
+	// ErrInvalidUpload is returned when the X-Upload-Id, X-Upload-Offset or X-Upload-Length header is invalid.
+	ErrInvalidUpload = newError(codes.InvalidArgument, "INVALID_UPLOAD", "invalid upload headers")
+	// ErrOffsetMismatch is returned when a chunk doesn't start at the committed offset of the upload,
+	// the client should query the offset (see ChunkUploadStatus) and resume from there.
+	// It's answered with 409 Conflict, see HTTPErrorHandler.
+	ErrOffsetMismatch = newError(codes.FailedPrecondition, "UPLOAD_OFFSET_MISMATCH", "upload offset mismatch")
+)
+
+// ChunkSink stores the uploads of the chunked upload protocol, see AppendChunk.
+// Implementations must be safe for concurrent use.
+type ChunkSink interface {
+	// Offset returns the committed offset of the upload, i.e. its size, 0 if it doesn't exist yet.
+	Offset(ctx context.Context, id string) (int64, error)
+	// Append appends the chunk r to the upload, which must be offset bytes long, or fails with ErrOffsetMismatch.
+	// It returns the committed offset after the append, which counts the bytes stored even on failure.
+	Append(ctx context.Context, id string, offset int64, r io.Reader) (int64, error)
+}
+
+// ChunkStatus is the state of a chunked upload.
+type ChunkStatus struct {
+	ID       string
+	Offset   int64 // Offset is the committed offset, where the next chunk starts.
+	Complete bool  // Complete reports whether Offset reached the X-Upload-Length of the request.
+}
+
+// AppendChunk implements a lightweight chunked upload protocol, a simpler alternative to tus:
+// each request carries a chunk of the upload X-Upload-Id starting at X-Upload-Offset, and optionally the total
+// X-Upload-Length. The chunk is appended to sink if it starts at the committed offset, otherwise it's rejected
+// with ErrOffsetMismatch. The committed offset is returned in the X-Upload-Offset response header,
+// a client resumes from it after a failure, or from the one reported by ChunkUploadStatus.
+// sizeLimit is the maximum size of a chunk in bytes (0 = unlimited).
+// The HTTPBody marshaler must be registered for the chunk Content-Type, e.g. MIMEOctetStream.
+func AppendChunk(server uploadServer, sink ChunkSink, sizeLimit int64, opts ...FormDataOption) (*ChunkStatus, error) {
+	ctx := server.Context()
+	o := newFormDataOptions(opts)
+	status, err := appendChunk(server, sink, sizeLimit, o)
+	var object string
+	if status != nil {
+		object = status.ID
+		_ = server.SetHeader(metadata.Pairs(headerUploadOffset, strconv.FormatInt(status.Offset, 10)))
+	}
+	o.finish(ctx, object, err)
+	if err != nil {
+		return status, withRequestID(ctx, fmt.Errorf("append chunk failed %w", err))
+	}
+	return status, nil
+}
+
+func appendChunk(server uploadServer, sink ChunkSink, sizeLimit int64, o *formDataOptions) (*ChunkStatus, error) {
+	ctx := server.Context()
+	md, _ := metadata.FromIncomingContext(ctx)
+	id, err := parseUploadID(md)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := strconv.ParseInt(pickHeader(md, headerXUploadOffset), 10, 64)
+	if err != nil || offset < 0 {
+		return nil, fmt.Errorf("%w: %s %q", ErrInvalidUpload, headerXUploadOffset, pickHeader(md, headerXUploadOffset))
+	}
+	length := int64(-1)
+	if value := pickHeader(md, headerXUploadLength); value != "" {
+		if length, err = strconv.ParseInt(value, 10, 64); err != nil || length < offset {
+			return nil, fmt.Errorf("%w: %s %q", ErrInvalidUpload, headerXUploadLength, value)
+		}
+	}
+
+	body := o.newReader(server, sizeLimit)
+	if length >= 0 {
+		body = &exactLimitReader{reader: body, remaining: length - offset}
+	}
+	committed, err := sink.Append(ctx, id, offset, body)
+	return &ChunkStatus{ID: id, Offset: committed, Complete: committed == length}, err
+}
+
+// ChunkUploadStatus reports the committed offset of the upload X-Upload-Id of the request,
+// in the returned status and the X-Upload-Offset response header, so a client can resume an upload.
+// If the request has a X-Upload-Length header, the status tells whether the upload is complete.
+func ChunkUploadStatus(ctx context.Context, sink ChunkSink) (*ChunkStatus, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	id, err := parseUploadID(md)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := sink.Offset(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.ParseInt(pickHeader(md, headerXUploadLength), 10, 64)
+	if err != nil {
+		length = -1
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs(headerUploadOffset, strconv.FormatInt(offset, 10)))
+	return &ChunkStatus{ID: id, Offset: offset, Complete: offset == length}, nil
+}
+
+// parseUploadID returns the X-Upload-Id header, which must be usable as a file name.
+func parseUploadID(md metadata.MD) (string, error) {
+	id := pickHeader(md, headerXUploadID)
+	if id == "" || len(id) > 128 || id == "." || id == ".." {
+		return "", fmt.Errorf("%w: %s %q", ErrInvalidUpload, headerXUploadID, id)
+	}
+	for _, c := range id {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return "", fmt.Errorf("%w: %s %q", ErrInvalidUpload, headerXUploadID, id)
+		}
+	}
+	return id, nil
+}
+
+// exactLimitReader reads at most remaining bytes, and fails with ErrSizeLimitExceeded if there are more.
+type exactLimitReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (r *exactLimitReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		var probe [1]byte
+		n, err := io.ReadFull(r.reader, probe[:])
+		if n > 0 {
+			return 0, ErrSizeLimitExceeded
+		}
+		return 0, err
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	return n, err
+}
+
+// DirChunkSink returns a ChunkSink which stores each upload in a file of dir named by its id.
+// Chunks of one upload are appended one at a time.
+func DirChunkSink(dir string) ChunkSink {
+	return &dirChunkSink{dir: filepath.Clean(dir), locks: make(map[string]*keyLock)}
+}
+
+type dirChunkSink struct {
+	dir string
+
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+func (s *dirChunkSink) Offset(_ context.Context, id string) (int64, error) {
+	info, err := os.Stat(filepath.Join(s.dir, id))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (s *dirChunkSink) Append(_ context.Context, id string, offset int64, r io.Reader) (int64, error) {
+	unlock := s.lock(id)
+	defer unlock()
+
+	file, err := os.OpenFile(filepath.Join(s.dir, id), os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = file.Close() }()
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() != offset {
+		return info.Size(), fmt.Errorf("%w: got %d, committed %d", ErrOffsetMismatch, offset, info.Size())
+	}
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+	n, err := io.Copy(file, r)
+	return offset + n, err
+}
+
+// lock locks the upload id, and returns its unlock function.
+func (s *dirChunkSink) lock(id string) func() {
+	s.mu.Lock()
+	l, ok := s.locks[id]
+	if !ok {
+		l = &keyLock{}
+		s.locks[id] = l
+	}
+	l.refs++
+	s.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		s.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(s.locks, id)
+		}
+		s.mu.Unlock()
+	}
+}
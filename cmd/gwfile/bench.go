@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// bench downloads the URL n times with c concurrent clients, and reports the latencies and the throughput.
+func bench(args []string) error {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	n := flags.Int("n", 100, "number of requests")
+	c := flags.Int("c", 10, "number of concurrent requests")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 || *n <= 0 || *c <= 0 {
+		return fmt.Errorf("bench needs an URL and positive -n and -c")
+	}
+	url := flags.Arg(0)
+
+	var (
+		mu        sync.Mutex
+		latencies []time.Duration
+		bytes     int64
+		failures  int
+		wg        sync.WaitGroup
+	)
+	requests := make(chan struct{}, *n)
+	for range *n {
+		requests <- struct{}{}
+	}
+	close(requests)
+
+	start := time.Now()
+	for range *c {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range requests {
+				begin := time.Now()
+				size, err := fetch(url)
+				elapsed := time.Since(begin)
+
+				mu.Lock()
+				if err != nil {
+					failures++
+				} else {
+					latencies = append(latencies, elapsed)
+					bytes += size
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	total := time.Since(start)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	fmt.Fprintf(os.Stdout, "requests: %d, failures: %d, duration: %s\n", *n, failures, total)
+	if len(latencies) > 0 {
+		fmt.Fprintf(os.Stdout, "latency p50: %s, p90: %s, p99: %s\n",
+			percentile(latencies, 50), percentile(latencies, 90), percentile(latencies, 99))
+	}
+	fmt.Fprintf(os.Stdout, "throughput: %.2f MB/s\n", float64(bytes)/total.Seconds()/(1<<20))
+	return nil
+}
+
+func fetch(url string) (int64, error) {
+	resp, err := http.Get(url) //nolint:gosec // the URL is the point of the tool.
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	n, err := io.Copy(io.Discard, resp.Body)
+	if err == nil && resp.StatusCode >= http.StatusBadRequest {
+		err = fmt.Errorf("%s", resp.Status)
+	}
+	return n, err
+}
+
+func percentile(sorted []time.Duration, p int) time.Duration {
+	return sorted[(len(sorted)-1)*p/100]
+}
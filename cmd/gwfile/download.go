@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+
+	gatewayfile "github.com/black-06/grpc-gateway-file"
+)
+
+// download fetches the URL, optionally a range of it or the rest of a partial file, and verifies the body.
+func download(args []string) error {
+	flags := flag.NewFlagSet("download", flag.ExitOnError)
+	output := flags.String("o", "", "output file, stdout if empty")
+	byteRange := flags.String("range", "", "Range header, e.g. bytes=0-99")
+	resume := flags.Bool("resume", false, "resume the output file from its size, with If-Range")
+	verify := flags.Bool("verify", false, "verify the x-goog-hash / x-amz-checksum-crc32c checksums of full responses")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("download needs an URL")
+	}
+	if *resume && *output == "" {
+		return fmt.Errorf("resume needs an output file")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, flags.Arg(0), nil)
+	if err != nil {
+		return err
+	}
+	if *byteRange != "" {
+		req.Header.Set("Range", *byteRange)
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		mode := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if *resume {
+			mode = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		}
+		if out, err = os.OpenFile(*output, mode, 0o644); err != nil {
+			return err
+		}
+		defer func() { _ = out.Close() }()
+	}
+	if *resume {
+		info, err := out.Stat()
+		if err != nil {
+			return err
+		}
+		if info.Size() > 0 {
+			req.Header.Set("Range", "bytes="+strconv.FormatInt(info.Size(), 10)+"-")
+			if etag := readETag(*output); etag != "" {
+				req.Header.Set("If-Range", etag)
+			}
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	fmt.Fprintln(os.Stderr, resp.Status)
+	switch {
+	case resp.StatusCode == http.StatusOK && *resume:
+		// the content changed or ranges are not supported, start over.
+		if err = out.Truncate(0); err != nil {
+			return err
+		}
+	case resp.StatusCode >= http.StatusBadRequest:
+		_, _ = io.Copy(os.Stderr, resp.Body)
+		return fmt.Errorf("download failed: %s", resp.Status)
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" && *output != "" {
+		_ = os.WriteFile(*output+".etag", []byte(etag), 0o644)
+	}
+
+	var body io.Reader = resp.Body
+	var hashing *gatewayfile.HashingReader
+	if *verify {
+		hashing = gatewayfile.NewHashingReader(resp.Body, gatewayfile.DigestCRC32C, gatewayfile.DigestMD5)
+		body = hashing
+	}
+	n, err := io.Copy(out, body)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%d bytes\n", n)
+
+	if msg := resp.Trailer.Get("X-Stream-Error"); msg != "" {
+		return fmt.Errorf("truncated after %s bytes: %s", resp.Trailer.Get("X-Stream-Truncated-Length"), msg)
+	}
+	if hashing != nil {
+		return verifyChecksums(resp, hashing.Digests())
+	}
+	return nil
+}
+
+// readETag reads the ETag saved next to a partial download.
+func readETag(output string) string {
+	etag, _ := os.ReadFile(output + ".etag")
+	return string(bytes.TrimSpace(etag))
+}
+
+func verifyChecksums(resp *http.Response, actual gatewayfile.Digests) error {
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("checksums describe the whole content, they can't verify a %s response", resp.Status)
+	}
+	expected := gatewayfile.ParseChecksumHeaders(resp.Header)
+	if len(expected) == 0 {
+		return fmt.Errorf("no checksum to verify")
+	}
+	for algorithm, sum := range expected {
+		if !bytes.Equal(actual[algorithm], sum) {
+			return fmt.Errorf("%s checksum mismatch", algorithm)
+		}
+		fmt.Fprintf(os.Stderr, "%s ok\n", algorithm)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+// Command gwfile exercises the file endpoints of a gRPC-Gateway, to test deployments and reproduce issues.
+//
+// Usage:
+//
+//	gwfile upload [-field file] [-F key=value]... URL FILE...
+//	gwfile download [-o FILE] [-range bytes=0-99] [-resume] [-verify] URL
+//	gwfile bench [-n 100] [-c 10] URL
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch os.Args[1] {
+	case "upload":
+		err = upload(os.Args[2:])
+	case "download":
+		err = download(os.Args[2:])
+	case "bench":
+		err = bench(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gwfile:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage:
+  gwfile upload [-field file] [-F key=value]... URL FILE...
+  gwfile download [-o FILE] [-range bytes=0-99] [-resume] [-verify] URL
+  gwfile bench [-n 100] [-c 10] URL`)
+	os.Exit(2)
+}
+
+// fields is a repeatable key=value flag.
+type fields map[string][]string
+
+func (f fields) String() string {
+	return fmt.Sprint(map[string][]string(f))
+}
+
+func (f fields) Set(value string) error {
+	key, v, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("invalid field %q, want key=value", value)
+	}
+	f[key] = append(f[key], v)
+	return nil
+}
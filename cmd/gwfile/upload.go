@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// upload streams the files as a multipart form, without buffering them in memory.
+func upload(args []string) error {
+	flags := flag.NewFlagSet("upload", flag.ExitOnError)
+	field := flags.String("field", "file", "form field of the files")
+	values := make(fields)
+	flags.Var(values, "F", "extra form value key=value, repeatable")
+	_ = flags.Parse(args)
+	if flags.NArg() < 2 {
+		return fmt.Errorf("upload needs an URL and at least one file")
+	}
+	url, files := flags.Arg(0), flags.Args()[1:]
+
+	pReader, pWriter := io.Pipe()
+	writer := multipart.NewWriter(pWriter)
+	go func() {
+		_ = pWriter.CloseWithError(writeForm(writer, *field, values, files))
+	}()
+
+	resp, err := http.Post(url, writer.FormDataContentType(), pReader) //nolint:gosec // the URL is the point of the tool.
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	fmt.Fprintln(os.Stderr, resp.Status)
+	_, err = io.Copy(os.Stdout, resp.Body)
+	if err == nil && resp.StatusCode >= http.StatusBadRequest {
+		err = fmt.Errorf("upload failed: %s", resp.Status)
+	}
+	return err
+}
+
+func writeForm(writer *multipart.Writer, field string, values fields, files []string) error {
+	for key, vs := range values {
+		for _, v := range vs {
+			if err := writer.WriteField(key, v); err != nil {
+				return err
+			}
+		}
+	}
+	for _, path := range files {
+		if err := writeFormFile(writer, field, path); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+func writeFormFile(writer *multipart.Writer, field, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	part, err := writer.CreateFormFile(field, filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, file)
+	return err
+}
@@ -0,0 +1,329 @@
+package gatewayfile
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	encodingGzip     = "gzip"
+	encodingDeflate  = "deflate"
+	encodingIdentity = "identity"
+
+	defaultCompressionMinSize    = 1 << 10  // 1 KB
+	defaultCompressionSampleSize = 64 << 10 // 64 KB
+)
+
+// ErrNotAcceptable is returned when the client accepts none of the supported content encodings,
+// and forbids the identity one, e.g. "Accept-Encoding: br, identity;q=0". It's answered with 406 Not Acceptable.
+var ErrNotAcceptable = newError(codes.InvalidArgument, "NOT_ACCEPTABLE", "no acceptable content encoding")
+
+// DefaultExcludedCompressionTypes are the content types which are already compressed.
+var DefaultExcludedCompressionTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"font/woff2",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/x-bzip2",
+	"application/x-xz",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/zstd",
+	"application/pdf",
+	"application/octet-stream",
+}
+
+// CompressionConfig configures on-the-fly compression of downloads, see WithCompression.
+// Range requests are never compressed, since ranges apply to the uncompressed content.
+type CompressionConfig struct {
+	// Level is the compression level, defaults to gzip.DefaultCompression.
+	Level int
+	// MinSize is the minimum content size in bytes to compress, defaults to 1 KB.
+	// Compressing smaller content rarely pays off.
+	MinSize int64
+	// ExcludedTypes are the content types which are never compressed, defaults to DefaultExcludedCompressionTypes.
+	// An entry ending with "/" matches the whole top-level type, e.g. "video/".
+	ExcludedTypes []string
+	// MaxRatio skips the compression of contents which don't compress well, e.g. already compressed data
+	// with a generic content type: the first SampleSize bytes are compressed, and the content is sent uncompressed
+	// if the compressed sample is larger than MaxRatio times the sample, e.g. 0.9. 0 disables sampling.
+	MaxRatio float64
+	// SampleSize is the size of the sample of MaxRatio, defaults to 64 KB.
+	SampleSize int64
+	// Stats collects compression metrics when not nil, so operators can verify compression pays off.
+	Stats *CompressionStats
+}
+
+// Reasons the compression of a response was skipped although the client accepts it, see CompressionStats.
+const (
+	CompressionSkippedSize  = "size"  // CompressionSkippedSize - the content is smaller than MinSize
+	CompressionSkippedType  = "type"  // CompressionSkippedType - the content type is excluded
+	CompressionSkippedRatio = "ratio" // CompressionSkippedRatio - the sample didn't compress well, see MaxRatio
+)
+
+// CompressionStats are the compression metrics of the responses served with a CompressionConfig.
+// It's safe for concurrent use.
+type CompressionStats struct {
+	mu        sync.Mutex
+	encodings map[string]EncodingStats
+	skipped   map[string]int64
+}
+
+// EncodingStats are the metrics of the responses compressed with an encoding.
+type EncodingStats struct {
+	Responses int64 // Responses is the number of compressed responses.
+	InBytes   int64 // InBytes is the size of the contents before compression.
+	OutBytes  int64 // OutBytes is the size of the contents after compression, of the responses fully sent.
+}
+
+// Ratio returns OutBytes/InBytes, lower is better. It's 0 if nothing was compressed.
+func (s EncodingStats) Ratio() float64 {
+	if s.InBytes == 0 {
+		return 0
+	}
+	return float64(s.OutBytes) / float64(s.InBytes)
+}
+
+// Encodings returns the metrics by encoding, e.g. "gzip".
+func (s *CompressionStats) Encodings() map[string]EncodingStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	encodings := make(map[string]EncodingStats, len(s.encodings))
+	for encoding, stats := range s.encodings {
+		encodings[encoding] = stats
+	}
+	return encodings
+}
+
+// Skipped returns the number of responses which were not compressed although the client accepts it,
+// by reason, e.g. CompressionSkippedRatio.
+func (s *CompressionStats) Skipped() map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	skipped := make(map[string]int64, len(s.skipped))
+	for reason, n := range s.skipped {
+		skipped[reason] = n
+	}
+	return skipped
+}
+
+func (s *CompressionStats) skip(reason string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.skipped == nil {
+		s.skipped = make(map[string]int64)
+	}
+	s.skipped[reason]++
+}
+
+func (s *CompressionStats) compressed(encoding string, in, out int64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.encodings == nil {
+		s.encodings = make(map[string]EncodingStats)
+	}
+	stats := s.encodings[encoding]
+	stats.Responses++
+	stats.InBytes += in
+	stats.OutBytes += out
+	s.encodings[encoding] = stats
+}
+
+// WithCompression compresses the content with gzip or deflate when the client accepts it.
+// Options are applied in order, so a route can override a shared compression option by appending its own
+// WithCompression or WithoutCompression.
+func WithCompression(cfg CompressionConfig) ServeOption {
+	if cfg.Level == 0 {
+		cfg.Level = gzip.DefaultCompression
+	}
+	if cfg.MinSize == 0 {
+		cfg.MinSize = defaultCompressionMinSize
+	}
+	if cfg.ExcludedTypes == nil {
+		cfg.ExcludedTypes = DefaultExcludedCompressionTypes
+	}
+	if cfg.SampleSize <= 0 {
+		cfg.SampleSize = defaultCompressionSampleSize
+	}
+	return func(o *serveOptions) {
+		o.compression = &cfg
+	}
+}
+
+// WithoutCompression disables the compression, see WithCompression.
+func WithoutCompression() ServeOption {
+	return func(o *serveOptions) {
+		o.compression = nil
+	}
+}
+
+// excluded reports whether contentType is never compressed.
+func (cfg *CompressionConfig) excluded(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return true
+	}
+	for _, excluded := range cfg.ExcludedTypes {
+		if (strings.HasSuffix(excluded, "/") && strings.HasPrefix(mediaType, excluded)) || mediaType == excluded {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiate returns the content encoding used for the response, or "" if it's not compressed.
+// The Accept-Encoding q-values are honored per RFC 9110: the preferred supported encoding is used, unless the client
+// prefers identity. The MinSize, ExcludedTypes and MaxRatio heuristics are bypassed when identity is forbidden,
+// and it fails with ErrNotAcceptable if no supported encoding is acceptable either.
+// content is sampled at its current offset, and rewound, see MaxRatio.
+func (cfg *CompressionConfig) negotiate(
+	incoming metadata.MD, contentType string, content io.ReadSeeker, size int64,
+) (string, error) {
+	header := pickHeader(incoming, headerAcceptEncoding)
+	if header == "" {
+		return "", nil
+	}
+	accept := parseAcceptEncoding(header)
+	var encoding string
+	var quality float64
+	for _, candidate := range []string{encodingGzip, encodingDeflate} {
+		if q := accept.quality(candidate); q > quality {
+			encoding, quality = candidate, q
+		}
+	}
+	identity := accept.quality(encodingIdentity)
+	switch {
+	case encoding == "" && identity == 0:
+		return "", ErrNotAcceptable
+	case encoding == "" || accept.listed(encodingIdentity) && identity > quality:
+		return "", nil
+	case identity == 0:
+		return encoding, nil
+	case size < cfg.MinSize:
+		cfg.Stats.skip(CompressionSkippedSize)
+		return "", nil
+	case cfg.excluded(contentType):
+		cfg.Stats.skip(CompressionSkippedType)
+		return "", nil
+	case cfg.MaxRatio > 0:
+		ratio, err := cfg.sampleRatio(encoding, content, min(size, cfg.SampleSize))
+		if err != nil {
+			return "", err
+		}
+		if ratio > cfg.MaxRatio {
+			cfg.Stats.skip(CompressionSkippedRatio)
+			return "", nil
+		}
+	}
+	return encoding, nil
+}
+
+// acceptEncoding are the q-values of the codings of an Accept-Encoding header, by lower-case coding.
+type acceptEncoding map[string]float64
+
+// parseAcceptEncoding parses an Accept-Encoding header, e.g. "br;q=1.0, gzip;q=0.8, *;q=0.1".
+// Codings with an invalid q-value are ignored.
+func parseAcceptEncoding(header string) acceptEncoding {
+	accept := make(acceptEncoding)
+	for _, item := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(item, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(param, "=")
+			if strings.EqualFold(strings.TrimSpace(name), "q") {
+				var err error
+				if q, err = strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil || q < 0 || q > 1 {
+					q = -1
+				}
+			}
+		}
+		if q >= 0 {
+			accept[coding] = q
+		}
+	}
+	return accept
+}
+
+// listed reports whether coding has an explicit q-value, directly or through "*".
+func (a acceptEncoding) listed(coding string) bool {
+	_, ok := a[coding]
+	_, wildcard := a["*"]
+	return ok || wildcard
+}
+
+// quality returns the q-value of coding. The codings not listed take the q-value of "*" if any,
+// or 0, except identity which is acceptable unless excluded.
+func (a acceptEncoding) quality(coding string) float64 {
+	if q, ok := a[coding]; ok {
+		return q
+	}
+	if q, ok := a["x-"+coding]; ok && coding == encodingGzip {
+		// RFC 9110, Section 8.4.1.3: x-gzip is equivalent to gzip.
+		return q
+	}
+	if q, ok := a["*"]; ok {
+		return q
+	}
+	if coding == encodingIdentity {
+		return 1
+	}
+	return 0
+}
+
+// sampleRatio compresses the next size bytes of content, rewinds it, and returns the compression ratio.
+func (cfg *CompressionConfig) sampleRatio(encoding string, content io.ReadSeeker, size int64) (float64, error) {
+	offset, err := content.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	var compressed countingWriter
+	encoder, err := newEncoder(encoding, &compressed, cfg.Level)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.CopyN(encoder, content, size)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	if err = encoder.Close(); err != nil {
+		return 0, err
+	}
+	if _, err = content.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return float64(compressed) / float64(n), nil
+}
+
+// newEncoder returns the encoder of encoding writing to w. The deflate content coding is the zlib format
+// (RFC 9110 section 8.4.1.2), not raw DEFLATE.
+func newEncoder(encoding string, w io.Writer, level int) (io.WriteCloser, error) {
+	if encoding == encodingDeflate {
+		return zlib.NewWriterLevel(w, level)
+	}
+	return gzip.NewWriterLevel(w, level)
+}
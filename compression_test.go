@@ -0,0 +1,174 @@
+package gatewayfile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestCompressionNegotiate(t *testing.T) {
+	text := bytes.Repeat([]byte("compressible text "), 1000)
+	noise := make([]byte, len(text))
+	_, _ = rand.New(rand.NewSource(1)).Read(noise)
+	tests := []struct {
+		name        string
+		accept      string
+		cfg         CompressionConfig
+		contentType string
+		content     []byte
+		want        string
+		wantErr     error
+		wantSkipped string
+	}{
+		{name: "no header", want: ""},
+		{name: "gzip", accept: "gzip", want: encodingGzip},
+		{name: "deflate", accept: "deflate", want: encodingDeflate},
+		{name: "preferred", accept: "gzip;q=0.5, deflate", want: encodingDeflate},
+		{name: "case insensitive", accept: "GZIP;Q=0.8, deflate;q=0.7", want: encodingGzip},
+		{name: "x-gzip", accept: "x-gzip", want: encodingGzip},
+		{name: "wildcard", accept: "*;q=0.1", want: encodingGzip},
+		{name: "identity preferred", accept: "identity, gzip;q=0.5", want: ""},
+		{name: "excluded", accept: "gzip;q=0, deflate;q=0", want: ""},
+		{name: "invalid q-value ignored", accept: "gzip;q=2, deflate;q=x", want: ""},
+		{name: "unsupported", accept: "br", want: ""},
+		{name: "not acceptable", accept: "br, identity;q=0", wantErr: ErrNotAcceptable},
+		{name: "wildcard excludes identity", accept: "br, *;q=0", wantErr: ErrNotAcceptable},
+		{name: "too small", accept: "gzip", cfg: CompressionConfig{MinSize: 1 << 20}, wantSkipped: CompressionSkippedSize},
+		{
+			name:   "too small without identity",
+			accept: "gzip, identity;q=0",
+			cfg:    CompressionConfig{MinSize: 1 << 20},
+			want:   encodingGzip,
+		},
+		{name: "excluded type", accept: "gzip", contentType: "image/png", wantSkipped: CompressionSkippedType},
+		{name: "type parameters", accept: "gzip", contentType: "text/plain; charset=utf-8", want: encodingGzip},
+		{
+			name:        "incompressible",
+			accept:      "gzip",
+			cfg:         CompressionConfig{MaxRatio: 0.9},
+			content:     noise,
+			wantSkipped: CompressionSkippedRatio,
+		},
+		{name: "compressible", accept: "gzip", cfg: CompressionConfig{MaxRatio: 0.9}, want: encodingGzip},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats := &CompressionStats{}
+			tt.cfg.Stats = stats
+			o := &serveOptions{}
+			WithCompression(tt.cfg)(o)
+			if tt.contentType == "" {
+				tt.contentType = "text/plain"
+			}
+			if tt.content == nil {
+				tt.content = text
+			}
+			var incoming metadata.MD
+			if tt.accept != "" {
+				incoming = metadata.Pairs("grpcgateway-accept-encoding", tt.accept)
+			}
+			content := bytes.NewReader(tt.content)
+			got, err := o.compression.negotiate(incoming, tt.contentType, content, int64(len(tt.content)))
+			if !errors.Is(err, tt.wantErr) || got != tt.want {
+				t.Fatalf("got %q, %v, want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+			if offset, _ := content.Seek(0, io.SeekCurrent); offset != 0 {
+				t.Fatalf("the content is at %d, want it rewound", offset)
+			}
+			skipped := stats.Skipped()
+			if tt.wantSkipped != "" && skipped[tt.wantSkipped] != 1 || tt.wantSkipped == "" && len(skipped) > 0 {
+				t.Fatalf("got skipped %v, want %q", skipped, tt.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestServeContentCompressed(t *testing.T) {
+	text := bytes.Repeat([]byte("compressible text "), 1000)
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		encodingGzip: func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		// deflate is zlib, a raw DEFLATE stream fails its header check.
+		encodingDeflate: func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) },
+	}
+	tests := []struct {
+		name         string
+		incoming     []string
+		opts         []ServeOption
+		wantEncoding string
+		wantETag     string
+		wantVary     string
+	}{
+		{
+			name:     "identity",
+			opts:     []ServeOption{WithETag(`"v1"`)},
+			wantETag: `"v1"`,
+			wantVary: "Accept-Encoding",
+		},
+		{
+			name:         "gzip",
+			incoming:     []string{"grpcgateway-accept-encoding", "gzip"},
+			opts:         []ServeOption{WithETag(`"v1"`)},
+			wantEncoding: encodingGzip,
+			wantETag:     `W/"v1"`,
+			wantVary:     "Accept-Encoding",
+		},
+		{
+			name:         "deflate",
+			incoming:     []string{"grpcgateway-accept-encoding", "deflate"},
+			opts:         []ServeOption{WithETag(`W/"v1"`)},
+			wantEncoding: encodingDeflate,
+			wantETag:     `W/"v1"`,
+			wantVary:     "Accept-Encoding",
+		},
+		{
+			name:         "vary kept",
+			incoming:     []string{"grpcgateway-accept-encoding", "gzip"},
+			opts:         []ServeOption{withHeader(headerVary, "Origin")},
+			wantEncoding: encodingGzip,
+			wantVary:     "Origin, Accept-Encoding",
+		},
+		{
+			name:     "range",
+			incoming: []string{"grpcgateway-accept-encoding", "gzip", "grpcgateway-range", "bytes=0-"},
+			opts:     []ServeOption{WithETag(`"v1"`)},
+			wantETag: `"v1"`,
+			wantVary: "Accept-Encoding",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeDownloadServer(tt.incoming...)
+			opts := append([]ServeOption{WithCompression(CompressionConfig{})}, tt.opts...)
+			err := ServeContent(server, bytes.NewReader(text), "text/plain", "a.txt", time.Time{}, int64(len(text)), opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			header := server.header
+			if got := pick(header, headerContentEncoding); got != tt.wantEncoding {
+				t.Fatalf("got Content-Encoding %q, want %q", got, tt.wantEncoding)
+			}
+			if got := pick(header, headerETag); got != tt.wantETag {
+				t.Fatalf("got ETag %q, want %q", got, tt.wantETag)
+			}
+			if got := pick(header, headerVary); got != tt.wantVary {
+				t.Fatalf("got Vary %q, want %q", got, tt.wantVary)
+			}
+			var body io.Reader = &server.body
+			if decode := decoders[tt.wantEncoding]; decode != nil {
+				if body, err = decode(body); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got, err := io.ReadAll(body); err != nil || !bytes.Equal(got, text) {
+				t.Fatalf("got %d bytes, %v, want the content", len(got), err)
+			}
+		})
+	}
+}
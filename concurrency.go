@@ -0,0 +1,216 @@
+package gatewayfile
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// ErrOverloaded is returned by ConcurrencyLimiter.Acquire when the queue is full or the wait timed out.
+var ErrOverloaded = newError(codes.Unavailable, "OVERLOADED", "too many concurrent transfers")
+
+// ConcurrencyConfig is the configuration of a ConcurrencyLimiter.
+type ConcurrencyConfig struct {
+	// MaxConcurrent is the maximum number of concurrent transfers.
+	MaxConcurrent int
+	// MaxQueue is the maximum number of transfers waiting for a slot, 0 rejects them immediately when saturated.
+	MaxQueue int
+	// MaxWait is the maximum time a transfer waits in the queue (0 = until its context is done).
+	MaxWait time.Duration
+	// ClientKey returns the client of a transfer, the queue is fair between clients: slots are granted
+	// round-robin between them, so a client queueing many downloads doesn't starve the others.
+	// The queue is plain FIFO if nil.
+	ClientKey func(ctx context.Context) string
+}
+
+// ClientIPKey is a ConcurrencyConfig.ClientKey which identifies clients by IP address,
+// from X-Forwarded-For or the peer address.
+func ClientIPKey(ctx context.Context) string {
+	return clientIP(ctx)
+}
+
+// Semaphore limits the number of concurrent transfers, e.g. a ConcurrencyLimiter within a process,
+// or the redisstore.Semaphore across a fleet. See WithSemaphore.
+type Semaphore interface {
+	// Acquire waits for a slot and returns the function releasing it.
+	// It returns ErrOverloaded if no slot is available in time, or the error of ctx if it's done.
+	Acquire(ctx context.Context) (release func(), err error)
+}
+
+// ConcurrencyLimiter limits the number of concurrent transfers. When saturated, transfers wait in a bounded queue
+// instead of being rejected immediately, so traffic spikes are smoothed out. See WithConcurrencyLimit.
+type ConcurrencyLimiter struct {
+	cfg ConcurrencyConfig
+
+	mu     sync.Mutex
+	active int
+	queued int
+	queues map[string][]*concurrencyWaiter // waiters by client, in FIFO order
+	order  []string                        // clients with waiters, in round-robin order
+}
+
+type concurrencyWaiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// NewConcurrencyLimiter returns a new ConcurrencyLimiter.
+func NewConcurrencyLimiter(cfg ConcurrencyConfig) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{cfg: cfg, queues: make(map[string][]*concurrencyWaiter)}
+}
+
+// Acquire waits for a slot and returns the function releasing it.
+// It returns ErrOverloaded if the queue is full or MaxWait elapsed, or the error of ctx if it's done.
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context) (release func(), err error) {
+	var key string
+	if l.cfg.ClientKey != nil {
+		key = l.cfg.ClientKey(ctx)
+	}
+
+	l.mu.Lock()
+	if l.active < l.cfg.MaxConcurrent && l.queued == 0 {
+		l.active++
+		l.mu.Unlock()
+		return l.releaser(), nil
+	}
+	if l.queued >= l.cfg.MaxQueue {
+		l.mu.Unlock()
+		return nil, ErrOverloaded
+	}
+	w := &concurrencyWaiter{ready: make(chan struct{})}
+	if len(l.queues[key]) == 0 {
+		l.order = append(l.order, key)
+	}
+	l.queues[key] = append(l.queues[key], w)
+	l.queued++
+	l.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if l.cfg.MaxWait > 0 {
+		timer := time.NewTimer(l.cfg.MaxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-w.ready:
+		return l.releaser(), nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timeout:
+		err = ErrOverloaded
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if w.granted {
+		// granted while giving up, hand the slot over.
+		l.grant()
+		return nil, err
+	}
+	l.remove(key, w)
+	return nil, err
+}
+
+// releaser returns the function releasing a slot, it's idempotent.
+func (l *ConcurrencyLimiter) releaser() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.grant()
+		})
+	}
+}
+
+// grant hands over a released slot to the next waiter, round-robin between clients,
+// unless there are more slots than MaxConcurrent since it was lowered. l.mu must be held.
+func (l *ConcurrencyLimiter) grant() {
+	if len(l.order) == 0 || l.active > l.cfg.MaxConcurrent {
+		l.active--
+		return
+	}
+	key := l.order[0]
+	w := l.queues[key][0]
+	l.queues[key] = l.queues[key][1:]
+	l.order = l.order[1:]
+	if len(l.queues[key]) > 0 {
+		l.order = append(l.order, key)
+	} else {
+		delete(l.queues, key)
+	}
+	l.queued--
+	w.granted = true
+	close(w.ready)
+}
+
+// setLimits changes MaxConcurrent and MaxQueue, raising MaxConcurrent grants the new slots to the waiters.
+// Lowering it doesn't interrupt the transfers holding a slot, the next ones wait until they're released.
+func (l *ConcurrencyLimiter) setLimits(maxConcurrent, maxQueue int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cfg.MaxConcurrent, l.cfg.MaxQueue = maxConcurrent, maxQueue
+	for l.active < maxConcurrent && len(l.order) > 0 {
+		l.active++
+		l.grant()
+	}
+}
+
+// remove removes a waiter which gave up. l.mu must be held.
+func (l *ConcurrencyLimiter) remove(key string, w *concurrencyWaiter) {
+	queue := l.queues[key]
+	for i := range queue {
+		if queue[i] == w {
+			l.queues[key] = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+	l.queued--
+	if len(l.queues[key]) > 0 {
+		return
+	}
+	delete(l.queues, key)
+	for i := range l.order {
+		if l.order[i] == key {
+			l.order = append(l.order[:i], l.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// WithConcurrencyLimit makes the download wait for a slot of limiter before serving.
+// It's answered with 503 Service Unavailable if the limiter is overloaded, see ConcurrencyConfig.
+func WithConcurrencyLimit(limiter *ConcurrencyLimiter) ServeOption {
+	return func(o *serveOptions) {
+		o.concurrency = nil
+		if limiter != nil {
+			// a nil *ConcurrencyLimiter would be a non-nil Semaphore.
+			o.concurrency = limiter
+		}
+	}
+}
+
+// WithSemaphore makes the download wait for a slot of semaphore before serving, like WithConcurrencyLimit.
+func WithSemaphore(semaphore Semaphore) ServeOption {
+	return func(o *serveOptions) {
+		o.concurrency = semaphore
+	}
+}
+
+// acquire waits for a concurrency slot, and answers 503 if the limiter is overloaded.
+// release is nil if the download must not be served.
+func (o *serveOptions) acquire(server downloadServer) (release func(), err error) {
+	if o.concurrency == nil {
+		return func() {}, nil
+	}
+	release, err = o.concurrency.Acquire(server.Context())
+	if errors.Is(err, ErrOverloaded) {
+		return nil, serveError(server, make(metadata.MD), err.Error(), http.StatusServiceUnavailable)
+	}
+	return release, err
+}
@@ -0,0 +1,253 @@
+package gatewayfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ConnectServerStream is the part of *connect.ServerStream[httpbody.HttpBody] (connectrpc.com/connect)
+// used by ConnectDownloadServer, so this package doesn't depend on connect-go.
+type ConnectServerStream interface {
+	Send(body *httpbody.HttpBody) error
+	ResponseHeader() http.Header
+	ResponseTrailer() http.Header
+}
+
+// ConnectClientStream is the part of *connect.ClientStream[httpbody.HttpBody] (connectrpc.com/connect)
+// used by ConnectUploadServer.
+type ConnectClientStream interface {
+	Receive() bool
+	Msg() *httpbody.HttpBody
+	Err() error
+	RequestHeader() http.Header
+}
+
+// ConnectDownloadServer adapts a connect-go server-streaming handler to a DownloadServer, so ServeFile,
+// ServeContent... work with the gRPC, gRPC-Web and Connect protocols served by connect-go:
+//
+//	func (s *service) Download(
+//		ctx context.Context, req *connect.Request[pb.DownloadRequest], stream *connect.ServerStream[httpbody.HttpBody],
+//	) error {
+//		server := gatewayfile.ConnectDownloadServer(ctx, req.Header(), req.Peer().Addr, stream)
+//		return gatewayfile.ServeFile(server, "", req.Msg.Path)
+//	}
+//
+// The response headers are written to the stream headers, but those of the framing (Content-Type,
+// Content-Length...), the messages carry the content type. These protocols have no status code,
+// so error responses (4xx, 5xx) are returned as errors with the matching code instead, e.g. codes.NotFound,
+// and successful ones, 206 and 304 included, are plain streams. Stream errors are reported as trailers.
+func ConnectDownloadServer(
+	ctx context.Context, requestHeader http.Header, remoteAddr string, stream ConnectServerStream,
+	opts ...ForwardOption,
+) DownloadServer {
+	return &connectDownloadServer{
+		ctx:    metadata.NewIncomingContext(ctx, incomingMetadata(requestHeader, remoteAddr)),
+		stream: stream,
+		o:      newForwardOptions(opts),
+		header: make(metadata.MD),
+	}
+}
+
+// connectReservedHeaders are the headers which describe the framing of the protocols of connect-go,
+// the representation is described by the messages instead, e.g. HttpBody.ContentType.
+var connectReservedHeaders = map[string]bool{
+	"Content-Type":      true,
+	"Content-Length":    true,
+	"Content-Encoding":  true,
+	"Transfer-Encoding": true,
+}
+
+type connectDownloadServer struct {
+	ctx    context.Context
+	stream ConnectServerStream
+	o      *forwardOptions
+
+	header     metadata.MD
+	headerSent bool
+	failure    int // the error status code of the response, if any
+}
+
+func (s *connectDownloadServer) Context() context.Context {
+	return s.ctx
+}
+
+func (s *connectDownloadServer) SetHeader(md metadata.MD) error {
+	if s.headerSent {
+		return errors.New("header already sent")
+	}
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func (s *connectDownloadServer) SendHeader(md metadata.MD) error {
+	if err := s.SetHeader(md); err != nil {
+		return err
+	}
+	s.headerSent = true
+	writer := &headerWriter{header: make(http.Header)}
+	if err := s.o.writeHeader(writer, s.header); err != nil {
+		return err
+	}
+	for key, values := range writer.header {
+		if !connectReservedHeaders[key] {
+			s.stream.ResponseHeader()[key] = values
+		}
+	}
+	if writer.code >= http.StatusBadRequest {
+		s.failure = writer.code
+	}
+	return nil
+}
+
+func (s *connectDownloadServer) SetTrailer(md metadata.MD) {
+	for key, values := range md {
+		for _, value := range values {
+			s.stream.ResponseTrailer().Add(key, value)
+		}
+	}
+}
+
+func (s *connectDownloadServer) Send(body *httpbody.HttpBody) error {
+	if !s.headerSent {
+		if err := s.SendHeader(nil); err != nil {
+			return err
+		}
+	}
+	if s.failure != 0 {
+		// the body of an error response is its message, see serveError.
+		return status.Error(codeFromHTTPStatus(s.failure), string(body.GetData()))
+	}
+	if streamErrorTrailers(body) != nil {
+		// a stream error marker, its trailers were set by SetTrailer, see sendStreamError.
+		return nil
+	}
+	return s.stream.Send(body)
+}
+
+func (s *connectDownloadServer) SendMsg(m any) error {
+	body, ok := m.(*httpbody.HttpBody)
+	if !ok {
+		return fmt.Errorf("unsupported message %T", m)
+	}
+	return s.Send(body)
+}
+
+func (s *connectDownloadServer) RecvMsg(any) error {
+	return errors.New("download server can't receive")
+}
+
+// ConnectUploadServer adapts a connect-go client-streaming handler to an UploadServer,
+// so NewFormData, StreamFormData... work with the protocols served by connect-go:
+//
+//	func (s *service) Upload(
+//		ctx context.Context, stream *connect.ClientStream[httpbody.HttpBody],
+//	) (*connect.Response[pb.UploadResponse], error) {
+//		form, err := gatewayfile.NewFormData(gatewayfile.ConnectUploadServer(ctx, stream), sizeLimit)
+//		...
+//	}
+//
+// Response headers set through it are discarded, the handler sets them on its response.
+func ConnectUploadServer(ctx context.Context, stream ConnectClientStream) UploadServer {
+	return &connectUploadServer{
+		ctx:    metadata.NewIncomingContext(ctx, incomingMetadata(stream.RequestHeader(), "")),
+		stream: stream,
+	}
+}
+
+type connectUploadServer struct {
+	ctx    context.Context
+	stream ConnectClientStream
+}
+
+func (s *connectUploadServer) Context() context.Context {
+	return s.ctx
+}
+
+func (s *connectUploadServer) Recv() (*httpbody.HttpBody, error) {
+	if s.stream.Receive() {
+		return s.stream.Msg(), nil
+	}
+	if err := s.stream.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (s *connectUploadServer) SetHeader(metadata.MD) error  { return nil }
+func (s *connectUploadServer) SendHeader(metadata.MD) error { return nil }
+func (s *connectUploadServer) SetTrailer(metadata.MD)       {}
+
+func (s *connectUploadServer) SendMsg(any) error {
+	return errors.New("upload server can't send")
+}
+
+func (s *connectUploadServer) RecvMsg(m any) error {
+	body, ok := m.(*httpbody.HttpBody)
+	if !ok {
+		return fmt.Errorf("unsupported message %T", m)
+	}
+	received, err := s.Recv()
+	if err != nil {
+		return err
+	}
+	body.ContentType, body.Data, body.Extensions = received.GetContentType(), received.GetData(), received.GetExtensions()
+	return nil
+}
+
+// headerWriter is a http.ResponseWriter which only records the headers and the status code.
+type headerWriter struct {
+	header http.Header
+	code   int
+}
+
+func (w *headerWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *headerWriter) Write([]byte) (int, error) {
+	return 0, errors.New("header writer can't write")
+}
+
+func (w *headerWriter) WriteHeader(code int) {
+	w.code = code
+}
+
+// codeFromHTTPStatus is the reverse of runtime.HTTPStatusFromCode, for error statuses.
+func codeFromHTTPStatus(code int) codes.Code {
+	switch code {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.Aborted
+	case http.StatusPreconditionFailed:
+		return codes.FailedPrecondition
+	case http.StatusRequestedRangeNotSatisfiable:
+		return codes.OutOfRange
+	case http.StatusTooManyRequests, http.StatusInsufficientStorage:
+		return codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout, http.StatusRequestTimeout:
+		return codes.DeadlineExceeded
+	}
+	if code >= http.StatusInternalServerError {
+		return codes.Internal
+	}
+	return codes.FailedPrecondition
+}
@@ -0,0 +1,58 @@
+package gatewayfile
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// contentDisposition formats the Content-Disposition of a file, per RFC 6266: disposition is "attachment" or
+// "inline". The filename is a quoted string, with its non-printable and non-ASCII characters replaced by "_".
+// If it has any, the exact filename follows as a percent-encoded UTF-8 filename*, which browsers prefer,
+// see RFC 8187. The header stays printable ASCII, so gRPC metadata accepts it.
+func contentDisposition(disposition, filename string) string {
+	var fallback strings.Builder
+	exact := true
+	for _, r := range filename {
+		switch {
+		case r == utf8.RuneError || r < ' ' || r > '~':
+			fallback.WriteByte('_')
+			exact = false
+		case r == '"' || r == '\\':
+			fallback.WriteByte('\\')
+			fallback.WriteRune(r)
+		default:
+			fallback.WriteRune(r)
+		}
+	}
+	value := disposition + `; filename="` + fallback.String() + `"`
+	if !exact {
+		value += "; filename*=UTF-8''" + encodeExtValue(filename)
+	}
+	return value
+}
+
+// encodeExtValue percent-encodes s as the value-chars of an RFC 8187 ext-value.
+func encodeExtValue(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isAttrChar(c) {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&0x0f])
+	}
+	return b.String()
+}
+
+// isAttrChar reports whether c is an attr-char of RFC 8187, which is not percent-encoded.
+func isAttrChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", c) >= 0
+}
@@ -0,0 +1,238 @@
+package gatewayfile
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+)
+
+var (
+	// ErrDecompressionRatio is returned when a compressed upload expands more than DecompressionConfig.MaxRatio.
+	// It's answered with 413 Content Too Large, see HTTPErrorHandler.
+	ErrDecompressionRatio = newError(
+		codes.ResourceExhausted, "DECOMPRESSION_RATIO_EXCEEDED", "decompression ratio exceeded",
+	)
+	// ErrDecompressedSizeExceeded is returned when a compressed upload expands to more than
+	// DecompressionConfig.MaxSize. It's answered with 413 Content Too Large, see HTTPErrorHandler.
+	ErrDecompressedSizeExceeded = newError(
+		codes.ResourceExhausted, "DECOMPRESSED_SIZE_EXCEEDED", "decompressed size exceeded",
+	)
+	// ErrInvalidArchive is returned by ExtractZip when the archive is malformed or has an entry escaping dir.
+	ErrInvalidArchive = newError(codes.InvalidArgument, "INVALID_ARCHIVE", "invalid archive")
+)
+
+const (
+	defaultMaxDecompressionRatio = 100
+	// decompressionRatioGrace is the decompressed size below which the ratio isn't enforced,
+	// small files of repeated bytes legitimately compress very well.
+	decompressionRatioGrace = 1 << 20 // 1 MB
+)
+
+// DecompressionConfig protects disk and memory from decompression bombs, see WithDecompression and ExtractZip.
+type DecompressionConfig struct {
+	// MaxRatio is the maximum ratio of the decompressed size to the compressed size, defaults to 100.
+	// It's enforced once the decompressed size is past 1 MB.
+	MaxRatio float64
+	// MaxSize is the maximum decompressed size in bytes of a file, or of all the entries of an archive
+	// (0 = unlimited).
+	MaxSize int64
+	// MaxEntries is the maximum number of entries of an archive (0 = unlimited).
+	MaxEntries int
+}
+
+func (cfg DecompressionConfig) maxRatio() float64 {
+	if cfg.MaxRatio <= 0 {
+		return defaultMaxDecompressionRatio
+	}
+	return cfg.MaxRatio
+}
+
+// WithDecompression decompresses the gzip uploaded files, i.e. with the application/gzip content type or the .gz
+// extension, while the form is parsed. The files keep their filename and content type. The upload fails with
+// ErrDecompressionRatio or ErrDecompressedSizeExceeded if a file expands past the limits of cfg,
+// before it fills the disk or the memory. See WithFileTransform.
+func WithDecompression(cfg DecompressionConfig) FormDataOption {
+	return WithFileTransform(func(file ScanFile, r io.Reader) io.Reader {
+		if !isGzipFile(file) {
+			return r
+		}
+		return &gunzipReader{compressed: &countingReader{reader: r}, cfg: cfg}
+	})
+}
+
+func isGzipFile(file ScanFile) bool {
+	switch fileContentType(file.ContentType) {
+	case "application/gzip", "application/x-gzip":
+		return true
+	}
+	return strings.EqualFold(filepath.Ext(file.Filename), ".gz")
+}
+
+// gunzipReader decompresses a gzip stream within the limits of cfg.
+type gunzipReader struct {
+	compressed *countingReader
+	cfg        DecompressionConfig
+	limit      *bombReader
+	err        error
+}
+
+func (r *gunzipReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.limit == nil {
+		zr, err := gzip.NewReader(r.compressed)
+		if err != nil {
+			r.err = fmt.Errorf("decompress gzip failed %w", err)
+			return 0, r.err
+		}
+		r.limit = newBombReader(zr, r.compressed, r.cfg)
+	}
+	return r.limit.Read(p)
+}
+
+// countingReader counts the bytes read.
+type countingReader struct {
+	reader io.Reader
+	n      int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// bombReader reads a decompressed stream, failing once it's larger than maxSize,
+// or more than maxRatio times larger than the compressed bytes read so far.
+type bombReader struct {
+	reader     io.Reader
+	compressed *countingReader
+	maxRatio   float64
+	maxSize    int64 // maxSize is the maximum decompressed size, negative if unlimited.
+	n          int64 // n is the decompressed size read so far.
+}
+
+func newBombReader(reader io.Reader, compressed *countingReader, cfg DecompressionConfig) *bombReader {
+	maxSize := cfg.MaxSize
+	if maxSize <= 0 {
+		maxSize = -1
+	}
+	return &bombReader{reader: reader, compressed: compressed, maxRatio: cfg.maxRatio(), maxSize: maxSize}
+}
+
+func (r *bombReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.n += int64(n)
+	if r.maxSize >= 0 && r.n > r.maxSize {
+		return 0, ErrDecompressedSizeExceeded
+	}
+	if r.n > decompressionRatioGrace && float64(r.n) > float64(r.compressed.n)*r.maxRatio {
+		return 0, ErrDecompressionRatio
+	}
+	return n, err
+}
+
+// ExtractZip extracts the zip archive r of size bytes into dir, e.g. an uploaded file opened from FormData,
+// and returns the paths of the extracted files. Entries are checked against the limits of cfg while they're
+// extracted, the ratio of each entry against its compressed size, MaxSize against the total.
+// Entries escaping dir are rejected with ErrInvalidArchive. The extracted files are removed if it fails.
+func ExtractZip(r io.ReaderAt, size int64, dir string, cfg DecompressionConfig) (paths []string, err error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	if cfg.MaxEntries > 0 && len(archive.File) > cfg.MaxEntries {
+		return nil, fmt.Errorf("%w: more than %d entries", ErrInvalidArchive, cfg.MaxEntries)
+	}
+	defer func() {
+		if err != nil {
+			for _, path := range paths {
+				_ = os.Remove(path)
+			}
+			paths = nil
+		}
+	}()
+
+	var total int64
+	for _, entry := range archive.File {
+		if !filepath.IsLocal(entry.Name) {
+			return paths, fmt.Errorf("%w: entry %q", ErrInvalidArchive, entry.Name)
+		}
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name)
+		if err = os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return paths, err
+		}
+		var n int64
+		if n, err = extractZipEntry(entry, path, cfg, total); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+		total += n
+	}
+	return paths, nil
+}
+
+// extractZipEntry extracts entry into path, extracted is the size of the entries extracted before it.
+// path is removed if it fails.
+func extractZipEntry(entry *zip.File, path string, cfg DecompressionConfig, extracted int64) (int64, error) {
+	compressed, err := entry.OpenRaw()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	// entries are decompressed from the raw stream, so the ratio is checked against the compressed bytes read,
+	// not against the sizes declared by the archive.
+	counting := &countingReader{reader: compressed}
+	var content io.Reader
+	switch entry.Method {
+	case zip.Store:
+		content = counting
+	case zip.Deflate:
+		inflater := flate.NewReader(counting)
+		defer func() { _ = inflater.Close() }()
+		content = inflater
+	default:
+		return 0, fmt.Errorf("%w: entry %q: %v", ErrInvalidArchive, entry.Name, zip.ErrAlgorithm)
+	}
+
+	limited := newBombReader(content, counting, cfg)
+	if cfg.MaxSize > 0 {
+		// the budget left by the previous entries is enforced even once it's used up.
+		limited.maxSize = max(cfg.MaxSize-extracted, 0)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	// the raw stream isn't checked by the archive reader, check it like zip.File.Open does.
+	checksum := crc32.NewIEEE()
+	n, err := io.Copy(io.MultiWriter(file, checksum), limited)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && uint64(n) != entry.UncompressedSize64 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil && entry.CRC32 != 0 && checksum.Sum32() != entry.CRC32 {
+		err = fmt.Errorf("%w: entry %q: %v", ErrInvalidArchive, entry.Name, zip.ErrChecksum)
+	}
+	if err != nil {
+		_ = os.Remove(path)
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = fmt.Errorf("%w: entry %q: %v", ErrInvalidArchive, entry.Name, err)
+	}
+	return n, err
+}
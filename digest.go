@@ -0,0 +1,143 @@
+package gatewayfile
+
+import (
+	"crypto/md5"  //nolint:gosec // md5 is used for Content-MD5 compatibility, not for security.
+	"crypto/sha1" //nolint:gosec // sha1 is used for legacy digest compatibility, not for security.
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"hash"
+	"hash/crc32"
+	"io"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// DigestAlgorithm is a digest algorithm, named as in the HTTP Digest Algorithm Values registry.
+type DigestAlgorithm string
+
+const (
+	DigestSHA256 DigestAlgorithm = "sha-256" // DigestSHA256 - SHA-256
+	DigestSHA512 DigestAlgorithm = "sha-512" // DigestSHA512 - SHA-512
+	DigestSHA1   DigestAlgorithm = "sha"     // DigestSHA1 - SHA-1, insecure
+	DigestMD5    DigestAlgorithm = "md5"     // DigestMD5 - MD5, insecure
+	DigestCRC32C DigestAlgorithm = "crc32c"  // DigestCRC32C - CRC32C (Castagnoli), big-endian, not cryptographic
+)
+
+// New returns a new hash.Hash for the algorithm, or nil if the algorithm is unknown.
+func (a DigestAlgorithm) New() hash.Hash {
+	switch a {
+	case DigestSHA256:
+		return sha256.New()
+	case DigestSHA512:
+		return sha512.New()
+	case DigestSHA1:
+		return sha1.New() //nolint:gosec
+	case DigestMD5:
+		return md5.New() //nolint:gosec
+	case DigestCRC32C:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	default:
+		return nil
+	}
+}
+
+// Digests are computed digests keyed by algorithm.
+type Digests map[DigestAlgorithm][]byte
+
+// String formats the digests as a Content-Digest / Repr-Digest field value (RFC 9530),
+// e.g. `sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:`.
+func (d Digests) String() string {
+	algorithms := make([]string, 0, len(d))
+	for algorithm := range d {
+		algorithms = append(algorithms, string(algorithm))
+	}
+	sort.Strings(algorithms)
+
+	fields := make([]string, 0, len(d))
+	for _, algorithm := range algorithms {
+		sum := base64.StdEncoding.EncodeToString(d[DigestAlgorithm(algorithm)])
+		fields = append(fields, algorithm+"=:"+sum+":")
+	}
+	return strings.Join(fields, ", ")
+}
+
+// digester computes digests of the bytes flowing through it.
+type digester struct {
+	hashes map[DigestAlgorithm]hash.Hash
+	writer io.Writer
+}
+
+func newDigester(algorithms []DigestAlgorithm) *digester {
+	d := &digester{hashes: make(map[DigestAlgorithm]hash.Hash, len(algorithms))}
+	writers := make([]io.Writer, 0, len(algorithms))
+	for _, algorithm := range algorithms {
+		if h := algorithm.New(); h != nil {
+			d.hashes[algorithm] = h
+			writers = append(writers, h)
+		}
+	}
+	d.writer = io.MultiWriter(writers...)
+	return d
+}
+
+func (d *digester) Write(p []byte) (int, error) {
+	return d.writer.Write(p)
+}
+
+func (d *digester) sums() Digests {
+	digests := make(Digests, len(d.hashes))
+	for algorithm, h := range d.hashes {
+		digests[algorithm] = h.Sum(nil)
+	}
+	return digests
+}
+
+// WithServeDigest computes the digests of the response body while it's sent, without a second read of the source.
+// When the body was sent completely, callback (may be nil) is called with the digests,
+// and they're set as the "content-digest" trailer of the gRPC stream.
+// The digests cover the bytes on the wire, i.e. after compression or multipart encoding.
+func WithServeDigest(callback func(Digests), algorithms ...DigestAlgorithm) ServeOption {
+	return func(o *serveOptions) {
+		d := newDigester(algorithms)
+		o.wrapWriters = append(o.wrapWriters, func(w io.Writer) io.Writer {
+			return io.MultiWriter(w, d)
+		})
+		o.onDone = append(o.onDone, func(server downloadServer, err error) {
+			if err != nil {
+				return
+			}
+			digests := d.sums()
+			server.SetTrailer(metadata.Pairs(headerContentDigest, digests.String()))
+			if callback != nil {
+				callback(digests)
+			}
+		})
+	}
+}
+
+// HashingReader computes digests of the bytes read through it.
+type HashingReader struct {
+	reader   io.Reader
+	digester *digester
+}
+
+// NewHashingReader returns a new HashingReader reading from r.
+func NewHashingReader(r io.Reader, algorithms ...DigestAlgorithm) *HashingReader {
+	return &HashingReader{reader: r, digester: newDigester(algorithms)}
+}
+
+func (r *HashingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		_, _ = r.digester.Write(p[:n])
+	}
+	return n, err
+}
+
+// Digests returns the digests of the bytes read so far.
+func (r *HashingReader) Digests() Digests {
+	return r.digester.sums()
+}
@@ -0,0 +1,47 @@
+//go:build linux
+
+package gatewayfile
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+func alignmentOf(buf []byte) int {
+	return int(uintptr(unsafe.Pointer(&buf[0])) & (directIOAlignment - 1))
+}
+
+// openDirect opens the file with O_DIRECT, it falls back to os.Open if the filesystem doesn't support it.
+func openDirect(path string) (io.ReadSeekCloser, os.FileInfo, error) {
+	file, err := os.OpenFile(path, os.O_RDONLY|syscall.O_DIRECT, 0)
+	if errors.Is(err, syscall.EINVAL) {
+		return openFile(path)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, nil, err
+	}
+
+	fd := int(file.Fd())
+	return &directReader{
+		pread: func(p []byte, offset int64) (int, error) {
+			for {
+				n, err := syscall.Pread(fd, p, offset)
+				if errors.Is(err, syscall.EINTR) {
+					continue
+				}
+				return n, err
+			}
+		},
+		close: file.Close,
+		size:  info.Size(),
+		buf:   alignedBuffer(directIOBufSize),
+	}, info, nil
+}
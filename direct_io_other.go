@@ -0,0 +1,17 @@
+//go:build !linux
+
+package gatewayfile
+
+import (
+	"io"
+	"os"
+)
+
+func alignmentOf([]byte) int {
+	return 0
+}
+
+// openDirect opens the file as usual, O_DIRECT is only supported on Linux.
+func openDirect(path string) (io.ReadSeekCloser, os.FileInfo, error) {
+	return openFile(path)
+}
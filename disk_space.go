@@ -0,0 +1,103 @@
+package gatewayfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// ErrInsufficientStorage is returned when the filesystem has not enough free space for an upload,
+// see WithDiskSpaceCheck and WithPreallocation. It's answered with 507 Insufficient Storage, see HTTPErrorHandler.
+var ErrInsufficientStorage = newError(codes.ResourceExhausted, "INSUFFICIENT_STORAGE", "insufficient storage")
+
+// WithDiskSpaceCheck fails an upload before reading its body with ErrInsufficientStorage
+// if the filesystem of dir has less free space than the expected size of the upload plus reserve bytes.
+// dir is the directory the files are written to, os.TempDir() if empty, which is where NewFormData spills them.
+// The expected size is the Content-Length of the request, or the total size declared by the UploadManifest.
+// Uploads of unknown size, or on platforms where the free space is unknown, are not checked.
+func WithDiskSpaceCheck(dir string, reserve int64) FormDataOption {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return func(o *formDataOptions) {
+		o.wrapReaders = append(o.wrapReaders, func(ctx context.Context, r io.Reader) io.Reader {
+			if err := checkDiskSpace(dir, expectedUploadSize(ctx, o.manifest)+reserve); err != nil {
+				return errReader{err: err}
+			}
+			return r
+		})
+	}
+}
+
+// WithPreallocation preallocates the files streamed by StreamFormData to the size declared by the UploadManifest,
+// when the sink writes to an os.File (DirSink, TempSink), so a full disk fails the upload as soon as the file
+// starts, with ErrInsufficientStorage, and the file is less fragmented. It's only supported on Linux.
+func WithPreallocation() FormDataOption {
+	return func(o *formDataOptions) {
+		o.preallocate = true
+	}
+}
+
+// expectedUploadSize returns the expected size of the upload, reserve excluded, 0 if it's unknown.
+func expectedUploadSize(ctx context.Context, manifest *UploadManifest) int64 {
+	md, _ := metadata.FromIncomingContext(ctx)
+	size, _ := strconv.ParseInt(pickHeader(md, headerRequestContentLength), 10, 64)
+	if manifest != nil {
+		var declared int64
+		for _, file := range manifest.Files {
+			declared += max(file.Size, 0)
+		}
+		size = max(size, declared)
+	}
+	return max(size, 0)
+}
+
+func checkDiskSpace(dir string, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	free, err := freeSpace(dir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("check free space of %s failed %w", dir, err)
+	}
+	if free < size {
+		return fmt.Errorf("%w: %d bytes needed, %d bytes free", ErrInsufficientStorage, size, free)
+	}
+	return nil
+}
+
+// preallocateWriter preallocates size bytes to the file written by w, if it's a file.
+func preallocateWriter(w io.Writer, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	if nop, ok := w.(nopWriteCloser); ok {
+		w = nop.Writer
+	}
+	file, ok := w.(*os.File)
+	if !ok {
+		return nil
+	}
+	err := preallocate(file, size)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	return err
+}
+
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package gatewayfile
+
+import (
+	"errors"
+)
+
+func freeSpace(string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,16 @@
+//go:build linux || darwin || freebsd
+
+package gatewayfile
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// freeSpace returns the space available to unprivileged users in the filesystem of dir.
+func freeSpace(dir string) (int64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return int64(uint64(stat.Bavail) * uint64(stat.Bsize)), nil
+}
@@ -0,0 +1,20 @@
+//go:build windows
+
+package gatewayfile
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+// freeSpace returns the space available to the caller in the volume of dir.
+func freeSpace(dir string) (int64, error) {
+	path, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var available uint64
+	if err = windows.GetDiskFreeSpaceEx(path, &available, nil, nil); err != nil {
+		return 0, err
+	}
+	return int64(available), nil
+}
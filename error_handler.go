@@ -0,0 +1,164 @@
+package gatewayfile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+)
+
+// problem are the standard members of RFC 9457 problem details, embedded in the problems of this package.
+type problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	RequestID string `json:"request-id,omitempty"`
+}
+
+func newProblem(code int, detail, requestID string) problem {
+	return problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(code),
+		Status:    code,
+		Detail:    detail,
+		RequestID: requestID,
+	}
+}
+
+// ProblemErrorHandler is a runtime.ErrorHandlerFunc which answers errors with RFC 9457 problem details,
+// mapping the details of the gRPC status into members clients can act upon:
+//
+//	{"type":"about:blank","title":"Service Unavailable","status":503,"detail":"circuit open",
+//	"code":"Unavailable","reason":"CIRCUIT_OPEN","domain":"gatewayfile","retry-after":30}
+//
+// "reason", "domain" and "metadata" come from an ErrorInfo detail, "retry-after" from a RetryInfo detail,
+// which is also answered with a Retry-After header, "invalid-params" from the field violations of a BadRequest detail,
+// e.g. of a FormValidationError. Set it as Config.ErrorHandler, so the status codes of the errors
+// of this package are mapped by HTTPErrorHandler. Unlike runtime.DefaultHTTPErrorHandler, it doesn't forward the
+// metadata of the gRPC response as headers.
+func ProblemErrorHandler(
+	_ context.Context, _ *runtime.ServeMux, _ runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error,
+) {
+	code := 0
+	var httpErr *runtime.HTTPStatusError
+	if errors.As(err, &httpErr) {
+		code, err = httpErr.HTTPStatus, httpErr.Err
+	}
+	st := status.Convert(err)
+	if code == 0 {
+		code = runtime.HTTPStatusFromCode(st.Code())
+	}
+
+	body := struct {
+		problem
+		Code       string            `json:"code"`
+		Reason     string            `json:"reason,omitempty"`
+		Domain     string            `json:"domain,omitempty"`
+		Metadata   map[string]string `json:"metadata,omitempty"`
+		RetryAfter int64             `json:"retry-after,omitempty"`
+		Params     []invalidParam    `json:"invalid-params,omitempty"`
+	}{
+		problem: newProblem(code, st.Message(), r.Header.Get(headerXRequestID)),
+		Code:    st.Code().String(),
+	}
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && body.Reason == "" {
+			body.Reason, body.Domain, body.Metadata = info.GetReason(), info.GetDomain(), info.GetMetadata()
+		}
+		if request, ok := detail.(*errdetails.BadRequest); ok {
+			for _, violation := range request.GetFieldViolations() {
+				body.Params = append(body.Params, invalidParam{
+					Name: violation.GetField(), Reason: violation.GetDescription(), Code: violation.GetReason(),
+				})
+			}
+		}
+	}
+	if after, ok := retryAfter(err); ok {
+		w.Header().Set(headerRetryAfter, after)
+		body.RetryAfter, _ = strconv.ParseInt(after, 10, 64)
+	}
+
+	data, _ := json.Marshal(body)
+	w.Header().Del("Trailer")
+	w.Header().Del(headerTransferEncoding)
+	w.Header().Set(headerContentType, MIMEProblemJSON)
+	w.Header().Set(headerXContentTypeOptions, "nosniff")
+	w.WriteHeader(code)
+	_, _ = w.Write(data)
+}
+
+// invalidParam is a member of the "invalid-params" of a problem, as in the example of RFC 9457.
+type invalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+	Code   string `json:"code,omitempty"`
+}
+
+type errorResponseKey struct{}
+
+// errorResponse is the response of a request, stored in its context by captureErrorResponse,
+// so a download failing before anything was written is answered by the error handler, like a unary RPC,
+// instead of the error chunk of the gateway with the status code of the gRPC code only.
+type errorResponse struct {
+	http.ResponseWriter
+	request  *http.Request
+	header   http.Header // header is the header before the handler, restored before answering an error.
+	wrote    bool        // wrote reports whether the status code or the body was written.
+	answered bool        // answered reports whether the error handler answered, later writes are discarded.
+}
+
+func (w *errorResponse) WriteHeader(code int) {
+	if w.answered {
+		return
+	}
+	w.wrote = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *errorResponse) Write(p []byte) (int, error) {
+	if w.answered {
+		return len(p), nil
+	}
+	w.wrote = true
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *errorResponse) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// captureErrorResponse is the middleware storing the errorResponse of a request in its context.
+func captureErrorResponse(next runtime.HandlerFunc) runtime.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		response := &errorResponse{ResponseWriter: w, header: w.Header().Clone()}
+		r = r.WithContext(context.WithValue(r.Context(), errorResponseKey{}, response))
+		response.request = r
+		next(response, r, pathParams)
+	}
+}
+
+// streamErrorHandler answers a stream error with errorHandler if nothing was written yet,
+// and falls back to next otherwise, i.e. when the body already started.
+func streamErrorHandler(
+	mux *runtime.ServeMux, errorHandler runtime.ErrorHandlerFunc, next runtime.StreamErrorHandlerFunc,
+) runtime.StreamErrorHandlerFunc {
+	return func(ctx context.Context, err error) *status.Status {
+		if w, ok := ctx.Value(errorResponseKey{}).(*errorResponse); ok && !w.wrote && !w.answered {
+			// the error handler writes the headers of the server metadata again.
+			clear(w.Header())
+			for key, values := range w.header {
+				w.Header()[key] = values
+			}
+			_, marshaler := runtime.MarshalerForRequest(mux, w.request)
+			errorHandler(ctx, mux, marshaler, w, w.request, err)
+			w.answered = true
+		}
+		return next(ctx, err)
+	}
+}
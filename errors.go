@@ -1,11 +1,134 @@
 package gatewayfile
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errorDomain is the domain of the ErrorInfo details of the errors of this package.
+const errorDomain = "gatewayfile"
 
 var (
-	ErrInvalidRange      = errors.New("invalid range")       // ErrInvalidRange - invalid range
-	ErrSizeLimitExceeded = errors.New("size limit exceeded") // ErrSizeLimitExceeded - message too large
+	ErrInvalidRange      = newError(codes.InvalidArgument, "INVALID_RANGE", "invalid range")               // ErrInvalidRange - invalid range
+	ErrSizeLimitExceeded = newError(codes.ResourceExhausted, "SIZE_LIMIT_EXCEEDED", "size limit exceeded") // ErrSizeLimitExceeded - message too large
 	// ErrNoOverlap is returned by serveContent's parseRange if first-byte-pos of
 	// all of the byte-range-spec values is greater than the content size.
-	ErrNoOverlap = errors.New("invalid range: failed to overlap")
+	ErrNoOverlap = newError(codes.OutOfRange, "RANGE_NOT_SATISFIABLE", "invalid range: failed to overlap")
+	// ErrOverlappingRanges is returned in strict range mode if the ranges overlap or are not in ascending order.
+	ErrOverlappingRanges = newError(
+		codes.InvalidArgument, "OVERLAPPING_RANGES", "invalid range: overlapping or descending ranges",
+	)
+	// ErrTooManyRanges is returned in strict range mode if the request has more ranges than allowed,
+	// or the ranges are larger than the content in total.
+	ErrTooManyRanges = newError(codes.OutOfRange, "TOO_MANY_RANGES", "invalid range: too many ranges")
 )
+
+// Error is an error of this package. It carries a gRPC code and an ErrorInfo detail,
+// so pure-gRPC callers can handle it programmatically: status.FromError and status.Code
+// convert it, even when it's wrapped.
+type Error struct {
+	Code   codes.Code
+	Reason string // Reason is the UPPER_SNAKE_CASE reason of the ErrorInfo detail.
+	text   string
+}
+
+func newError(code codes.Code, reason, text string) *Error {
+	return &Error{Code: code, Reason: reason, text: text}
+}
+
+func (e *Error) Error() string {
+	return e.text
+}
+
+// GRPCStatus returns the gRPC status of the error, with an ErrorInfo detail.
+func (e *Error) GRPCStatus() *status.Status {
+	st := status.New(e.Code, e.text)
+	if detailed, err := st.WithDetails(&errdetails.ErrorInfo{Reason: e.Reason, Domain: errorDomain}); err == nil {
+		return detailed
+	}
+	return st
+}
+
+// ToStatus converts err to a gRPC status error, so it can be returned by a gRPC handler as-is.
+// The errors of this package keep their code and details even when wrapped, the message is the message of err.
+// Multipart parsing errors become codes.InvalidArgument, other errors are converted by status.FromError.
+func ToStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, http.ErrNotMultipart) || errors.Is(err, http.ErrMissingBoundary) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	st, _ := status.FromError(err)
+	return st.Err()
+}
+
+// httpStatuses are the HTTP statuses of the errors of this package the gRPC codes don't map to, by reason.
+var httpStatuses = map[string]int{
+	"INSUFFICIENT_STORAGE":          http.StatusInsufficientStorage,
+	"UPLOAD_TOO_SLOW":               http.StatusRequestTimeout,
+	"UPLOAD_OFFSET_MISMATCH":        http.StatusConflict,
+	"LINK_EXPIRED":                  http.StatusGone,
+	"LINK_EXHAUSTED":                http.StatusGone,
+	"NOT_ACCEPTABLE":                http.StatusNotAcceptable,
+	"FILE_REJECTED":                 http.StatusUnprocessableEntity,
+	"REQUEST_BODY_TOO_LARGE":        http.StatusRequestEntityTooLarge,
+	"DECOMPRESSION_RATIO_EXCEEDED":  http.StatusRequestEntityTooLarge,
+	"DECOMPRESSED_SIZE_EXCEEDED":    http.StatusRequestEntityTooLarge,
+	"UNAVAILABLE_FOR_LEGAL_REASONS": http.StatusUnavailableForLegalReasons,
+}
+
+// HTTPErrorHandler wraps an error handler, so the errors of this package the gRPC codes don't map to
+// are answered with their own HTTP status, e.g. ErrInsufficientStorage with 507 instead of 429,
+// ErrUploadTooSlow with 408 instead of 504.
+// They're recognized by their ErrorInfo detail, so they're mapped even when returned by a remote gRPC service.
+// A RetryInfo detail, e.g. of ErrCircuitOpen, is answered with a Retry-After header.
+// WithFileSupport installs it.
+func HTTPErrorHandler(next runtime.ErrorHandlerFunc) runtime.ErrorHandlerFunc {
+	return func(
+		ctx context.Context, mux *runtime.ServeMux, marshaler runtime.Marshaler,
+		w http.ResponseWriter, r *http.Request, err error,
+	) {
+		if after, ok := retryAfter(err); ok {
+			w.Header().Set(headerRetryAfter, after)
+		}
+		if code, ok := httpStatusOf(err); ok {
+			w = &statusWriter{ResponseWriter: w, code: code}
+		}
+		next(ctx, mux, marshaler, w, r, err)
+	}
+}
+
+func httpStatusOf(err error) (int, bool) {
+	st, ok := status.FromError(err)
+	if !ok {
+		return 0, false
+	}
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.GetDomain() == errorDomain {
+			code, ok := httpStatuses[info.GetReason()]
+			return code, ok
+		}
+	}
+	return 0, false
+}
+
+// statusWriter overrides the status code written to the ResponseWriter.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(int) {
+	w.ResponseWriter.WriteHeader(w.code)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,58 @@
+package gatewayfile
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// ETagStrategy computes the ETag of a content, see WithETagStrategy.
+// content is positioned at its start, and may be left anywhere: it's rewound afterward.
+type ETagStrategy func(content io.Reader, size int64, modTime time.Time) (string, error)
+
+// ETagSizeModTime is a strong ETag from the size and the modification time of the content, e.g. `"5-18df00ce0"`.
+// It's cheap, but it's only as strong as the modification time: a content changed twice within its resolution
+// keeps its ETag. No ETag is emitted if the modification time is zero.
+func ETagSizeModTime(_ io.Reader, size int64, modTime time.Time) (string, error) {
+	if isZeroTime(modTime) {
+		return "", nil
+	}
+	return fmt.Sprintf(`"%x-%x"`, size, modTime.UnixNano()), nil
+}
+
+// ETagContentHash is a strong ETag from the SHA-256 of the content. It reads the whole content before serving it,
+// so it's suited to small contents: the hash of large ones is better stored along them, and given to WithETag.
+func ETagContentHash(content io.Reader, _ int64, _ time.Time) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return "", err
+	}
+	return strconv.Quote(base64.RawURLEncoding.EncodeToString(hash.Sum(nil))), nil
+}
+
+// WithETagStrategy computes the ETag of the content with strategy when none is given by WithETag or the provider,
+// so conditional requests (If-None-Match, If-Match, If-Range) work end to end. It takes precedence over the weak
+// ETag derived by the provider-based entrypoints, see WithoutAutoETag.
+func WithETagStrategy(strategy ETagStrategy) ServeOption {
+	return func(o *serveOptions) {
+		o.etagStrategy = strategy
+	}
+}
+
+// computeETag returns the ETag of content computed by the strategy of the options, and rewinds content.
+func (o *serveOptions) computeETag(content io.ReadSeeker, size int64, modTime time.Time) (string, error) {
+	etag, err := o.etagStrategy(io.LimitReader(content, size), size, modTime)
+	if err != nil {
+		return "", err
+	}
+	if _, err = content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	if valid, _ := scanETag(etag); valid == "" && etag != "" {
+		etag = strconv.Quote(etag)
+	}
+	return etag, nil
+}
@@ -3,44 +3,30 @@ package main
 import (
 	"context"
 	"log"
-	"net"
 	"net/http"
 
-	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
-
 	gatewayfile "github.com/black-06/grpc-gateway-file"
 	"github.com/black-06/grpc-gateway-file/examples/proto"
 )
 
 func main() {
-	grpcAddr, gatewayAddr := ":7070", ":8080"
+	gatewayAddr := ":8080"
 
-	// grpc server
-	grpcServer := grpc.NewServer()
-	proto.RegisterServiceServer(grpcServer, &Service{})
-	listener, err := net.Listen("tcp", grpcAddr)
+	// the grpc server and the gateway, connected by an in-memory pipe.
+	gateway, err := gatewayfile.Bootstrap(gatewayfile.BootstrapConfig{})
 	if err != nil {
-		log.Fatalf("listen %s failed, err: %v", grpcAddr, err)
+		log.Fatalf("bootstrap failed, err: %v", err)
 	}
+	defer func() { _ = gateway.Close() }()
+
+	proto.RegisterServiceServer(gateway.Server, &Service{})
 	go func() {
-		log.Fatalf(grpcServer.Serve(listener).Error())
+		log.Fatalf(gateway.Serve().Error())
 	}()
 
-	// grpc gateway server
-	mux := runtime.NewServeMux(
-		gatewayfile.WithFileIncomingHeaderMatcher(),
-		gatewayfile.WithFileForwardResponseOption(),
-		gatewayfile.WithHTTPBodyMarshaler("*"),
-	)
-	conn, err := grpc.NewClient(grpcAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
-	if err != nil {
-		log.Fatalf("dial grpc %s failed, err: %v", grpcAddr, err)
-	}
-	err = proto.RegisterServiceHandler(context.Background(), mux, conn)
+	err = proto.RegisterServiceHandler(context.Background(), gateway.Mux, gateway.Conn)
 	if err != nil {
 		log.Fatalf("register gateway failed, err: %v", err)
 	}
-	log.Fatalf(http.ListenAndServe(gatewayAddr, mux).Error())
+	log.Fatalf(http.ListenAndServe(gatewayAddr, gateway.Mux).Error())
 }
@@ -30,13 +30,11 @@ func (*Service) DownloadFile(_ *proto.DownloadFileRequest, server proto.Service_
 const maxDataSize = 1024 * 1024 * 100 // 100MB
 
 func (*Service) UploadFile(server proto.Service_UploadFileServer) error {
-	formData, err := gatewayfile.NewFormData(server, maxDataSize)
+	// The digests are computed while the form is parsed, no need to re-read the file.
+	formData, err := gatewayfile.NewFormData(server, maxDataSize, gatewayfile.WithUploadDigest(gatewayfile.DigestMD5))
 	if err != nil {
-		if errors.Is(err, gatewayfile.ErrSizeLimitExceeded) {
-			return status.Errorf(codes.InvalidArgument, "size limit exceeded")
-		}
-
-		return status.Errorf(codes.Internal, err.Error())
+		// e.g. ErrSizeLimitExceeded becomes codes.ResourceExhausted.
+		return gatewayfile.ToStatus(err)
 	}
 	// Clean up all temporary form data files after processing completes.
 	defer formData.RemoveAll()
@@ -46,9 +44,8 @@ func (*Service) UploadFile(server proto.Service_UploadFileServer) error {
 		return status.Errorf(codes.InvalidArgument, "missing file for key key1")
 	}
 
-	if err = calcFileHash(fileHeader); err != nil {
-		return status.Errorf(codes.InvalidArgument, err.Error())
-	}
+	digest := formData.Digests(fileHeader)[gatewayfile.DigestMD5]
+	_, _ = fmt.Printf("hash for file %s: %s\n", fileHeader.Filename, hex.EncodeToString(digest))
 
 	// Of course, it can also be saved.
 	// gatewayfile.SaveMultipartFile(header, "/to/save/path")
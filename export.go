@@ -0,0 +1,255 @@
+package gatewayfile
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	MIMECSV  = "text/csv; charset=utf-8"                                           // MIMECSV - comma-separated values
+	MIMEXLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet" // MIMEXLSX - Excel workbooks
+)
+
+// ExportFormat is the format of the files served by ServeExport.
+type ExportFormat int
+
+const (
+	ExportCSV  ExportFormat = iota // ExportCSV - RFC 4180 CSV
+	ExportXLSX                     // ExportXLSX - an Excel workbook of one sheet of text cells
+)
+
+// maxXLSXRows is the maximum number of rows of an Excel sheet.
+const maxXLSXRows = 1 << 20
+
+// RowFunc returns the next row of an export, and io.EOF after the last one.
+type RowFunc func() ([]string, error)
+
+// ServeExport streams the rows returned by next as a CSV or XLSX attachment named name, e.g. for "export" endpoints.
+// columns is the header row, nil for none. The file is encoded while the rows are produced, so a large export
+// isn't held in memory; the response has no Content-Length and doesn't support ranges.
+// A failure of next after the header was sent is reported as a mid-stream failure, see WithFileForwardResponseOption.
+func ServeExport(
+	server downloadServer, name string, format ExportFormat, columns []string, next RowFunc, opts ...ServeOption,
+) error {
+	o := newServeOptions(server.Context(), opts)
+	return o.serve(server, name, func(server downloadServer) error {
+		return serveExport(server, name, format, columns, next, o)
+	})
+}
+
+func serveExport(
+	server downloadServer, name string, format ExportFormat, columns []string, next RowFunc, o *serveOptions,
+) error {
+	var contentType string
+	var encode func(w io.Writer, columns []string, next RowFunc) error
+	switch format {
+	case ExportCSV:
+		contentType, encode = MIMECSV, encodeCSV
+	case ExportXLSX:
+		contentType, encode = MIMEXLSX, encodeXLSX
+	default:
+		return fmt.Errorf("unknown export format %d", format)
+	}
+
+	outgoing := make(metadata.MD)
+	for key, values := range o.header {
+		outgoing.Set(key, values...)
+	}
+	setContentType(outgoing, contentType)
+	if name != "" {
+		disposition := "attachment"
+		if o.inline {
+			disposition = "inline"
+		}
+		outgoing.Set(headerContentDisposition, contentDisposition(disposition, name))
+	}
+	outgoing.Set(headerCode, strconv.Itoa(o.okCode()))
+	if err := server.SendHeader(outgoing); err != nil {
+		return err
+	}
+
+	// rows are small writes, buffer them into full messages.
+	writer := bufio.NewWriterSize(o.newWriter(server, contentType), defaultBufSize)
+	err := encode(writer, columns, next)
+	if err == nil {
+		err = writer.Flush()
+	}
+	return o.done(server, err)
+}
+
+// eachRow calls f with columns, if any, then with each row returned by next.
+func eachRow(columns []string, next RowFunc, f func(row []string) error) error {
+	if columns != nil {
+		if err := f(columns); err != nil {
+			return err
+		}
+	}
+	for {
+		row, err := next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = f(row); err != nil {
+			return err
+		}
+	}
+}
+
+func encodeCSV(w io.Writer, columns []string, next RowFunc) error {
+	writer := csv.NewWriter(w)
+	err := eachRow(columns, next, writer.Write)
+	if err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// The parts of an XLSX workbook besides its sheet, see ECMA-376.
+var xlsxParts = []struct{ name, content string }{
+	{"[Content_Types].xml", xml.Header +
+		`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml"` +
+		` ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml"` +
+		` ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", xml.Header +
+		`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Target="xl/workbook.xml"` +
+		` Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", xml.Header +
+		`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"` +
+		` xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Sheet1" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", xml.Header +
+		`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Target="worksheets/sheet1.xml"` +
+		` Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet"/>` +
+		`</Relationships>`},
+}
+
+// encodeXLSX writes a workbook of one sheet, whose cells are inline strings,
+// so the rows are written as they come instead of being collected into a shared strings table.
+func encodeXLSX(w io.Writer, columns []string, next RowFunc) error {
+	zw := zip.NewWriter(w)
+	for _, part := range xlsxParts {
+		dst, err := zw.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err = io.WriteString(dst, part.content); err != nil {
+			return err
+		}
+	}
+
+	sheet, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	if _, err = io.WriteString(sheet, xml.Header+
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`); err != nil {
+		return err
+	}
+	rows := 0
+	err = eachRow(columns, next, func(row []string) error {
+		if rows++; rows > maxXLSXRows {
+			return fmt.Errorf("too many rows, a sheet has at most %d rows", maxXLSXRows)
+		}
+		var b strings.Builder
+		b.WriteString(`<row r="` + strconv.Itoa(rows) + `">`)
+		for _, cell := range row {
+			b.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+			_ = xml.EscapeText(&b, []byte(cell))
+			b.WriteString(`</t></is></c>`)
+		}
+		b.WriteString(`</row>`)
+		_, err := io.WriteString(sheet, b.String())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = io.WriteString(sheet, `</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// MessageRows returns a RowFunc rendering the messages returned by next, e.g. the Recv of a gRPC stream,
+// with one column per field of fields, by proto name. next returns io.EOF after the last message.
+// Enums are rendered by name, bytes as base64, messages as JSON, repeated and map fields as "; " separated items.
+// Unknown fields are rendered empty.
+func MessageRows[M proto.Message](next func() (M, error), fields ...string) RowFunc {
+	return func() ([]string, error) {
+		message, err := next()
+		if err != nil {
+			return nil, err
+		}
+		m := message.ProtoReflect()
+		row := make([]string, len(fields))
+		for i, field := range fields {
+			if fd := m.Descriptor().Fields().ByName(protoreflect.Name(field)); fd != nil {
+				row[i] = formatField(fd, m.Get(fd))
+			}
+		}
+		return row, nil
+	}
+}
+
+func formatField(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	switch {
+	case fd.IsList():
+		list := v.List()
+		items := make([]string, list.Len())
+		for i := range items {
+			items[i] = formatValue(fd, list.Get(i))
+		}
+		return strings.Join(items, "; ")
+	case fd.IsMap():
+		var items []string
+		v.Map().Range(func(key protoreflect.MapKey, value protoreflect.Value) bool {
+			items = append(items, key.String()+"="+formatValue(fd.MapValue(), value))
+			return true
+		})
+		slices.Sort(items)
+		return strings.Join(items, "; ")
+	}
+	return formatValue(fd, v)
+}
+
+func formatValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	switch fd.Kind() {
+	case protoreflect.EnumKind:
+		if value := fd.Enum().Values().ByNumber(v.Enum()); value != nil {
+			return string(value.Name())
+		}
+		return strconv.Itoa(int(v.Enum()))
+	case protoreflect.BytesKind:
+		return base64.StdEncoding.EncodeToString(v.Bytes())
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		data, _ := protojson.Marshal(v.Message().Interface())
+		return string(data)
+	}
+	return v.String()
+}
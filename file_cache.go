@@ -0,0 +1,154 @@
+package gatewayfile
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFileCacheMaxFileSize = 64 << 10 // 64 KB
+	defaultFileCacheTTL         = time.Minute
+	defaultFileCacheMaxEntries  = 1024
+)
+
+// FileCacheConfig configures a FileCache.
+type FileCacheConfig struct {
+	// MaxFileSize is the maximum size of a cached content in bytes, defaults to 64 KB.
+	// Larger contents are served from their provider as usual.
+	MaxFileSize int64
+	// TTL is how long a content is served from memory before it's revalidated against its provider,
+	// defaults to 1 minute.
+	TTL time.Duration
+	// MaxEntries is the maximum number of cached contents, defaults to 1024.
+	MaxEntries int
+}
+
+// FileCache caches whole small contents in memory, so hot icons or thumbnails are served from RAM instead of
+// hitting the filesystem or the object store on every request. It's safe for concurrent use.
+type FileCache struct {
+	cfg FileCacheConfig
+
+	mu      sync.Mutex
+	entries map[string]*fileCacheEntry
+}
+
+type fileCacheEntry struct {
+	data    []byte
+	info    ContentInfo
+	expires time.Time
+}
+
+// NewFileCache returns a new FileCache.
+func NewFileCache(cfg FileCacheConfig) *FileCache {
+	if cfg.MaxFileSize <= 0 {
+		cfg.MaxFileSize = defaultFileCacheMaxFileSize
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = defaultFileCacheTTL
+	}
+	if cfg.MaxEntries <= 0 {
+		cfg.MaxEntries = defaultFileCacheMaxEntries
+	}
+	return &FileCache{cfg: cfg, entries: make(map[string]*fileCacheEntry)}
+}
+
+// Provider returns a ContentProvider serving the content of provider from the cache, key identifies the content.
+// When the TTL expired, the provider is opened again, and the cached content is reused if its ModTime, ETag
+// and size didn't change.
+func (c *FileCache) Provider(key string, provider ContentProvider) ContentProvider {
+	return ContentProviderFunc(func(ctx context.Context) (io.ReadSeekCloser, ContentInfo, error) {
+		now := time.Now()
+		if entry := c.get(key); entry != nil && now.Before(entry.expires) {
+			return entry.open()
+		}
+
+		content, info, err := provider.Open(ctx)
+		if err != nil {
+			c.Invalidate(key)
+			return nil, ContentInfo{}, err
+		}
+		if info.Size > c.cfg.MaxFileSize {
+			c.Invalidate(key)
+			return content, info, nil
+		}
+		if entry := c.get(key); entry != nil && entry.matches(info) {
+			_ = content.Close()
+			c.put(key, &fileCacheEntry{data: entry.data, info: entry.info, expires: now.Add(c.cfg.TTL)})
+			return entry.open()
+		}
+
+		defer func() { _ = content.Close() }()
+		data, err := io.ReadAll(io.LimitReader(content, c.cfg.MaxFileSize+1))
+		if err != nil {
+			return nil, ContentInfo{}, err
+		}
+		if int64(len(data)) != info.Size {
+			// the content changed while reading it, serve it from the provider without caching it.
+			c.Invalidate(key)
+			return provider.Open(ctx)
+		}
+		entry := &fileCacheEntry{data: data, info: info, expires: now.Add(c.cfg.TTL)}
+		c.put(key, entry)
+		return entry.open()
+	})
+}
+
+// Invalidate removes the content from the cache.
+func (c *FileCache) Invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+func (c *FileCache) get(key string) *fileCacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries[key]
+}
+
+func (c *FileCache) put(key string, entry *fileCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.cfg.MaxEntries {
+		c.evictLocked()
+	}
+	c.entries[key] = entry
+}
+
+// evictLocked removes the expired entries, or the one expiring first if none expired.
+func (c *FileCache) evictLocked() {
+	now := time.Now()
+	var oldestKey string
+	var oldest *fileCacheEntry
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+			continue
+		}
+		if oldest == nil || entry.expires.Before(oldest.expires) {
+			oldestKey, oldest = key, entry
+		}
+	}
+	if len(c.entries) >= c.cfg.MaxEntries && oldest != nil {
+		delete(c.entries, oldestKey)
+	}
+}
+
+func (e *fileCacheEntry) open() (io.ReadSeekCloser, ContentInfo, error) {
+	return nopReadSeekCloser{bytes.NewReader(e.data)}, e.info, nil
+}
+
+// matches reports whether info describes the same version of the content as the entry.
+func (e *fileCacheEntry) matches(info ContentInfo) bool {
+	return e.info.Size == info.Size && e.info.ETag == info.ETag && e.info.ModTime.Equal(info.ModTime)
+}
+
+// nopReadSeekCloser is a io.ReadSeekCloser with a no-op Close.
+type nopReadSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopReadSeekCloser) Close() error { return nil }
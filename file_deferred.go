@@ -0,0 +1,167 @@
+package gatewayfile
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// DeferredWriter is an io.Writer for "generate then download" handlers.
+// It holds back SendHeader until the first byte is produced, so the handler can still change
+// the headers (or fail with a proper status code) while the content is being generated.
+//
+// If timeout is positive and nothing was written in time, the headers are committed anyway.
+// This starts the HTTP response, so proxies in front of the gateway don't hit their idle timeout
+// while waiting for the first byte. Headers modified after that point are ignored.
+//
+// It can't send 102 Processing or any other interim response as a keepalive: the gateway writes the response
+// header once, from the gRPC header of the stream, and the gRPC header can be sent only once, so there is no way
+// to relay an informational status before the final one. Committing the headers early is the only keepalive,
+// proxies then wait on their read timeout between body chunks, which the handler has to keep under.
+type DeferredWriter struct {
+	server downloadServer
+	header metadata.MD
+	writer *downloadServerWriter
+
+	mu    sync.Mutex
+	sent  bool
+	err   error
+	timer *time.Timer
+}
+
+// NewDeferredWriter returns a new DeferredWriter. contentType and name may be empty.
+// timeout is the maximum time to wait for the first byte before committing headers (0 = wait forever).
+func NewDeferredWriter(server downloadServer, contentType, name string, timeout time.Duration) *DeferredWriter {
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header := make(metadata.MD)
+	setContentType(header, contentType)
+	if name != "" {
+		header.Set(headerContentDisposition, contentDisposition("attachment", name))
+	}
+	header.Set(headerCode, strconv.Itoa(http.StatusOK))
+
+	w := &DeferredWriter{
+		server: server,
+		header: header,
+		writer: newDownloadServerWriter(server, contentType),
+	}
+	if timeout > 0 {
+		w.timer = time.AfterFunc(timeout, func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			_ = w.sendHeaderLocked()
+		})
+	}
+	return w
+}
+
+// Header returns a copy of the outgoing metadata which will be sent as response headers.
+// Change them with SetHeader or AddHeader, which are safe while the timeout commits the headers.
+func (w *DeferredWriter) Header() metadata.MD {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.header.Copy()
+}
+
+// SetHeader sets the values of the response header key. Calls after the headers were committed are ignored.
+func (w *DeferredWriter) SetHeader(key string, values ...string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.sent {
+		w.header.Set(key, values...)
+	}
+}
+
+// AddHeader appends values to the response header key. Calls after the headers were committed are ignored.
+func (w *DeferredWriter) AddHeader(key string, values ...string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.sent {
+		w.header.Append(key, values...)
+	}
+}
+
+// SetStatusCode sets the 2xx code of the response instead of 200, e.g. 202 Accepted when generating
+// only starts an asynchronous export. Invalid codes, and calls after the headers were committed, are ignored.
+func (w *DeferredWriter) SetStatusCode(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.sent && isSuccessCode(code) {
+		w.header.Set(headerCode, strconv.Itoa(code))
+	}
+}
+
+// Committed reports whether the headers have been sent.
+func (w *DeferredWriter) Committed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sent
+}
+
+// Write sends the headers if they are not sent yet, then sends data.
+func (w *DeferredWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.sendHeaderLocked(); err != nil {
+		return 0, err
+	}
+	return w.writer.Write(data)
+}
+
+// Close sends the headers if nothing was written, e.g. for an empty report.
+func (w *DeferredWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sendHeaderLocked()
+}
+
+// Fail responds with the given error text and status code.
+// It reports false if the headers are already committed, in which case the status can't be changed anymore.
+func (w *DeferredWriter) Fail(text string, code int) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.sent {
+		return false, w.err
+	}
+	w.stopTimer()
+	w.sent = true
+	w.err = serveError(w.server, w.header, text, code)
+	return true, w.err
+}
+
+func (w *DeferredWriter) sendHeaderLocked() error {
+	if w.sent {
+		return w.err
+	}
+	w.stopTimer()
+	w.sent = true
+	w.err = w.server.SendHeader(w.header)
+	return w.err
+}
+
+func (w *DeferredWriter) stopTimer() {
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+}
+
+// ServeGenerated runs generate with a DeferredWriter and streams whatever it produces.
+// If generate fails before the first byte is written, the client receives a 500 response with the error text
+// instead of a truncated 200 response.
+func ServeGenerated(
+	server downloadServer, contentType, name string, timeout time.Duration, generate func(w *DeferredWriter) error,
+) error {
+	w := NewDeferredWriter(server, contentType, name, timeout)
+	if err := generate(w); err != nil {
+		if ok, _ := w.Fail(err.Error(), http.StatusInternalServerError); ok {
+			return nil
+		}
+		return err
+	}
+	return w.Close()
+}
@@ -2,6 +2,7 @@ package gatewayfile
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
@@ -28,6 +29,9 @@ const (
 	headerIfNoneMatch       = "If-None-Match"
 	headerIfUnmodifiedSince = "If-Unmodified-Since"
 	headerIfModifiedSince   = "If-Modified-Since"
+	headerAcceptEncoding    = "Accept-Encoding"
+	// headerRequestContentLength is the size of an upload, see WithDiskSpaceCheck.
+	headerRequestContentLength = "Content-Length"
 )
 
 // response headers, We temporarily store them in metadata,
@@ -43,47 +47,73 @@ const (
 	headerLastModified        = "last-modified"
 	headerETag                = "etag"
 	headerCacheControl        = "cache-control"
+	headerExpires             = "expires"
 	headerXContentTypeOptions = "x-content-type-options"
 	headerTransferEncoding    = "transfer-encoding"
+	headerSurrogateKey        = "surrogate-key"
+	headerCacheTag            = "cache-tag"
+	headerVary                = "vary"
+	headerContentDigest       = "content-digest"
+	headerContentLanguage     = "content-language"
+	headerReprDigest          = "repr-digest"
+	headerContentMD5          = "content-md5"
+	headerXChunkSize          = "x-chunk-size"
+	headerXTotalSize          = "x-total-size"
+	headerGoogHash            = "x-goog-hash"
+	headerAmzChecksumCRC32C   = "x-amz-checksum-crc32c"
+	headerRetryAfter          = "retry-after"
+	headerLocation            = "location"
+	headerMetaPrefix          = "x-meta-" // prefix of custom object metadata, see ContentInfo.Metadata
 )
 
 // WithFileIncomingHeaderMatcher returns a ServeMuxOption representing a headerMatcher for incoming request to gateway.
 // This matcher will be called with each header in http.Request. If matcher returns true, that header will be passed
 // to gRPC context. To transform the header before passing to gRPC context, matcher should return modified header.
 func WithFileIncomingHeaderMatcher() runtime.ServeMuxOption {
-	return runtime.WithIncomingHeaderMatcher(func(key string) (string, bool) {
-		key = textproto.CanonicalMIMEHeaderKey(key)
-		switch key {
-		case headerRange,
-			headerIfRange,
-			headerIfMatch,
-			headerIfNoneMatch,
-			headerIfUnmodifiedSince,
-			headerIfModifiedSince:
-			return runtime.MetadataPrefix + key, true
-		default:
-			return runtime.DefaultHeaderMatcher(key)
-		}
-	})
+	return runtime.WithIncomingHeaderMatcher(fileHeaderMatcher)
+}
+
+func fileHeaderMatcher(key string) (string, bool) {
+	key = textproto.CanonicalMIMEHeaderKey(key)
+	switch key {
+	case headerRange,
+		headerIfRange,
+		headerIfMatch,
+		headerIfNoneMatch,
+		headerIfUnmodifiedSince,
+		headerIfModifiedSince,
+		headerAcceptEncoding,
+		headerXRequestID,
+		headerTraceparent,
+		headerRequestContentLength,
+		headerXUploadID,
+		headerXUploadOffset,
+		headerXUploadLength,
+		headerRequestContentRange:
+		return runtime.MetadataPrefix + key, true
+	default:
+		return runtime.DefaultHeaderMatcher(key)
+	}
 }
 
 // WithFileForwardResponseOption - forwardResponseOption is an option that will be called on the relevant
 // context.Context, http.ResponseWriter, and proto.Message before every forwarded response.
-func WithFileForwardResponseOption() runtime.ServeMuxOption {
-	headers := []string{
-		headerAcceptRanges,
-		headerContentType,
-		headerContentRange,
-		headerContentLength,
-		headerContentEncoding,
-		headerContentDisposition,
-		headerLastModified,
-		headerETag,
-		headerCacheControl,
-		headerXContentTypeOptions,
-		headerTransferEncoding,
-	}
+func WithFileForwardResponseOption(opts ...ForwardOption) runtime.ServeMuxOption {
+	o := newForwardOptions(opts)
 	return runtime.WithForwardResponseOption(func(ctx context.Context, writer http.ResponseWriter, message proto.Message) error {
+		o.control(writer, message)
+		if body, ok := message.(*httpbody.HttpBody); ok {
+			setStreamErrorTrailers(writer, body)
+			if n, ok := ctx.Value(negotiationKey{}).(*negotiation); ok && n.pending() {
+				return n.writeMetadata(writer, body.GetContentType())
+			}
+		}
+		if _, ok := message.(*httpbody.HttpBody); !ok && message != nil {
+			// the response of an upload.
+			if md, ok := runtime.ServerMetadataFromContext(ctx); ok {
+				return writeUnaryHeader(writer, md.HeaderMD)
+			}
+		}
 		if message != nil {
 			return nil
 		}
@@ -92,54 +122,198 @@ func WithFileForwardResponseOption() runtime.ServeMuxOption {
 		if !ok {
 			return fmt.Errorf("metadata not found")
 		}
-		for _, header := range headers {
-			if v := pick(md.HeaderMD, header); v != "" {
-				writer.Header().Set(header, v)
+		if n, ok := ctx.Value(negotiationKey{}).(*negotiation); ok && n.metadata && n.begin(md.HeaderMD) {
+			return nil
+		}
+		return o.writeHeader(writer, md.HeaderMD)
+	})
+}
+
+// forwardedHeaders are the response headers stored in metadata which are written to the response.
+var forwardedHeaders = []string{
+	headerAcceptRanges,
+	headerContentType,
+	headerContentRange,
+	headerContentLength,
+	headerContentEncoding,
+	headerContentDisposition,
+	headerLastModified,
+	headerETag,
+	headerCacheControl,
+	headerExpires,
+	headerXContentTypeOptions,
+	headerTransferEncoding,
+	headerSurrogateKey,
+	headerCacheTag,
+	headerVary,
+	headerContentLanguage,
+	headerContentLocation,
+	headerGoogHash,
+	headerAmzChecksumCRC32C,
+	headerXChunkSize,
+	headerXTotalSize,
+	headerXBlockSize,
+	headerReprDigest,
+	headerContentMD5,
+	headerRetryAfter,
+	headerLocation,
+}
+
+// writeHeader writes the response headers stored in the header metadata md, and the status code.
+func (o *forwardOptions) writeHeader(writer http.ResponseWriter, md metadata.MD) error {
+	h := writer.Header()
+	for _, header := range forwardedHeaders {
+		switch v := pick(md, header); {
+		case header == headerContentType:
+			if v = contentTypeOf(md); v != "" {
+				o.setHeader(h, header, v)
 			}
+		case v == "":
+		case header == headerVary:
+			// added to the Vary of the middlewares, e.g. Origin of CORS.
+			o.addHeader(h, header, v)
+		default:
+			o.setHeader(h, header, v)
 		}
-		if codeStr := pick(md.HeaderMD, headerCode); codeStr != "" {
-			code, err := strconv.Atoi(codeStr)
-			if err != nil {
-				return err
+	}
+	// the extra headers keep all their values, in order, e.g. several Link headers.
+	for _, header := range append(md.Get(headerFileHeaders), o.forwardedHeaders...) {
+		if values := md.Get(header); len(values) > 0 {
+			o.setHeader(h, header, values...)
+		}
+	}
+	for key := range md {
+		if name, ok := strings.CutPrefix(key, headerMetaPrefix); ok {
+			for _, prefix := range o.metadataPrefixes {
+				o.setHeader(h, prefix+name, pick(md, key))
 			}
-			writer.WriteHeader(code)
 		}
-		return nil
-	})
+	}
+	if codeStr := pick(md, headerCode); codeStr != "" {
+		code, err := strconv.Atoi(codeStr)
+		if err != nil {
+			return err
+		}
+		writer.WriteHeader(code)
+	}
+	flushHeaders(writer, md)
+	return nil
 }
 
 // ServeFile comes from http.ServeFile, and made some adaptations for DownloadServer
-func ServeFile(server downloadServer, contentType, path string) error {
+func ServeFile(server downloadServer, contentType, path string, opts ...ServeOption) error {
 	path = filepath.Clean(path)
-	file, err := os.Open(path)
-	if err != nil {
-		return err
+	o := newServeOptions(server.Context(), opts)
+	open := openFile
+	if o.directIO && !isProbeRequest(server.Context()) {
+		open = openDirect
 	}
-	defer func() { _ = file.Close() }()
-
-	info, err := file.Stat()
+	file, info, err := open(path)
 	if err != nil {
 		return err
 	}
+	closer := closeOnDone(server.Context(), file)
+	defer func() { _ = closer.Close() }()
+
 	if info.IsDir() {
 		return fmt.Errorf("invalid path %s", path)
 	}
-	return ServeContent(server, file, contentType, info.Name(), info.ModTime(), info.Size())
+	// the options are built once, the inherited ones and the callbacks of WithContextPolicy run once per download.
+	// No ServeOption sets the auto ETag or the digests, so applying them last doesn't override any.
+	withAutoETag(info.Size(), info.ModTime())(o)
+	if o.sidecarChecksums {
+		withReprDigests(readSidecarChecksums(path, info.ModTime()))(o)
+	}
+	return serveContentWithOptions(server, file, contentType, info.Name(), info.ModTime(), info.Size(), o)
+}
+
+// openFile opens the file for reading and returns its FileInfo.
+func openFile(path string) (io.ReadSeekCloser, os.FileInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, nil, err
+	}
+	return file, info, nil
 }
 
 // ServeContent comes from http.ServeContent, and made some adaptations for DownloadServer
-func ServeContent( //nolint:gocognit
+func ServeContent(
+	server downloadServer, content io.ReadSeeker, contentType, name string, modTime time.Time, size int64,
+	opts ...ServeOption,
+) error {
+	o := newServeOptions(server.Context(), opts)
+	return serveContentWithOptions(server, content, contentType, name, modTime, size, o)
+}
+
+// serveContentWithOptions is ServeContent with built options.
+func serveContentWithOptions(
+	server downloadServer, content io.ReadSeeker, contentType, name string, modTime time.Time, size int64,
+	o *serveOptions,
+) error {
+	return o.serve(server, name, func(server downloadServer) error {
+		return serveContent(server, content, contentType, name, modTime, size, o)
+	})
+}
+
+func serveContent( //nolint:gocognit
 	server downloadServer, content io.ReadSeeker, contentType, name string, modTime time.Time, size int64,
+	o *serveOptions,
 ) error {
 	outgoing := make(metadata.MD)
 	incoming, _ := metadata.FromIncomingContext(server.Context())
 
+	if o.retry != nil {
+		reader := newRetryReader(server.Context(), content, o.retry, o.reopen)
+		defer func() { _ = reader.Close() }()
+		content = reader
+	}
+	content = newContextReader(server.Context(), content)
+	for _, wrap := range o.wrapContents {
+		content = wrap(content)
+	}
+
 	setLastModified(outgoing, modTime)
+	for key, values := range o.header {
+		outgoing.Set(key, values...)
+	}
+	etag := o.etag
+	if etag == "" && o.etagStrategy != nil {
+		var err error
+		if etag, err = o.computeETag(content, size, modTime); err != nil {
+			return err
+		}
+	}
+	if etag == "" && !o.noAutoETag {
+		etag = o.autoETag
+	}
+	if etag != "" {
+		outgoing.Set(headerETag, etag)
+	}
+	if o.cacheProfile != nil {
+		o.cacheProfile.apply(outgoing)
+	}
+	setChunkHints(outgoing, o.chunkSize, size)
+	setBlockSize(outgoing, o.blockSize)
+	info := DownloadInfo{Name: name, ContentType: contentType, Size: size, ModTime: modTime, ETag: etag}
+	if err := o.checkPreSend(server.Context(), info); err != nil {
+		return err
+	}
 	done, rangeReq := checkPreconditions(outgoing, incoming, modTime)
 	if done {
 		return serveDone(server, outgoing)
 	}
+	if o.strongResume && rangeReq != "" && !checkStrongIfRange(outgoing, incoming) {
+		rangeReq = ""
+	}
 
+	if contentType == "" && o.explicitContentType {
+		contentType = "application/octet-stream"
+	}
 	if contentType == "" {
 		contentType = mime.TypeByExtension(filepath.Ext(name))
 		if contentType == "" {
@@ -152,11 +326,15 @@ func ServeContent( //nolint:gocognit
 				return serveError(server, outgoing, "seeker can't seek", http.StatusInternalServerError)
 			}
 		}
-		outgoing.Set(headerContentType, contentType)
 	}
+	// the status code is written with the headers, before the first message could set its content type.
+	setContentType(outgoing, contentType)
 
 	// handle Content-Range header.
 	ranges, err := parseRange(rangeReq, size)
+	if err == nil && o.strictRange {
+		err = validateRanges(ranges, size, o.maxRanges)
+	}
 	switch err {
 	case nil:
 	case ErrNoOverlap:
@@ -168,10 +346,14 @@ func ServeContent( //nolint:gocognit
 			ranges = nil
 			break
 		}
-		outgoing.Set(headerContentRange, fmt.Sprintf("bytes */%d", size))
-		fallthrough
+		return serveRangeNotSatisfiable(server, outgoing, o, rangeReq, size, err)
+	case ErrInvalidRange:
+		if o.strictRange {
+			return serveError(server, outgoing, err.Error(), http.StatusBadRequest)
+		}
+		return serveRangeNotSatisfiable(server, outgoing, o, rangeReq, size, err)
 	default:
-		return serveError(server, outgoing, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return serveRangeNotSatisfiable(server, outgoing, o, rangeReq, size, err)
 	}
 
 	if sumRangesSize(ranges) > size {
@@ -181,14 +363,35 @@ func ServeContent( //nolint:gocognit
 		// dumb client. Ignore the range request.
 		ranges = nil
 	}
+	ranges = alignRanges(ranges, o.blockSize, size)
+	var rangeContent io.Reader
+	if len(ranges) == 1 {
+		if rangeContent, err = o.openRange(content, ranges[0]); err != nil {
+			full, current, fallbackErr := o.fallBack(server.Context(), content, size, err)
+			if fallbackErr != nil {
+				return fallbackErr
+			}
+			if !full {
+				return serveRangeNotSatisfiable(server, outgoing, o, rangeReq, current, ErrRangeRejected)
+			}
+			ranges = nil
+		}
+	}
+	for _, f := range o.onRanges {
+		f(ranges, size)
+	}
 
 	var (
-		sendCode              = http.StatusOK
+		sendCode              = o.okCode()
 		sendContent io.Reader = content
 		sendSize              = size
 	)
 	if name != "" {
-		outgoing.Set(headerContentDisposition, fmt.Sprintf("attachment; filename=%s", name))
+		disposition := "attachment"
+		if o.inline {
+			disposition = "inline"
+		}
+		outgoing.Set(headerContentDisposition, contentDisposition(disposition, name))
 	}
 
 	switch {
@@ -205,9 +408,7 @@ func ServeContent( //nolint:gocognit
 		// does not request multiple parts might not support
 		// multipart responses."
 		ra := ranges[0]
-		if _, err = content.Seek(ra.start, io.SeekStart); err != nil {
-			return err
-		}
+		sendContent = rangeContent
 		sendSize = ra.length
 		sendCode = http.StatusPartialContent
 		outgoing.Set(headerContentRange, ra.contentRange(size))
@@ -216,32 +417,54 @@ func ServeContent( //nolint:gocognit
 		sendCode = http.StatusPartialContent
 
 		pReader, pWriter := io.Pipe()
-		mWriter := multipart.NewWriter(newDownloadServerWriter(server, contentType))
+		mWriter := multipart.NewWriter(pWriter)
 
-		outgoing.Set(headerContentType, "multipart/byteranges; boundary="+mWriter.Boundary())
+		setContentType(outgoing, "multipart/byteranges; boundary="+mWriter.Boundary())
 		sendContent = pReader
 		defer func() { _ = pReader.Close() }() // cause writing goroutine to fail and exit if CopyN doesn't finish.
 		go func() {
-			for _, ra := range ranges {
-				part, err := mWriter.CreatePart(ra.mimeHeader(contentType, size))
-				if err != nil {
-					_ = pWriter.CloseWithError(err)
-					return
-				}
-				if _, err := content.Seek(ra.start, io.SeekStart); err != nil {
-					_ = pWriter.CloseWithError(err)
-					return
-				}
-				if _, err := io.CopyN(part, content, ra.length); err != nil {
-					_ = pWriter.CloseWithError(err)
-					return
-				}
+			var err error
+			if o.readerAt != nil {
+				err = writeRangesAt(server.Context(), mWriter, o.readerAt, ranges, contentType, size)
+			} else {
+				err = writeRanges(mWriter, content, ranges, contentType, size)
+			}
+			if err == nil {
+				err = mWriter.Close()
 			}
-			_ = mWriter.Close()
-			_ = pWriter.Close()
+			_ = pWriter.CloseWithError(err)
 		}()
 	}
 
+	var encoding string
+	if o.compression != nil {
+		addVary(outgoing, headerAcceptEncoding)
+		if len(ranges) == 0 {
+			encoding, err = o.compression.negotiate(incoming, contentType, content, size)
+			if errors.Is(err, ErrNotAcceptable) {
+				return serveError(server, outgoing, err.Error(), http.StatusNotAcceptable)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if encoding != "" {
+		outgoing.Set(headerContentEncoding, encoding)
+		// the encoded bytes differ from the identity ones, so the ETag is weak: If-Range and the caches combining
+		// ranges never take one for the other, see RFC 9110 section 8.8.1.
+		if etag := pick(outgoing, headerETag); etag != "" && !strings.HasPrefix(etag, "W/") {
+			outgoing.Set(headerETag, "W/"+etag)
+		}
+	} else {
+		// digests describe the unencoded representation.
+		setReprDigests(outgoing, o.reprDigests, len(ranges) == 0)
+		if len(ranges) == 0 {
+			// checksums describe the whole representation.
+			setChecksumHeaders(outgoing, o.checksums)
+		}
+	}
+
 	outgoing.Set(headerAcceptRanges, "bytes")
 	// We should be able to unconditionally set the Content-Length here.
 	//
@@ -277,17 +500,111 @@ func ServeContent( //nolint:gocognit
 	if err = server.SendHeader(outgoing); err != nil {
 		return err
 	}
-	_, err = io.CopyN(newDownloadServerWriter(server, contentType), sendContent, sendSize)
+	writer := o.newWriter(server, contentType)
+	if len(ranges) == 1 && sendSize <= probeRangeSize {
+		return o.done(server, sendProbe(writer, sendContent, sendSize))
+	}
+	return o.done(server, copyContent(writer, sendContent, sendSize, encoding, o.compression))
+}
+
+// writeRanges writes the parts of a multipart/byteranges body, seeking content to each range.
+func writeRanges(
+	mWriter *multipart.Writer, content io.ReadSeeker, ranges []httpRange, contentType string, size int64,
+) error {
+	for _, ra := range ranges {
+		part, err := mWriter.CreatePart(ra.mimeHeader(contentType, size))
+		if err != nil {
+			return err
+		}
+		if _, err := content.Seek(ra.start, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(part, content, ra.length); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// probeRangeSize is the maximum size of the probe ranges served by sendProbe.
+const probeRangeSize = 4 << 10
+
+// isProbeRequest reports whether the request is a probe, i.e. has a single bounded range of at most probeRangeSize,
+// so it's not worth the read-ahead of direct I/O.
+func isProbeRequest(ctx context.Context) bool {
+	incoming, _ := metadata.FromIncomingContext(ctx)
+	spec, ok := strings.CutPrefix(pickHeader(incoming, headerRange), "bytes=")
+	if !ok {
+		return false
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	start, startErr := strconv.ParseInt(first, 10, 64)
+	end, endErr := strconv.ParseInt(last, 10, 64)
+	return ok && startErr == nil && endErr == nil && start <= end && end-start < probeRangeSize
+}
+
+// sendProbe sends a tiny range, e.g. the "Range: bytes=0-1" probes of media players,
+// in one read and one message, instead of taking a pooled chunk of defaultBufSize for a few bytes.
+func sendProbe(writer io.Writer, content io.Reader, size int64) error {
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(content, buf); err != nil {
+		return err
+	}
+	_, err := writer.Write(buf)
 	return err
 }
 
+// addVary adds name to the Vary header of outgoing, unless it's already listed.
+func addVary(outgoing metadata.MD, name string) {
+	vary := pick(outgoing, headerVary)
+	for _, listed := range strings.Split(vary, ",") {
+		if strings.EqualFold(strings.TrimSpace(listed), name) {
+			return
+		}
+	}
+	if vary != "" {
+		name = vary + ", " + name
+	}
+	outgoing.Set(headerVary, name)
+}
+
+// copyContent copies size bytes from content to writer, compressing them with encoding if it's not empty.
+func copyContent(writer io.Writer, content io.Reader, size int64, encoding string, cfg *CompressionConfig) error {
+	if encoding == "" {
+		_, err := io.CopyN(writer, content, size)
+		return err
+	}
+	var compressed countingWriter
+	encoder, err := newEncoder(encoding, io.MultiWriter(writer, &compressed), cfg.Level)
+	if err != nil {
+		return err
+	}
+	if _, err = io.CopyN(encoder, content, size); err != nil {
+		return err
+	}
+	if err = encoder.Close(); err != nil {
+		return err
+	}
+	cfg.Stats.compressed(encoding, size, int64(compressed))
+	return nil
+}
+
 func serveDone(server downloadServer, outgoing metadata.MD) error {
 	return server.SendHeader(outgoing)
 }
 
 func serveError(server downloadServer, outgoing metadata.MD, text string, code int) error {
+	if id := RequestID(server.Context()); id != "" {
+		text = fmt.Sprintf("%s (request id %s)", text, id)
+	}
+	return serveErrorBody(server, outgoing, "text/plain; charset=utf-8", []byte(text), code)
+}
+
+// serveErrorBody responds with the given error body and status code.
+func serveErrorBody(server downloadServer, outgoing metadata.MD, contentType string, body []byte, code int) error {
 	for _, k := range []string{
 		headerCacheControl,
+		headerExpires,
 		headerContentEncoding,
 		headerETag,
 		headerLastModified,
@@ -299,8 +616,7 @@ func serveError(server downloadServer, outgoing metadata.MD, text string, code i
 		outgoing.Delete(k)
 	}
 
-	contentType := "text/plain; charset=utf-8"
-	outgoing.Set(headerContentType, contentType)
+	setContentType(outgoing, contentType)
 	outgoing.Set(headerXContentTypeOptions, "nosniff")
 	outgoing.Set(headerCode, strconv.Itoa(code))
 
@@ -309,7 +625,7 @@ func serveError(server downloadServer, outgoing metadata.MD, text string, code i
 	}
 	return server.Send(&httpbody.HttpBody{
 		ContentType: contentType,
-		Data:        []byte(text),
+		Data:        body,
 	})
 }
 
@@ -364,7 +680,7 @@ const (
 )
 
 func checkIfMatch(outgoing, incoming metadata.MD) condResult {
-	im := pick(incoming, headerIfMatch)
+	im := pickHeader(incoming, headerIfMatch)
 	if im == "" {
 		return condNone
 	}
@@ -394,7 +710,7 @@ func checkIfMatch(outgoing, incoming metadata.MD) condResult {
 }
 
 func checkIfUnmodifiedSince(incoming metadata.MD, modtime time.Time) condResult {
-	ius := pick(incoming, headerIfUnmodifiedSince)
+	ius := pickHeader(incoming, headerIfUnmodifiedSince)
 	if ius == "" || isZeroTime(modtime) {
 		return condNone
 	}
@@ -413,7 +729,7 @@ func checkIfUnmodifiedSince(incoming metadata.MD, modtime time.Time) condResult
 }
 
 func checkIfNoneMatch(outgoing, incoming metadata.MD) condResult {
-	inm := pick(incoming, headerIfNoneMatch)
+	inm := pickHeader(incoming, headerIfNoneMatch)
 	if inm == "" {
 		return condNone
 	}
@@ -443,7 +759,7 @@ func checkIfNoneMatch(outgoing, incoming metadata.MD) condResult {
 }
 
 func checkIfModifiedSince(incoming metadata.MD, modtime time.Time) condResult {
-	ims := pick(incoming, headerIfModifiedSince)
+	ims := pickHeader(incoming, headerIfModifiedSince)
 	if ims == "" || isZeroTime(modtime) {
 		return condNone
 	}
@@ -461,7 +777,7 @@ func checkIfModifiedSince(incoming metadata.MD, modtime time.Time) condResult {
 }
 
 func checkIfRange(outgoing, incoming metadata.MD, modtime time.Time) condResult {
-	ir := pick(incoming, headerIfRange)
+	ir := pickHeader(incoming, headerIfRange)
 	if ir == "" {
 		return condNone
 	}
@@ -488,6 +804,12 @@ func checkIfRange(outgoing, incoming metadata.MD, modtime time.Time) condResult
 	return condFalse
 }
 
+// checkStrongIfRange reports whether If-Range carries an ETag which strongly matches the content's ETag.
+func checkStrongIfRange(outgoing, incoming metadata.MD) bool {
+	etag, _ := scanETag(pickHeader(incoming, headerIfRange))
+	return etag != "" && eTagStrongMatch(etag, pick(outgoing, headerETag))
+}
+
 var unixEpochTime = time.Unix(0, 0)
 
 // isZeroTime reports whether t is obviously unspecified (either zero or Unix()=0).
@@ -508,6 +830,7 @@ func writeNotModified(outgoing metadata.MD) {
 	// guiding cache updates (e.g., Last-Modified might be useful if the
 	// response does not have an ETag field).
 	outgoing.Delete(headerContentType)
+	outgoing.Delete(headerFileContentType)
 	outgoing.Delete(headerContentLength)
 	outgoing.Delete(headerContentEncoding)
 	if pick(outgoing, headerETag) != "" {
@@ -540,7 +863,7 @@ func checkPreconditions(outgoing, incoming metadata.MD, modTime time.Time) (done
 		}
 	}
 
-	rangeHeader = pick(incoming, headerRange)
+	rangeHeader = pickHeader(incoming, headerRange)
 	if rangeHeader != "" && checkIfRange(outgoing, incoming, modTime) == condFalse {
 		rangeHeader = ""
 	}
@@ -663,6 +986,23 @@ func rangesMIMESize(ranges []httpRange, contentType string, contentSize int64) (
 	return
 }
 
+// validateRanges checks the ranges in strict range mode.
+func validateRanges(ranges []httpRange, size int64, maxRanges int) error {
+	if maxRanges > 0 && len(ranges) > maxRanges {
+		return ErrTooManyRanges
+	}
+	if sumRangesSize(ranges) > size {
+		return ErrTooManyRanges
+	}
+	for i := 1; i < len(ranges); i++ {
+		prev := ranges[i-1]
+		if ranges[i].start < prev.start+prev.length {
+			return ErrOverlappingRanges
+		}
+	}
+	return nil
+}
+
 func sumRangesSize(ranges []httpRange) (size int64) {
 	for _, ra := range ranges {
 		size += ra.length
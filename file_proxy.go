@@ -0,0 +1,115 @@
+package gatewayfile
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/metadata"
+)
+
+// request headers relayed to the upstream by ServeURL.
+var proxyRequestHeaders = []string{
+	headerAcceptEncoding,
+	headerRange,
+	headerIfRange,
+	headerIfMatch,
+	headerIfNoneMatch,
+	headerIfUnmodifiedSince,
+	headerIfModifiedSince,
+}
+
+// response headers relayed from the upstream by ServeURL.
+var proxyResponseHeaders = []string{
+	headerAcceptRanges,
+	headerContentType,
+	headerContentEncoding,
+	headerContentRange,
+	headerContentDisposition,
+	headerContentLanguage,
+	headerLastModified,
+	headerETag,
+	headerCacheControl,
+	headerGoogHash,
+	headerAmzChecksumCRC32C,
+	headerVary,
+}
+
+// ServeURL fetches the content from an upstream HTTP source and relays it through the gRPC stream,
+// e.g. to proxy files during a migration. client may be nil to use http.DefaultClient.
+// Range, conditional and Accept-Encoding request headers are forwarded upstream, the status code, the validator
+// headers and the Content-Encoding of the upstream response are copied downstream, as well as its custom object
+// metadata (x-amz-meta-*...): an encoded body is relayed as is.
+func ServeURL(server downloadServer, client *http.Client, url string, opts ...ServeOption) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	o := newServeOptions(server.Context(), opts)
+	return o.serve(server, url, func(server downloadServer) error {
+		return serveURL(server, client, url, o)
+	})
+}
+
+func serveURL(server downloadServer, client *http.Client, url string, o *serveOptions) error {
+	incoming, _ := metadata.FromIncomingContext(server.Context())
+
+	req, err := http.NewRequestWithContext(server.Context(), http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	for _, key := range proxyRequestHeaders {
+		if v := pickHeader(incoming, key); v != "" {
+			req.Header.Set(key, v)
+		}
+	}
+	if req.Header.Get(headerAcceptEncoding) == "" {
+		// otherwise the Transport asks for gzip and decodes it, see Response.Uncompressed.
+		req.Header.Set(headerAcceptEncoding, "identity")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// the error may tell about the upstream, e.g. its address, it's only logged.
+		grpclog.Errorf("gatewayfile: fetch upstream content failed: %v", err)
+		return serveError(server, make(metadata.MD), http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	outgoing := make(metadata.MD)
+	for _, key := range proxyResponseHeaders {
+		if v := resp.Header.Get(key); v != "" {
+			outgoing.Set(key, v)
+		}
+	}
+	if contentType := resp.Header.Get(headerContentType); contentType != "" {
+		setContentType(outgoing, contentType)
+	}
+	copyMetadataHeaders(outgoing, resp.Header)
+	if resp.Uncompressed {
+		// a client whose Transport decoded the body anyway: the ranges and the validators describe the encoded one.
+		outgoing.Delete(headerAcceptRanges)
+		outgoing.Delete(headerContentRange)
+		if etag := pick(outgoing, headerETag); etag != "" && !strings.HasPrefix(etag, "W/") {
+			outgoing.Set(headerETag, "W/"+etag)
+		}
+	}
+	if resp.ContentLength >= 0 {
+		outgoing.Set(headerContentLength, strconv.FormatInt(resp.ContentLength, 10))
+		outgoing.Set(headerTransferEncoding, "identity")
+	}
+	outgoing.Set(headerCode, strconv.Itoa(resp.StatusCode))
+
+	if err = server.SendHeader(outgoing); err != nil {
+		return err
+	}
+	var body io.Reader = resp.Body
+	if resp.StatusCode == http.StatusOK && resp.Header.Get(headerContentEncoding) == "" && !resp.Uncompressed {
+		// checksums of the upstream describe the whole object, verify them while relaying it.
+		body = newChecksumVerifier(body, ParseChecksumHeaders(resp.Header))
+	}
+	writer := o.newWriter(server, resp.Header.Get(headerContentType))
+	_, err = io.Copy(writer, body)
+	return o.done(server, err)
+}
@@ -0,0 +1,119 @@
+package gatewayfile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeURL(t *testing.T) {
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	_, _ = zw.Write([]byte("hello"))
+	_ = zw.Close()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Set("Vary", "Accept-Encoding")
+			w.Header().Set("ETag", `"gz"`)
+			_, _ = w.Write(gzipped.Bytes())
+			return
+		}
+		w.Header().Set("ETag", `"id"`)
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name         string
+		incoming     []string
+		client       *http.Client
+		wantCode     string
+		wantEncoding string
+		wantETag     string
+		wantBody     []byte
+	}{
+		{name: "identity", wantCode: "200", wantETag: `"id"`, wantBody: []byte("hello")},
+		{
+			name:         "encoded",
+			incoming:     []string{"grpcgateway-accept-encoding", "gzip"},
+			wantCode:     "200",
+			wantEncoding: "gzip",
+			wantETag:     `"gz"`,
+			wantBody:     gzipped.Bytes(),
+		},
+		{
+			name:     "decoded by the Transport",
+			client:   &http.Client{Transport: &decodingTransport{}},
+			wantCode: "200",
+			wantETag: `W/"gz"`,
+			wantBody: []byte("hello"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeDownloadServer(tt.incoming...)
+			if err := ServeURL(server, tt.client, upstream.URL); err != nil {
+				t.Fatal(err)
+			}
+			header := server.header
+			if got := pick(header, headerCode); got != tt.wantCode {
+				t.Fatalf("got code %s, want %s", got, tt.wantCode)
+			}
+			if got := pick(header, headerContentEncoding); got != tt.wantEncoding {
+				t.Fatalf("got Content-Encoding %q, want %q", got, tt.wantEncoding)
+			}
+			if got := pick(header, headerETag); got != tt.wantETag {
+				t.Fatalf("got ETag %q, want %q", got, tt.wantETag)
+			}
+			if !bytes.Equal(server.body.Bytes(), tt.wantBody) {
+				t.Fatalf("got body %q, want %q", server.body.Bytes(), tt.wantBody)
+			}
+		})
+	}
+}
+
+// decodingTransport asks for gzip and decodes it, like http.Transport does when there is no Accept-Encoding.
+type decodingTransport struct{}
+
+func (decodingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil || resp.Header.Get("Content-Encoding") != "gzip" {
+		return resp, err
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{zr, resp.Body}
+	return resp, nil
+}
+
+func TestServeURLUnreachable(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	upstream.Close()
+
+	server := newFakeDownloadServer()
+	if err := ServeURL(server, nil, upstream.URL+"/secret/path"); err != nil {
+		t.Fatal(err)
+	}
+	if got := pick(server.header, headerCode); got != "502" {
+		t.Fatalf("got code %s, want 502", got)
+	}
+	if body := server.body.String(); body != "Bad Gateway" {
+		t.Fatalf("got body %q, want the status text only", body)
+	}
+}
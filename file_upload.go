@@ -1,15 +1,21 @@
 package gatewayfile
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"mime"
 	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 )
 
@@ -57,19 +63,68 @@ func SaveMultipartFile(header *multipart.FileHeader, path string) error {
 	return nil
 }
 
+// saveNewMultipartFile saves the multipart file to path like SaveMultipartFile, but fails with an fs.ErrExist
+// error instead of replacing an existing file. A spilled file is linked rather than renamed, which never replaces.
+func saveNewMultipartFile(header *multipart.FileHeader, path string) error {
+	path = filepath.Clean(path)
+	file, err := header.Open()
+	if err != nil {
+		return fmt.Errorf("open file failed %w", err)
+	}
+	defer func() { _ = file.Close() }()
+	if f, ok := file.(*os.File); ok {
+		// linking could fail if the files are on different devices, they're copied then.
+		if err = os.Link(f.Name(), path); err == nil || errors.Is(err, fs.ErrExist) {
+			return err
+		}
+	}
+
+	output, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("create output file failed %w", err)
+	}
+	if _, err = io.Copy(output, file); err != nil {
+		_ = output.Close()
+		_ = os.Remove(path)
+		return fmt.Errorf("copy file failed %w", err)
+	}
+	return output.Close()
+}
+
+var (
+	// ErrMissingValue is returned by the typed getters of FormData when the form has no value for the key.
+	ErrMissingValue = newError(codes.InvalidArgument, "MISSING_FORM_VALUE", "missing form value")
+	// ErrInvalidValue is returned by the typed getters of FormData when the value can't be parsed.
+	ErrInvalidValue = newError(codes.InvalidArgument, "INVALID_FORM_VALUE", "invalid form value")
+)
+
 // FormData is a wrapper around multipart.Form.
 type FormData struct {
-	form *multipart.Form
+	form         *multipart.Form
+	digests      map[*multipart.FileHeader]Digests
+	onStored     []func(ctx context.Context, file *StreamedFile)
+	pathTemplate *PathTemplate
 }
 
 // NewFormData returns a new FormData.
 // sizeLimit is the maximum size of the form data in bytes (0 = unlimited).
-func NewFormData(server uploadServer, sizeLimit int64) (*FormData, error) {
-	form, err := parseMultipartForm(server, sizeLimit)
+func NewFormData(server uploadServer, sizeLimit int64, opts ...FormDataOption) (*FormData, error) {
+	o := newFormDataOptions(opts)
+	form, digests, err := parseMultipartForm(server, sizeLimit, o)
+	err = o.limitErr(err)
+	if err == nil {
+		err = o.validateForm(form)
+	}
+	o.finish(server.Context(), formFileNames(form), err)
 	if err != nil {
-		return nil, fmt.Errorf("parse multipart form failed %w", err)
+		return nil, withRequestID(server.Context(), fmt.Errorf("parse multipart form failed %w", err))
 	}
-	return &FormData{form: form}, nil
+	return &FormData{form: form, digests: digests, onStored: o.onStored, pathTemplate: o.pathTemplate}, nil
+}
+
+// Digests returns the digests of the provided file, computed while parsing the form, see WithUploadDigest.
+func (f *FormData) Digests(header *multipart.FileHeader) Digests {
+	return f.digests[header]
 }
 
 // Files returns the files for the provided form key
@@ -108,6 +163,103 @@ func (f *FormData) FirstValue(key string) string {
 	return values[0]
 }
 
+// IntValue parses the first value of key as a base 10 integer.
+// It fails with ErrMissingValue if there is no value, ErrInvalidValue if it's not an integer.
+func (f *FormData) IntValue(key string) (int64, error) {
+	return parseValue(f, key, func(value string) (int64, error) {
+		return strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	})
+}
+
+// FloatValue parses the first value of key as a floating-point number.
+// It fails with ErrMissingValue if there is no value, ErrInvalidValue if it's not a number.
+func (f *FormData) FloatValue(key string) (float64, error) {
+	return parseValue(f, key, func(value string) (float64, error) {
+		return strconv.ParseFloat(strings.TrimSpace(value), 64)
+	})
+}
+
+// BoolValue parses the first value of key as a boolean, see strconv.ParseBool. "on" and "off" are accepted too,
+// since "on" is what browsers send for a checked checkbox without value.
+// It fails with ErrMissingValue if there is no value, ErrInvalidValue if it's not a boolean.
+func (f *FormData) BoolValue(key string) (bool, error) {
+	return parseValue(f, key, func(value string) (bool, error) {
+		switch value = strings.TrimSpace(value); strings.ToLower(value) {
+		case "on":
+			return true, nil
+		case "off":
+			return false, nil
+		}
+		return strconv.ParseBool(value)
+	})
+}
+
+// TimeValue parses the first value of key as a time with the given layout, see time.Parse, e.g. time.RFC3339.
+// It fails with ErrMissingValue if there is no value, ErrInvalidValue if it doesn't match the layout.
+func (f *FormData) TimeValue(key, layout string) (time.Time, error) {
+	return parseValue(f, key, func(value string) (time.Time, error) {
+		return time.Parse(layout, strings.TrimSpace(value))
+	})
+}
+
+func parseValue[T any](f *FormData, key string, parse func(value string) (T, error)) (T, error) {
+	var zero T
+	values := f.Values(key)
+	if len(values) == 0 {
+		return zero, fmt.Errorf("%w: %s", ErrMissingValue, key)
+	}
+	v, err := parse(values[0])
+	if err != nil {
+		return zero, fmt.Errorf("%w: %s: %v", ErrInvalidValue, key, err)
+	}
+	return v, nil
+}
+
+// SaveAll saves all the files of the form into dir, named by the base name of their filename or laid out by
+// WithPathTemplate, and calls the WithOnStored callbacks with each file once they're all saved.
+// Files are saved by field name order. It never replaces an existing file, e.g. saved before or by another file of
+// the same name: it fails with an fs.ErrExist error instead.
+func (f *FormData) SaveAll(ctx context.Context, dir string) ([]*StreamedFile, error) {
+	var saved []*StreamedFile
+	for _, field := range sortedKeys(f.form.File) {
+		for _, header := range f.form.File[field] {
+			path, err := f.savePath(ctx, dir, field, header)
+			if err != nil {
+				return saved, err
+			}
+			if err = saveNewMultipartFile(header, path); err != nil {
+				return saved, err
+			}
+			saved = append(saved, &StreamedFile{
+				Field:       field,
+				Filename:    header.Filename,
+				ContentType: header.Header.Get("Content-Type"),
+				Size:        header.Size,
+				Destination: path,
+				Digests:     f.digests[header],
+			})
+		}
+	}
+	for _, file := range saved {
+		for _, stored := range f.onStored {
+			stored(ctx, file)
+		}
+	}
+	return saved, nil
+}
+
+// savePath returns the path SaveAll saves the file of field to.
+func (f *FormData) savePath(ctx context.Context, dir, field string, header *multipart.FileHeader) (string, error) {
+	if f.pathTemplate != nil {
+		return f.pathTemplate.templatePath(ctx, dir, header, field)
+	}
+	name, err := baseFilename(header.Filename)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
 // RemoveAll removes any temporary files associated with a from data
 func (f *FormData) RemoveAll() error {
 	return f.form.RemoveAll()
@@ -116,14 +268,32 @@ func (f *FormData) RemoveAll() error {
 // ProcessMultipartUpload processes the provided multipart upload. The provided function is called for each part.
 // sizeLimit is the maximum size of the form data in bytes (0 = unlimited).
 // Useful for forwarding multipart requests to another server without saving them locally or in memory.
-func ProcessMultipartUpload(server uploadServer, f func(part *multipart.Part) error, sizeLimit int64) error {
+func ProcessMultipartUpload(
+	server uploadServer, f func(part *multipart.Part) error, sizeLimit int64, opts ...FormDataOption,
+) error {
+	o := newFormDataOptions(opts)
+	body := o.newReader(server, sizeLimit)
 	md, _ := metadata.FromIncomingContext(server.Context())
 	boundary, err := ParseBoundary(md)
 	if err != nil {
-		return err
+		o.finish(server.Context(), "", err)
+		return withRequestID(server.Context(), err)
 	}
 
-	reader := multipart.NewReader(newUploadServerReader(server, sizeLimit), boundary)
+	reader := o.newMultipartReader(body, boundary)
+	var names []string
+	err = processParts(reader, func(part *multipart.Part) error {
+		if name := part.FileName(); name != "" {
+			names = append(names, name)
+		}
+		return f(part)
+	})
+	err = o.limitErr(err)
+	o.finish(server.Context(), strings.Join(names, ","), err)
+	return withRequestID(server.Context(), err)
+}
+
+func processParts(reader *multipart.Reader, f func(part *multipart.Part) error) error {
 	for {
 		p, err := reader.NextPart()
 		if err != nil {
@@ -142,20 +312,110 @@ func ProcessMultipartUpload(server uploadServer, f func(part *multipart.Part) er
 	}
 }
 
-func parseMultipartForm(server uploadServer, sizeLimit int64) (*multipart.Form, error) {
+func parseMultipartForm(
+	server uploadServer, sizeLimit int64, o *formDataOptions,
+) (*multipart.Form, map[*multipart.FileHeader]Digests, error) {
+	body := o.newReader(server, sizeLimit)
 	md, _ := metadata.FromIncomingContext(server.Context())
 	boundary, err := ParseBoundary(md)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	if len(o.digests) == 0 && o.manifest == nil && o.scanner == nil && len(o.transforms) == 0 {
+		body, memory := o.readForm(body)
+		form, err := o.newMultipartReader(body, boundary).ReadForm(memory)
+		return form, nil, err
 	}
+	reader := o.newMultipartReader(body, boundary)
+	return readFormWithDigests(server.Context(), reader, o)
+}
+
+// readFormWithDigests reads the form like multipart.Reader.ReadForm, computes the digests of each file,
+// verifies the files against the upload manifest of the options, if any, and scans them, see WithScanner.
+// The parts are hashed while they're re-encoded into a pipe consumed by ReadForm,
+// so ReadForm still owns the memory/temp-file handling of the FileHeaders.
+func readFormWithDigests(
+	ctx context.Context, reader *multipart.Reader, o *formDataOptions,
+) (*multipart.Form, map[*multipart.FileHeader]Digests, error) {
+	pReader, pWriter := io.Pipe()
+	mWriter := multipart.NewWriter(pWriter)
+	digests := make(map[string][]Digests) // by form name, in order of appearance
+	verifier := newManifestVerifier(o.manifest)
+
+	go func() {
+		_ = pWriter.CloseWithError(func() error {
+			for {
+				part, err := reader.NextPart()
+				if err == io.EOF {
+					if err = verifier.finish(); err != nil {
+						return err
+					}
+					return mWriter.Close()
+				}
+				if err != nil {
+					return err
+				}
+				if part.FormName() == "" {
+					// ReadForm skips them too.
+					continue
+				}
+				dst, err := mWriter.CreatePart(part.Header)
+				if err != nil {
+					return err
+				}
+				if part.FileName() == "" {
+					if _, err = io.Copy(dst, part); err != nil {
+						return err
+					}
+					continue
+				}
+				declared, err := verifier.begin(part)
+				if err != nil {
+					return err
+				}
+				hashing := NewHashingReader(part, append(verifier.algorithms(declared), o.digests...)...)
+				// the size as uploaded, the transforms may change it.
+				var uploaded countingWriter
+				file := scanFile(part)
+				scanning, scanned := o.startScan(ctx, file, io.TeeReader(verifier.limit(declared, hashing), &uploaded))
+				_, err = io.Copy(dst, o.transform(file, scanning))
+				if err = scanned(err); err != nil {
+					return err
+				}
+				if err = verifier.end(declared, int64(uploaded), hashing.Digests()); err != nil {
+					return err
+				}
+				digests[part.FormName()] = append(digests[part.FormName()], hashing.Digests())
+			}
+		}())
+	}()
+
+	body, memory := o.readForm(pReader)
+	form, err := multipart.NewReader(body, mWriter.Boundary()).ReadForm(memory)
+	_ = pReader.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result := make(map[*multipart.FileHeader]Digests)
+	for name, headers := range form.File {
+		for i, header := range headers {
+			if i < len(digests[name]) {
+				result[header] = digests[name][i]
+			}
+		}
+	}
+	return form, result, nil
+}
 
-	reader := multipart.NewReader(newUploadServerReader(server, sizeLimit), boundary)
-	return reader.ReadForm(maxMemory)
+func scanFile(part *multipart.Part) ScanFile {
+	return ScanFile{Field: part.FormName(), Filename: part.FileName(), ContentType: part.Header.Get("Content-Type")}
 }
 
 // ParseBoundary parses the boundary parameter from the given metadata.
 func ParseBoundary(md metadata.MD) (string, error) {
-	contentType := pick(md, fmt.Sprintf("%s%s", runtime.MetadataPrefix, "content-type"))
+	contentType := pickHeader(md, headerContentType)
 	if contentType == "" {
 		return "", http.ErrNotMultipart
 	}
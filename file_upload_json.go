@@ -0,0 +1,185 @@
+package gatewayfile
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// NewJSONFormData returns a new FormData from a JSON request body which embeds files as base64,
+// for clients that cannot send multipart requests.
+// sizeLimit is the maximum size of the JSON body in bytes (0 = unlimited), it's enforced while decoding.
+// The body is decoded one member at a time, in the order of the document, and a member is held in memory
+// while it's decoded, so a member larger than 32 MB fails with ErrSizeLimitExceeded whatever sizeLimit.
+//
+// The body must be a JSON object. A member becomes a file when it is either
+//   - a data URI string, e.g. "data:image/png;name=a.png;base64,iVBORw0..."
+//   - an object with a base64 "data" field and optional "filename" and "content_type" fields,
+//     an empty "data" is an empty file
+//
+// Arrays produce multiple files or values for the same key, other scalars become values.
+// The files are exposed as *multipart.FileHeader, so they work with SaveMultipartFile like regular uploads.
+func NewJSONFormData(server uploadServer, sizeLimit int64, opts ...FormDataOption) (*FormData, error) {
+	o := newFormDataOptions(opts)
+	pReader, pWriter := io.Pipe()
+	mWriter := multipart.NewWriter(pWriter)
+	go func() {
+		err := decodeJSONForm(o.newReader(server, sizeLimit), mWriter)
+		if err == nil {
+			err = mWriter.Close()
+		}
+		_ = pWriter.CloseWithError(err)
+	}()
+
+	body, memory := o.readForm(pReader)
+	form, err := multipart.NewReader(body, mWriter.Boundary()).ReadForm(memory)
+	_ = pReader.Close()
+	if err == nil {
+		err = o.validateForm(form)
+	}
+	o.finish(server.Context(), formFileNames(form), err)
+	if err != nil {
+		return nil, withRequestID(server.Context(), fmt.Errorf("parse json form failed %w", err))
+	}
+	return &FormData{form: form, onStored: o.onStored, pathTemplate: o.pathTemplate}, nil
+}
+
+// maxJSONFormMember is the maximum size of a member of a JSON form, which is held in memory while it's decoded.
+const maxJSONFormMember = maxMemory
+
+var errJSONFormNotObject = errors.New("json form is not an object")
+
+// jsonFile is the object form of an embedded file.
+type jsonFile struct {
+	Filename    string  `json:"filename"`
+	ContentType string  `json:"content_type"`
+	Data        *string `json:"data"` // Data is nil if the object has no "data", it's not a file then.
+}
+
+// decodeJSONForm writes the members of the JSON object of body to writer, one at a time, in order.
+func decodeJSONForm(body io.Reader, writer *multipart.Writer) error {
+	limiter := &jsonFormLimiter{reader: body, end: maxJSONFormMember}
+	decoder := json.NewDecoder(limiter)
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != json.Delim('{') {
+		return errJSONFormNotObject
+	}
+	for decoder.More() {
+		limiter.end = decoder.InputOffset() + maxJSONFormMember
+		if token, err = decoder.Token(); err != nil {
+			return err
+		}
+		key := token.(string) // a member of an object starts with its name.
+		var raw json.RawMessage
+		if err = decoder.Decode(&raw); err != nil {
+			return fmt.Errorf("decode field %s failed %w", key, err)
+		}
+		var items []json.RawMessage
+		if err = json.Unmarshal(raw, &items); err != nil {
+			items = []json.RawMessage{raw}
+		}
+		for _, item := range items {
+			if err = writeJSONFormItem(writer, key, item); err != nil {
+				return fmt.Errorf("decode field %s failed %w", key, err)
+			}
+		}
+	}
+	// the closing brace.
+	_, err = decoder.Token()
+	return err
+}
+
+// jsonFormLimiter reads the body of a JSON form, and fails with ErrSizeLimitExceeded past end,
+// the offset the current member must end before, whatever the decoder buffered ahead.
+type jsonFormLimiter struct {
+	reader io.Reader
+	read   int64
+	end    int64
+}
+
+func (l *jsonFormLimiter) Read(p []byte) (int, error) {
+	if l.read >= l.end {
+		return 0, ErrSizeLimitExceeded
+	}
+	if int64(len(p)) > l.end-l.read {
+		p = p[:l.end-l.read]
+	}
+	n, err := l.reader.Read(p)
+	l.read += int64(n)
+	return n, err
+}
+
+func writeJSONFormItem(writer *multipart.Writer, key string, item json.RawMessage) error {
+	var file jsonFile
+	if err := json.Unmarshal(item, &file); err != nil || file.Data == nil {
+		var s *string
+		if err := json.Unmarshal(item, &s); err != nil || s == nil {
+			// numbers, booleans and null are kept as their JSON text.
+			return writer.WriteField(key, string(item))
+		}
+		if !strings.HasPrefix(*s, "data:") {
+			return writer.WriteField(key, *s)
+		}
+		if file, err = parseDataURI(*s); err != nil {
+			return err
+		}
+	}
+
+	data, err := base64.StdEncoding.DecodeString(*file.Data)
+	if err != nil {
+		return err
+	}
+	if file.Filename == "" {
+		file.Filename = key
+	}
+	if file.ContentType == "" {
+		file.ContentType = "application/octet-stream"
+	}
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(
+		`form-data; name=%s; filename=%s`, strconv.Quote(key), strconv.Quote(file.Filename),
+	))
+	header.Set("Content-Type", file.ContentType)
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	_, err = part.Write(data)
+	return err
+}
+
+var errInvalidDataURI = errors.New("invalid data uri")
+
+// parseDataURI parses a base64 data URI as per RFC 2397, the "name" parameter is used as filename.
+func parseDataURI(s string) (jsonFile, error) {
+	meta, data, ok := strings.Cut(strings.TrimPrefix(s, "data:"), ",")
+	if !ok {
+		return jsonFile{}, errInvalidDataURI
+	}
+	params := strings.Split(meta, ";")
+	if params[len(params)-1] != "base64" {
+		return jsonFile{}, errInvalidDataURI
+	}
+
+	file := jsonFile{ContentType: params[0], Data: &data}
+	for _, param := range params[1 : len(params)-1] {
+		if name, ok := strings.CutPrefix(param, "name="); ok {
+			if unescaped, err := url.PathUnescape(name); err == nil {
+				name = unescaped
+			}
+			file.Filename = name
+		}
+	}
+	return file, nil
+}
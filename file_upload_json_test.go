@@ -0,0 +1,84 @@
+package gatewayfile
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSONForm(t *testing.T) {
+	// a part is its name, its filename and its content.
+	tests := []struct {
+		name    string
+		body    string
+		want    [][3]string
+		wantErr error
+	}{
+		{
+			name: "values in order",
+			body: `{"b": "1", "a": 2, "c": [true, null], "b": "3"}`,
+			want: [][3]string{{"b", "", "1"}, {"a", "", "2"}, {"c", "", "true"}, {"c", "", "null"}, {"b", "", "3"}},
+		},
+		{
+			name: "file object",
+			body: `{"f": {"data": "aGk=", "filename": "x.txt", "content_type": "text/plain"}}`,
+			want: [][3]string{{"f", "x.txt", "hi"}},
+		},
+		{
+			name: "empty file",
+			body: `{"f": {"data": ""}}`,
+			want: [][3]string{{"f", "f", ""}},
+		},
+		{
+			name: "object without data",
+			body: `{"o": {"x": 1}}`,
+			want: [][3]string{{"o", "", `{"x": 1}`}},
+		},
+		{
+			name: "data uri",
+			body: `{"f": ["data:text/plain;name=a%20b.txt;base64,aGk=", "data:;base64,"]}`,
+			want: [][3]string{{"f", "a b.txt", "hi"}, {"f", "f", ""}},
+		},
+		{name: "empty object", body: `{}`},
+		{name: "not an object", body: `["a"]`, wantErr: errJSONFormNotObject},
+		{name: "invalid data uri", body: `{"f": "data:text/plain,hi"}`, wantErr: errInvalidDataURI},
+		{
+			name:    "member too large",
+			body:    `{"a": "small", "big": "` + strings.Repeat("x", maxJSONFormMember) + `"}`,
+			want:    [][3]string{{"a", "", "small"}},
+			wantErr: ErrSizeLimitExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body bytes.Buffer
+			writer := multipart.NewWriter(&body)
+			err := decodeJSONForm(strings.NewReader(tt.body), writer)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			_ = writer.Close()
+
+			var got [][3]string
+			reader := multipart.NewReader(&body, writer.Boundary())
+			for {
+				part, err := reader.NextPart()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				content, _ := io.ReadAll(part)
+				got = append(got, [3]string{part.FormName(), part.FileName(), string(content)})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got parts %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package gatewayfile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// newTestForm returns the form of the given files, by field. maxMemory is the parameter of ReadForm,
+// 0 spills the files to temporary files.
+func newTestForm(t *testing.T, maxMemory int64, files ...[3]string) *FormData {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, file := range files {
+		w, err := mw.CreateFormFile(file[0], file[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write([]byte(file[2]))
+	}
+	_ = mw.Close()
+	form, err := multipart.NewReader(&body, mw.Boundary()).ReadForm(maxMemory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = form.RemoveAll() })
+	return &FormData{form: form}
+}
+
+func TestFormDataSaveAllNeverReplaces(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    [][3]string
+		existing string // existing is the content of a file "a.txt" saved before, if not empty.
+		wantErr  error
+		want     map[string]string
+	}{
+		{
+			name:  "distinct names",
+			files: [][3]string{{"f", "a.txt", "one"}, {"g", "b.txt", "two"}},
+			want:  map[string]string{"a.txt": "one", "b.txt": "two"},
+		},
+		{
+			name:    "same name twice",
+			files:   [][3]string{{"f", "a.txt", "one"}, {"g", "dir/a.txt", "two"}},
+			wantErr: fs.ErrExist,
+			want:    map[string]string{"a.txt": "one"},
+		},
+		{
+			name:     "saved before",
+			files:    [][3]string{{"f", "a.txt", "new"}},
+			existing: "old",
+			wantErr:  fs.ErrExist,
+			want:     map[string]string{"a.txt": "old"},
+		},
+	}
+	for _, tt := range tests {
+		for _, spill := range []bool{false, true} {
+			name := tt.name + "/in memory"
+			maxMemory := int64(1 << 20)
+			if spill {
+				name, maxMemory = tt.name+"/spilled", 0
+			}
+			t.Run(name, func(t *testing.T) {
+				dir := t.TempDir()
+				if tt.existing != "" {
+					if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte(tt.existing), 0o644); err != nil {
+						t.Fatal(err)
+					}
+				}
+				_, err := newTestForm(t, maxMemory, tt.files...).SaveAll(context.Background(), dir)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+				for name, want := range tt.want {
+					if got, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(got) != want {
+						t.Fatalf("%s is %q, %v, want %q", name, got, err, want)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestNewFormDataWithoutDiskSpill(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		opts    []FormDataOption
+		wantErr error
+		spilled bool
+	}{
+		{name: "small", size: 1 << 10, opts: []FormDataOption{WithoutDiskSpill()}},
+		{name: "large", size: maxMemory + 1, spilled: true},
+		{
+			name:    "large without spill",
+			size:    maxMemory + 1,
+			opts:    []FormDataOption{WithoutDiskSpill()},
+			wantErr: ErrSizeLimitExceeded,
+		},
+		{
+			name: "small with digests",
+			size: 1 << 10,
+			opts: []FormDataOption{WithoutDiskSpill(), WithUploadDigest(DigestSHA256)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body bytes.Buffer
+			mw := multipart.NewWriter(&body)
+			w, _ := mw.CreateFormFile("f", "a.bin")
+			_, _ = w.Write(bytes.Repeat([]byte{'x'}, tt.size))
+			_ = mw.Close()
+			server := newFakeUploadServer(split(body.Bytes(), 32<<10)...)
+			server.ctx = metadata.NewIncomingContext(context.Background(),
+				metadata.Pairs("grpcgateway-content-type", mw.FormDataContentType()))
+
+			form, err := NewFormData(server, 0, tt.opts...)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer func() { _ = form.form.RemoveAll() }()
+			file, err := form.FirstFile("f").Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = file.Close() }()
+			if _, spilled := file.(*os.File); spilled != tt.spilled {
+				t.Fatalf("got spilled %v, want %v", spilled, tt.spilled)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package gatewayfile
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// NewURLEncodedFormData returns a new FormData from an application/x-www-form-urlencoded request body,
+// e.g. an HTML form without files. It has values only, so a handler reading FormData.Values
+// serves plain and multipart forms alike. The HTTPBody marshaler must be registered for
+// MIMEFormURLEncoded, see WithResumableMIMEs.
+// sizeLimit is the maximum size of the body in bytes (0 = 32 MB, since the body is held in memory).
+func NewURLEncodedFormData(server uploadServer, sizeLimit int64, opts ...FormDataOption) (*FormData, error) {
+	if sizeLimit <= 0 {
+		sizeLimit = maxMemory
+	}
+	o := newFormDataOptions(opts)
+	form, err := parseURLEncodedForm(o.newReader(server, sizeLimit))
+	if err == nil {
+		err = o.validateForm(form)
+	}
+	o.finish(server.Context(), "", err)
+	if err != nil {
+		return nil, withRequestID(server.Context(), fmt.Errorf("parse urlencoded form failed %w", err))
+	}
+	return &FormData{form: form, onStored: o.onStored, pathTemplate: o.pathTemplate}, nil
+}
+
+func parseURLEncodedForm(body io.Reader) (*multipart.Form, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return nil, err
+	}
+	return &multipart.Form{Value: values, File: make(map[string][]*multipart.FileHeader)}, nil
+}
+
+// ParseFormData returns a new FormData from the request body according to its Content-Type:
+// a multipart form (NewFormData), a urlencoded form (NewURLEncodedFormData) or a JSON object (NewJSONFormData).
+// Other types are rejected with http.ErrNotMultipart.
+func ParseFormData(server uploadServer, sizeLimit int64, opts ...FormDataOption) (*FormData, error) {
+	md, _ := metadata.FromIncomingContext(server.Context())
+	mediaType, _, _ := mime.ParseMediaType(pickHeader(md, headerContentType))
+	switch mediaType {
+	case "multipart/form-data", "multipart/mixed":
+		return NewFormData(server, sizeLimit, opts...)
+	case MIMEFormURLEncoded:
+		return NewURLEncodedFormData(server, sizeLimit, opts...)
+	case "application/json":
+		return NewJSONFormData(server, sizeLimit, opts...)
+	default:
+		err := fmt.Errorf("unsupported content type %q %w", mediaType, http.ErrNotMultipart)
+		return nil, withRequestID(server.Context(), err)
+	}
+}
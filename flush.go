@@ -0,0 +1,52 @@
+package gatewayfile
+
+import (
+	"io"
+	"net/http"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// headerFlushHeaders asks WithFileForwardResponseOption to flush the headers before the body, see FlushPolicy.
+const headerFlushHeaders = "flush-headers"
+
+// FlushPolicy controls when the body of a download reaches the client.
+// The gateway flushes every message of the stream, so the policy decides how the body is split into messages:
+// the default policy fills 1 MB chunks, which is efficient for files but buffers progressive content
+// (progressive images, logs...) until a chunk is full.
+type FlushPolicy struct {
+	// Bytes is the maximum size of the messages, 0 or more than 1 MB means 1 MB.
+	Bytes int
+	// EachRead sends the bytes of each read of the content at once, instead of waiting for a full message.
+	EachRead bool
+	// Headers flushes the headers as soon as they're sent, instead of with the first bytes of the body.
+	Headers bool
+}
+
+// WithFlushPolicy sets the flush policy of the download.
+func WithFlushPolicy(policy FlushPolicy) ServeOption {
+	return func(o *serveOptions) {
+		o.flush = policy
+		if policy.Headers {
+			withHeader(headerFlushHeaders, "true")(o)
+		}
+	}
+}
+
+// newWriter returns the writer of the response body, split into messages by the flush policy,
+// and wrapped by the writer wrappers of the options.
+func (o *serveOptions) newWriter(server downloadServer, contentType string) io.Writer {
+	writer := newDownloadServerWriter(server, contentType)
+	if o.flush.Bytes > 0 {
+		writer.size = min(o.flush.Bytes, defaultBufSize)
+	}
+	writer.eachRead = o.flush.EachRead
+	return o.wrapWriter(writer)
+}
+
+// flushHeaders flushes the headers written by the forward response option if the stream asked for it.
+func flushHeaders(writer http.ResponseWriter, md metadata.MD) {
+	if pick(md, headerFlushHeaders) != "" {
+		_ = http.NewResponseController(writer).Flush()
+	}
+}
@@ -0,0 +1,112 @@
+package gatewayfile
+
+import (
+	"context"
+	"io"
+	"math"
+	"time"
+)
+
+// FormDataOption configures NewFormData.
+type FormDataOption func(*formDataOptions)
+
+type formDataOptions struct {
+	digests     []DigestAlgorithm
+	manifest    *UploadManifest
+	preallocate bool
+	noDiskSpill bool // noDiskSpill keeps the files of the form in memory, see WithoutDiskSpill.
+	scanner     Scanner
+	scanSummary *ScanSummary
+	schema      []*FieldRule
+
+	pathTemplate *PathTemplate // pathTemplate lays out the files saved by FormData.SaveAll.
+
+	multipartLimits *MultipartLimits
+	guard           *multipartGuard // guard enforces multipartLimits, once the multipart reader is created.
+
+	// transforms transform the content of the uploaded files, the first one is the innermost.
+	transforms []func(file ScanFile, r io.Reader) io.Reader
+	// wrapReaders wrap the reader of the request body, the first one is the innermost.
+	wrapReaders []func(ctx context.Context, r io.Reader) io.Reader
+	// onFinish is called when the upload finishes, whatever the outcome.
+	onFinish []func(ctx context.Context, record TransferRecord)
+	// onStored is called with each file once it's persisted, see WithOnStored.
+	onStored []func(ctx context.Context, file *StreamedFile)
+	recorder *uploadRecorder
+}
+
+func newFormDataOptions(opts []FormDataOption) *formDataOptions {
+	o := &formDataOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// newReader returns the reader of the request body, wrapped by the reader wrappers of the options.
+func (o *formDataOptions) newReader(server uploadServer, sizeLimit int64) io.Reader {
+	var r io.Reader = newUploadServerReader(server, sizeLimit)
+	for _, wrap := range o.wrapReaders {
+		r = wrap(server.Context(), r)
+	}
+	if len(o.onFinish) > 0 {
+		o.recorder = &uploadRecorder{reader: r, start: time.Now()}
+		r = o.recorder
+	}
+	return r
+}
+
+// readForm returns the maxMemory parameter of ReadForm, and body limited to it if the form mustn't spill to disk.
+func (o *formDataOptions) readForm(body io.Reader) (io.Reader, int64) {
+	if !o.noDiskSpill {
+		return body, maxMemory
+	}
+	// the whole form is limited instead, so ReadForm never needs to spill a file.
+	return &exactLimitReader{reader: body, remaining: maxMemory}, math.MaxInt64
+}
+
+// transform returns r transformed by the file transforms of the options.
+func (o *formDataOptions) transform(file ScanFile, r io.Reader) io.Reader {
+	for _, transform := range o.transforms {
+		r = transform(file, r)
+	}
+	return r
+}
+
+// finish reports the outcome of the upload to the onFinish callbacks. object names what was uploaded.
+func (o *formDataOptions) finish(ctx context.Context, object string, err error) {
+	if o.recorder == nil {
+		return
+	}
+	record := o.recorder.record(ctx, object, err)
+	for _, f := range o.onFinish {
+		f(ctx, record)
+	}
+}
+
+// WithUploadDigest computes the digests of each uploaded file while the form is parsed,
+// so handlers don't have to re-read the temporary files to hash them. See FormData.Digests.
+func WithUploadDigest(algorithms ...DigestAlgorithm) FormDataOption {
+	return func(o *formDataOptions) {
+		o.digests = append(o.digests, algorithms...)
+	}
+}
+
+// WithFileTransform transforms the content of each uploaded file while the form is parsed, e.g. to strip metadata
+// (see WithImageMetadataStripping) or to encrypt it. The digests, the manifest and the Scanner see the files
+// as uploaded, the form and the sinks get them transformed.
+func WithFileTransform(transform func(file ScanFile, r io.Reader) io.Reader) FormDataOption {
+	return func(o *formDataOptions) {
+		o.transforms = append(o.transforms, transform)
+	}
+}
+
+// WithoutDiskSpill keeps the files of NewFormData and NewJSONFormData in memory, instead of spilling the files of
+// large forms to temporary files in plaintext, for uploads which mustn't hit the disk unencrypted.
+// A form larger than 32 MB then fails with ErrSizeLimitExceeded. Larger sensitive uploads should be streamed
+// to a NewEncryptedTempSink with StreamFormData instead.
+func WithoutDiskSpill() FormDataOption {
+	return func(o *formDataOptions) {
+		o.noDiskSpill = true
+	}
+}
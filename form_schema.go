@@ -0,0 +1,226 @@
+package gatewayfile
+
+import (
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrInvalidForm is returned when a form doesn't match the schema of WithSchema.
+// The returned error is a *FormValidationError, which matches it with errors.Is.
+var ErrInvalidForm = newError(codes.InvalidArgument, "INVALID_FORM", "invalid form")
+
+// The reasons of the FieldViolations of a FormValidationError.
+const (
+	FieldRequired        = "REQUIRED"
+	FieldEmpty           = "EMPTY"
+	FieldTooLarge        = "TOO_LARGE"
+	FieldUnsupportedType = "UNSUPPORTED_TYPE"
+	FieldNotAFile        = "NOT_A_FILE"
+)
+
+// FieldRule is a rule of a form schema, built by Require or Optional and checked by WithSchema:
+//
+//	gatewayfile.WithSchema(
+//		gatewayfile.Require("file").Types("image/png", "image/jpeg").MaxSize(5<<20),
+//		gatewayfile.Require("title").NonEmpty(),
+//	)
+type FieldRule struct {
+	name     string
+	required bool
+	nonEmpty bool
+	types    []string
+	maxSize  int64
+}
+
+// Require returns a rule of the field name, which fails if the form has neither a value nor a file for it.
+func Require(name string) *FieldRule {
+	return &FieldRule{name: name, required: true}
+}
+
+// Optional returns a rule of the field name, which only checks its values and files if the form has some.
+func Optional(name string) *FieldRule {
+	return &FieldRule{name: name}
+}
+
+// Types restricts the field to files of the given content types, parameters aside.
+// A type may be a wildcard, e.g. "image/*". Files sent without a content type are application/octet-stream.
+func (r *FieldRule) Types(types ...string) *FieldRule {
+	r.types = append(r.types, types...)
+	return r
+}
+
+// MaxSize limits the size of each file of the field, and the length of each of its values, in bytes.
+func (r *FieldRule) MaxSize(size int64) *FieldRule {
+	r.maxSize = size
+	return r
+}
+
+// NonEmpty rejects blank values and empty files.
+func (r *FieldRule) NonEmpty() *FieldRule {
+	r.nonEmpty = true
+	return r
+}
+
+// WithSchema checks the parsed form against rules, so handlers don't have to validate each field by hand.
+// All the violations are reported at once by a *FormValidationError, answered with 400 and a BadRequest detail.
+// The files of an invalid form are removed. Several rules may apply to the same field.
+func WithSchema(rules ...*FieldRule) FormDataOption {
+	return func(o *formDataOptions) {
+		o.schema = append(o.schema, rules...)
+	}
+}
+
+// FieldViolation is a field of a form which doesn't match its rule.
+type FieldViolation struct {
+	Field       string
+	Reason      string // Reason is one of FieldRequired, FieldEmpty, FieldTooLarge...
+	Description string
+}
+
+// FormValidationError reports the fields of a form which don't match the schema of WithSchema.
+type FormValidationError struct {
+	Violations []FieldViolation
+}
+
+func (e *FormValidationError) Error() string {
+	descriptions := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		descriptions[i] = violation.Field + ": " + violation.Description
+	}
+	return ErrInvalidForm.Error() + ": " + strings.Join(descriptions, "; ")
+}
+
+// Is reports whether target is ErrInvalidForm.
+func (e *FormValidationError) Is(target error) bool {
+	return target == ErrInvalidForm
+}
+
+// GRPCStatus returns an InvalidArgument status, with the ErrorInfo detail of ErrInvalidForm
+// and a BadRequest detail listing the violations.
+func (e *FormValidationError) GRPCStatus() *status.Status {
+	violations := make([]*errdetails.BadRequest_FieldViolation, len(e.Violations))
+	for i, violation := range e.Violations {
+		violations[i] = &errdetails.BadRequest_FieldViolation{
+			Field:       violation.Field,
+			Reason:      violation.Reason,
+			Description: violation.Description,
+		}
+	}
+	st := status.New(ErrInvalidForm.Code, e.Error())
+	detailed, err := st.WithDetails(
+		&errdetails.ErrorInfo{Reason: ErrInvalidForm.Reason, Domain: errorDomain},
+		&errdetails.BadRequest{FieldViolations: violations},
+	)
+	if err != nil {
+		return st
+	}
+	return detailed
+}
+
+// formFile is a file of a form, as checked by a FieldRule.
+type formFile struct {
+	filename    string
+	contentType string
+	size        int64
+}
+
+// validateForm checks a parsed form against the schema of the options, and removes its files if it's invalid.
+func (o *formDataOptions) validateForm(form *multipart.Form) error {
+	if len(o.schema) == 0 {
+		return nil
+	}
+	files := make(map[string][]formFile, len(form.File))
+	for field, headers := range form.File {
+		for _, header := range headers {
+			files[field] = append(files[field], formFile{
+				filename:    header.Filename,
+				contentType: header.Header.Get("Content-Type"),
+				size:        header.Size,
+			})
+		}
+	}
+	err := o.validate(form.Value, files)
+	if err != nil {
+		_ = form.RemoveAll()
+	}
+	return err
+}
+
+// validate checks the values and files of a form against the schema of the options.
+func (o *formDataOptions) validate(values map[string][]string, files map[string][]formFile) error {
+	var violations []FieldViolation
+	for _, rule := range o.schema {
+		violations = append(violations, rule.check(values[rule.name], files[rule.name])...)
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	return &FormValidationError{Violations: violations}
+}
+
+func (r *FieldRule) check(values []string, files []formFile) []FieldViolation {
+	var violations []FieldViolation
+	violate := func(reason, format string, args ...any) {
+		violations = append(violations, FieldViolation{
+			Field: r.name, Reason: reason, Description: fmt.Sprintf(format, args...),
+		})
+	}
+
+	if len(values) == 0 && len(files) == 0 {
+		if r.required {
+			violate(FieldRequired, "is required")
+		}
+		return violations
+	}
+	for _, value := range values {
+		switch {
+		case len(r.types) > 0:
+			violate(FieldNotAFile, "must be a file")
+		case r.nonEmpty && strings.TrimSpace(value) == "":
+			violate(FieldEmpty, "must not be empty")
+		case r.maxSize > 0 && int64(len(value)) > r.maxSize:
+			violate(FieldTooLarge, "must not be longer than %d bytes", r.maxSize)
+		}
+	}
+	for _, file := range files {
+		switch contentType := fileContentType(file.contentType); {
+		case len(r.types) > 0 && !matchesType(contentType, r.types):
+			violate(FieldUnsupportedType, "%s: type %s is not one of %s",
+				file.filename, contentType, strings.Join(r.types, ", "))
+		case r.nonEmpty && file.size == 0:
+			violate(FieldEmpty, "%s: must not be empty", file.filename)
+		case r.maxSize > 0 && file.size > r.maxSize:
+			violate(FieldTooLarge, "%s: must not be larger than %d bytes", file.filename, r.maxSize)
+		}
+	}
+	return violations
+}
+
+// fileContentType returns the media type of a file part, application/octet-stream if it has none (RFC 7578).
+func fileContentType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "" {
+		return "application/octet-stream"
+	}
+	return mediaType
+}
+
+// matchesType reports whether mediaType matches one of types, which may be wildcards like "image/*".
+func matchesType(mediaType string, types []string) bool {
+	for _, t := range types {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "*/*" || t == mediaType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(t, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
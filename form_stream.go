@@ -0,0 +1,232 @@
+package gatewayfile
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// PartSink is the destination of the file parts streamed by StreamFormData, e.g. a directory or an object store.
+type PartSink interface {
+	// Create returns the writer the content of the part is streamed to, and a destination naming where it goes,
+	// e.g. a path or an object key. The writer is closed once the part was streamed, even on failure.
+	// If the writer has a Destination() string method, the destination is updated from it once it's closed,
+	// e.g. for files named after their content, see TemplateSink.
+	Create(ctx context.Context, part *multipart.Part) (w io.WriteCloser, destination string, err error)
+	// Remove removes a destination created by Create, when the upload fails after it was created.
+	Remove(ctx context.Context, destination string) error
+}
+
+// DirSink returns a PartSink which writes each file into dir, named by the base name of its filename.
+// It refuses to overwrite an existing file.
+func DirSink(dir string) PartSink {
+	return dirSink(filepath.Clean(dir))
+}
+
+type dirSink string
+
+func (dir dirSink) Create(_ context.Context, part *multipart.Part) (io.WriteCloser, string, error) {
+	name, err := baseFilename(part.FileName())
+	if err != nil {
+		return nil, "", err
+	}
+	path := filepath.Join(string(dir), name)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, "", err
+	}
+	return file, path, nil
+}
+
+// baseFilename returns the base name of a filename sent by a client, to prevent path traversal.
+func baseFilename(filename string) (string, error) {
+	name := filepath.Base(filepath.FromSlash(filename))
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		return "", fmt.Errorf("invalid filename %s", filename)
+	}
+	return name, nil
+}
+
+func (dirSink) Remove(_ context.Context, destination string) error {
+	return os.Remove(destination)
+}
+
+// StreamedForm is a form parsed by StreamFormData.
+type StreamedForm struct {
+	Value map[string][]string
+	File  map[string][]*StreamedFile
+}
+
+// StreamedFile is a file part streamed to a PartSink, or saved by FormData.SaveAll.
+type StreamedFile struct {
+	Field       string // Field is the form field of the file.
+	Filename    string
+	ContentType string
+	Size        int64
+	Destination string  // Destination is where the sink wrote the file, see PartSink.Create.
+	Digests     Digests // Digests are computed while streaming, see WithUploadDigest.
+}
+
+// files returns the files of the form, as checked by WithSchema.
+func (f *StreamedForm) files() map[string][]formFile {
+	files := make(map[string][]formFile, len(f.File))
+	for field, streamed := range f.File {
+		for _, file := range streamed {
+			files[field] = append(files[field], formFile{
+				filename: file.Filename, contentType: file.ContentType, size: file.Size,
+			})
+		}
+	}
+	return files
+}
+
+// StreamFormData parses a multipart form like NewFormData, but streams the file parts to sink as they arrive,
+// instead of buffering them in memory or spilling them to temporary files to be copied again later.
+// It halves the disk I/O of large uploads. Values are kept in memory, up to 32 MB in total.
+// sizeLimit is the maximum size of the form data in bytes (0 = unlimited).
+// If parsing fails, the destinations created so far are removed.
+func StreamFormData(
+	server uploadServer, sizeLimit int64, sink PartSink, opts ...FormDataOption,
+) (*StreamedForm, error) {
+	o := newFormDataOptions(opts)
+	body := o.newReader(server, sizeLimit)
+	form := &StreamedForm{Value: make(map[string][]string), File: make(map[string][]*StreamedFile)}
+
+	md, _ := metadata.FromIncomingContext(server.Context())
+	boundary, err := ParseBoundary(md)
+	if err == nil {
+		err = o.limitErr(streamParts(server.Context(), o.newMultipartReader(body, boundary), sink, form, o))
+	}
+	if err == nil {
+		err = o.validate(form.Value, form.files())
+	}
+
+	var names []string
+	for _, files := range form.File {
+		for _, file := range files {
+			names = append(names, file.Filename)
+		}
+	}
+	o.finish(server.Context(), strings.Join(names, ","), err)
+	if err != nil {
+		for _, files := range form.File {
+			for _, file := range files {
+				_ = sink.Remove(server.Context(), file.Destination)
+			}
+		}
+		return nil, withRequestID(server.Context(), fmt.Errorf("stream multipart form failed %w", err))
+	}
+	for _, field := range sortedKeys(form.File) {
+		for _, file := range form.File[field] {
+			o.stored(server.Context(), file)
+		}
+	}
+	return form, nil
+}
+
+// WithOnStored calls f with each file once it's persisted, by StreamFormData once the whole form was streamed,
+// or by FormData.SaveAll. The file tells where it's stored, its size, content type and digests,
+// so services can enqueue post-processing jobs, e.g. transcoding or thumbnailing, without listing the storage.
+func WithOnStored(f func(ctx context.Context, file *StreamedFile)) FormDataOption {
+	return func(o *formDataOptions) {
+		o.onStored = append(o.onStored, f)
+	}
+}
+
+func (o *formDataOptions) stored(ctx context.Context, file *StreamedFile) {
+	for _, f := range o.onStored {
+		f(ctx, file)
+	}
+}
+
+func streamParts(
+	ctx context.Context, reader *multipart.Reader, sink PartSink, form *StreamedForm, o *formDataOptions,
+) error {
+	verifier := newManifestVerifier(o.manifest)
+	valueBudget := int64(maxMemory)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return verifier.finish()
+		}
+		if err != nil {
+			return err
+		}
+		name := part.FormName()
+		if name == "" {
+			continue
+		}
+
+		if part.FileName() == "" {
+			var value strings.Builder
+			n, err := io.Copy(&value, io.LimitReader(part, valueBudget+1))
+			if err != nil {
+				return err
+			}
+			if valueBudget -= n; valueBudget < 0 {
+				return multipart.ErrMessageTooLarge
+			}
+			form.Value[name] = append(form.Value[name], value.String())
+			continue
+		}
+
+		declared, err := verifier.begin(part)
+		if err != nil {
+			return err
+		}
+		file, err := streamPart(ctx, part, sink, verifier, declared, o)
+		if file != nil {
+			// recorded even on failure, so the destination is removed.
+			form.File[name] = append(form.File[name], file)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func streamPart(
+	ctx context.Context, part *multipart.Part, sink PartSink,
+	verifier *manifestVerifier, declared *DeclaredFile, o *formDataOptions,
+) (*StreamedFile, error) {
+	w, destination, err := sink.Create(ctx, part)
+	if err != nil {
+		return nil, err
+	}
+	file := &StreamedFile{
+		Field:       part.FormName(),
+		Filename:    part.FileName(),
+		ContentType: part.Header.Get("Content-Type"),
+		Destination: destination,
+	}
+
+	if o.preallocate && declared != nil {
+		if err = preallocateWriter(w, declared.Size); err != nil {
+			_ = w.Close()
+			return file, err
+		}
+	}
+	hashing := NewHashingReader(part, append(verifier.algorithms(declared), o.digests...)...)
+	// the size as uploaded, the transforms may change it.
+	var uploaded countingWriter
+	scanning, scanned := o.startScan(ctx, scanFile(part), io.TeeReader(verifier.limit(declared, hashing), &uploaded))
+	file.Size, err = io.Copy(w, o.transform(scanFile(part), scanning))
+	err = scanned(err)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if named, ok := w.(interface{ Destination() string }); ok {
+		file.Destination = named.Destination()
+	}
+	if err != nil {
+		return file, err
+	}
+	file.Digests = hashing.Digests()
+	return file, verifier.end(declared, int64(uploaded), file.Digests)
+}
@@ -0,0 +1,117 @@
+package gatewayfile
+
+import (
+	"net/http"
+	"net/textproto"
+	"slices"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"google.golang.org/protobuf/proto"
+)
+
+// ForwardOption configures WithFileForwardResponseOption.
+type ForwardOption func(*forwardOptions)
+
+type forwardOptions struct {
+	metadataPrefixes []string
+	forwardedHeaders []string // forwardedHeaders are the extra headers written, see WithForwardedHeaders.
+	writeTimeout     *time.Duration
+	// headerCasing maps the lower-cased names of the headers written with an exact casing to it,
+	// see WithHeaderCasing.
+	headerCasing map[string]string
+}
+
+func newForwardOptions(opts []ForwardOption) *forwardOptions {
+	o := &forwardOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if len(o.metadataPrefixes) == 0 {
+		o.metadataPrefixes = []string{headerMetaPrefix}
+	}
+	return o
+}
+
+// WithMetadataPrefixes sets the prefixes of the response headers carrying the custom object metadata,
+// defaults to "X-Meta-". E.g. WithMetadataPrefixes(AmzMetaPrefix, GoogMetaPrefix) emits each key both as
+// "X-Amz-Meta-<key>" and "X-Goog-Meta-<key>", so S3 and GCS SDK clients find the metadata where they expect it.
+func WithMetadataPrefixes(prefixes ...string) ForwardOption {
+	return func(o *forwardOptions) {
+		o.metadataPrefixes = append(o.metadataPrefixes, prefixes...)
+	}
+}
+
+// WithWriteTimeout sets a sliding write deadline on file responses: each chunk must be written within timeout,
+// 0 disables the deadline. It overrides the http.Server WriteTimeout for downloads, which otherwise kills
+// giant downloads once the global timeout elapsed, however healthy they are; while stalled clients are still
+// disconnected after timeout. It uses http.ResponseController, so it's a no-op if the writer doesn't support it.
+func WithWriteTimeout(timeout time.Duration) ForwardOption {
+	return func(o *forwardOptions) {
+		o.writeTimeout = &timeout
+	}
+}
+
+// control applies the per-response settings of the options through http.ResponseController.
+// It's called at the start of file streams (nil message) and before each of their chunks.
+func (o *forwardOptions) control(writer http.ResponseWriter, message proto.Message) {
+	if _, ok := message.(*httpbody.HttpBody); !ok && message != nil {
+		return
+	}
+	if o.writeTimeout == nil {
+		return
+	}
+	var deadline time.Time
+	if *o.writeTimeout > 0 {
+		deadline = time.Now().Add(*o.writeTimeout)
+	}
+	_ = http.NewResponseController(writer).SetWriteDeadline(deadline)
+}
+
+// uncasedHeaders are the headers net/http interprets by their canonical names, they're always canonicalized.
+var uncasedHeaders = []string{"Content-Type", "Content-Length", "Transfer-Encoding", "Connection", "Date", "Trailer"}
+
+// WithHeaderCasing writes the given response headers of file responses with their exact casing instead of the
+// canonical one, e.g. "ETag" rather than "Etag", or "X-API-Key" rather than "X-Api-Key", for clients which
+// match header names case-sensitively. The names are matched case-insensitively. It only matters for HTTP/1.x,
+// HTTP/2 lower-cases all header names. Content-Type, Content-Length and the other headers net/http interprets
+// are always canonicalized, and the middlewares which look up the canonical names don't see the others.
+func WithHeaderCasing(names ...string) ForwardOption {
+	return func(o *forwardOptions) {
+		if o.headerCasing == nil {
+			o.headerCasing = make(map[string]string)
+		}
+		for _, name := range names {
+			if !slices.ContainsFunc(uncasedHeaders, func(header string) bool { return strings.EqualFold(header, name) }) {
+				o.headerCasing[strings.ToLower(name)] = name
+			}
+		}
+	}
+}
+
+// headerName returns the name the response header key is written with, see WithHeaderCasing.
+func (o *forwardOptions) headerName(key string) string {
+	if name, ok := o.headerCasing[strings.ToLower(key)]; ok {
+		return name
+	}
+	return textproto.CanonicalMIMEHeaderKey(key)
+}
+
+// setHeader replaces the values of the response header key with values.
+func (o *forwardOptions) setHeader(h http.Header, key string, values ...string) {
+	delete(h, textproto.CanonicalMIMEHeaderKey(key))
+	h[o.headerName(key)] = slices.Clone(values)
+}
+
+// addHeader adds value to the values of the response header key.
+func (o *forwardOptions) addHeader(h http.Header, key, value string) {
+	name, canonical := o.headerName(key), textproto.CanonicalMIMEHeaderKey(key)
+	values := h[name]
+	if name != canonical {
+		// the values added by the middlewares, e.g. Origin of CORS, are moved to the exact name.
+		values = append(h[canonical], values...)
+		delete(h, canonical)
+	}
+	h[name] = append(values, value)
+}
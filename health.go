@@ -0,0 +1,162 @@
+package gatewayfile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/grpclog"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const defaultHealthTimeout = 5 * time.Second
+
+// HealthConfig is the configuration of a HealthChecker.
+type HealthConfig struct {
+	// Providers are checked by StatContent, by name, e.g. a sentinel object of each bucket.
+	Providers map[string]ContentProvider
+	// Dirs are checked by writing, reading back and removing a small file, e.g. the upload temp directories.
+	Dirs []string
+	// Breakers fail the check while they're open, by name.
+	Breakers map[string]*CircuitBreaker
+	// Timeout bounds each check, defaults to 5s.
+	Timeout time.Duration
+}
+
+// HealthChecker verifies the storage is available: providers can be read, and directories written,
+// so file-serving instances don't receive traffic when their storage is unavailable.
+// It's an http.Handler for readiness probes, and reports a serving status for the gRPC health service:
+//
+//	healthServer.SetServingStatus("", checker.ServingStatus(ctx))
+type HealthChecker struct {
+	cfg HealthConfig
+}
+
+// NewHealthChecker returns a new HealthChecker.
+func NewHealthChecker(cfg HealthConfig) *HealthChecker {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultHealthTimeout
+	}
+	return &HealthChecker{cfg: cfg}
+}
+
+// Check runs all checks, and returns the errors of the failed ones.
+func (h *HealthChecker) Check(ctx context.Context) error {
+	var errs []error
+	for _, failure := range h.check(ctx) {
+		errs = append(errs, fmt.Errorf("%s: %w", failure.detail, failure.err))
+	}
+	return errors.Join(errs...)
+}
+
+// healthFailure is a failed check.
+type healthFailure struct {
+	name   string // name names the check without telling about the storage, e.g. "dir 0".
+	detail string // detail names the check, e.g. "dir /var/tmp/uploads".
+	err    error
+}
+
+func (h *HealthChecker) check(ctx context.Context) []healthFailure {
+	var failures []healthFailure
+	for _, name := range sortedKeys(h.cfg.Breakers) {
+		if h.cfg.Breakers[name].Open() {
+			failures = append(failures, healthFailure{"breaker " + name, "breaker " + name, ErrCircuitOpen})
+		}
+	}
+	for _, name := range sortedKeys(h.cfg.Providers) {
+		checkCtx, cancel := context.WithTimeout(ctx, h.cfg.Timeout)
+		_, err := StatContent(checkCtx, h.cfg.Providers[name])
+		cancel()
+		if err != nil {
+			failures = append(failures, healthFailure{"provider " + name, "provider " + name, err})
+		}
+	}
+	for i, dir := range h.cfg.Dirs {
+		if err := h.checkDir(ctx, dir); err != nil {
+			failures = append(failures, healthFailure{"dir " + strconv.Itoa(i), "dir " + dir, err})
+		}
+	}
+	return failures
+}
+
+var healthProbe = []byte("gatewayfile health probe\n")
+
+// checkDir writes, reads back and removes a file in dir, it gives up after the timeout,
+// since a hung filesystem (e.g. NFS) blocks instead of failing.
+func (h *HealthChecker) checkDir(ctx context.Context, dir string) error {
+	result := make(chan error, 1)
+	go func() {
+		result <- func() error {
+			file, err := os.CreateTemp(dir, ".health-")
+			if err != nil {
+				return err
+			}
+			defer func() { _ = os.Remove(file.Name()) }()
+			_, err = file.Write(healthProbe)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+			data, err := os.ReadFile(file.Name())
+			if err == nil && !bytes.Equal(data, healthProbe) {
+				err = errors.New("read back mismatch")
+			}
+			return err
+		}()
+	}()
+
+	timer := time.NewTimer(h.cfg.Timeout)
+	defer timer.Stop()
+	select {
+	case err := <-result:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("timed out after %s", h.cfg.Timeout)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ServingStatus returns the status of the gRPC health service according to Check.
+func (h *HealthChecker) ServingStatus(ctx context.Context) healthpb.HealthCheckResponse_ServingStatus {
+	if h.Check(ctx) != nil {
+		return healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	return healthpb.HealthCheckResponse_SERVING
+}
+
+// ServeHTTP answers 200 if the checks pass, 503 with the names of the failed checks otherwise, e.g.
+// "provider s3" or "dir 0" for the first of HealthConfig.Dirs. The errors may tell about the storage,
+// e.g. its paths, they're only logged.
+func (h *HealthChecker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	failures := h.check(r.Context())
+	if len(failures) == 0 {
+		_, _ = fmt.Fprintln(w, "ok")
+		return
+	}
+	w.WriteHeader(http.StatusServiceUnavailable)
+	_, _ = fmt.Fprintln(w, "unavailable")
+	for _, failure := range failures {
+		grpclog.Warningf("gatewayfile: health check %s failed: %v", failure.detail, failure.err)
+		_, _ = fmt.Fprintln(w, failure.name)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
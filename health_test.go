@@ -0,0 +1,54 @@
+package gatewayfile
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealthCheckerServeHTTP(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "secret", "missing")
+	open := NewCircuitBreaker(BreakerConfig{Threshold: 1, OpenTimeout: time.Hour})
+	_ = open.Do(func() error { return errors.New("backend down") })
+
+	tests := []struct {
+		name     string
+		cfg      HealthConfig
+		wantCode int
+		wantBody string
+	}{
+		{name: "ok", cfg: HealthConfig{Dirs: []string{t.TempDir()}}, wantCode: http.StatusOK, wantBody: "ok\n"},
+		{
+			name:     "dir",
+			cfg:      HealthConfig{Dirs: []string{t.TempDir(), missing}},
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: "unavailable\ndir 1\n",
+		},
+		{
+			name:     "breaker",
+			cfg:      HealthConfig{Breakers: map[string]*CircuitBreaker{"storage": open}},
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: "unavailable\nbreaker storage\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			NewHealthChecker(tt.cfg).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			if recorder.Code != tt.wantCode || recorder.Body.String() != tt.wantBody {
+				t.Fatalf("got %d %q, want %d %q", recorder.Code, recorder.Body, tt.wantCode, tt.wantBody)
+			}
+		})
+	}
+
+	// Check still tells the details.
+	err := NewHealthChecker(HealthConfig{Dirs: []string{missing}}).Check(context.Background())
+	if err == nil || !strings.Contains(err.Error(), missing) {
+		t.Fatalf("got %v, want the error of %s", err, missing)
+	}
+}
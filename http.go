@@ -0,0 +1,199 @@
+package gatewayfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// UploadServer is the client-stream of an upload, e.g. grpc.ClientStreamingServer[httpbody.HttpBody, Response].
+type UploadServer = uploadServer
+
+// httpRecvSize is the size of the messages received by an HTTP upload server.
+const httpRecvSize = 32 << 10
+
+// ServeHTTP runs serve on a plain net/http request, for routes which don't go through grpc-gateway,
+// with the same range, precondition, limit... handling. The request headers are passed to serve as
+// the gateway would, see WithFileIncomingHeaderMatcher, and the response is written as WithFileForwardResponseOption
+// would. If serve fails before the response started, the error is answered like the gateway would,
+// with the status code of its gRPC code, see HTTPErrorHandler. The error is returned either way, e.g. for logging.
+//
+//	http.HandleFunc("/files/{name}", func(w http.ResponseWriter, r *http.Request) {
+//		_ = gatewayfile.ServeHTTP(w, r, func(server gatewayfile.DownloadServer) error {
+//			return gatewayfile.ServeFile(server, "", filepath.Join(root, r.PathValue("name")))
+//		})
+//	})
+func ServeHTTP(w http.ResponseWriter, r *http.Request, serve ServeFunc, opts ...ForwardOption) error {
+	server := newHTTPServer(w, r, opts)
+	err := serve(server)
+	if err != nil && !server.headerSent {
+		writeHTTPError(w, err)
+	}
+	return err
+}
+
+// ServeContentHTTP is ServeContent for a plain net/http request, see ServeHTTP.
+func ServeContentHTTP(
+	w http.ResponseWriter, r *http.Request, content io.ReadSeeker, contentType, name string, modTime time.Time,
+	size int64, opts ...ServeOption,
+) error {
+	return ServeHTTP(w, r, func(server DownloadServer) error {
+		return ServeContent(server, content, contentType, name, modTime, size, opts...)
+	})
+}
+
+// HTTPUploadServer returns an UploadServer reading the body of a plain net/http request, so the form parsers
+// (NewFormData, StreamFormData, AppendChunk...) work on routes which don't go through grpc-gateway.
+// Response headers set through it (e.g. X-Upload-Offset) are not written, the handler writes the response.
+func HTTPUploadServer(r *http.Request) UploadServer {
+	return newHTTPServer(nil, r, nil)
+}
+
+// NewFormDataHTTP is NewFormData for a plain net/http request, see HTTPUploadServer.
+func NewFormDataHTTP(r *http.Request, sizeLimit int64, opts ...FormDataOption) (*FormData, error) {
+	return NewFormData(HTTPUploadServer(r), sizeLimit, opts...)
+}
+
+// httpServer adapts a net/http request and its response to downloadServer and uploadServer.
+type httpServer struct {
+	w   http.ResponseWriter
+	r   *http.Request
+	ctx context.Context
+	o   *forwardOptions
+
+	header     metadata.MD
+	headerSent bool
+}
+
+func newHTTPServer(w http.ResponseWriter, r *http.Request, opts []ForwardOption) *httpServer {
+	return &httpServer{
+		w:      w,
+		r:      r,
+		ctx:    metadata.NewIncomingContext(r.Context(), incomingMetadata(r.Header, r.RemoteAddr)),
+		o:      newForwardOptions(opts),
+		header: make(metadata.MD),
+	}
+}
+
+// incomingMetadata returns the metadata the gateway would pass for a request with header from remoteAddr.
+func incomingMetadata(header http.Header, remoteAddr string) metadata.MD {
+	md := make(metadata.MD)
+	for key, values := range header {
+		if name, ok := fileHeaderMatcher(key); ok {
+			md.Append(name, values...)
+		}
+	}
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		if forwarded := header.Get("X-Forwarded-For"); forwarded != "" {
+			host = forwarded + ", " + host
+		}
+		md.Set("x-forwarded-for", host)
+	}
+	return md
+}
+
+func (s *httpServer) Context() context.Context {
+	return s.ctx
+}
+
+func (s *httpServer) SetHeader(md metadata.MD) error {
+	if s.headerSent {
+		return errors.New("header already sent")
+	}
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func (s *httpServer) SendHeader(md metadata.MD) error {
+	if err := s.SetHeader(md); err != nil {
+		return err
+	}
+	s.headerSent = true
+	if s.w == nil {
+		return nil
+	}
+	return s.o.writeHeader(s.w, s.header)
+}
+
+// SetTrailer is a no-op, the stream error trailers are set by Send, see sendStreamError.
+func (s *httpServer) SetTrailer(metadata.MD) {}
+
+func (s *httpServer) Send(body *httpbody.HttpBody) error {
+	if s.w == nil {
+		return errors.New("upload server can't send")
+	}
+	if !s.headerSent {
+		if err := s.SendHeader(nil); err != nil {
+			return err
+		}
+	}
+	s.o.control(s.w, body)
+	setStreamErrorTrailers(s.w, body)
+	if _, err := s.w.Write(body.GetData()); err != nil {
+		return err
+	}
+	// the gateway flushes each message as well.
+	err := http.NewResponseController(s.w).Flush()
+	if errors.Is(err, http.ErrNotSupported) {
+		return nil
+	}
+	return err
+}
+
+func (s *httpServer) Recv() (*httpbody.HttpBody, error) {
+	data := make([]byte, httpRecvSize)
+	for {
+		n, err := s.r.Body.Read(data)
+		if n > 0 {
+			return &httpbody.HttpBody{ContentType: s.r.Header.Get("Content-Type"), Data: data[:n]}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (s *httpServer) SendMsg(m any) error {
+	body, ok := m.(*httpbody.HttpBody)
+	if !ok {
+		return fmt.Errorf("unsupported message %T", m)
+	}
+	return s.Send(body)
+}
+
+func (s *httpServer) RecvMsg(m any) error {
+	body, ok := m.(*httpbody.HttpBody)
+	if !ok {
+		return fmt.Errorf("unsupported message %T", m)
+	}
+	received, err := s.Recv()
+	if err != nil {
+		return err
+	}
+	body.ContentType, body.Data = received.GetContentType(), received.GetData()
+	return nil
+}
+
+// writeHTTPError answers err like the gateway would with HTTPErrorHandler, in plain text.
+func writeHTTPError(w http.ResponseWriter, err error) {
+	code := runtime.HTTPStatusFromCode(status.Code(err))
+	if override, ok := httpStatusOf(err); ok {
+		code = override
+	}
+	if after, ok := retryAfter(err); ok {
+		w.Header().Set(headerRetryAfter, after)
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	_, _ = io.WriteString(w, status.Convert(err).Message()+"\n")
+}
@@ -0,0 +1,341 @@
+package gatewayfile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"slices"
+)
+
+// Kinds of image metadata reported by WithImageMetadataStripping and WithImageMetadataDetection.
+const (
+	ImageMetadataExif    = "exif"    // ImageMetadataExif - Exif, e.g. the camera model and the capture date
+	ImageMetadataGPS     = "gps"     // ImageMetadataGPS - the location, in the Exif
+	ImageMetadataXMP     = "xmp"     // ImageMetadataXMP - XMP packets
+	ImageMetadataIPTC    = "iptc"    // ImageMetadataIPTC - IPTC records, e.g. the author
+	ImageMetadataComment = "comment" // ImageMetadataComment - JPEG comments
+	ImageMetadataText    = "text"    // ImageMetadataText - PNG text chunks
+)
+
+// maxImageMetadataSize is the maximum size of a metadata block buffered to be inspected.
+const maxImageMetadataSize = 1 << 20
+
+var (
+	jpegMagic  = []byte{0xFF, 0xD8, 0xFF}
+	pngMagic   = []byte("\x89PNG\r\n\x1a\n")
+	heifBrands = []string{"heic", "heix", "heim", "heis", "hevc", "hevm", "hevs", "mif1", "msf1", "avif"}
+)
+
+// WithImageMetadataStripping strips the Exif (including GPS), XMP, IPTC and comment metadata of the uploaded JPEG
+// and PNG files on the fly, a common privacy requirement of user-generated content. The pixels and the ICC profile
+// are kept, the Exif orientation is lost. onFound, if not nil, is called with the kinds of metadata found in a file.
+// HEIC and AVIF files are only inspected: their metadata is referenced by offsets, it can't be stripped on the fly,
+// so onFound is the only way to handle them, e.g. by rejecting them. See WithFileTransform.
+func WithImageMetadataStripping(onFound func(file ScanFile, kinds []string)) FormDataOption {
+	return WithFileTransform(func(file ScanFile, r io.Reader) io.Reader {
+		return newImageMetadataReader(file, r, true, onFound)
+	})
+}
+
+// WithImageMetadataDetection calls onFound with the kinds of metadata found in the uploaded images,
+// like WithImageMetadataStripping, but leaves the files as uploaded.
+func WithImageMetadataDetection(onFound func(file ScanFile, kinds []string)) FormDataOption {
+	return WithFileTransform(func(file ScanFile, r io.Reader) io.Reader {
+		return newImageMetadataReader(file, r, false, onFound)
+	})
+}
+
+// imageMetadataReader reads an image segment by segment, dropping the metadata segments if strip.
+// Files which are not images are passed through.
+type imageMetadataReader struct {
+	src     *bufio.Reader
+	file    ScanFile
+	strip   bool
+	onFound func(file ScanFile, kinds []string)
+
+	next    func() (io.Reader, error) // next returns the next segment to emit, io.EOF at the end.
+	current io.Reader
+	started bool
+	found   []string
+}
+
+func newImageMetadataReader(
+	file ScanFile, r io.Reader, strip bool, onFound func(file ScanFile, kinds []string),
+) *imageMetadataReader {
+	m := &imageMetadataReader{src: bufio.NewReader(r), file: file, strip: strip, onFound: onFound}
+	m.next = m.sniff
+	return m
+}
+
+func (m *imageMetadataReader) Read(p []byte) (int, error) {
+	for {
+		if m.current == nil {
+			segment, err := m.next()
+			if err == io.EOF {
+				m.report()
+			}
+			if err != nil {
+				return 0, err
+			}
+			m.current = segment
+		}
+		n, err := m.current.Read(p)
+		if err == io.EOF {
+			m.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (m *imageMetadataReader) report() {
+	if len(m.found) > 0 && m.onFound != nil {
+		m.onFound(m.file, m.found)
+	}
+	m.found = nil
+}
+
+func (m *imageMetadataReader) add(kinds ...string) {
+	for _, kind := range kinds {
+		if !slices.Contains(m.found, kind) {
+			m.found = append(m.found, kind)
+		}
+	}
+}
+
+// sniff picks the parser of the image format.
+func (m *imageMetadataReader) sniff() (io.Reader, error) {
+	magic, _ := m.src.Peek(12)
+	switch {
+	case bytes.HasPrefix(magic, jpegMagic):
+		m.next = m.nextJPEG
+	case bytes.HasPrefix(magic, pngMagic):
+		m.next = m.nextPNG
+	case len(magic) == 12 && string(magic[4:8]) == "ftyp" && slices.Contains(heifBrands, string(magic[8:12])):
+		m.next = m.nextHEIF
+	default:
+		m.next = m.rest
+	}
+	return m.next()
+}
+
+// rest emits the rest of the source as-is.
+func (m *imageMetadataReader) rest() (io.Reader, error) {
+	m.next = m.end
+	return m.src, nil
+}
+
+func (m *imageMetadataReader) end() (io.Reader, error) {
+	return nil, io.EOF
+}
+
+// segment emits header and the payload of the given size, or drops them if drop.
+func (m *imageMetadataReader) segment(header []byte, payload []byte, size int64, drop bool) (io.Reader, error) {
+	switch {
+	case drop && payload != nil:
+		return bytes.NewReader(nil), nil
+	case drop:
+		_, err := io.CopyN(io.Discard, m.src, size)
+		return bytes.NewReader(nil), noEOF(err)
+	case payload != nil:
+		return io.MultiReader(bytes.NewReader(header), bytes.NewReader(payload)), nil
+	default:
+		return io.MultiReader(bytes.NewReader(header), io.LimitReader(m.src, size)), nil
+	}
+}
+
+// nextJPEG emits the next marker segment of a JPEG, see ITU T.81 Annex B.
+func (m *imageMetadataReader) nextJPEG() (io.Reader, error) {
+	if !m.started {
+		m.started = true
+		return io.LimitReader(m.src, 2), nil // SOI
+	}
+	b, err := m.src.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if b != 0xFF {
+		// not a marker, pass the rest through.
+		_ = m.src.UnreadByte()
+		return m.rest()
+	}
+	marker := byte(0xFF)
+	for marker == 0xFF { // fill bytes
+		if marker, err = m.src.ReadByte(); err != nil {
+			return nil, noEOF(err)
+		}
+	}
+	header := []byte{0xFF, marker}
+	switch {
+	case marker == 0xDA || marker == 0xD9:
+		// start of scan or end of image, the rest is entropy-coded data.
+		m.next = m.rest
+		return bytes.NewReader(header), nil
+	case marker >= 0xD0 && marker <= 0xD7 || marker == 0x01:
+		// markers without payload.
+		return bytes.NewReader(header), nil
+	}
+
+	var length [2]byte
+	if _, err = io.ReadFull(m.src, length[:]); err != nil {
+		return nil, noEOF(err)
+	}
+	header = append(header, length[:]...)
+	size := int64(binary.BigEndian.Uint16(length[:])) - 2
+	if size < 0 {
+		return io.MultiReader(bytes.NewReader(header), m.src), nil
+	}
+
+	var kinds []string
+	var payload []byte
+	switch marker {
+	case 0xE1: // APP1
+		prefix, _ := m.src.Peek(int(min(size, 29)))
+		switch {
+		case bytes.HasPrefix(prefix, []byte("Exif\x00\x00")):
+			if payload, err = m.readPayload(size); err != nil {
+				return nil, err
+			}
+			kinds = append(kinds, ImageMetadataExif)
+			if exifHasGPS(payload[6:]) {
+				kinds = append(kinds, ImageMetadataGPS)
+			}
+		case bytes.HasPrefix(prefix, []byte("http://ns.adobe.com/")):
+			kinds = append(kinds, ImageMetadataXMP)
+		}
+	case 0xED: // APP13, Photoshop IRB
+		kinds = append(kinds, ImageMetadataIPTC)
+	case 0xFE: // COM
+		kinds = append(kinds, ImageMetadataComment)
+	}
+	m.add(kinds...)
+	return m.segment(header, payload, size, m.strip && len(kinds) > 0)
+}
+
+// nextPNG emits the next chunk of a PNG, see the PNG specification, section 5.
+func (m *imageMetadataReader) nextPNG() (io.Reader, error) {
+	if !m.started {
+		m.started = true
+		return io.LimitReader(m.src, int64(len(pngMagic))), nil
+	}
+	var header [8]byte
+	if n, err := io.ReadFull(m.src, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			// trailing bytes, not a chunk.
+			m.next = m.end
+			return bytes.NewReader(header[:n]), nil
+		}
+		return nil, err
+	}
+	size := int64(binary.BigEndian.Uint32(header[:4])) + 4 // data and CRC
+	var kinds []string
+	var payload []byte
+	switch string(header[4:8]) {
+	case "eXIf":
+		kinds = append(kinds, ImageMetadataExif)
+		if size <= maxImageMetadataSize {
+			var err error
+			if payload, err = m.readPayload(size); err != nil {
+				return nil, err
+			}
+			if exifHasGPS(payload) {
+				kinds = append(kinds, ImageMetadataGPS)
+			}
+		}
+	case "iTXt":
+		keyword, _ := m.src.Peek(int(min(size, 18)))
+		if bytes.HasPrefix(keyword, []byte("XML:com.adobe.xmp\x00")) {
+			kinds = append(kinds, ImageMetadataXMP)
+		} else {
+			kinds = append(kinds, ImageMetadataText)
+		}
+	case "tEXt", "zTXt":
+		kinds = append(kinds, ImageMetadataText)
+	}
+	m.add(kinds...)
+	return m.segment(header[:], payload, size, m.strip && len(kinds) > 0)
+}
+
+// nextHEIF emits the next top-level box of an HEIF (ISO/IEC 23008-12) file, the Exif and XMP items are only
+// reported, since the offsets of the other items would have to be rewritten to remove them.
+func (m *imageMetadataReader) nextHEIF() (io.Reader, error) {
+	var header [8]byte
+	if n, err := io.ReadFull(m.src, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			// trailing bytes, not a chunk.
+			m.next = m.end
+			return bytes.NewReader(header[:n]), nil
+		}
+		return nil, err
+	}
+	size := int64(binary.BigEndian.Uint32(header[:4])) - 8
+	if size < 0 {
+		// 64-bit or open-ended box, which is never the meta box in practice.
+		return io.MultiReader(bytes.NewReader(header[:]), m.src), nil
+	}
+	if string(header[4:8]) != "meta" || size > maxImageMetadataSize {
+		return m.segment(header[:], nil, size, false)
+	}
+	payload, err := m.readPayload(size)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Contains(payload, []byte("infe")) && bytes.Contains(payload, []byte("Exif")) {
+		m.add(ImageMetadataExif)
+	}
+	if bytes.Contains(payload, []byte("application/rdf+xml")) {
+		m.add(ImageMetadataXMP)
+	}
+	return m.segment(header[:], payload, size, false)
+}
+
+func (m *imageMetadataReader) readPayload(size int64) ([]byte, error) {
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(m.src, payload); err != nil {
+		return nil, noEOF(err)
+	}
+	return payload, nil
+}
+
+// exifHasGPS reports whether the IFD0 of the Exif TIFF structure has a GPS IFD pointer.
+func exifHasGPS(tiff []byte) bool {
+	if len(tiff) < 8 {
+		return false
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return false
+	}
+	offset := int64(order.Uint32(tiff[4:8]))
+	if offset+2 > int64(len(tiff)) {
+		return false
+	}
+	count := int64(order.Uint16(tiff[offset:]))
+	for i := range count {
+		entry := offset + 2 + i*12
+		if entry+12 > int64(len(tiff)) {
+			return false
+		}
+		if order.Uint16(tiff[entry:]) == 0x8825 {
+			return true
+		}
+	}
+	return false
+}
+
+// noEOF converts io.EOF to io.ErrUnexpectedEOF, for the reads which must not reach the end.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,134 @@
+package gatewayfile
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// JanitorConfig is the configuration of a Janitor.
+type JanitorConfig struct {
+	// Dirs are the directories of the temporary files, defaults to os.TempDir().
+	Dirs []string
+	// Patterns match the names of the temporary files (see filepath.Match), defaults to the spill files
+	// of multipart forms ("multipart-*") and of TempSink ("gatewayfile-*").
+	Patterns []string
+	// TTL is the age after which a temporary file is considered orphaned, defaults to 24 hours.
+	// It must be longer than the longest upload.
+	TTL time.Duration
+	// Interval is the interval between sweeps, defaults to TTL/4.
+	Interval time.Duration
+	// OnSweep is called after each sweep with its stats, e.g. to export metrics. May be nil.
+	OnSweep func(stats JanitorStats)
+}
+
+// JanitorStats are the stats of sweeps.
+type JanitorStats struct {
+	Files  int   // Files is the number of files removed.
+	Bytes  int64 // Bytes is the size of the files removed.
+	Errors int   // Errors is the number of files which could not be removed.
+}
+
+// Janitor removes the temporary files of uploads left behind by crashed or canceled requests.
+// It's opt-in: start it with Run, e.g. go janitor.Run(ctx).
+type Janitor struct {
+	cfg JanitorConfig
+
+	mu    sync.Mutex
+	total JanitorStats
+}
+
+// NewJanitor returns a new Janitor.
+func NewJanitor(cfg JanitorConfig) *Janitor {
+	if len(cfg.Dirs) == 0 {
+		cfg.Dirs = []string{os.TempDir()}
+	}
+	if len(cfg.Patterns) == 0 {
+		cfg.Patterns = []string{"multipart-*", "gatewayfile-*"}
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 24 * time.Hour
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = cfg.TTL / 4
+	}
+	return &Janitor{cfg: cfg}
+}
+
+// Run sweeps now, then every interval, until ctx is done. It returns the error of ctx.
+func (j *Janitor) Run(ctx context.Context) error {
+	ticker := time.NewTicker(j.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		j.Sweep()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep removes the temporary files older than the TTL once, and returns what it reclaimed.
+// Subdirectories are not scanned.
+func (j *Janitor) Sweep() JanitorStats {
+	var stats JanitorStats
+	deadline := time.Now().Add(-j.cfg.TTL)
+	for _, dir := range j.cfg.Dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				stats.Errors++
+			}
+			continue
+		}
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() || !j.match(entry.Name()) {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil || info.ModTime().After(deadline) {
+				// removed meanwhile, or still in use.
+				continue
+			}
+			if err = os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+				if !errors.Is(err, fs.ErrNotExist) {
+					stats.Errors++
+				}
+				continue
+			}
+			stats.Files++
+			stats.Bytes += info.Size()
+		}
+	}
+
+	j.mu.Lock()
+	j.total.Files += stats.Files
+	j.total.Bytes += stats.Bytes
+	j.total.Errors += stats.Errors
+	j.mu.Unlock()
+	if j.cfg.OnSweep != nil {
+		j.cfg.OnSweep(stats)
+	}
+	return stats
+}
+
+// Stats returns the total stats of the sweeps so far.
+func (j *Janitor) Stats() JanitorStats {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.total
+}
+
+func (j *Janitor) match(name string) bool {
+	for _, pattern := range j.cfg.Patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package gatewayfile
+
+import (
+	"context"
+	"io"
+)
+
+// Limiter limits the bandwidth of transfers, one token is one byte.
+// *rate.Limiter of golang.org/x/time/rate implements it, as can external limiters, e.g. Redis-based ones.
+type Limiter interface {
+	// WaitN blocks until n tokens are available, or ctx is done.
+	WaitN(ctx context.Context, n int) error
+}
+
+// burster is implemented by limiters which can't grant more than Burst tokens at once, like *rate.Limiter.
+type burster interface {
+	Burst() int
+}
+
+// waitN waits for n tokens, split into chunks the limiter can grant.
+func waitN(ctx context.Context, limiter Limiter, n int) error {
+	chunk := n
+	if b, ok := limiter.(burster); ok && b.Burst() > 0 {
+		chunk = b.Burst()
+	}
+	for n > 0 {
+		wn := min(n, chunk)
+		if err := limiter.WaitN(ctx, wn); err != nil {
+			return err
+		}
+		n -= wn
+	}
+	return nil
+}
+
+// NewLimitedReader returns a reader which waits for the limiter before returning the bytes read from r.
+func NewLimitedReader(ctx context.Context, r io.Reader, limiter Limiter) io.Reader {
+	return &limitedReader{ctx: ctx, reader: r, limiter: limiter}
+}
+
+type limitedReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	limiter Limiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		if waitErr := waitN(r.ctx, r.limiter, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// NewLimitedWriter returns a writer which waits for the limiter before writing to w.
+func NewLimitedWriter(ctx context.Context, w io.Writer, limiter Limiter) io.Writer {
+	return &limitedWriter{ctx: ctx, writer: w, limiter: limiter}
+}
+
+type limitedWriter struct {
+	ctx     context.Context
+	writer  io.Writer
+	limiter Limiter
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if err := waitN(w.ctx, w.limiter, len(p)); err != nil {
+		return 0, err
+	}
+	return w.writer.Write(p)
+}
+
+// WithRateLimit limits the bandwidth of the download with limiter.
+// The limiter may be shared by many downloads to cap their total bandwidth.
+func WithRateLimit(limiter Limiter) ServeOption {
+	return func(o *serveOptions) {
+		o.wrapWriters = append(o.wrapWriters, func(w io.Writer) io.Writer {
+			return NewLimitedWriter(o.ctx, w, limiter)
+		})
+	}
+}
+
+// WithUploadRateLimit limits the bandwidth of the upload with limiter.
+// The limiter may be shared by many uploads to cap their total bandwidth.
+func WithUploadRateLimit(limiter Limiter) FormDataOption {
+	return func(o *formDataOptions) {
+		o.wrapReaders = append(o.wrapReaders, func(ctx context.Context, r io.Reader) io.Reader {
+			return NewLimitedReader(ctx, r, limiter)
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package gatewayfile
+
+import (
+	"context"
+	"io"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limits are the limits of transfers which can be adjusted at runtime, see LiveLimits. 0 means unlimited.
+type Limits struct {
+	// MaxUploadSize caps the size of upload bodies, on top of the sizeLimit given to NewFormData, StreamFormData...
+	MaxUploadSize int64
+	// UploadRate is the total bandwidth of the uploads, in bytes per second.
+	UploadRate int64
+	// DownloadRate is the total bandwidth of the downloads, in bytes per second.
+	DownloadRate int64
+	// ChunkSize is the maximum size of the messages of the downloads, see FlushPolicy.Bytes.
+	ChunkSize int
+	// MaxConcurrent is the maximum number of concurrent downloads, see ConcurrencyConfig.
+	MaxConcurrent int
+	// MaxQueue is the maximum number of downloads waiting for a slot when MaxConcurrent is reached.
+	MaxQueue int
+}
+
+// LiveLimits holds Limits which can be swapped atomically at runtime, e.g. by an admin endpoint or a config file
+// watcher, so operators can loosen or tighten them during an incident without restarting the gateway.
+// Sizes and chunk sizes are read when a transfer starts, rates and concurrency caps also apply to the transfers
+// in progress, e.g. lowering MaxConcurrent doesn't abort downloads but delays the next ones.
+type LiveLimits struct {
+	limits atomic.Pointer[Limits]
+
+	uploads     *rateBucket
+	downloads   *rateBucket
+	concurrency *ConcurrencyLimiter
+
+	mu       sync.Mutex
+	watchers map[int]func(limits Limits)
+	nextID   int
+}
+
+// NewLiveLimits returns LiveLimits starting with limits.
+func NewLiveLimits(limits Limits) *LiveLimits {
+	l := &LiveLimits{
+		uploads:     &rateBucket{},
+		downloads:   &rateBucket{},
+		concurrency: NewConcurrencyLimiter(ConcurrencyConfig{}),
+		watchers:    make(map[int]func(limits Limits)),
+	}
+	l.apply(limits)
+	return l
+}
+
+// Config returns the current limits.
+func (l *LiveLimits) Config() Limits {
+	return *l.limits.Load()
+}
+
+// SetConfig replaces the limits, and calls the Watch callbacks with them.
+func (l *LiveLimits) SetConfig(limits Limits) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.apply(limits)
+	for _, f := range l.watchers {
+		f(limits)
+	}
+}
+
+// apply replaces the limits, l.mu must be held once the LiveLimits is shared.
+func (l *LiveLimits) apply(limits Limits) {
+	l.limits.Store(&limits)
+	l.uploads.setRate(limits.UploadRate)
+	l.downloads.setRate(limits.DownloadRate)
+	maxConcurrent, maxQueue := limits.MaxConcurrent, limits.MaxQueue
+	if maxConcurrent <= 0 {
+		maxConcurrent = math.MaxInt
+	}
+	l.concurrency.setLimits(maxConcurrent, maxQueue)
+}
+
+// Watch calls f with the new limits each time they're replaced, e.g. to log them,
+// until stop is called. f is called by SetConfig, it should not block.
+func (l *LiveLimits) Watch(f func(limits Limits)) (stop func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	id := l.nextID
+	l.nextID++
+	l.watchers[id] = f
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.watchers, id)
+	}
+}
+
+// ServeOption applies the download limits: DownloadRate, ChunkSize, MaxConcurrent and MaxQueue.
+// It's answered with 503 Service Unavailable when the queue is full, see WithConcurrencyLimit.
+func (l *LiveLimits) ServeOption() ServeOption {
+	return func(o *serveOptions) {
+		limits := l.Config()
+		if limits.ChunkSize > 0 {
+			o.flush.Bytes = limits.ChunkSize
+		}
+		o.concurrency = l.concurrency
+		o.wrapWriters = append(o.wrapWriters, func(w io.Writer) io.Writer {
+			return NewLimitedWriter(o.ctx, w, l.downloads)
+		})
+	}
+}
+
+// FormDataOption applies the upload limits: MaxUploadSize, which fails the upload with ErrSizeLimitExceeded,
+// and UploadRate.
+func (l *LiveLimits) FormDataOption() FormDataOption {
+	return func(o *formDataOptions) {
+		o.wrapReaders = append(o.wrapReaders, func(ctx context.Context, r io.Reader) io.Reader {
+			if limit := l.Config().MaxUploadSize; limit > 0 {
+				r = &exactLimitReader{reader: r, remaining: limit}
+			}
+			return NewLimitedReader(ctx, r, l.uploads)
+		})
+	}
+}
+
+// rateBucket is a token bucket Limiter whose rate can be changed while it's used, 0 is unlimited.
+// It holds up to one second of tokens. Tokens are borrowed, a transfer waits until the debt is paid back.
+type rateBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	tokens float64
+	last   time.Time
+}
+
+func (b *rateBucket) setRate(rate int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	b.rate = float64(max(rate, 0))
+	b.tokens = min(b.tokens, b.rate)
+}
+
+// refill adds the tokens earned since the last refill, b.mu must be held.
+func (b *rateBucket) refill(now time.Time) {
+	if !b.last.IsZero() {
+		b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.rate, b.rate)
+	}
+	b.last = now
+}
+
+func (b *rateBucket) WaitN(ctx context.Context, n int) error {
+	b.mu.Lock()
+	if b.rate == 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	b.refill(time.Now())
+	b.tokens -= float64(n)
+	wait := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Burst splits large reads and writes, so a lowered rate applies to them quickly. 0 doesn't split them.
+func (b *rateBucket) Burst() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return int(b.rate)
+}
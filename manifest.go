@@ -0,0 +1,89 @@
+package gatewayfile
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Manifest describes a batch of files, so clients can orchestrate parallel or resumable batch downloads
+// against the individual file endpoints, see BuildManifest.
+type Manifest struct {
+	Files     []ManifestEntry `json:"files"`
+	TotalSize int64           `json:"total_size"`
+}
+
+// ManifestEntry describes a file of a Manifest.
+type ManifestEntry struct {
+	Name         string     `json:"name"`
+	Size         int64      `json:"size"`
+	ContentType  string     `json:"content_type,omitempty"`
+	ETag         string     `json:"etag,omitempty"`
+	LastModified *time.Time `json:"last_modified,omitempty"`
+	// URL is where the file can be downloaded, e.g. a signed URL, see ManifestConfig.URL.
+	URL string `json:"url,omitempty"`
+	// Ranges are Range header values splitting the file in chunks, see ManifestConfig.ChunkSize.
+	Ranges []string `json:"ranges,omitempty"`
+}
+
+// ManifestConfig configures BuildManifest.
+type ManifestConfig struct {
+	// ChunkSize splits each file in range hints of ChunkSize bytes (0 = no range hints).
+	ChunkSize int64
+	// URL returns the download URL of a file, e.g. a signed URL. May be nil.
+	URL func(ctx context.Context, source string, info ContentInfo) (string, error)
+}
+
+// BuildManifest builds the manifest of the sources. The entries are named after the sources.
+func BuildManifest(ctx context.Context, cfg ManifestConfig, sources ...Source) (Manifest, error) {
+	manifest := Manifest{Files: make([]ManifestEntry, 0, len(sources))}
+	for _, source := range sources {
+		info, err := StatContent(ctx, source.Provider)
+		if err != nil {
+			return Manifest{}, fmt.Errorf("stat %s failed %w", source.Name, err)
+		}
+		entry := ManifestEntry{
+			Name:        source.Name,
+			Size:        info.Size,
+			ContentType: info.ContentType,
+			ETag:        info.ETag,
+			Ranges:      rangeHints(info.Size, cfg.ChunkSize),
+		}
+		if !isZeroTime(info.ModTime) {
+			modTime := info.ModTime.UTC()
+			entry.LastModified = &modTime
+		}
+		if cfg.URL != nil {
+			if entry.URL, err = cfg.URL(ctx, source.Name, info); err != nil {
+				return Manifest{}, fmt.Errorf("url of %s failed %w", source.Name, err)
+			}
+		}
+		manifest.Files = append(manifest.Files, entry)
+		manifest.TotalSize += info.Size
+	}
+	return manifest, nil
+}
+
+// rangeHints splits size bytes in Range header values of chunkSize bytes.
+func rangeHints(size, chunkSize int64) []string {
+	if chunkSize <= 0 || size <= chunkSize {
+		return nil
+	}
+	hints := make([]string, 0, (size+chunkSize-1)/chunkSize)
+	for start := int64(0); start < size; start += chunkSize {
+		end := min(start+chunkSize, size) - 1
+		hints = append(hints, fmt.Sprintf("bytes=%d-%d", start, end))
+	}
+	return hints
+}
+
+// ServeManifest serves the manifest as JSON.
+func ServeManifest(server downloadServer, manifest Manifest, opts ...ServeOption) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	return ServeContent(server, bytes.NewReader(data), "application/json", "", time.Time{}, int64(len(data)), opts...)
+}
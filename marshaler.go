@@ -11,19 +11,69 @@ import (
 
 // WithDefaultHTTPBodyMarshaler returns a ServeMuxOption which associates inbound and outbound Marshalers to
 // a MIME type in mux.
-func WithDefaultHTTPBodyMarshaler() runtime.ServeMuxOption {
-	return WithHTTPBodyMarshaler("multipart/form-data")
+func WithDefaultHTTPBodyMarshaler(opts ...MarshalerOption) runtime.ServeMuxOption {
+	return WithHTTPBodyMarshaler("multipart/form-data", opts...)
 }
 
-func WithHTTPBodyMarshaler(mime string) runtime.ServeMuxOption {
-	return runtime.WithMarshalerOption(mime, &httpBodyMarshaler{
-		HTTPBodyMarshaler: &runtime.HTTPBodyMarshaler{
-			Marshaler: &runtime.JSONPb{
-				MarshalOptions:   protojson.MarshalOptions{EmitUnpopulated: true},
-				UnmarshalOptions: protojson.UnmarshalOptions{DiscardUnknown: true},
-			},
+// WithHTTPBodyMarshaler returns a ServeMuxOption which associates the HttpBody marshaler to the given MIME type.
+// Messages other than HttpBody are handled by the fallback marshaler, JSONPb by default, see WithFallbackMarshaler.
+func WithHTTPBodyMarshaler(mime string, opts ...MarshalerOption) runtime.ServeMuxOption {
+	o := &marshalerOptions{
+		fallback: &runtime.JSONPb{
+			MarshalOptions:   protojson.MarshalOptions{EmitUnpopulated: true},
+			UnmarshalOptions: protojson.UnmarshalOptions{DiscardUnknown: true},
 		},
-	})
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	marshaler := &httpBodyMarshaler{
+		HTTPBodyMarshaler: &runtime.HTTPBodyMarshaler{Marshaler: o.fallback},
+	}
+	return func(mux *runtime.ServeMux) {
+		for _, mime := range append([]string{mime}, o.mimes...) {
+			runtime.WithMarshalerOption(mime, marshaler)(mux)
+		}
+	}
+}
+
+// MIME types of upload protocols, see WithMIMEs.
+const (
+	MIMEOffsetOctetStream = "application/offset+octet-stream"   // MIMEOffsetOctetStream - tus PATCH requests
+	MIMEFormURLEncoded    = "application/x-www-form-urlencoded" // MIMEFormURLEncoded - HTML forms without files
+	MIMEOctetStream       = "application/octet-stream"          // MIMEOctetStream - raw binary uploads
+)
+
+// MarshalerOption configures the HttpBody marshaler, see WithHTTPBodyMarshaler.
+type MarshalerOption func(*marshalerOptions)
+
+type marshalerOptions struct {
+	fallback runtime.Marshaler
+	mimes    []string
+}
+
+// WithMIMEs also registers the HttpBody marshaler for the given MIME types, so one call covers all the types
+// of an upload protocol, e.g. WithMIMEs(MIMEOffsetOctetStream) for tus, or MIMEFormURLEncoded for plain forms.
+func WithMIMEs(mimes ...string) MarshalerOption {
+	return func(o *marshalerOptions) {
+		o.mimes = append(o.mimes, mimes...)
+	}
+}
+
+// WithResumableMIMEs registers the HttpBody marshaler for the types of the resumable upload protocols
+// and of plain forms: MIMEOffsetOctetStream, MIMEOctetStream and MIMEFormURLEncoded.
+func WithResumableMIMEs() MarshalerOption {
+	return WithMIMEs(MIMEOffsetOctetStream, MIMEOctetStream, MIMEFormURLEncoded)
+}
+
+// WithFallbackMarshaler sets the marshaler used for messages other than HttpBody,
+// e.g. runtime.ProtoMarshaller or a JSONPb with the project's own options.
+func WithFallbackMarshaler(marshaler runtime.Marshaler) MarshalerOption {
+	return func(o *marshalerOptions) {
+		if marshaler != nil {
+			o.fallback = marshaler
+		}
+	}
 }
 
 // httpBodyMarshaler is the same as runtime.HTTPBodyMarshaler.
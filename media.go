@@ -0,0 +1,129 @@
+package gatewayfile
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The content types of HLS and DASH playlists and segments.
+const (
+	MIMEHLSPlaylist  = "application/vnd.apple.mpegurl" // MIMEHLSPlaylist - .m3u8
+	MIMEDASHManifest = "application/dash+xml"          // MIMEDASHManifest - .mpd
+)
+
+// mediaTypes are the content types of the media segments by extension,
+// some of them are missing from the mime package on most systems.
+var mediaTypes = map[string]string{
+	".m3u8": MIMEHLSPlaylist,
+	".mpd":  MIMEDASHManifest,
+	".ts":   "video/mp2t",
+	".m4s":  "video/iso.segment",
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".m4a":  "audio/mp4",
+	".aac":  "audio/aac",
+	".cmfv": "video/mp4",
+	".cmfa": "audio/mp4",
+	".vtt":  "text/vtt",
+	".webm": "video/webm",
+}
+
+// segmentFlushSize is the size of the messages of media segments, smaller than defaultBufSize
+// so players get the first frames sooner.
+const segmentFlushSize = 256 << 10
+
+// ServePlaylist serves an HLS (.m3u8) or DASH (.mpd) playlist named name, with its content type
+// and no-cache directives, since live playlists change constantly.
+// If rewrite is not nil, it rewrites the URIs of the playlist, e.g. to sign them or point them to a CDN:
+// the segment and variant lines and the URI attributes of HLS, the BaseURL elements and the media, initialization
+// and sourceURL attributes of DASH. Options may override the defaults, e.g. WithCacheProfile.
+func ServePlaylist(
+	server downloadServer, name string, playlist io.Reader, rewrite func(uri string) string, opts ...ServeOption,
+) error {
+	data, err := io.ReadAll(playlist)
+	if err != nil {
+		return err
+	}
+	contentType := mediaTypes[strings.ToLower(filepath.Ext(name))]
+	if rewrite != nil {
+		switch contentType {
+		case MIMEHLSPlaylist:
+			data = rewriteHLS(data, rewrite)
+		case MIMEDASHManifest:
+			data = rewriteDASH(data, rewrite)
+		}
+	}
+	defaults := []ServeOption{WithCacheProfile(CacheProfile{NoCache: true}), WithInline()}
+	return ServeContent(
+		server, bytes.NewReader(data), contentType, name, time.Time{}, int64(len(data)), append(defaults, opts...)...,
+	)
+}
+
+// ServeSegment serves the media segment at path, e.g. a .ts or .m4s file, with its content type,
+// an inline disposition, and messages of 256 KB so players start sooner. Segments never change once written,
+// so they're cacheable for a year. Options may override the defaults, e.g. WithFlushPolicy.
+func ServeSegment(server downloadServer, path string, opts ...ServeOption) error {
+	defaults := []ServeOption{
+		WithCacheProfile(CacheProfile{Public: true, MaxAge: 365 * 24 * time.Hour, Immutable: true}),
+		WithInline(),
+		WithFlushPolicy(FlushPolicy{Bytes: segmentFlushSize}),
+	}
+	contentType := mediaTypes[strings.ToLower(filepath.Ext(path))]
+	return ServeFile(server, contentType, path, append(defaults, opts...)...)
+}
+
+var hlsURIAttribute = regexp.MustCompile(`URI="([^"]*)"`)
+
+// rewriteHLS rewrites the URI lines and the URI attributes of the tags of an HLS playlist.
+func rewriteHLS(data []byte, rewrite func(uri string) string) []byte {
+	lines := strings.SplitAfter(string(data), "\n")
+	for i, line := range lines {
+		content := strings.TrimRight(line, "\r\n")
+		eol := line[len(content):]
+		switch {
+		case strings.TrimSpace(content) == "":
+		case strings.HasPrefix(content, "#"):
+			lines[i] = hlsURIAttribute.ReplaceAllStringFunc(content, func(attr string) string {
+				return `URI="` + rewrite(hlsURIAttribute.FindStringSubmatch(attr)[1]) + `"`
+			}) + eol
+		default:
+			lines[i] = rewrite(strings.TrimSpace(content)) + eol
+		}
+	}
+	return []byte(strings.Join(lines, ""))
+}
+
+var (
+	dashBaseURL   = regexp.MustCompile(`(<BaseURL[^>]*>)([^<]*)(</BaseURL>)`)
+	dashAttribute = regexp.MustCompile(`\b(media|initialization|sourceURL)="([^"]*)"`)
+)
+
+// rewriteDASH rewrites the BaseURL elements and the URL attributes of a DASH manifest.
+// The URL templates, e.g. "seg-$Number$.m4s", are passed to rewrite as-is.
+func rewriteDASH(data []byte, rewrite func(uri string) string) []byte {
+	data = dashBaseURL.ReplaceAllFunc(data, func(element []byte) []byte {
+		m := dashBaseURL.FindSubmatch(element)
+		return []byte(string(m[1]) + escapeXML(rewrite(unescapeXML(string(m[2])))) + string(m[3]))
+	})
+	return dashAttribute.ReplaceAllFunc(data, func(attr []byte) []byte {
+		m := dashAttribute.FindSubmatch(attr)
+		return []byte(string(m[1]) + `="` + escapeXML(rewrite(unescapeXML(string(m[2])))) + `"`)
+	})
+}
+
+var xmlUnescaper = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&quot;", `"`, "&apos;", "'")
+
+func unescapeXML(s string) string {
+	return xmlUnescaper.Replace(s)
+}
+
+func escapeXML(s string) string {
+	var buf strings.Builder
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
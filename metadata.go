@@ -0,0 +1,41 @@
+package gatewayfile
+
+import (
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// Prefixes of the custom object metadata headers of the object stores, see WithMetadataPrefixes.
+const (
+	AmzMetaPrefix  = "X-Amz-Meta-"  // AmzMetaPrefix - S3
+	GoogMetaPrefix = "X-Goog-Meta-" // GoogMetaPrefix - GCS
+)
+
+// WithMetadata attaches custom object metadata to the response, like ContentInfo.Metadata does for providers.
+// Each key is emitted as a response header named by the metadata prefixes of the forward response option,
+// "X-Meta-<key>" by default, see WithMetadataPrefixes.
+func WithMetadata(md map[string]string) ServeOption {
+	return func(o *serveOptions) {
+		for k, v := range md {
+			withHeader(headerMetaPrefix+strings.ToLower(k), v)(o)
+		}
+	}
+}
+
+// metadataPrefixes are the header prefixes recognized as custom object metadata in upstream responses.
+var metadataPrefixes = []string{headerMetaPrefix, strings.ToLower(AmzMetaPrefix), strings.ToLower(GoogMetaPrefix)}
+
+// copyMetadataHeaders copies the custom object metadata of an upstream response to outgoing.
+func copyMetadataHeaders(outgoing metadata.MD, header http.Header) {
+	for key := range header {
+		lower := strings.ToLower(key)
+		for _, prefix := range metadataPrefixes {
+			if name, ok := strings.CutPrefix(lower, prefix); ok && name != "" {
+				outgoing.Set(headerMetaPrefix+name, header.Get(key))
+				break
+			}
+		}
+	}
+}
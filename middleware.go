@@ -0,0 +1,57 @@
+package gatewayfile
+
+import (
+	"context"
+)
+
+// DownloadServer is the server-stream of a download, e.g. grpc.ServerStreamingServer[httpbody.HttpBody].
+type DownloadServer = downloadServer
+
+// ServeFunc serves a download, e.g. a closure calling ServeFile.
+type ServeFunc func(server DownloadServer) error
+
+// DownloadMiddleware wraps a ServeFunc, e.g. to authorize, measure, throttle or transform downloads.
+// A middleware may wrap the server it passes to next, to observe or alter what is sent.
+type DownloadMiddleware func(next ServeFunc) ServeFunc
+
+// Chain composes middlewares into one, the first one is the outermost:
+//
+//	serve := gatewayfile.Chain(auth, metrics, gatewayfile.Options(gatewayfile.WithRateLimit(limiter)))(
+//		func(server gatewayfile.DownloadServer) error {
+//			return gatewayfile.ServeFile(server, "", path)
+//		},
+//	)
+//	return serve(server)
+func Chain(middlewares ...DownloadMiddleware) DownloadMiddleware {
+	return func(next ServeFunc) ServeFunc {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](next)
+		}
+		return next
+	}
+}
+
+type serveOptionsKey struct{}
+
+// Options returns a middleware which applies opts to the downloads served by next,
+// before the options given to ServeFile, ServeContent... which take precedence over them.
+func Options(opts ...ServeOption) DownloadMiddleware {
+	return func(next ServeFunc) ServeFunc {
+		return func(server DownloadServer) error {
+			ctx := server.Context()
+			inherited, _ := ctx.Value(serveOptionsKey{}).([]ServeOption)
+			all := append(append([]ServeOption(nil), inherited...), opts...)
+			return next(&contextServer{downloadServer: server, ctx: context.WithValue(ctx, serveOptionsKey{}, all)})
+		}
+	}
+}
+
+// contextServer overrides the context of a downloadServer.
+type contextServer struct {
+	downloadServer
+	ctx context.Context
+}
+
+func (s *contextServer) Context() context.Context {
+	return s.ctx
+}
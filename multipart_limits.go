@@ -0,0 +1,309 @@
+package gatewayfile
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+)
+
+var (
+	// ErrPreambleTooLarge is returned when the preamble of a multipart body, before its first boundary,
+	// exceeds MultipartLimits.MaxPreambleBytes.
+	ErrPreambleTooLarge = newError(codes.InvalidArgument, "MULTIPART_PREAMBLE_TOO_LARGE", "multipart preamble too large")
+	// ErrTooManyParts is returned when a multipart body has more parts than MultipartLimits.MaxParts.
+	ErrTooManyParts = newError(codes.InvalidArgument, "MULTIPART_TOO_MANY_PARTS", "too many multipart parts")
+	// ErrTooManyPartHeaders is returned when a part has more headers than MultipartLimits.MaxHeadersPerPart.
+	ErrTooManyPartHeaders = newError(codes.InvalidArgument, "MULTIPART_TOO_MANY_HEADERS", "too many part headers")
+	// ErrValuesTooLarge is returned when the values of a form, its parts without filename,
+	// exceed MultipartLimits.MaxValueBytes in total.
+	ErrValuesTooLarge = newError(codes.ResourceExhausted, "MULTIPART_VALUES_TOO_LARGE", "form values too large")
+	// ErrFilesTooLarge is returned when the files of a form exceed MultipartLimits.MaxFileBytes in total.
+	ErrFilesTooLarge = newError(codes.ResourceExhausted, "MULTIPART_FILES_TOO_LARGE", "form files too large")
+	// ErrPartHeaderTooLarge is returned when the headers of a part exceed MultipartLimits.MaxPartHeaderBytes,
+	// or one of them exceeds MultipartLimits.MaxHeaderLineBytes.
+	ErrPartHeaderTooLarge = newError(codes.InvalidArgument, "MULTIPART_HEADER_TOO_LARGE", "part header too large")
+)
+
+// MultipartLimits are the limits of the structure of a multipart body, see WithMultipartLimits.
+// mime/multipart only enforces process-wide limits, 10000 headers and 1000 parts by default (see the
+// multipartmaxheaders and multipartmaxparts GODEBUG settings), and 10 MB of headers. 0 means no limit beyond them.
+type MultipartLimits struct {
+	MaxPreambleBytes   int64 // MaxPreambleBytes is the maximum size of the preamble, clients never send one.
+	MaxParts           int   // MaxParts is the maximum number of parts, files and values.
+	MaxHeadersPerPart  int   // MaxHeadersPerPart is the maximum number of headers of a part.
+	MaxPartHeaderBytes int64 // MaxPartHeaderBytes is the maximum size of the headers of a part.
+	// MaxHeaderLineBytes is the maximum size of a header line of a part, e.g. its Content-Disposition with
+	// the filename, so a single crafted header can't force a large allocation while the part is parsed.
+	MaxHeaderLineBytes int64
+	// MaxValueBytes is the maximum size of the values, the parts without filename, in total.
+	// With MaxFileBytes, it lets the size limit of an upload be 0, so the files don't have to accommodate
+	// large text fields, and the text fields can't use the room of the files.
+	MaxValueBytes int64
+	// MaxFileBytes is the maximum size of the files in total.
+	MaxFileBytes int64
+}
+
+// StrictMultipartLimits are limits for forms of a few files and values sent by browsers or usual clients.
+var StrictMultipartLimits = MultipartLimits{
+	MaxPreambleBytes:   1 << 10,
+	MaxParts:           100,
+	MaxHeadersPerPart:  8,
+	MaxPartHeaderBytes: 8 << 10,
+	MaxHeaderLineBytes: 2 << 10,
+	MaxValueBytes:      1 << 20,
+}
+
+// WithMultipartLimits enforces limits on the structure of the multipart body while it's read, to harden the
+// gateway against crafted payloads. The upload fails with a typed error as soon as a limit is exceeded,
+// e.g. ErrTooManyParts, before mime/multipart parses the offending part.
+func WithMultipartLimits(limits MultipartLimits) FormDataOption {
+	return func(o *formDataOptions) {
+		o.multipartLimits = &limits
+	}
+}
+
+// newMultipartReader returns the multipart reader of body, which enforces the multipart limits of the options.
+func (o *formDataOptions) newMultipartReader(body io.Reader, boundary string) *multipart.Reader {
+	if o.multipartLimits != nil {
+		o.guard = newMultipartGuard(body, boundary, o.multipartLimits)
+		body = o.guard
+	}
+	return multipart.NewReader(body, boundary)
+}
+
+// limitErr returns the error of the multipart limit exceeded, if any, instead of err.
+// mime/multipart may report a malformed header rather than the error of the read which exceeded the limit.
+func (o *formDataOptions) limitErr(err error) error {
+	if err != nil && o.guard != nil && o.guard.err != nil && !errors.Is(err, o.guard.err) {
+		return o.guard.err
+	}
+	return err
+}
+
+// states of a multipartGuard.
+const (
+	guardPreamble  = iota // before the first delimiter
+	guardDelimiter        // the rest of a delimiter line
+	guardHeaders          // the headers of a part
+	guardBody             // the body of a part
+	guardEpilogue         // after the close delimiter
+)
+
+// multipartGuard tracks the structure of a multipart body while it's read, as described by RFC 2046 section 5.1.1,
+// and fails the read which exceeds a limit.
+type multipartGuard struct {
+	r      io.Reader
+	limits *MultipartLimits
+
+	// delimiter is "\r\n--boundary", or "\n--boundary" for bodies with bare LF line breaks, and before the first
+	// delimiter line tells which.
+	delimiter []byte
+	state     int
+	found     int // found is the state a delimiter was found in, restored if its line is not a delimiter line.
+	match     int // match is the length of the prefix of delimiter matched so far.
+	dashes    int // dashes is the number of dashes after a delimiter, two make a close delimiter.
+	prev      byte
+	err       error
+
+	preamble    int64
+	parts       int
+	headers     int    // headers is the number of headers of the current part.
+	headerBytes int64  // headerBytes is the size of the headers of the current part.
+	lineBytes   int64  // lineBytes is the size of the current header or delimiter line.
+	header      []byte // header are the headers of the current part, kept to tell files from values.
+	file        bool   // file reports whether the current part is a file.
+	valueBytes  int64
+	fileBytes   int64
+}
+
+// maxGuardHeader is the maximum size of the headers kept to tell files from values,
+// a part with larger headers is considered a value.
+const maxGuardHeader = 16 << 10
+
+// classify reports whether the parts are told apart, to enforce MaxValueBytes or MaxFileBytes.
+func (g *multipartGuard) classify() bool {
+	return g.limits.MaxValueBytes > 0 || g.limits.MaxFileBytes > 0
+}
+
+func newMultipartGuard(r io.Reader, boundary string, limits *MultipartLimits) *multipartGuard {
+	return &multipartGuard{
+		r:         r,
+		limits:    limits,
+		delimiter: []byte("\n--" + boundary),
+		// the first delimiter may start the body, without line break.
+		match:    1,
+		preamble: -1,
+	}
+}
+
+func (g *multipartGuard) Read(p []byte) (int, error) {
+	if g.err != nil {
+		return 0, g.err
+	}
+	n, err := g.r.Read(p)
+	if scanErr := g.scan(p[:n]); scanErr != nil {
+		g.err = scanErr
+		return 0, scanErr
+	}
+	return n, err
+}
+
+func (g *multipartGuard) scan(p []byte) error {
+	for i := 0; i < len(p); {
+		switch g.state {
+		case guardPreamble, guardBody:
+			if g.match == 0 {
+				// skip to the next possible delimiter.
+				j := bytes.IndexByte(p[i:], g.delimiter[0])
+				if j < 0 {
+					j = len(p) - i
+				}
+				if err := g.content(int64(j)); err != nil {
+					return err
+				}
+				if i += j; i == len(p) {
+					continue
+				}
+			}
+			b := p[i]
+			i++
+			if b == g.delimiter[g.match] {
+				if g.match++; g.match == len(g.delimiter) {
+					g.found, g.state = g.state, guardDelimiter
+					g.match, g.dashes, g.prev, g.lineBytes = 0, 0, 0, 0
+				}
+				continue
+			}
+			// the matched prefix was content.
+			consumed := int64(g.match)
+			if g.match = 0; b == g.delimiter[0] {
+				g.match = 1
+			} else {
+				consumed++
+			}
+			if err := g.content(consumed); err != nil {
+				return err
+			}
+		case guardDelimiter:
+			b := p[i]
+			switch {
+			case b == '-' && int64(g.dashes) == g.lineBytes:
+				// "--" right after the delimiter makes it the close delimiter.
+				if g.dashes++; g.dashes == 2 {
+					g.state = guardEpilogue
+				}
+			case g.dashes == 1 || g.prev == '\r' && b != '\n':
+				// like mime/multipart, "--boundary-x" is content, and a CR only ends the line.
+				if err := g.notDelimiter(); err != nil {
+					return err
+				}
+				continue
+			case b == ' ' || b == '\t' || b == '\r':
+				// linear whitespace may follow the delimiter.
+			case b == '\n':
+				if g.parts == 0 && g.prev == '\r' {
+					// like mime/multipart, the line break of the first delimiter line is the one of the body.
+					g.delimiter = append([]byte{'\r'}, g.delimiter...)
+				}
+				i++
+				if err := g.beginPart(); err != nil {
+					return err
+				}
+				continue
+			default:
+				if err := g.notDelimiter(); err != nil {
+					return err
+				}
+				continue
+			}
+			i++
+			g.prev = b
+			g.lineBytes++
+		case guardHeaders:
+			b := p[i]
+			i++
+			g.headerBytes++
+			g.lineBytes++
+			if g.classify() && len(g.header) < maxGuardHeader {
+				g.header = append(g.header, b)
+			}
+			if g.limits.MaxPartHeaderBytes > 0 && g.headerBytes > g.limits.MaxPartHeaderBytes {
+				return fmt.Errorf("%w: more than %d bytes in part %d",
+					ErrPartHeaderTooLarge, g.limits.MaxPartHeaderBytes, g.parts)
+			}
+			if g.limits.MaxHeaderLineBytes > 0 && g.lineBytes > g.limits.MaxHeaderLineBytes {
+				return fmt.Errorf("%w: header line of more than %d bytes in part %d",
+					ErrPartHeaderTooLarge, g.limits.MaxHeaderLineBytes, g.parts)
+			}
+			if b != '\n' {
+				continue
+			}
+			if g.lineBytes <= 2 {
+				// the empty line ending the headers.
+				g.state = guardBody
+				g.file = g.classify() && isFilePart(g.header)
+				continue
+			}
+			g.lineBytes = 0
+			if g.headers++; g.limits.MaxHeadersPerPart > 0 && g.headers > g.limits.MaxHeadersPerPart {
+				return fmt.Errorf("%w: more than %d in part %d", ErrTooManyPartHeaders, g.limits.MaxHeadersPerPart, g.parts)
+			}
+		default: // guardEpilogue
+			return nil
+		}
+	}
+	return nil
+}
+
+// notDelimiter restores the state a delimiter was found in, because the rest of its line is not the linear
+// whitespace and line break of a delimiter line, so it's content as far as mime/multipart is concerned.
+func (g *multipartGuard) notDelimiter() error {
+	g.state = g.found
+	return g.content(int64(len(g.delimiter)) + g.lineBytes)
+}
+
+// content counts n bytes of preamble or part body.
+func (g *multipartGuard) content(n int64) error {
+	switch {
+	case g.state == guardPreamble:
+		if g.preamble += n; g.limits.MaxPreambleBytes > 0 && g.preamble > g.limits.MaxPreambleBytes {
+			return fmt.Errorf("%w: more than %d bytes", ErrPreambleTooLarge, g.limits.MaxPreambleBytes)
+		}
+	case g.file:
+		if g.fileBytes += n; g.limits.MaxFileBytes > 0 && g.fileBytes > g.limits.MaxFileBytes {
+			return fmt.Errorf("%w: more than %d bytes", ErrFilesTooLarge, g.limits.MaxFileBytes)
+		}
+	default:
+		if g.valueBytes += n; g.limits.MaxValueBytes > 0 && g.valueBytes > g.limits.MaxValueBytes {
+			return fmt.Errorf("%w: more than %d bytes", ErrValuesTooLarge, g.limits.MaxValueBytes)
+		}
+	}
+	return nil
+}
+
+// isFilePart reports whether the part of the given headers has a filename, like multipart.Part.FileName.
+func isFilePart(header []byte) bool {
+	for _, line := range bytes.Split(header, []byte("\n")) {
+		name, value, ok := bytes.Cut(line, []byte(":"))
+		if !ok || !strings.EqualFold(string(bytes.TrimSpace(name)), "Content-Disposition") {
+			continue
+		}
+		_, params, err := mime.ParseMediaType(string(bytes.TrimSpace(value)))
+		return err == nil && params["filename"] != ""
+	}
+	return false
+}
+
+func (g *multipartGuard) beginPart() error {
+	g.state, g.headers, g.headerBytes, g.lineBytes, g.header = guardHeaders, 0, 0, 0, g.header[:0]
+	if g.parts++; g.limits.MaxParts > 0 && g.parts > g.limits.MaxParts {
+		return fmt.Errorf("%w: more than %d", ErrTooManyParts, g.limits.MaxParts)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package gatewayfile
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// testMultipartBody is a form of a value "val" and a file "file!", with LF line breaks, boundary "b".
+const testMultipartBody = "--b\n" +
+	"Content-Disposition: form-data; name=\"v\"\n" +
+	"\n" +
+	"val\n" +
+	"--b\n" +
+	"Content-Disposition: form-data; name=\"f\"; filename=\"a.txt\"\n" +
+	"Content-Type: text/plain\n" +
+	"\n" +
+	"file!\n" +
+	"--b--\n"
+
+func TestMultipartGuard(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		limits MultipartLimits
+		// wantErr is the error of the guard, the other fields are checked when it's nil.
+		wantErr    error
+		wantParts  int
+		wantValues int64
+		wantFiles  int64
+	}{
+		{name: "form", body: testMultipartBody, wantParts: 2, wantValues: 3, wantFiles: 5},
+		{
+			name: "preamble and epilogue",
+			body: "preamble text\n" + testMultipartBody + "epilogue\n--b\nContent-Disposition: form-data\n\n",
+			// "preamble text" and its CR, if any.
+			limits:    MultipartLimits{MaxPreambleBytes: 14, MaxParts: 2},
+			wantParts: 2, wantValues: 3, wantFiles: 5,
+		},
+		{
+			name:    "preamble too large",
+			body:    "preamble text\n" + testMultipartBody,
+			limits:  MultipartLimits{MaxPreambleBytes: 12},
+			wantErr: ErrPreambleTooLarge,
+		},
+		{
+			name:      "no preamble",
+			body:      testMultipartBody,
+			limits:    MultipartLimits{MaxPreambleBytes: 1},
+			wantParts: 2, wantValues: 3, wantFiles: 5,
+		},
+		{
+			name: "delimiter followed by other bytes",
+			body: strings.Replace(testMultipartBody, "val\n", "val\n--bx\n--b-x\n", 1),
+			// "val\n--bx\n--b-x" is the value.
+			wantParts: 2, wantValues: 14, wantFiles: 5,
+		},
+		{
+			name:      "whitespace after the delimiter",
+			body:      strings.Replace(testMultipartBody, "val\n--b\n", "val\n--b \t\n", 1),
+			wantParts: 2, wantValues: 3, wantFiles: 5,
+		},
+		{
+			name:      "parts",
+			body:      testMultipartBody,
+			limits:    MultipartLimits{MaxParts: 2},
+			wantParts: 2, wantValues: 3, wantFiles: 5,
+		},
+		{name: "too many parts", body: testMultipartBody, limits: MultipartLimits{MaxParts: 1}, wantErr: ErrTooManyParts},
+		{
+			name:      "headers per part",
+			body:      testMultipartBody,
+			limits:    MultipartLimits{MaxHeadersPerPart: 2},
+			wantParts: 2, wantValues: 3, wantFiles: 5,
+		},
+		{
+			name:    "too many headers",
+			body:    testMultipartBody,
+			limits:  MultipartLimits{MaxHeadersPerPart: 1},
+			wantErr: ErrTooManyPartHeaders,
+		},
+		{
+			name: "header line",
+			body: testMultipartBody,
+			// the Content-Disposition of the file and its CRLF.
+			limits:    MultipartLimits{MaxHeaderLineBytes: 60},
+			wantParts: 2, wantValues: 3, wantFiles: 5,
+		},
+		{
+			name: "header line too large",
+			body: testMultipartBody,
+			// the Content-Disposition of the file, with LF, is 59 bytes.
+			limits:  MultipartLimits{MaxHeaderLineBytes: 58},
+			wantErr: ErrPartHeaderTooLarge,
+		},
+		{
+			name: "part header",
+			body: testMultipartBody,
+			// the headers of the file, and the empty line ending them, with CRLF.
+			limits:    MultipartLimits{MaxPartHeaderBytes: 88},
+			wantParts: 2, wantValues: 3, wantFiles: 5,
+		},
+		{
+			name: "part header too large",
+			body: testMultipartBody,
+			// they're 85 bytes with LF.
+			limits:  MultipartLimits{MaxPartHeaderBytes: 84},
+			wantErr: ErrPartHeaderTooLarge,
+		},
+		{
+			name: "value and file budgets",
+			body: testMultipartBody,
+			// the value doesn't use the room of the file, and conversely.
+			limits:    MultipartLimits{MaxValueBytes: 3, MaxFileBytes: 5},
+			wantParts: 2, wantValues: 3, wantFiles: 5,
+		},
+		{
+			name:    "values too large",
+			body:    testMultipartBody,
+			limits:  MultipartLimits{MaxValueBytes: 2, MaxFileBytes: 5},
+			wantErr: ErrValuesTooLarge,
+		},
+		{
+			name:    "files too large",
+			body:    testMultipartBody,
+			limits:  MultipartLimits{MaxValueBytes: 3, MaxFileBytes: 4},
+			wantErr: ErrFilesTooLarge,
+		},
+	}
+	lineBreaks := []struct {
+		name    string
+		replace func(string) string
+	}{
+		{name: "LF", replace: func(s string) string { return s }},
+		{name: "CRLF", replace: func(s string) string { return strings.ReplaceAll(s, "\n", "\r\n") }},
+	}
+	reads := []struct {
+		name string
+		wrap func(io.Reader) io.Reader
+	}{
+		{name: "whole", wrap: func(r io.Reader) io.Reader { return r }},
+		// every delimiter is split across Reads.
+		{name: "byte by byte", wrap: iotest.OneByteReader},
+	}
+	for _, tt := range tests {
+		for _, lineBreak := range lineBreaks {
+			for _, read := range reads {
+				t.Run(tt.name+"/"+lineBreak.name+"/"+read.name, func(t *testing.T) {
+					body := lineBreak.replace(tt.body)
+					limits := tt.limits
+					guard := newMultipartGuard(read.wrap(strings.NewReader(body)), "b", &limits)
+					got, err := io.ReadAll(guard)
+					if !errors.Is(err, tt.wantErr) {
+						t.Fatalf("got error %v, want %v", err, tt.wantErr)
+					}
+					if err != nil {
+						return
+					}
+					if string(got) != body {
+						t.Fatalf("got %q, want the body unchanged", got)
+					}
+					wantValues, wantFiles := tt.wantValues, tt.wantFiles
+					if lineBreak.name == "CRLF" {
+						// the line breaks within the contents are CRLF too.
+						wantValues += int64(strings.Count(tt.body, "\n--bx"))
+						wantValues += int64(strings.Count(tt.body, "\n--b-x"))
+					}
+					if !guard.classify() {
+						// the files are counted as values.
+						wantValues, wantFiles = wantValues+wantFiles, 0
+					}
+					if guard.parts != tt.wantParts || guard.valueBytes != wantValues || guard.fileBytes != wantFiles {
+						t.Fatalf("got %d parts, %d bytes of values and %d of files, want %d, %d and %d",
+							guard.parts, guard.valueBytes, guard.fileBytes, tt.wantParts, wantValues, wantFiles)
+					}
+				})
+			}
+		}
+	}
+}
+
+// TestMultipartGuardMatchesMultipart verifies the guard counts the parts mime/multipart reads.
+func TestMultipartGuardMatchesMultipart(t *testing.T) {
+	bodies := []string{
+		testMultipartBody,
+		"preamble\n" + testMultipartBody + "epilogue\n",
+		strings.ReplaceAll("preamble\n"+testMultipartBody+"epilogue\n", "\n", "\r\n"),
+		// a part without headers.
+		"--b\r\n\r\nval\r\n--b--\r\n",
+		strings.Replace(testMultipartBody, "val\n", "val\n--bx\n--b-x\n", 1),
+		strings.ReplaceAll(testMultipartBody, "\n", "\r\n"),
+	}
+	for _, body := range bodies {
+		guard := newMultipartGuard(strings.NewReader(body), "b", &MultipartLimits{})
+		reader := multipart.NewReader(guard, "b")
+		parts := 0
+		var contents [][]byte
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%q: %v", body, err)
+			}
+			content, _ := io.ReadAll(part)
+			contents = append(contents, content)
+			parts++
+		}
+		if parts != guard.parts {
+			t.Fatalf("%q: mime/multipart read %d parts, the guard counted %d", body, parts, guard.parts)
+		}
+		var total int64
+		for _, content := range contents {
+			total += int64(len(content))
+		}
+		if total != guard.valueBytes+guard.fileBytes {
+			t.Fatalf("%q: mime/multipart read %d bytes of contents %q, the guard counted %d",
+				body, total, bytes.Join(contents, []byte("|")), guard.valueBytes+guard.fileBytes)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package gatewayfile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	MIMEMultipartFormData = "multipart/form-data" // MIMEMultipartFormData - parts named by form field
+	MIMEMultipartMixed    = "multipart/mixed"     // MIMEMultipartMixed - independent parts, e.g. attachments
+)
+
+// ResponsePart is a part of a multipart response, see ServeMultipart.
+type ResponsePart struct {
+	Name        string // Name is the form field of the part, required by multipart/form-data.
+	Filename    string // Filename is the filename of the part if it's a file.
+	ContentType string
+	// Header are additional headers of the part, e.g. Content-ID or Content-Transfer-Encoding.
+	Header textproto.MIMEHeader
+	// Content is the body of the part. It's closed once the response was served if it's an io.Closer.
+	Content io.Reader
+}
+
+// FilePart returns a part carrying a file.
+func FilePart(name, filename, contentType string, content io.Reader) ResponsePart {
+	return ResponsePart{Name: name, Filename: filename, ContentType: contentType, Content: content}
+}
+
+// ValuePart returns a text/plain part carrying a value.
+func ValuePart(name, value string) ResponsePart {
+	return ResponsePart{Name: name, ContentType: "text/plain; charset=utf-8", Content: strings.NewReader(value)}
+}
+
+// JSONPart returns an application/json part carrying v, encoded by protojson if it's a proto.Message,
+// by encoding/json otherwise.
+func JSONPart(name string, v any) (ResponsePart, error) {
+	var data []byte
+	var err error
+	if message, ok := v.(proto.Message); ok {
+		data, err = protojson.Marshal(message)
+	} else {
+		data, err = json.Marshal(v)
+	}
+	if err != nil {
+		return ResponsePart{}, err
+	}
+	return ResponsePart{Name: name, ContentType: "application/json", Content: bytes.NewReader(data)}, nil
+}
+
+// ServeMultipart streams parts as a multipart response of mediaType, MIMEMultipartFormData or MIMEMultipartMixed,
+// e.g. to return a file and its metadata at once. The parts of a multipart/form-data response must be named,
+// those of a multipart/mixed response are inline, or attachments if they have a filename.
+// The response is streamed while it's built, so it has no Content-Length and doesn't support ranges.
+func ServeMultipart(server downloadServer, mediaType string, parts []ResponsePart, opts ...ServeOption) error {
+	o := newServeOptions(server.Context(), opts)
+	return o.serve(server, mediaType, func(server downloadServer) error {
+		return serveMultipart(server, mediaType, parts, o)
+	})
+}
+
+func serveMultipart(server downloadServer, mediaType string, parts []ResponsePart, o *serveOptions) error {
+	defer func() {
+		for _, part := range parts {
+			closeContent(part.Content)
+		}
+	}()
+	if mediaType != MIMEMultipartFormData && mediaType != MIMEMultipartMixed {
+		return fmt.Errorf("unsupported multipart media type %s", mediaType)
+	}
+	headers := make([]textproto.MIMEHeader, len(parts))
+	for i, part := range parts {
+		header, err := partHeader(mediaType, part)
+		if err != nil {
+			return err
+		}
+		headers[i] = header
+	}
+
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+	contentType := mediaType + "; boundary=" + boundary
+	outgoing := make(metadata.MD)
+	for key, values := range o.header {
+		outgoing.Set(key, values...)
+	}
+	setContentType(outgoing, contentType)
+	outgoing.Set(headerCode, strconv.Itoa(o.okCode()))
+	if err := server.SendHeader(outgoing); err != nil {
+		return err
+	}
+
+	// the part headers and boundaries are small writes, buffer them into full messages.
+	writer := bufio.NewWriterSize(o.newWriter(server, contentType), defaultBufSize)
+	mWriter := multipart.NewWriter(writer)
+	_ = mWriter.SetBoundary(boundary)
+	err := func() error {
+		for i, part := range parts {
+			w, err := mWriter.CreatePart(headers[i])
+			if err != nil {
+				return err
+			}
+			if part.Content != nil {
+				if _, err = io.Copy(w, part.Content); err != nil {
+					return err
+				}
+			}
+		}
+		if err := mWriter.Close(); err != nil {
+			return err
+		}
+		return writer.Flush()
+	}()
+	return o.done(server, err)
+}
+
+// partHeader returns the header of a part of a multipart response of mediaType.
+func partHeader(mediaType string, part ResponsePart) (textproto.MIMEHeader, error) {
+	header := make(textproto.MIMEHeader, len(part.Header)+2)
+	for key, values := range part.Header {
+		header[textproto.CanonicalMIMEHeaderKey(key)] = values
+	}
+
+	params := make(map[string]string)
+	disposition := "inline"
+	switch {
+	case mediaType == MIMEMultipartFormData:
+		if part.Name == "" {
+			return nil, fmt.Errorf("unnamed part %s of multipart/form-data response", part.Filename)
+		}
+		disposition, params["name"] = "form-data", part.Name
+	case part.Filename != "":
+		disposition = "attachment"
+	}
+	if part.Filename != "" {
+		params["filename"] = part.Filename
+	}
+	header.Set("Content-Disposition", mime.FormatMediaType(disposition, params))
+	if part.ContentType != "" {
+		header.Set("Content-Type", part.ContentType)
+	} else if part.Filename != "" {
+		header.Set("Content-Type", MIMEOctetStream)
+	}
+	return header, nil
+}
+
+// closeContent closes the content of a part if it's an io.Closer.
+func closeContent(content io.Reader) {
+	if closer, ok := content.(io.Closer); ok {
+		_ = closer.Close()
+	}
+}
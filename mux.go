@@ -0,0 +1,234 @@
+package gatewayfile
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+)
+
+// Config is the configuration of file support in gRPC-Gateway, see NewServeMux and WithFileSupport.
+type Config struct {
+	// MarshalerMIMEs are the MIME types the HTTPBody marshaler is registered for.
+	// Defaults to "*", which matches all Content-Type.
+	MarshalerMIMEs []string
+	// FallbackMarshaler marshals messages other than HttpBody, defaults to JSONPb.
+	FallbackMarshaler runtime.Marshaler
+	// MetadataPrefixes are the prefixes of the custom object metadata response headers, see WithMetadataPrefixes.
+	MetadataPrefixes []string
+	// ForwardOptions are extra options of the forward response option, e.g. WithWriteTimeout.
+	ForwardOptions []ForwardOption
+	// CORS enables CORS handling for all routes of the mux when not nil.
+	CORS *CORSConfig
+	// ErrorHandler handles errors returned by the gRPC service, defaults to runtime.DefaultHTTPErrorHandler.
+	// It's wrapped by HTTPErrorHandler. It also answers the downloads failing before anything was written,
+	// instead of the error chunk of the gateway. See ProblemErrorHandler for RFC 9457 problem details.
+	ErrorHandler runtime.ErrorHandlerFunc
+	// MaxRequestBodySize limits the request bodies in the gateway (0 = unlimited), see WithMaxRequestBodySize.
+	MaxRequestBodySize int64
+	// AcceptNegotiation answers file responses with their JSON metadata when the client prefers application/json,
+	// see WithAcceptNegotiation.
+	AcceptNegotiation bool
+	// RoutingErrorHandler handles routing errors, defaults to runtime.DefaultRoutingErrorHandler.
+	// CORS preflight requests are answered before it is called.
+	RoutingErrorHandler runtime.RoutingErrorHandlerFunc
+}
+
+// CORSConfig is the CORS configuration, see Config.
+type CORSConfig struct {
+	// AllowedOrigins is the list of origins a cross-domain request can be executed from, "*" allows all origins.
+	AllowedOrigins []string
+	// AllowedMethods is the list of methods allowed for cross-domain requests,
+	// defaults to GET, HEAD, POST, PUT, PATCH and DELETE.
+	AllowedMethods []string
+	// AllowedHeaders is the list of non-simple headers allowed for cross-domain requests,
+	// defaults to the headers used by file transfer (Range, If-*, Content-Type...).
+	AllowedHeaders []string
+	// ExposedHeaders is the list of response headers exposed to the client,
+	// defaults to the headers written by WithFileForwardResponseOption.
+	ExposedHeaders []string
+	// AllowCredentials indicates whether the request can include user credentials.
+	AllowCredentials bool
+	// MaxAge indicates how long the results of a preflight request can be cached (0 = not set).
+	MaxAge time.Duration
+}
+
+// NewServeMux returns a new runtime.ServeMux with file support installed from cfg.
+// extra options are applied after the file options, so they can override them.
+func NewServeMux(cfg Config, extra ...runtime.ServeMuxOption) *runtime.ServeMux {
+	return runtime.NewServeMux(append([]runtime.ServeMuxOption{WithFileSupport(cfg)}, extra...)...)
+}
+
+// WithFileSupport returns a ServeMuxOption which installs the incoming header matcher, the forward response option,
+// the HTTPBody marshalers, CORS and error handling from one config. It replaces the stream error handler of the mux.
+func WithFileSupport(cfg Config) runtime.ServeMuxOption {
+	mimes := cfg.MarshalerMIMEs
+	if len(mimes) == 0 {
+		mimes = []string{"*"}
+	}
+
+	opts := []runtime.ServeMuxOption{
+		WithFileIncomingHeaderMatcher(),
+		WithFileForwardResponseOption(append(
+			[]ForwardOption{WithMetadataPrefixes(cfg.MetadataPrefixes...)}, cfg.ForwardOptions...,
+		)...),
+	}
+	for _, mime := range mimes {
+		opts = append(opts, WithHTTPBodyMarshaler(mime, WithFallbackMarshaler(cfg.FallbackMarshaler)))
+	}
+	errorHandler := cfg.ErrorHandler
+	if errorHandler == nil {
+		errorHandler = runtime.DefaultHTTPErrorHandler
+	}
+	errorHandler = HTTPErrorHandler(errorHandler)
+	opts = append(opts, runtime.WithErrorHandler(errorHandler))
+
+	routingErrorHandler := cfg.RoutingErrorHandler
+	if routingErrorHandler == nil {
+		routingErrorHandler = runtime.DefaultRoutingErrorHandler
+	}
+	if cfg.CORS != nil {
+		cors := newCORS(cfg.CORS)
+		opts = append(opts, runtime.WithMiddlewares(cors.middleware))
+		routingErrorHandler = cors.routingErrorHandler(routingErrorHandler)
+	}
+	opts = append(opts, runtime.WithRoutingErrorHandler(routingErrorHandler))
+	// after CORS, so a 413 response has the CORS headers.
+	opts = append(opts, WithMaxRequestBodySize(cfg.MaxRequestBodySize))
+	if cfg.AcceptNegotiation {
+		opts = append(opts, WithAcceptNegotiation(WithFallbackMarshaler(cfg.FallbackMarshaler)))
+	}
+	// innermost, so the headers it restores include the CORS headers.
+	opts = append(opts, runtime.WithMiddlewares(captureErrorResponse))
+
+	return func(mux *runtime.ServeMux) {
+		for _, opt := range opts {
+			opt(mux)
+		}
+		runtime.WithStreamErrorHandler(streamErrorHandler(mux, errorHandler, runtime.DefaultStreamErrorHandler))(mux)
+	}
+}
+
+type cors struct {
+	allowedOrigins   []string
+	allowedMethods   string
+	allowedHeaders   string
+	exposedHeaders   string
+	allowCredentials bool
+	maxAge           string
+}
+
+func newCORS(cfg *CORSConfig) *cors {
+	methods := cfg.AllowedMethods
+	if len(methods) == 0 {
+		methods = []string{
+			http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+		}
+	}
+	headers := cfg.AllowedHeaders
+	if len(headers) == 0 {
+		headers = []string{
+			headerRange,
+			headerIfRange,
+			headerIfMatch,
+			headerIfNoneMatch,
+			headerIfUnmodifiedSince,
+			headerIfModifiedSince,
+			headerXUploadID,
+			headerXUploadOffset,
+			headerXUploadLength,
+			headerRequestContentRange,
+			"Content-Type",
+			"Authorization",
+		}
+	}
+	exposed := cfg.ExposedHeaders
+	if len(exposed) == 0 {
+		exposed = []string{
+			headerAcceptRanges,
+			headerContentRange,
+			headerContentLength,
+			headerContentEncoding,
+			headerContentDisposition,
+			headerLastModified,
+			headerETag,
+			headerXChunkSize,
+			headerXTotalSize,
+			headerXBlockSize,
+			headerUploadOffset,
+			headerCommittedRange,
+			headerLocation,
+			headerContentLocation,
+			headerScanStatus,
+			headerScanRejected,
+		}
+	}
+
+	c := &cors{
+		allowedOrigins:   cfg.AllowedOrigins,
+		allowedMethods:   strings.Join(methods, ", "),
+		allowedHeaders:   strings.Join(headers, ", "),
+		exposedHeaders:   strings.Join(exposed, ", "),
+		allowCredentials: cfg.AllowCredentials,
+	}
+	if cfg.MaxAge > 0 {
+		c.maxAge = strconv.Itoa(int(cfg.MaxAge.Seconds()))
+	}
+	return c
+}
+
+// allowOrigin writes the Access-Control-Allow-Origin header and reports whether the origin is allowed.
+func (c *cors) allowOrigin(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	w.Header().Add("Vary", "Origin")
+	switch {
+	case slices.Contains(c.allowedOrigins, "*") && !c.allowCredentials:
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	case slices.Contains(c.allowedOrigins, "*") || slices.Contains(c.allowedOrigins, origin):
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	default:
+		return false
+	}
+	if c.allowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+	return true
+}
+
+func (c *cors) middleware(next runtime.HandlerFunc) runtime.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		if c.allowOrigin(w, r) {
+			w.Header().Set("Access-Control-Expose-Headers", c.exposedHeaders)
+		}
+		next(w, r, pathParams)
+	}
+}
+
+// routingErrorHandler answers preflight requests, which never match a route, since routes don't declare OPTIONS.
+func (c *cors) routingErrorHandler(next runtime.RoutingErrorHandlerFunc) runtime.RoutingErrorHandlerFunc {
+	return func(
+		ctx context.Context, mux *runtime.ServeMux, marshaler runtime.Marshaler,
+		w http.ResponseWriter, r *http.Request, httpStatus int,
+	) {
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if !preflight || httpStatus != http.StatusMethodNotAllowed {
+			next(ctx, mux, marshaler, w, r, httpStatus)
+			return
+		}
+		if c.allowOrigin(w, r) {
+			w.Header().Set("Access-Control-Allow-Methods", c.allowedMethods)
+			w.Header().Set("Access-Control-Allow-Headers", c.allowedHeaders)
+			if c.maxAge != "" {
+				w.Header().Set("Access-Control-Max-Age", c.maxAge)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
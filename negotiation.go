@@ -0,0 +1,213 @@
+package gatewayfile
+
+import (
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	headerAccept = "accept"
+	mimeJSON     = "application/json"
+)
+
+// FileMetadata is the JSON rendering of a file response, when the client asked for it, see WithAcceptNegotiation.
+type FileMetadata struct {
+	ContentType  string `json:"content_type"`
+	Size         *int64 `json:"size,omitempty"` // Size is unknown when the response is compressed or streamed.
+	Filename     string `json:"filename,omitempty"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// WithAcceptNegotiation returns a ServeMuxOption which answers HttpBody responses, unary or streamed,
+// with their FileMetadata instead of their content when the client prefers application/json to any other type,
+// e.g. "Accept: application/json", and with their content otherwise, e.g. "Accept: image/png" or "*/*".
+// A client downloading a JSON file must accept another type as much, e.g. "application/json, */*".
+// A streamed download is canceled once its header is rendered, the content is not sent.
+// The marshaler registered for application/json handles other messages with the fallback marshaler,
+// and decodes HttpBody uploads like the HttpBody marshaler, see WithHTTPBodyMarshaler.
+// The forward response option must be installed, see WithFileForwardResponseOption. Config.AcceptNegotiation
+// installs it.
+func WithAcceptNegotiation(opts ...MarshalerOption) runtime.ServeMuxOption {
+	o := &marshalerOptions{
+		fallback: &runtime.JSONPb{
+			MarshalOptions:   protojson.MarshalOptions{EmitUnpopulated: true},
+			UnmarshalOptions: protojson.UnmarshalOptions{DiscardUnknown: true},
+		},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	marshaler := &metadataMarshaler{
+		httpBodyMarshaler: &httpBodyMarshaler{HTTPBodyMarshaler: &runtime.HTTPBodyMarshaler{Marshaler: o.fallback}},
+	}
+	return func(mux *runtime.ServeMux) {
+		runtime.WithMarshalerOption(mimeJSON, marshaler)(mux)
+		runtime.WithMiddlewares(negotiateAccept)(mux)
+	}
+}
+
+// metadataMarshaler marshals HttpBody messages into their FileMetadata.
+type metadataMarshaler struct {
+	*httpBodyMarshaler
+}
+
+func (m *metadataMarshaler) ContentType(v any) string {
+	if _, ok := v.(*httpbody.HttpBody); ok {
+		return mimeJSON
+	}
+	return m.httpBodyMarshaler.ContentType(v)
+}
+
+func (m *metadataMarshaler) Marshal(v any) ([]byte, error) {
+	if body, ok := v.(*httpbody.HttpBody); ok {
+		size := int64(len(body.GetData()))
+		return json.Marshal(FileMetadata{ContentType: body.GetContentType(), Size: &size})
+	}
+	return m.httpBodyMarshaler.Marshal(v)
+}
+
+type negotiationKey struct{}
+
+// negotiation is the outcome of the negotiation of a request, stored in its context by negotiateAccept.
+type negotiation struct {
+	metadata bool               // metadata reports whether the client asked for the FileMetadata.
+	header   metadata.MD        // header is the header metadata of the download, see begin.
+	writer   *negotiatedWriter  // writer discards the content once the FileMetadata was rendered.
+	cancel   context.CancelFunc // cancel cancels the download once the FileMetadata was rendered.
+}
+
+// negotiateAccept is the middleware negotiating the response of a request, see WithAcceptNegotiation.
+// The Accept header of a request asking for the FileMetadata is replaced by application/json only,
+// so the gateway selects the metadataMarshaler, it's removed from the Accept header of others.
+func negotiateAccept(next runtime.HandlerFunc) runtime.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		w.Header().Add(headerVary, "Accept")
+		n := &negotiation{metadata: prefersJSON(r.Header.Values(headerAccept))}
+		ctx := r.Context()
+		if n.metadata {
+			r.Header.Set(headerAccept, mimeJSON)
+			n.writer = &negotiatedWriter{ResponseWriter: w}
+			w = n.writer
+			ctx, n.cancel = context.WithCancel(ctx)
+			defer n.cancel()
+		} else {
+			values := r.Header.Values(headerAccept)
+			r.Header.Del(headerAccept)
+			for _, value := range values {
+				if strings.TrimSpace(value) != mimeJSON {
+					r.Header.Add(headerAccept, value)
+				}
+			}
+		}
+		next(w, r.WithContext(context.WithValue(ctx, negotiationKey{}, n)), pathParams)
+		if n.pending() {
+			// the download ended without any message nor error, its content is empty.
+			_ = n.writeMetadata(w, "")
+		}
+	}
+}
+
+// prefersJSON reports whether the Accept header prefers application/json to any other type.
+// The Accept header has the syntax of Accept-Encoding, with media ranges instead of codings.
+func prefersJSON(accept []string) bool {
+	ranges := parseAcceptEncoding(strings.Join(accept, ","))
+	q, ok := ranges[mimeJSON]
+	if !ok || q == 0 {
+		return false
+	}
+	for mediaRange, other := range ranges {
+		if mediaRange != mimeJSON && other >= q {
+			return false
+		}
+	}
+	return true
+}
+
+// begin defers the header of a download asking for the FileMetadata, whose header metadata is md,
+// until its content type is known from its first message, see writeMetadata.
+// It reports false if the download isn't successful, e.g. answered with 304 or an error, so it's forwarded as is.
+func (n *negotiation) begin(md metadata.MD) bool {
+	if code := pick(md, headerCode); code != "" {
+		if c, err := strconv.Atoi(code); err != nil || !isSuccessCode(c) {
+			n.metadata = false
+			return false
+		}
+	}
+	n.header = md
+	return true
+}
+
+// pending reports whether the FileMetadata of a download is to be written.
+func (n *negotiation) pending() bool {
+	return n.metadata && n.header != nil && !n.writer.wrote
+}
+
+// writeMetadata writes the FileMetadata of the download, and cancels it.
+func (n *negotiation) writeMetadata(writer http.ResponseWriter, contentType string) error {
+	md := n.header
+	info := FileMetadata{
+		ContentType:  contentType,
+		ETag:         pick(md, headerETag),
+		LastModified: pick(md, headerLastModified),
+	}
+	if _, params, err := mime.ParseMediaType(pick(md, headerContentDisposition)); err == nil {
+		info.Filename = params["filename"]
+	}
+	size := pick(md, headerContentLength)
+	if contentRange := pick(md, headerContentRange); contentRange != "" {
+		// the complete length of a partial response, "bytes 0-99/1234".
+		_, size, _ = strings.Cut(contentRange, "/")
+	}
+	if size, err := strconv.ParseInt(size, 10, 64); err == nil && pick(md, headerContentEncoding) == "" {
+		info.Size = &size
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	writer.Header().Del(headerTransferEncoding)
+	writer.Header().Set(headerContentType, mimeJSON)
+	writer.Header().Set(headerContentLength, strconv.Itoa(len(data)))
+	writer.WriteHeader(http.StatusOK)
+	_, _ = writer.Write(data)
+	n.writer.answered = true
+	n.cancel()
+	return nil
+}
+
+// negotiatedWriter discards what is written once the FileMetadata was rendered.
+type negotiatedWriter struct {
+	http.ResponseWriter
+	wrote    bool // wrote reports whether the status code or the body was written, e.g. by the error handler.
+	answered bool
+}
+
+func (w *negotiatedWriter) WriteHeader(code int) {
+	if !w.answered {
+		w.wrote = true
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *negotiatedWriter) Write(p []byte) (int, error) {
+	if w.answered {
+		return len(p), nil
+	}
+	w.wrote = true
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *negotiatedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
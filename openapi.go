@@ -0,0 +1,137 @@
+package gatewayfile
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// OpenAPIConfig configures PatchOpenAPI.
+type OpenAPIConfig struct {
+	// UploadFields are the form fields of the upload operations, keyed by operationId,
+	// e.g. "Service_UploadFile". Operations not listed get a single required "file" field.
+	UploadFields map[string][]OpenAPIFormField
+	// DownloadTypes are the MIME types produced by the download operations, defaults to application/octet-stream.
+	DownloadTypes []string
+}
+
+// OpenAPIFormField is a field of a multipart/form-data upload.
+type OpenAPIFormField struct {
+	Name        string
+	Description string
+	File        bool // File is true for a file field, false for a string value.
+	Required    bool
+}
+
+// PatchOpenAPI rewrites an OpenAPI v2 document generated by protoc-gen-openapiv2, so the file endpoints are
+// documented as what they are on the wire instead of HttpBody JSON objects:
+//   - operations streaming HttpBody requests consume multipart/form-data with file parameters,
+//   - operations streaming HttpBody responses produce binary content, with 206 and 304 responses.
+//
+// The operations can still be annotated with openapiv2_operation options, PatchOpenAPI only touches
+// the request body parameter, the consumes/produces lists and the 200 response schema.
+func PatchOpenAPI(spec []byte, cfg OpenAPIConfig) ([]byte, error) {
+	var doc map[string]any
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return nil, err
+	}
+	if len(cfg.DownloadTypes) == 0 {
+		cfg.DownloadTypes = []string{"application/octet-stream"}
+	}
+
+	paths, _ := doc["paths"].(map[string]any)
+	for _, item := range paths {
+		operations, _ := item.(map[string]any)
+		for _, op := range operations {
+			operation, ok := op.(map[string]any)
+			if !ok {
+				continue
+			}
+			if isHTTPBodyUpload(operation) {
+				patchUpload(operation, cfg)
+			}
+			if isHTTPBodyDownload(operation) {
+				patchDownload(operation, cfg)
+			}
+		}
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+const openAPIHTTPBodyRef = "#/definitions/apiHttpBody"
+
+func isHTTPBodyUpload(operation map[string]any) bool {
+	parameters, _ := operation["parameters"].([]any)
+	for _, p := range parameters {
+		parameter, _ := p.(map[string]any)
+		schema, _ := parameter["schema"].(map[string]any)
+		if parameter["in"] == "body" && schema["$ref"] == openAPIHTTPBodyRef {
+			return true
+		}
+	}
+	return false
+}
+
+func isHTTPBodyDownload(operation map[string]any) bool {
+	responses, _ := operation["responses"].(map[string]any)
+	ok, _ := responses["200"].(map[string]any)
+	schema, _ := ok["schema"].(map[string]any)
+	if schema["$ref"] == openAPIHTTPBodyRef {
+		return true
+	}
+	// server streams are wrapped as {"result": ..., "error": ...}.
+	properties, _ := schema["properties"].(map[string]any)
+	result, _ := properties["result"].(map[string]any)
+	return result["$ref"] == openAPIHTTPBodyRef
+}
+
+func patchUpload(operation map[string]any, cfg OpenAPIConfig) {
+	fields := []OpenAPIFormField{{Name: "file", File: true, Required: true}}
+	if id, _ := operation["operationId"].(string); len(cfg.UploadFields[id]) > 0 {
+		fields = cfg.UploadFields[id]
+	}
+
+	parameters, _ := operation["parameters"].([]any)
+	patched := make([]any, 0, len(parameters)+len(fields))
+	for _, p := range parameters {
+		if parameter, _ := p.(map[string]any); parameter["in"] != "body" {
+			patched = append(patched, p)
+		}
+	}
+	for _, field := range fields {
+		parameter := map[string]any{
+			"name":     field.Name,
+			"in":       "formData",
+			"required": field.Required,
+			"type":     "string",
+		}
+		if field.File {
+			parameter["type"] = "file"
+		}
+		if field.Description != "" {
+			parameter["description"] = field.Description
+		}
+		patched = append(patched, parameter)
+	}
+	operation["parameters"] = patched
+	operation["consumes"] = []any{"multipart/form-data"}
+}
+
+func patchDownload(operation map[string]any, cfg OpenAPIConfig) {
+	responses, _ := operation["responses"].(map[string]any)
+	ok, _ := responses["200"].(map[string]any)
+	ok["schema"] = map[string]any{"type": "file"}
+	if description, _ := ok["description"].(string); description == "" || strings.Contains(description, "stream") {
+		ok["description"] = "The file content."
+	}
+	responses["206"] = map[string]any{
+		"description": "Partial content, for Range requests.",
+		"schema":      map[string]any{"type": "file"},
+	}
+	responses["304"] = map[string]any{"description": "Not modified, for conditional requests."}
+
+	produces := make([]any, 0, len(cfg.DownloadTypes))
+	for _, t := range cfg.DownloadTypes {
+		produces = append(produces, t)
+	}
+	operation["produces"] = produces
+}
@@ -0,0 +1,89 @@
+package gatewayfile
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// WithChunkHints emits hints for clients downloading in parallel: X-Chunk-Size is the recommended size
+// of the ranges to fetch in parallel, X-Total-Size is the size of the whole content, whatever the range served.
+// Together with the ETag, used as If-Range, clients can fetch N ranges concurrently and reassemble them.
+func WithChunkHints(chunkSize int64) ServeOption {
+	return func(o *serveOptions) {
+		o.chunkSize = chunkSize
+	}
+}
+
+func setChunkHints(outgoing metadata.MD, chunkSize, size int64) {
+	if chunkSize <= 0 {
+		return
+	}
+	outgoing.Set(headerXChunkSize, strconv.FormatInt(chunkSize, 10))
+	outgoing.Set(headerXTotalSize, strconv.FormatInt(size, 10))
+}
+
+// ChunkManifest splits a content in ranges to fetch in parallel, see ServeChunkManifest.
+type ChunkManifest struct {
+	Size      int64    `json:"size"`
+	ETag      string   `json:"etag,omitempty"`
+	ChunkSize int64    `json:"chunk_size"`
+	Ranges    []string `json:"ranges"` // Ranges are Range header values, in order.
+}
+
+// ChunkManifestConfig configures ServeChunkManifest.
+type ChunkManifestConfig struct {
+	// ChunkSize is the size of the chunks in bytes.
+	ChunkSize int64
+	// Cache is pre-warmed in the background with the HotChunks first chunks of the content,
+	// so the parallel range requests which follow the manifest hit the cache. May be nil.
+	Cache     *RangeCache
+	CacheKey  string // CacheKey is the key of the content in Cache, see WithRangeCache.
+	HotChunks int
+}
+
+// ServeChunkManifest serves the ChunkManifest of the content of provider as JSON,
+// for clients which fetch the ranges in parallel from the download endpoint.
+func ServeChunkManifest(
+	server downloadServer, provider ContentProvider, cfg ChunkManifestConfig, opts ...ServeOption,
+) error {
+	info, err := StatContent(server.Context(), provider)
+	if err != nil {
+		return err
+	}
+	manifest := ChunkManifest{Size: info.Size, ETag: info.ETag, ChunkSize: cfg.ChunkSize}
+	if manifest.Ranges = rangeHints(info.Size, cfg.ChunkSize); manifest.Ranges == nil && info.Size > 0 {
+		manifest.Ranges = []string{"bytes=0-" + strconv.FormatInt(info.Size-1, 10)}
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Cache != nil && cfg.HotChunks > 0 {
+		// detached from the request, which returns before the chunks are fetched.
+		ctx := context.WithoutCancel(server.Context())
+		go cfg.Cache.prefetch(ctx, cfg.CacheKey, provider, int64(cfg.HotChunks)*cfg.ChunkSize)
+	}
+	return ServeContent(server, bytes.NewReader(data), "application/json", "", time.Time{}, int64(len(data)), opts...)
+}
+
+// prefetch loads the blocks of the first size bytes of the content into the cache, errors are ignored.
+func (c *RangeCache) prefetch(ctx context.Context, key string, provider ContentProvider, size int64) {
+	content, _, err := provider.Open(ctx)
+	if err != nil {
+		return
+	}
+	defer func() { _ = content.Close() }()
+
+	reader := &rangeCacheReader{cache: c, key: key, content: content}
+	for index := int64(0); index*c.blockSize < size; index++ {
+		if block, err := reader.block(index); err != nil || int64(len(block)) < c.blockSize {
+			return
+		}
+	}
+}
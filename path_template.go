@@ -0,0 +1,310 @@
+package gatewayfile
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"mime/multipart"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// ErrInvalidPathValue is returned when a variable of a PathTemplate has no value, or a value which would escape
+// its path segment, e.g. a tenant "../other".
+var ErrInvalidPathValue = newError(codes.InvalidArgument, "INVALID_PATH_VALUE", "invalid path template value")
+
+// PathTemplate lays out the stored files, e.g. "{tenant}/{yyyy}/{mm}/{hash}{ext}", see ParsePathTemplate.
+// The variables are:
+//
+//   - {field}: the form field of the file.
+//   - {filename}: the base name of the filename, {name} without its extension, {ext} its lowercased extension,
+//     with the dot.
+//   - {hash}: the hex SHA-256 of the content as stored.
+//   - {yyyy}, {mm}, {dd}, {hh}: the UTC time the file is stored.
+//   - {random}: 32 random hex digits.
+//   - any other name: the value given to WithPathValues, e.g. {tenant} is the tenant of the authenticated user.
+//     The incoming metadata is sent by the client, it's only read for the variables given to WithPathMetadata.
+type PathTemplate struct {
+	template string
+	parts    []templatePart
+	hash     bool
+}
+
+// templatePart is a literal, or a variable if name is not empty.
+type templatePart struct {
+	literal string
+	name    string
+}
+
+// ParsePathTemplate parses a slash-separated path template, see PathTemplate.
+func ParsePathTemplate(template string) (*PathTemplate, error) {
+	t := &PathTemplate{template: template}
+	for rest := template; rest != ""; {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			t.parts = append(t.parts, templatePart{literal: rest})
+			break
+		}
+		closing := strings.IndexByte(rest[open:], '}')
+		if closing < 0 {
+			return nil, fmt.Errorf("path template %q: unclosed variable", template)
+		}
+		name := rest[open+1 : open+closing]
+		if name == "" || strings.ContainsAny(name, "{/") {
+			return nil, fmt.Errorf("path template %q: invalid variable %q", template, name)
+		}
+		if open > 0 {
+			t.parts = append(t.parts, templatePart{literal: rest[:open]})
+		}
+		t.parts = append(t.parts, templatePart{name: name})
+		t.hash = t.hash || name == "hash"
+		rest = rest[open+closing+1:]
+	}
+	if strings.HasPrefix(template, "/") || strings.Contains(template, "..") {
+		return nil, fmt.Errorf("path template %q: not a relative path", template)
+	}
+	return t, nil
+}
+
+// MustParsePathTemplate is like ParsePathTemplate but panics if the template is invalid.
+func MustParsePathTemplate(template string) *PathTemplate {
+	t, err := ParsePathTemplate(template)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func (t *PathTemplate) String() string {
+	return t.template
+}
+
+// PathFile is the file whose path a PathTemplate evaluates.
+type PathFile struct {
+	Field    string
+	Filename string // Filename is the filename sent by the client.
+	Hash     string // Hash is the hex SHA-256 of the content, required by {hash}.
+	Time     time.Time
+}
+
+type pathValuesKey struct{}
+
+// WithPathValues returns a context carrying values of PathTemplate variables, e.g. the tenant of the
+// authenticated user. They're added to the values already carried by ctx, replacing those of the same name.
+func WithPathValues(ctx context.Context, values map[string]string) context.Context {
+	merged := make(map[string]string)
+	for name, value := range pathValues(ctx) {
+		merged[name] = value
+	}
+	for name, value := range values {
+		merged[name] = value
+	}
+	return context.WithValue(ctx, pathValuesKey{}, merged)
+}
+
+// WithPathMetadata returns a context carrying the first value of the incoming metadata of each name as the value
+// of the PathTemplate variable of the same name, e.g. "region" sent as the Grpc-Metadata-Region header through
+// the gateway. The client chooses these values: never use it for variables which isolate users, such as a tenant.
+// The values already carried by ctx take precedence, a name missing from the metadata is left without value.
+func WithPathMetadata(ctx context.Context, names ...string) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := pathValues(ctx)
+	fromMetadata := make(map[string]string)
+	for _, name := range names {
+		if _, ok := values[name]; ok {
+			continue
+		}
+		if value := md.Get(name); len(value) > 0 {
+			fromMetadata[name] = value[0]
+		}
+	}
+	return WithPathValues(ctx, fromMetadata)
+}
+
+func pathValues(ctx context.Context) map[string]string {
+	values, _ := ctx.Value(pathValuesKey{}).(map[string]string)
+	return values
+}
+
+// Execute returns the slash-separated relative path of file. It fails with ErrInvalidPathValue if a variable
+// has no value, or a value which is not a single path segment.
+func (t *PathTemplate) Execute(ctx context.Context, file PathFile) (string, error) {
+	values := pathValues(ctx)
+	filename := path.Base(strings.ReplaceAll(file.Filename, `\`, "/"))
+	ext := path.Ext(filename)
+	var b strings.Builder
+	for _, part := range t.parts {
+		if part.name == "" {
+			b.WriteString(part.literal)
+			continue
+		}
+		var value string
+		switch part.name {
+		case "field":
+			value = file.Field
+		case "filename":
+			value = filename
+		case "name":
+			value = strings.TrimSuffix(filename, ext)
+		case "ext":
+			value = strings.ToLower(ext)
+		case "hash":
+			value = file.Hash
+		case "yyyy":
+			value = file.Time.UTC().Format("2006")
+		case "mm":
+			value = file.Time.UTC().Format("01")
+		case "dd":
+			value = file.Time.UTC().Format("02")
+		case "hh":
+			value = file.Time.UTC().Format("15")
+		case "random":
+			random := make([]byte, 16)
+			if _, err := rand.Read(random); err != nil {
+				return "", err
+			}
+			value = hex.EncodeToString(random)
+		default:
+			value = values[part.name]
+		}
+		if (value == "" && part.name != "ext") || strings.ContainsAny(value, `/\`) || value == "." || value == ".." {
+			return "", fmt.Errorf("%w: {%s} is %q", ErrInvalidPathValue, part.name, value)
+		}
+		b.WriteString(value)
+	}
+	p := b.String()
+	if !filepath.IsLocal(filepath.FromSlash(p)) {
+		return "", fmt.Errorf("%w: %q is not a relative path", ErrInvalidPathValue, p)
+	}
+	return p, nil
+}
+
+// WithPathTemplate lays out the files saved by FormData.SaveAll under its dir with template, instead of naming
+// them by the base name of their filename. Missing directories are created. See TemplateSink for StreamFormData.
+func WithPathTemplate(template *PathTemplate) FormDataOption {
+	return func(o *formDataOptions) {
+		o.pathTemplate = template
+	}
+}
+
+// templatePath returns the path of the file under dir, laid out by t.
+func (t *PathTemplate) templatePath(
+	ctx context.Context, dir string, header *multipart.FileHeader, field string,
+) (string, error) {
+	file := PathFile{Field: field, Filename: header.Filename, Time: time.Now()}
+	if t.hash {
+		// the digests of the form are computed as uploaded, the file may have been transformed since.
+		content, err := header.Open()
+		if err != nil {
+			return "", fmt.Errorf("open file failed %w", err)
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, content)
+		_ = content.Close()
+		if err != nil {
+			return "", err
+		}
+		file.Hash = hex.EncodeToString(h.Sum(nil))
+	}
+	p, err := t.Execute(ctx, file)
+	if err != nil {
+		return "", err
+	}
+	p = filepath.Join(dir, filepath.FromSlash(p))
+	return p, os.MkdirAll(filepath.Dir(p), 0o755)
+}
+
+// TemplateSink returns a PartSink which writes each file into dir, laid out by template. Missing directories are
+// created, and it refuses to overwrite an existing file. If the template names files by {hash}, they're written
+// to a temporary file in dir first, and renamed once their content is known, overwriting a file of the same
+// content.
+func TemplateSink(dir string, template *PathTemplate) PartSink {
+	return &templateSink{dir: filepath.Clean(dir), template: template}
+}
+
+type templateSink struct {
+	dir      string
+	template *PathTemplate
+}
+
+func (s *templateSink) Create(ctx context.Context, part *multipart.Part) (io.WriteCloser, string, error) {
+	file := PathFile{Field: part.FormName(), Filename: part.FileName(), Time: time.Now()}
+	if s.template.hash {
+		temp, err := os.CreateTemp(s.dir, ".upload-*")
+		if err != nil {
+			return nil, "", err
+		}
+		return &hashNamedFile{file: temp, ctx: ctx, sink: s, path: file, hash: sha256.New()}, temp.Name(), nil
+	}
+	p, err := s.path(ctx, file)
+	if err != nil {
+		return nil, "", err
+	}
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, "", err
+	}
+	return f, p, nil
+}
+
+func (s *templateSink) path(ctx context.Context, file PathFile) (string, error) {
+	p, err := s.template.Execute(ctx, file)
+	if err != nil {
+		return "", err
+	}
+	p = filepath.Join(s.dir, filepath.FromSlash(p))
+	return p, os.MkdirAll(filepath.Dir(p), 0o755)
+}
+
+func (s *templateSink) Remove(_ context.Context, destination string) error {
+	return os.Remove(destination)
+}
+
+// hashNamedFile is a temporary file renamed after the hash of its content once it's closed.
+// It doesn't embed the file, so its ReadFrom doesn't bypass the hash.
+type hashNamedFile struct {
+	file        *os.File
+	ctx         context.Context
+	sink        *templateSink
+	path        PathFile
+	hash        hash.Hash
+	destination string
+}
+
+func (f *hashNamedFile) Write(p []byte) (int, error) {
+	n, err := f.file.Write(p)
+	f.hash.Write(p[:n])
+	return n, err
+}
+
+func (f *hashNamedFile) Close() error {
+	f.destination = f.file.Name()
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.path.Hash = hex.EncodeToString(f.hash.Sum(nil))
+	p, err := f.sink.path(f.ctx, f.path)
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(f.file.Name(), p); err != nil {
+		return err
+	}
+	f.destination = p
+	return nil
+}
+
+// Destination returns where the file is, once it's closed.
+func (f *hashNamedFile) Destination() string {
+	return f.destination
+}
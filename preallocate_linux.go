@@ -0,0 +1,26 @@
+//go:build linux
+
+package gatewayfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// preallocate allocates size bytes to file without changing its size, so the file is written in place.
+func preallocate(file *os.File, size int64) error {
+	err := unix.Fallocate(int(file.Fd()), unix.FALLOC_FL_KEEP_SIZE, 0, size)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, unix.ENOSPC), errors.Is(err, unix.EDQUOT):
+		return fmt.Errorf("%w: preallocate %d bytes failed %w", ErrInsufficientStorage, size, err)
+	case errors.Is(err, unix.EOPNOTSUPP):
+		return errors.ErrUnsupported
+	default:
+		return err
+	}
+}
@@ -0,0 +1,51 @@
+package gatewayfile
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+var (
+	// ErrQuarantined vetoes the download of a content quarantined by a malware scan, see WithPreSend.
+	// It's answered with 403 Forbidden.
+	ErrQuarantined = newError(codes.PermissionDenied, "CONTENT_QUARANTINED", "content quarantined")
+	// ErrUnavailableForLegalReasons vetoes the download of a content which can't be served for legal reasons,
+	// see WithPreSend. It's answered with 451 Unavailable For Legal Reasons, see HTTPErrorHandler.
+	ErrUnavailableForLegalReasons = newError(
+		codes.PermissionDenied, "UNAVAILABLE_FOR_LEGAL_REASONS", "unavailable for legal reasons",
+	)
+)
+
+// DownloadInfo describes the content of a download, see WithPreSend.
+type DownloadInfo struct {
+	Name        string
+	ContentType string // ContentType is the content type given to ServeContent, it may be empty.
+	Size        int64
+	ModTime     time.Time
+	ETag        string
+}
+
+// PreSendFunc vetoes a download by returning an error, see WithPreSend.
+type PreSendFunc func(ctx context.Context, info DownloadInfo) error
+
+// WithPreSend calls f once the content is opened, before anything is sent, even a 304 Not Modified.
+// If f returns an error, the download is aborted and the handler returns it, e.g. ErrQuarantined after checking
+// a scan status store, so quarantined contents are never served. The error is answered by the error handler,
+// with the HTTP status of its gRPC code, e.g. 403 for ErrQuarantined, 451 for ErrUnavailableForLegalReasons.
+func WithPreSend(f PreSendFunc) ServeOption {
+	return func(o *serveOptions) {
+		o.preSend = append(o.preSend, f)
+	}
+}
+
+// checkPreSend calls the PreSendFuncs of the options, and returns the first veto.
+func (o *serveOptions) checkPreSend(ctx context.Context, info DownloadInfo) error {
+	for _, f := range o.preSend {
+		if err := f(ctx, info); err != nil {
+			return withRequestID(ctx, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package gatewayfile
+
+import (
+	"strconv"
+
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"google.golang.org/grpc/metadata"
+)
+
+// ProgressFunc reports the progress of a download, see WithProgress.
+// totalBytes is the size of the response body, -1 if it's unknown.
+type ProgressFunc func(sentBytes, totalBytes int64)
+
+// WithProgress calls f once the header of a successful response is sent, then after each chunk of its body,
+// so the service can report the download progress to its metrics or session store. The bytes are counted
+// as sent on the wire, i.e. after compression or multipart encoding; totalBytes is -1 when the size isn't known
+// in advance, e.g. for compressed responses or ServeReader. f is called by the sending goroutine, it should
+// not block. Error responses are not reported.
+func WithProgress(f ProgressFunc) ServeOption {
+	return func(o *serveOptions) {
+		o.progress = append(o.progress, f)
+	}
+}
+
+// progressServer reports the progress of the body sent through a downloadServer, see WithProgress.
+type progressServer struct {
+	downloadServer
+	progress []ProgressFunc
+	report   bool
+	sent     int64
+	total    int64
+}
+
+// withProgress returns server reporting to the WithProgress callbacks of o, if any.
+func (o *serveOptions) withProgress(server downloadServer) downloadServer {
+	if len(o.progress) == 0 {
+		return server
+	}
+	return &progressServer{downloadServer: server, progress: o.progress}
+}
+
+func (s *progressServer) SendHeader(md metadata.MD) error {
+	if err := s.downloadServer.SendHeader(md); err != nil {
+		return err
+	}
+	code, err := strconv.Atoi(pick(md, headerCode))
+	if s.report = err != nil || isSuccessCode(code); !s.report {
+		return nil
+	}
+	s.total = -1
+	if total, err := strconv.ParseInt(pick(md, headerContentLength), 10, 64); err == nil {
+		s.total = total
+	}
+	s.notify()
+	return nil
+}
+
+func (s *progressServer) Send(body *httpbody.HttpBody) error {
+	if err := s.downloadServer.Send(body); err != nil {
+		return err
+	}
+	if s.report {
+		s.sent += int64(len(body.GetData()))
+		s.notify()
+	}
+	return nil
+}
+
+func (s *progressServer) notify() {
+	for _, f := range s.progress {
+		f(s.sent, s.total)
+	}
+}
@@ -0,0 +1,201 @@
+package gatewayfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ContentInfo describes a content, as reported by its ContentProvider.
+type ContentInfo struct {
+	Name        string    // Name is used in Content-Disposition and to guess the content type, may be empty.
+	ContentType string    // ContentType, detected from Name or the content if empty.
+	Size        int64     // Size of the content in bytes.
+	ModTime     time.Time // ModTime is used as Last-Modified, may be zero.
+
+	// The following fields are passed through to the response as-is, to preserve cache coherence with the backend.
+	// They're usually reported by object stores like S3 or GCS.
+
+	ETag            string            // ETag, e.g. S3 ETag or GCS generation.
+	CacheControl    string            // CacheControl is emitted as Cache-Control.
+	ContentLanguage string            // ContentLanguage is emitted as Content-Language.
+	Metadata        map[string]string // Metadata is custom object metadata, see WithMetadata.
+	Checksums       Digests           // Checksums of the whole content, emitted as x-goog-hash etc, see WithChecksums.
+	Header          map[string]string // Header are other representation headers, see WithRepresentationHeader.
+
+	// Source is the name of the source which provided the content, see FallbackProvider.
+	Source string
+}
+
+// ContentProvider provides a content to serve, e.g. a local file or an object of an object store.
+type ContentProvider interface {
+	// Open opens the content. The returned ReadSeekCloser is closed after serving.
+	Open(ctx context.Context) (io.ReadSeekCloser, ContentInfo, error)
+}
+
+// ContentProviderFunc is an adapter to allow the use of ordinary functions as ContentProvider.
+type ContentProviderFunc func(ctx context.Context) (io.ReadSeekCloser, ContentInfo, error)
+
+// Open calls f(ctx).
+func (f ContentProviderFunc) Open(ctx context.Context) (io.ReadSeekCloser, ContentInfo, error) {
+	return f(ctx)
+}
+
+// ContentStater is implemented by providers which can describe their content without opening it.
+type ContentStater interface {
+	Stat(ctx context.Context) (ContentInfo, error)
+}
+
+// StatContent returns the ContentInfo of the provider, by Stat if it implements ContentStater,
+// or by opening and closing it.
+func StatContent(ctx context.Context, provider ContentProvider) (ContentInfo, error) {
+	if stater, ok := provider.(ContentStater); ok {
+		return stater.Stat(ctx)
+	}
+	content, info, err := provider.Open(ctx)
+	if err != nil {
+		return ContentInfo{}, err
+	}
+	_ = content.Close()
+	return info, nil
+}
+
+// FileProvider returns a ContentProvider for a local file, it implements ContentStater.
+func FileProvider(path string) ContentProvider {
+	return fileProvider(filepath.Clean(path))
+}
+
+type fileProvider string
+
+func (path fileProvider) Open(context.Context) (io.ReadSeekCloser, ContentInfo, error) {
+	file, info, err := openFile(string(path))
+	if err != nil {
+		return nil, ContentInfo{}, err
+	}
+	if info.IsDir() {
+		_ = file.Close()
+		return nil, ContentInfo{}, fmt.Errorf("invalid path %s", path)
+	}
+	return file, fileContentInfo(info), nil
+}
+
+func (path fileProvider) Stat(context.Context) (ContentInfo, error) {
+	info, err := os.Stat(string(path))
+	if err != nil {
+		return ContentInfo{}, err
+	}
+	if info.IsDir() {
+		return ContentInfo{}, fmt.Errorf("invalid path %s", path)
+	}
+	return fileContentInfo(info), nil
+}
+
+func fileContentInfo(info os.FileInfo) ContentInfo {
+	return ContentInfo{Name: info.Name(), Size: info.Size(), ModTime: info.ModTime()}
+}
+
+// ServeProvider serves the content of the provider like ServeContent.
+// The ETag, Cache-Control, Content-Language and custom metadata reported by the provider are passed through,
+// options explicitly given in opts take precedence over them.
+func ServeProvider(server downloadServer, provider ContentProvider, opts ...ServeOption) error {
+	content, info, err := provider.Open(server.Context())
+	if errors.Is(err, ErrCircuitOpen) {
+		return serveCircuitOpen(server, err)
+	}
+	if err != nil {
+		return err
+	}
+	// closing the content as soon as the client disconnects unblocks a pending read of a slow backend.
+	closer := closeOnDone(server.Context(), content)
+	defer func() { _ = closer.Close() }()
+
+	if o := newServeOptions(server.Context(), opts); o.onProviderInfo != nil {
+		o.onProviderInfo(info)
+	}
+
+	reopen := func(ctx context.Context) (io.ReadSeekCloser, error) {
+		reopened, reopenedInfo, err := provider.Open(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if reopenedInfo.ETag != info.ETag || reopenedInfo.Size != info.Size {
+			_ = reopened.Close()
+			return nil, errContentChanged
+		}
+		return reopened, nil
+	}
+	currentSize := func(ctx context.Context) (int64, bool, error) {
+		current, err := StatContent(ctx, provider)
+		return current.Size, current.ETag != info.ETag || current.Size != info.Size, err
+	}
+	return ServeContent(
+		server, content, info.ContentType, info.Name, info.ModTime, info.Size,
+		append(append(info.serveOptions(), withReopen(reopen), withCurrentSize(currentSize)), opts...)...,
+	)
+}
+
+var errContentChanged = errors.New("content changed")
+
+func (info ContentInfo) serveOptions() []ServeOption {
+	var opts []ServeOption
+	if info.ETag != "" {
+		opts = append(opts, WithETag(info.ETag))
+	} else {
+		opts = append(opts, withAutoETag(info.Size, info.ModTime))
+	}
+	if info.CacheControl != "" {
+		opts = append(opts, withHeader(headerCacheControl, info.CacheControl))
+	}
+	if info.ContentLanguage != "" {
+		opts = append(opts, withHeader(headerContentLanguage, info.ContentLanguage))
+	}
+	if len(info.Checksums) > 0 {
+		opts = append(opts, WithChecksums(info.Checksums))
+	}
+	for _, key := range sortedKeys(info.Header) {
+		opts = append(opts, WithRepresentationHeader(key, info.Header[key]))
+	}
+	if len(info.Metadata) > 0 {
+		opts = append(opts, WithMetadata(info.Metadata))
+	}
+	return opts
+}
+
+// WithProviderInfo calls f with the ContentInfo reported by the provider before serving it, see ServeProvider.
+// It's useful to record which source served the request, see FallbackProvider.
+func WithProviderInfo(f func(info ContentInfo)) ServeOption {
+	return func(o *serveOptions) {
+		o.onProviderInfo = f
+	}
+}
+
+// Source is a named ContentProvider, see FallbackProvider.
+type Source struct {
+	Name     string
+	Provider ContentProvider
+}
+
+// FallbackProvider returns a ContentProvider which tries the sources in order, e.g. a local cache, then S3,
+// then the origin. The first source opened successfully provides the content, and its name is recorded
+// in ContentInfo.Source. If all sources fail, the errors of all of them are returned.
+func FallbackProvider(sources ...Source) ContentProvider {
+	return ContentProviderFunc(func(ctx context.Context) (io.ReadSeekCloser, ContentInfo, error) {
+		errs := make([]error, 0, len(sources))
+		for _, source := range sources {
+			content, info, err := source.Provider.Open(ctx)
+			if err == nil {
+				info.Source = source.Name
+				return content, info, nil
+			}
+			errs = append(errs, fmt.Errorf("source %s: %w", source.Name, err))
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		return nil, ContentInfo{}, errors.Join(errs...)
+	})
+}
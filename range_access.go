@@ -0,0 +1,171 @@
+package gatewayfile
+
+import (
+	"encoding/json"
+	"math/bits"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+const (
+	defaultRangeAccessBucketSize = 1 << 20 // 1 MB
+	defaultRangeAccessMaxObjects = 10000
+)
+
+// RangeAccessConfig is the configuration of a RangeAccessLog.
+type RangeAccessConfig struct {
+	// BucketSize is the size in bytes of the buckets the objects are split into, defaults to 1 MB.
+	BucketSize int64
+	// SampleRate is the fraction of the downloads recorded, from 0 to 1, defaults to 1: all of them.
+	SampleRate float64
+	// MaxObjects is the maximum number of objects recorded, defaults to 10000.
+	// The downloads of other objects are ignored once it's reached, until Reset.
+	MaxObjects int
+}
+
+// RangeAccessLog records which byte ranges of which objects are requested, so operators of video or large
+// artifact services can tune the caching and the chunk sizes from real access patterns, see WithRangeAccessLog.
+// It's an http.Handler serving the heatmaps as JSON, all of them or the one of the "object" query parameter.
+// It's safe for concurrent use.
+type RangeAccessLog struct {
+	cfg RangeAccessConfig
+
+	mu      sync.Mutex
+	objects map[string]*RangeHeatmap
+}
+
+// RangeHeatmap is the aggregated access pattern of an object.
+type RangeHeatmap struct {
+	Object     string `json:"object"`
+	Size       int64  `json:"size"`        // Size is the size of the object at its last download.
+	BucketSize int64  `json:"bucket_size"` // BucketSize is the size of the buckets of Buckets.
+	// Requests is the number of recorded downloads, FullRequests the number of them without range.
+	Requests     int64 `json:"requests"`
+	FullRequests int64 `json:"full_requests"`
+	// Buckets are the numbers of served ranges overlapping each bucket of the object, full downloads excluded.
+	Buckets []int64 `json:"buckets"`
+	// Lengths is the histogram of the lengths of the served ranges:
+	// Lengths[i] is the number of ranges of 2^i to 2^(i+1)-1 bytes.
+	Lengths []int64 `json:"lengths"`
+}
+
+// NewRangeAccessLog returns a new RangeAccessLog.
+func NewRangeAccessLog(cfg RangeAccessConfig) *RangeAccessLog {
+	if cfg.BucketSize <= 0 {
+		cfg.BucketSize = defaultRangeAccessBucketSize
+	}
+	if cfg.SampleRate <= 0 || cfg.SampleRate > 1 {
+		cfg.SampleRate = 1
+	}
+	if cfg.MaxObjects <= 0 {
+		cfg.MaxObjects = defaultRangeAccessMaxObjects
+	}
+	return &RangeAccessLog{cfg: cfg, objects: make(map[string]*RangeHeatmap)}
+}
+
+// WithRangeAccessLog records the ranges served of the content in log, object names the content.
+func WithRangeAccessLog(log *RangeAccessLog, object string) ServeOption {
+	return func(o *serveOptions) {
+		o.onRanges = append(o.onRanges, func(ranges []httpRange, size int64) {
+			log.record(object, ranges, size)
+		})
+	}
+}
+
+func (l *RangeAccessLog) record(object string, ranges []httpRange, size int64) {
+	if l.cfg.SampleRate < 1 && rand.Float64() >= l.cfg.SampleRate {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	heatmap, ok := l.objects[object]
+	if !ok {
+		if len(l.objects) >= l.cfg.MaxObjects {
+			return
+		}
+		heatmap = &RangeHeatmap{Object: object, BucketSize: l.cfg.BucketSize}
+		l.objects[object] = heatmap
+	}
+	heatmap.Size = size
+	heatmap.Requests++
+	if len(ranges) == 0 {
+		heatmap.FullRequests++
+		return
+	}
+	if n := int((size + l.cfg.BucketSize - 1) / l.cfg.BucketSize); len(heatmap.Buckets) < n {
+		heatmap.Buckets = append(heatmap.Buckets, make([]int64, n-len(heatmap.Buckets))...)
+	}
+	for _, ra := range ranges {
+		if ra.length <= 0 {
+			continue
+		}
+		first, last := ra.start/l.cfg.BucketSize, (ra.start+ra.length-1)/l.cfg.BucketSize
+		for i := first; i <= last && i < int64(len(heatmap.Buckets)); i++ {
+			heatmap.Buckets[i]++
+		}
+		i := bits.Len64(uint64(ra.length)) - 1
+		if len(heatmap.Lengths) <= i {
+			heatmap.Lengths = append(heatmap.Lengths, make([]int64, i+1-len(heatmap.Lengths))...)
+		}
+		heatmap.Lengths[i]++
+	}
+}
+
+// Heatmap returns the heatmap of object, false if it wasn't recorded.
+func (l *RangeAccessLog) Heatmap(object string) (RangeHeatmap, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	heatmap, ok := l.objects[object]
+	if !ok {
+		return RangeHeatmap{}, false
+	}
+	return heatmap.clone(), true
+}
+
+// Heatmaps returns the heatmaps of all the recorded objects, the most requested first.
+func (l *RangeAccessLog) Heatmaps() []RangeHeatmap {
+	l.mu.Lock()
+	heatmaps := make([]RangeHeatmap, 0, len(l.objects))
+	for _, heatmap := range l.objects {
+		heatmaps = append(heatmaps, heatmap.clone())
+	}
+	l.mu.Unlock()
+	sort.Slice(heatmaps, func(i, j int) bool {
+		if heatmaps[i].Requests != heatmaps[j].Requests {
+			return heatmaps[i].Requests > heatmaps[j].Requests
+		}
+		return heatmaps[i].Object < heatmaps[j].Object
+	})
+	return heatmaps
+}
+
+// Reset forgets all the recorded objects.
+func (l *RangeAccessLog) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	clear(l.objects)
+}
+
+// ServeHTTP serves the heatmaps as JSON, see Heatmaps, or the heatmap of the "object" query parameter.
+func (l *RangeAccessLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body any = l.Heatmaps()
+	if object := r.URL.Query().Get("object"); object != "" {
+		heatmap, ok := l.Heatmap(object)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		body = heatmap
+	}
+	w.Header().Set(headerContentType, mimeJSON)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func (h *RangeHeatmap) clone() RangeHeatmap {
+	c := *h
+	c.Buckets = append([]int64(nil), h.Buckets...)
+	c.Lengths = append([]int64(nil), h.Lengths...)
+	return c
+}
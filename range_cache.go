@@ -0,0 +1,163 @@
+package gatewayfile
+
+import (
+	"container/list"
+	"errors"
+	"io"
+	"sync"
+)
+
+const defaultRangeCacheBlockSize = 256 << 10 // 256 KB
+
+// RangeCache keeps recently served blocks of contents in memory, evicting the least recently used ones
+// when its memory budget is exceeded. It reduces backend reads for popular seek patterns (e.g. video)
+// and repeated partial downloads. It's safe for concurrent use, see WithRangeCache.
+type RangeCache struct {
+	blockSize int64
+	budget    int64
+
+	mu     sync.Mutex
+	used   int64
+	lru    *list.List // of *rangeCacheBlock, front is the most recently used
+	blocks map[rangeCacheKey]*list.Element
+}
+
+type rangeCacheKey struct {
+	content string
+	index   int64
+}
+
+type rangeCacheBlock struct {
+	key  rangeCacheKey
+	data []byte
+}
+
+// NewRangeCache returns a new RangeCache.
+// blockSize is the size of the cached blocks in bytes (0 = 256 KB), budget is the maximum memory used in bytes.
+func NewRangeCache(blockSize, budget int64) *RangeCache {
+	if blockSize <= 0 {
+		blockSize = defaultRangeCacheBlockSize
+	}
+	return &RangeCache{
+		blockSize: blockSize,
+		budget:    budget,
+		lru:       list.New(),
+		blocks:    make(map[rangeCacheKey]*list.Element),
+	}
+}
+
+func (c *RangeCache) get(key rangeCacheKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.blocks[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*rangeCacheBlock).data, true
+}
+
+func (c *RangeCache) put(key rangeCacheKey, data []byte) {
+	if int64(len(data)) > c.budget {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.blocks[key]; ok {
+		return
+	}
+	c.blocks[key] = c.lru.PushFront(&rangeCacheBlock{key: key, data: data})
+	c.used += int64(len(data))
+	for c.used > c.budget {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+// Invalidate removes all blocks of the content.
+func (c *RangeCache) Invalidate(content string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, elem := range c.blocks {
+		if key.content == content {
+			c.removeElement(elem)
+		}
+	}
+}
+
+func (c *RangeCache) removeElement(elem *list.Element) {
+	block := c.lru.Remove(elem).(*rangeCacheBlock)
+	delete(c.blocks, block.key)
+	c.used -= int64(len(block.data))
+}
+
+// WithRangeCache reads the content through cache. key identifies the content and its version,
+// e.g. the path plus the ETag, so a modified content never gets stale blocks.
+func WithRangeCache(cache *RangeCache, key string) ServeOption {
+	return func(o *serveOptions) {
+		o.wrapContents = append(o.wrapContents, func(content io.ReadSeeker) io.ReadSeeker {
+			return &rangeCacheReader{cache: cache, key: key, content: content}
+		})
+	}
+}
+
+// rangeCacheReader reads the content block by block through the cache.
+type rangeCacheReader struct {
+	cache   *RangeCache
+	key     string
+	content io.ReadSeeker
+	offset  int64
+}
+
+func (r *rangeCacheReader) Read(p []byte) (int, error) {
+	index := r.offset / r.cache.blockSize
+	block, err := r.block(index)
+	if err != nil {
+		return 0, err
+	}
+	start := r.offset - index*r.cache.blockSize
+	if start >= int64(len(block)) {
+		return 0, io.EOF
+	}
+	n := copy(p, block[start:])
+	r.offset += int64(n)
+	return n, nil
+}
+
+func (r *rangeCacheReader) block(index int64) ([]byte, error) {
+	key := rangeCacheKey{content: r.key, index: index}
+	if data, ok := r.cache.get(key); ok {
+		return data, nil
+	}
+	if _, err := r.content.Seek(index*r.cache.blockSize, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data := make([]byte, r.cache.blockSize)
+	n, err := io.ReadFull(r.content, data)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	data = data[:n]
+	r.cache.put(key, data)
+	return data, nil
+}
+
+func (r *rangeCacheReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		end, err := r.content.Seek(0, io.SeekEnd)
+		if err != nil {
+			return 0, err
+		}
+		offset += end
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.offset = offset
+	return offset, nil
+}
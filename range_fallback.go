@@ -0,0 +1,97 @@
+package gatewayfile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+
+	"google.golang.org/grpc/codes"
+)
+
+// ErrRangeRejected is returned by a content, from Seek or Read, when its source can't serve the requested range,
+// e.g. an object recompacted or moved to a storage class without range reads, see WithRangeFallback.
+var ErrRangeRejected = newError(codes.OutOfRange, "RANGE_REJECTED", "range rejected by the source")
+
+// rangeReadAhead is how much of a single range is read before the header is sent, see WithRangeFallback.
+const rangeReadAhead = 32 << 10
+
+// RangeFallback is how a single range rejected by the source is answered, see WithRangeFallback.
+type RangeFallback int
+
+const (
+	// RangeFallbackNone returns the error of the source, the default.
+	RangeFallbackNone RangeFallback = iota
+	// RangeFallbackFull serves the whole content with a 200 response instead,
+	// unless it changed since it was opened, in which case it's answered like RangeFallbackNotSatisfiable.
+	RangeFallbackFull
+	// RangeFallbackNotSatisfiable answers 416 Range Not Satisfiable with the current size of the content,
+	// so the client restarts the download rather than failing on a stream error.
+	RangeFallbackNotSatisfiable
+)
+
+// WithRangeFallback answers a single range request whose range is rejected by the source with policy,
+// rather than surfacing a raw stream error. The source rejects a range when seeking or reading fails with
+// ErrRangeRejected, or when it's shorter than expected. The beginning of the range is read before the header
+// is sent, so the rejection is caught while the status can still change.
+// With ServeProvider the content is stat-ed again, so a 416 carries its current size, and a content which changed
+// is never served in full. Otherwise, the size given to ServeContent is assumed unchanged.
+func WithRangeFallback(policy RangeFallback) ServeOption {
+	return func(o *serveOptions) {
+		o.rangeFallback = policy
+	}
+}
+
+// withCurrentSize sets how WithRangeFallback learns the current size of the content,
+// and whether it changed since it was opened.
+func withCurrentSize(current func(ctx context.Context) (size int64, changed bool, err error)) ServeOption {
+	return func(o *serveOptions) {
+		o.currentSize = current
+	}
+}
+
+// isRangeRejection reports whether err means the source can't serve the range any longer.
+func isRangeRejection(err error) bool {
+	return errors.Is(err, ErrRangeRejected) || errors.Is(err, errContentChanged) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// openRange seeks content to the single range ra, and returns the reader of the range.
+// With a fallback policy, the beginning of the range is read ahead, so a rejection fails here.
+func (o *serveOptions) openRange(content io.ReadSeeker, ra httpRange) (io.Reader, error) {
+	if _, err := content.Seek(ra.start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if o.rangeFallback == RangeFallbackNone {
+		return content, nil
+	}
+	head := make([]byte, min(ra.length, rangeReadAhead))
+	if _, err := io.ReadFull(content, head); err != nil {
+		return nil, err
+	}
+	return io.MultiReader(bytes.NewReader(head), content), nil
+}
+
+// fallBack decides how a single range rejected with err is answered. It returns whether the whole content is served
+// instead, content seeked back to its start, or otherwise the current size of the content to answer 416 with.
+// err is returned as-is if there is no fallback policy or it's not a rejection.
+func (o *serveOptions) fallBack(
+	ctx context.Context, content io.ReadSeeker, size int64, err error,
+) (full bool, current int64, _ error) {
+	if o.rangeFallback == RangeFallbackNone || !isRangeRejection(err) {
+		return false, 0, err
+	}
+	current, changed := size, false
+	if o.currentSize != nil {
+		if currentSize, currentChanged, statErr := o.currentSize(ctx); statErr == nil {
+			current, changed = currentSize, currentChanged
+		}
+	}
+	if o.rangeFallback == RangeFallbackNotSatisfiable || changed {
+		return false, current, nil
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return false, 0, err
+	}
+	return true, size, nil
+}
@@ -0,0 +1,151 @@
+package gatewayfile
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header  string
+		size    int64
+		want    []httpRange
+		wantErr error
+	}{
+		{header: "", size: 10},
+		{header: "bytes=0-4", size: 10, want: []httpRange{{0, 5}}},
+		{header: "bytes=5-", size: 10, want: []httpRange{{5, 5}}},
+		{header: "bytes=-3", size: 10, want: []httpRange{{7, 3}}},
+		{header: "bytes=-20", size: 10, want: []httpRange{{0, 10}}},
+		{header: "bytes=8-20", size: 10, want: []httpRange{{8, 2}}},
+		{header: "bytes= 0-1 , 4-5,", size: 10, want: []httpRange{{0, 2}, {4, 2}}},
+		{header: "bytes=0-0,-1", size: 10, want: []httpRange{{0, 1}, {9, 1}}},
+		{header: "bytes=10-", size: 10, wantErr: ErrNoOverlap},
+		{header: "bytes=10-, 2-3", size: 10, want: []httpRange{{2, 2}}},
+		{header: "bytes=0-", size: 0, wantErr: ErrNoOverlap},
+		{header: "items=0-1", size: 10, wantErr: ErrInvalidRange},
+		{header: "bytes=1", size: 10, wantErr: ErrInvalidRange},
+		{header: "bytes=-", size: 10, wantErr: ErrInvalidRange},
+		{header: "bytes=--1", size: 10, wantErr: ErrInvalidRange},
+		{header: "bytes=-1-2", size: 10, wantErr: ErrInvalidRange},
+		{header: "bytes=5-4", size: 10, wantErr: ErrInvalidRange},
+		{header: "bytes=a-4", size: 10, wantErr: ErrInvalidRange},
+		{header: "bytes=0-b", size: 10, wantErr: ErrInvalidRange},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			got, err := parseRange(tt.header, tt.size)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if len(got) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// rejectingContent rejects the seeks past its start with ErrRangeRejected, like an object which lost range reads.
+type rejectingContent struct {
+	*bytes.Reader
+}
+
+func (c rejectingContent) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekStart && offset > 0 {
+		return 0, ErrRangeRejected
+	}
+	return c.Reader.Seek(offset, whence)
+}
+
+func TestServeContentRangeFallback(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10)
+	size := int64(len(content))
+	tests := []struct {
+		name      string
+		content   io.ReadSeeker
+		rng       string
+		policy    RangeFallback
+		wantErr   error
+		wantCode  string
+		wantRange string
+		wantBody  []byte
+	}{
+		{
+			name:    "no fallback",
+			content: rejectingContent{bytes.NewReader(content)},
+			rng:     "bytes=10-19",
+			wantErr: ErrRangeRejected,
+		},
+		{
+			name:     "full",
+			content:  rejectingContent{bytes.NewReader(content)},
+			rng:      "bytes=10-19",
+			policy:   RangeFallbackFull,
+			wantCode: "200",
+			wantBody: content,
+		},
+		{
+			name:      "not satisfiable",
+			content:   rejectingContent{bytes.NewReader(content)},
+			rng:       "bytes=10-19",
+			policy:    RangeFallbackNotSatisfiable,
+			wantCode:  "416",
+			wantRange: "bytes */100",
+		},
+		{
+			name:      "shorter than expected",
+			content:   bytes.NewReader(content[:50]),
+			rng:       "bytes=60-69",
+			policy:    RangeFallbackNotSatisfiable,
+			wantCode:  "416",
+			wantRange: "bytes */100",
+		},
+		{
+			name:      "served",
+			content:   bytes.NewReader(content),
+			rng:       "bytes=10-19",
+			policy:    RangeFallbackFull,
+			wantCode:  "206",
+			wantRange: "bytes 10-19/100",
+			wantBody:  content[10:20],
+		},
+		{
+			name:     "first byte",
+			content:  rejectingContent{bytes.NewReader(content)},
+			rng:      "bytes=0-9",
+			policy:   RangeFallbackFull,
+			wantCode: "206",
+			// the range starts at 0, nothing to reject.
+			wantRange: "bytes 0-9/100",
+			wantBody:  content[:10],
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeDownloadServer("grpcgateway-range", tt.rng)
+			err := ServeContent(server, tt.content, "text/plain", "", time.Time{}, size, WithRangeFallback(tt.policy))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := pick(server.header, headerCode); got != tt.wantCode {
+				t.Fatalf("got code %s, want %s", got, tt.wantCode)
+			}
+			if got := pick(server.header, headerContentRange); got != tt.wantRange {
+				t.Fatalf("got Content-Range %q, want %q", got, tt.wantRange)
+			}
+			if tt.wantBody != nil && !bytes.Equal(server.body.Bytes(), tt.wantBody) {
+				t.Fatalf("got body %q, want %q", server.body.Bytes(), tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package gatewayfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// MIMEProblemJSON is the content type of RFC 9457 problem details.
+const MIMEProblemJSON = "application/problem+json"
+
+// RangeNotSatisfiable describes a request answered with 416 Range Not Satisfiable.
+type RangeNotSatisfiable struct {
+	Range     string // Range is the Range header of the request.
+	Size      int64  // Size is the size of the content, the available range is bytes 0 to Size-1.
+	Err       error  // Err is why the range can't be satisfied, e.g. ErrNoOverlap or ErrInvalidRange.
+	RequestID string // RequestID is the id of the request, if any, see RequestID.
+}
+
+// AvailableRange returns the range which can be requested, e.g. "bytes 0-1023", or "" if the content is empty.
+func (r RangeNotSatisfiable) AvailableRange() string {
+	if r.Size <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("bytes 0-%d", r.Size-1)
+}
+
+// RangeNotSatisfiableFunc renders the body of a 416 response, and its content type.
+type RangeNotSatisfiableFunc func(r RangeNotSatisfiable) (contentType string, body []byte)
+
+// WithRangeNotSatisfiable renders the body of 416 responses with f, instead of the default plain text,
+// e.g. ProblemRangeNotSatisfiable. The Content-Range header is set anyway.
+func WithRangeNotSatisfiable(f RangeNotSatisfiableFunc) ServeOption {
+	return func(o *serveOptions) {
+		o.rangeNotSatisfiable = f
+	}
+}
+
+// ProblemRangeNotSatisfiable renders 416 responses as RFC 9457 problem details, with the extension members
+// "size" and "available-range", so clients can retry with a valid range:
+//
+//	{"type":"about:blank","title":"Range Not Satisfiable","status":416,"detail":"invalid range: failed to overlap",
+//	"size":1024,"available-range":"bytes 0-1023"}
+func ProblemRangeNotSatisfiable(r RangeNotSatisfiable) (string, []byte) {
+	body := struct {
+		problem
+		Size           int64  `json:"size"`
+		AvailableRange string `json:"available-range,omitempty"`
+	}{
+		Size:           r.Size,
+		AvailableRange: r.AvailableRange(),
+	}
+	body.problem = newProblem(http.StatusRequestedRangeNotSatisfiable, "", r.RequestID)
+	if r.Err != nil {
+		body.Detail = r.Err.Error()
+	}
+	data, _ := json.Marshal(body)
+	return MIMEProblemJSON, data
+}
+
+// serveRangeNotSatisfiable answers 416 for the range request rangeReq of a content of the given size.
+func serveRangeNotSatisfiable(
+	server downloadServer, outgoing metadata.MD, o *serveOptions, rangeReq string, size int64, err error,
+) error {
+	// RFC 9110, Section 14.4: the Content-Range of a 416 response is the current length of the representation.
+	outgoing.Set(headerContentRange, fmt.Sprintf("bytes */%d", size))
+	if o.rangeNotSatisfiable == nil {
+		return serveError(server, outgoing, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+	}
+	contentType, body := o.rangeNotSatisfiable(RangeNotSatisfiable{
+		Range:     rangeReq,
+		Size:      size,
+		Err:       err,
+		RequestID: RequestID(server.Context()),
+	})
+	return serveErrorBody(server, outgoing, contentType, body, http.StatusRequestedRangeNotSatisfiable)
+}
@@ -0,0 +1,143 @@
+package gatewayfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+)
+
+const (
+	// rangePrefetchParts is the number of parts of a multi-range response prefetched in parallel, see writeRangesAt.
+	rangePrefetchParts = 4
+	// rangePrefetchSize is the maximum size of a prefetched part, larger parts are streamed when they're written.
+	rangePrefetchSize = 1 << 20 // 1 MB
+)
+
+// ReadAtCloser is a content read at offsets.
+type ReadAtCloser interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// ReaderAtProvider provides a content read at offsets rather than through a cursor, e.g. an object store client
+// issuing a ranged GET per read. Reads at different offsets don't share any state, so they can run in parallel.
+// FileProvider implements it.
+type ReaderAtProvider interface {
+	// OpenReaderAt opens the content. The returned ReadAtCloser is closed after serving.
+	OpenReaderAt(ctx context.Context) (ReadAtCloser, ContentInfo, error)
+}
+
+func (path fileProvider) OpenReaderAt(ctx context.Context) (ReadAtCloser, ContentInfo, error) {
+	file, info, err := path.Open(ctx)
+	if err != nil {
+		return nil, ContentInfo{}, err
+	}
+	return file.(ReadAtCloser), info, nil
+}
+
+// ServeReaderAt serves the content of the provider like ServeProvider.
+// Multi-range requests are answered by direct offset reads of each range, without seeking,
+// and the next parts are prefetched in parallel while a part is written. WithRangeCache doesn't apply to them.
+func ServeReaderAt(server downloadServer, provider ReaderAtProvider, opts ...ServeOption) error {
+	content, info, err := provider.OpenReaderAt(server.Context())
+	if errors.Is(err, ErrCircuitOpen) {
+		return serveCircuitOpen(server, err)
+	}
+	if err != nil {
+		return err
+	}
+	closer := closeOnDone(server.Context(), content)
+	defer func() { _ = closer.Close() }()
+
+	if o := newServeOptions(server.Context(), opts); o.onProviderInfo != nil {
+		o.onProviderInfo(info)
+	}
+	return ServeContent(
+		server, io.NewSectionReader(content, 0, info.Size), info.ContentType, info.Name, info.ModTime, info.Size,
+		append(append(info.serveOptions(), withReaderAt(content)), opts...)...,
+	)
+}
+
+func withReaderAt(content io.ReaderAt) ServeOption {
+	return func(o *serveOptions) {
+		o.readerAt = content
+	}
+}
+
+// prefetchedPart is a part of a multi-range response read ahead of time.
+type prefetchedPart struct {
+	data []byte
+	err  error
+}
+
+// writeRangesAt writes the parts of a multipart/byteranges body with direct offset reads of content.
+// Up to rangePrefetchParts parts of at most rangePrefetchSize are read in parallel ahead of the part being written.
+func writeRangesAt(
+	ctx context.Context, mWriter *multipart.Writer, content io.ReaderAt, ranges []httpRange,
+	contentType string, size int64,
+) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	parts := make([]chan prefetchedPart, len(ranges))
+	for i := range parts {
+		parts[i] = make(chan prefetchedPart, 1)
+	}
+	slots := make(chan struct{}, rangePrefetchParts)
+	go func() {
+		for i, ra := range ranges {
+			if ra.length > rangePrefetchSize {
+				continue
+			}
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			go func() {
+				parts[i] <- readPartAt(content, ra)
+			}()
+		}
+	}()
+
+	for i, ra := range ranges {
+		part, err := mWriter.CreatePart(ra.mimeHeader(contentType, size))
+		if err != nil {
+			return err
+		}
+		if ra.length > rangePrefetchSize {
+			if _, err = io.CopyN(part, io.NewSectionReader(content, ra.start, ra.length), ra.length); err != nil {
+				return err
+			}
+			continue
+		}
+		var prefetched prefetchedPart
+		select {
+		case prefetched = <-parts[i]:
+			<-slots
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if prefetched.err != nil {
+			return prefetched.err
+		}
+		if _, err = part.Write(prefetched.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readPartAt(content io.ReaderAt, ra httpRange) prefetchedPart {
+	data := make([]byte, ra.length)
+	n, err := content.ReadAt(data, ra.start)
+	if n == len(data) {
+		return prefetchedPart{data: data}
+	}
+	if err == nil || errors.Is(err, io.EOF) {
+		err = fmt.Errorf("read range %d-%d: %w", ra.start, ra.start+ra.length-1, io.ErrUnexpectedEOF)
+	}
+	return prefetchedPart{err: err}
+}
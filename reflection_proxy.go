@@ -0,0 +1,184 @@
+package gatewayfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/utilities"
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// httpBodyName is the full name of google.api.HttpBody.
+const httpBodyName protoreflect.FullName = "google.api.HttpBody"
+
+// ReflectionProxyConfig is the configuration of RegisterReflectionProxy.
+type ReflectionProxyConfig struct {
+	// Prefix is the path prefix of the routes, defaults to "/files".
+	Prefix string
+	// Services restricts the proxied services to these full names, all services if empty.
+	Services []string
+}
+
+// RegisterReflectionProxy discovers the server-streaming methods returning google.api.HttpBody of the upstream
+// conn by gRPC server reflection, and registers a GET route for each of them on mux:
+// "{prefix}/{package.Service}/{Method}". The query parameters populate the request message, like the generated
+// gateway handlers, and the request headers are forwarded as metadata by the header matcher of mux.
+// So a central file gateway can front many backends without generating and registering their handlers.
+// The upstream must register the reflection service (google.golang.org/grpc/reflection).
+// It returns the full names of the registered methods.
+func RegisterReflectionProxy(
+	ctx context.Context, mux *runtime.ServeMux, conn grpc.ClientConnInterface, cfg ReflectionProxyConfig,
+) ([]string, error) {
+	if cfg.Prefix == "" {
+		cfg.Prefix = "/files"
+	}
+	files, err := reflectFiles(ctx, conn, cfg.Services)
+	if err != nil {
+		return nil, fmt.Errorf("reflect upstream failed %w", err)
+	}
+
+	var registered []string
+	var errs []error
+	files.RangeFiles(func(file protoreflect.FileDescriptor) bool {
+		services := file.Services()
+		for i := range services.Len() {
+			service := services.Get(i)
+			if len(cfg.Services) > 0 && !slices.Contains(cfg.Services, string(service.FullName())) {
+				continue
+			}
+			methods := service.Methods()
+			for j := range methods.Len() {
+				method := methods.Get(j)
+				if !method.IsStreamingServer() || method.IsStreamingClient() || method.Output().FullName() != httpBodyName {
+					continue
+				}
+				fullMethod := fmt.Sprintf("/%s/%s", service.FullName(), method.Name())
+				pattern := strings.TrimSuffix(cfg.Prefix, "/") + fullMethod
+				err := mux.HandlePath(http.MethodGet, pattern, reflectionHandler(mux, conn, method, fullMethod, pattern))
+				if err != nil {
+					errs = append(errs, fmt.Errorf("register %s failed %w", fullMethod, err))
+					continue
+				}
+				registered = append(registered, string(method.FullName()))
+			}
+		}
+		return true
+	})
+	sort.Strings(registered)
+	return registered, errors.Join(errs...)
+}
+
+func reflectionHandler(
+	mux *runtime.ServeMux, conn grpc.ClientConnInterface, method protoreflect.MethodDescriptor, fullMethod, pattern string,
+) runtime.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+		_, marshaler := runtime.MarshalerForRequest(mux, r)
+		ctx, err := runtime.AnnotateContext(r.Context(), mux, r, fullMethod, runtime.WithHTTPPathPattern(pattern))
+		if err != nil {
+			runtime.HTTPError(r.Context(), mux, marshaler, w, r, err)
+			return
+		}
+		request := dynamicpb.NewMessage(method.Input())
+		if err = runtime.PopulateQueryParameters(request, r.URL.Query(), &utilities.DoubleArray{}); err != nil {
+			runtime.HTTPError(ctx, mux, marshaler, w, r, err)
+			return
+		}
+
+		stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, fullMethod)
+		if err == nil {
+			err = stream.SendMsg(request)
+		}
+		if err == nil {
+			err = stream.CloseSend()
+		}
+		var header metadata.MD
+		if err == nil {
+			header, err = stream.Header()
+		}
+		if err != nil {
+			runtime.HTTPError(ctx, mux, marshaler, w, r, err)
+			return
+		}
+
+		ctx = runtime.NewServerMetadataContext(ctx, runtime.ServerMetadata{HeaderMD: header, TrailerMD: stream.Trailer()})
+		runtime.ForwardResponseStream(ctx, mux, marshaler, w, r, func() (proto.Message, error) {
+			body := new(httpbody.HttpBody)
+			if err := stream.RecvMsg(body); err != nil {
+				return nil, err
+			}
+			return body, nil
+		}, mux.GetForwardResponseOptions()...)
+	}
+}
+
+// reflectFiles returns the files of the services of the upstream, and their dependencies.
+func reflectFiles(ctx context.Context, conn grpc.ClientConnInterface, services []string) (*protoregistry.Files, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	call := func(request *reflectionpb.ServerReflectionRequest) (*reflectionpb.ServerReflectionResponse, error) {
+		if err := stream.Send(request); err != nil {
+			return nil, err
+		}
+		response, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		if e := response.GetErrorResponse(); e != nil {
+			return nil, fmt.Errorf("reflection error %d: %s", e.GetErrorCode(), e.GetErrorMessage())
+		}
+		return response, nil
+	}
+
+	if len(services) == 0 {
+		response, err := call(&reflectionpb.ServerReflectionRequest{
+			MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, service := range response.GetListServicesResponse().GetService() {
+			services = append(services, service.GetName())
+		}
+	}
+
+	protos := make(map[string]*descriptorpb.FileDescriptorProto)
+	for _, service := range services {
+		response, err := call(&reflectionpb.ServerReflectionRequest{
+			MessageRequest: &reflectionpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: service},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", service, err)
+		}
+		for _, raw := range response.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			file := new(descriptorpb.FileDescriptorProto)
+			if err := proto.Unmarshal(raw, file); err != nil {
+				return nil, err
+			}
+			protos[file.GetName()] = file
+		}
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, file := range protos {
+		set.File = append(set.File, file)
+	}
+	return protodesc.NewFiles(set)
+}
@@ -0,0 +1,74 @@
+package gatewayfile
+
+import (
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// headerFileContentType carries the Content-Type of file responses: gRPC servers replace the content-type
+	// of the header metadata with their own, e.g. "application/grpc".
+	headerFileContentType = "file-content-type"
+	// headerFileHeaders lists the other headers of the response to write, see WithRepresentationHeader.
+	headerFileHeaders     = "file-headers"
+	headerContentLocation = "content-location"
+)
+
+// setContentType sets the Content-Type of the response stored in the header metadata md.
+func setContentType(md metadata.MD, contentType string) {
+	md.Set(headerFileContentType, contentType)
+	md.Set(headerContentType, contentType)
+}
+
+// contentTypeOf returns the Content-Type of the response stored in the header metadata md, see setContentType.
+func contentTypeOf(md metadata.MD) string {
+	if contentType := pick(md, headerFileContentType); contentType != "" {
+		return contentType
+	}
+	if contentType := pick(md, headerContentType); !strings.HasPrefix(contentType, "application/grpc") {
+		return contentType
+	}
+	return ""
+}
+
+// WithContentLanguage sets the Content-Language of the response, the languages of its intended audience,
+// e.g. WithContentLanguage("de-DE") for a localized document. It takes precedence over ContentInfo.ContentLanguage.
+func WithContentLanguage(tags ...string) ServeOption {
+	return withHeader(headerContentLanguage, strings.Join(tags, ", "))
+}
+
+// WithContentLocation sets the Content-Location of the response, e.g. the URL of the localized variant served
+// for a generic URL.
+func WithContentLocation(location string) ServeOption {
+	return withHeader(headerContentLocation, location)
+}
+
+// WithRepresentationHeader sets another header of the response, e.g. Link or a custom locale header.
+// The header is listed in the response metadata, so WithFileForwardResponseOption writes it
+// like the ones given to WithForwardedHeaders. Several values are written as repeated headers, in order,
+// e.g. WithRepresentationHeader("Link", preload, canonical). They replace the values of a previous call.
+func WithRepresentationHeader(key string, values ...string) ServeOption {
+	key = strings.ToLower(key)
+	return func(o *serveOptions) {
+		if o.header == nil {
+			o.header = make(metadata.MD)
+		}
+		o.header.Set(key, values...)
+		if !slices.Contains(o.header.Get(headerFileHeaders), key) {
+			o.header.Append(headerFileHeaders, key)
+		}
+	}
+}
+
+// WithForwardedHeaders also writes the given headers of the response metadata to file responses,
+// e.g. the ones set by the gRPC handler with grpc.SetHeader. All their values are written, in order.
+// They should be exposed to cross-domain requests too, see CORSConfig.ExposedHeaders.
+func WithForwardedHeaders(keys ...string) ForwardOption {
+	return func(o *forwardOptions) {
+		for _, key := range keys {
+			o.forwardedHeaders = append(o.forwardedHeaders, strings.ToLower(key))
+		}
+	}
+}
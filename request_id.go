@@ -0,0 +1,45 @@
+package gatewayfile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// request headers used to correlate transfers across systems.
+const (
+	headerXRequestID  = "X-Request-Id"
+	headerTraceparent = "Traceparent"
+)
+
+// RequestID returns the ID correlating the request across systems: the X-Request-Id header, or else the trace ID
+// of the W3C traceparent header. Headers forwarded by the gateway and plain gRPC metadata are both supported.
+func RequestID(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if id := requestHeader(md, headerXRequestID); id != "" {
+		return id
+	}
+	// traceparent: version-traceid-parentid-flags
+	if parts := strings.Split(requestHeader(md, headerTraceparent), "-"); len(parts) == 4 {
+		return parts[1]
+	}
+	return ""
+}
+
+// requestHeader returns the request header key, forwarded by the gateway or sent as gRPC metadata.
+func requestHeader(md metadata.MD, key string) string {
+	if v := pickHeader(md, key); v != "" {
+		return v
+	}
+	return pick(md, strings.ToLower(key))
+}
+
+// withRequestID annotates err with the request ID of ctx, if any.
+func withRequestID(ctx context.Context, err error) error {
+	if id := RequestID(ctx); id != "" && err != nil {
+		return fmt.Errorf("%w (request id %s)", err, id)
+	}
+	return err
+}
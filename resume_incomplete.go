@@ -0,0 +1,135 @@
+package gatewayfile
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// StatusResumeIncomplete is the status code of the resumable uploads of Google APIs, "308 Resume Incomplete",
+// answered while an upload isn't complete.
+const StatusResumeIncomplete = http.StatusPermanentRedirect
+
+const (
+	// headerRequestContentRange is the range of the chunk of a resumable upload request, see AppendResumable.
+	headerRequestContentRange = "Content-Range"
+	// headerCommittedRange is the range of the committed bytes in 308 Resume Incomplete responses.
+	headerCommittedRange = "range"
+)
+
+// SetResumeIncomplete sets the response of a unary RPC to 308 Resume Incomplete, with the Range header of the
+// committed bytes, e.g. "bytes=0-1048575" when 1 MiB is committed, and no Range header when nothing is.
+// Like SetResponseStatus, it must be called before the handler returns.
+func SetResumeIncomplete(ctx context.Context, committed int64) error {
+	return grpc.SetHeader(ctx, resumeIncompleteHeader(committed))
+}
+
+func resumeIncompleteHeader(committed int64) metadata.MD {
+	md := metadata.Pairs(headerCode, strconv.Itoa(StatusResumeIncomplete))
+	if committed > 0 {
+		md.Set(headerCommittedRange, "bytes=0-"+strconv.FormatInt(committed-1, 10))
+	}
+	return md
+}
+
+// AppendResumable implements the resumable upload protocol of Google APIs (Cloud Storage, Drive, YouTube...)
+// on top of a ChunkSink, so their client SDKs can upload through the gateway. id names the upload, e.g. from the
+// upload_id query parameter of the session URI. The Content-Range request header tells where the chunk goes:
+//   - "bytes 0-524287/2000000" or "bytes 0-524287/*": a chunk, appended to sink if it starts at the committed
+//     offset, otherwise it's rejected with ErrOffsetMismatch.
+//   - "bytes */2000000" or "bytes */*": a status query, complete if the committed offset is the total size.
+//   - none: the whole upload in one request.
+//
+// Until the upload is complete, the response is set to 308 Resume Incomplete with the committed range,
+// see SetResumeIncomplete. Once it's complete, the handler answers the final response, e.g. the object metadata
+// with 200, or 201 with SetResponseStatus.
+// sizeLimit is the maximum size of a chunk in bytes (0 = unlimited).
+// The HTTPBody marshaler must be registered for the chunk Content-Type, e.g. MIMEOctetStream.
+func AppendResumable(
+	server uploadServer, sink ChunkSink, id string, sizeLimit int64, opts ...FormDataOption,
+) (*ChunkStatus, error) {
+	ctx := server.Context()
+	o := newFormDataOptions(opts)
+	status, err := appendResumable(server, sink, id, sizeLimit, o)
+	if status != nil && !status.Complete {
+		_ = server.SetHeader(resumeIncompleteHeader(status.Offset))
+	}
+	o.finish(ctx, id, err)
+	if err != nil {
+		return status, withRequestID(ctx, fmt.Errorf("append resumable chunk failed %w", err))
+	}
+	return status, nil
+}
+
+func appendResumable(
+	server uploadServer, sink ChunkSink, id string, sizeLimit int64, o *formDataOptions,
+) (*ChunkStatus, error) {
+	ctx := server.Context()
+	md, _ := metadata.FromIncomingContext(ctx)
+	contentRange := pickHeader(md, headerRequestContentRange)
+	start, end, total, err := parseUploadContentRange(contentRange)
+	if err != nil {
+		return nil, err
+	}
+
+	if start < 0 {
+		// a status query, without chunk.
+		offset, err := sink.Offset(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return &ChunkStatus{ID: id, Offset: offset, Complete: offset == total}, nil
+	}
+
+	body := o.newReader(server, sizeLimit)
+	if end >= 0 {
+		body = &exactLimitReader{reader: body, remaining: end - start + 1}
+	}
+	committed, err := sink.Append(ctx, id, start, body)
+	complete := committed == total || contentRange == ""
+	return &ChunkStatus{ID: id, Offset: committed, Complete: complete}, err
+}
+
+// parseUploadContentRange parses the Content-Range of a resumable upload request, see AppendResumable.
+// start and end are -1 for "bytes */total", end is -1 without Content-Range, total is -1 when it's unknown.
+func parseUploadContentRange(value string) (start, end, total int64, err error) {
+	if value == "" {
+		return 0, -1, -1, nil
+	}
+	invalid := fmt.Errorf("%w: %s %q", ErrInvalidUpload, headerRequestContentRange, value)
+	spec, ok := strings.CutPrefix(value, "bytes ")
+	if !ok {
+		return 0, 0, 0, invalid
+	}
+	chunk, size, ok := strings.Cut(strings.TrimSpace(spec), "/")
+	if !ok {
+		return 0, 0, 0, invalid
+	}
+	total = -1
+	if size != "*" {
+		if total, err = strconv.ParseInt(size, 10, 64); err != nil || total < 0 {
+			return 0, 0, 0, invalid
+		}
+	}
+	if chunk == "*" {
+		return -1, -1, total, nil
+	}
+	first, last, ok := strings.Cut(chunk, "-")
+	if !ok {
+		return 0, 0, 0, invalid
+	}
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, 0, invalid
+	}
+	end, err = strconv.ParseInt(last, 10, 64)
+	if err != nil || end < start || total >= 0 && end >= total {
+		return 0, 0, 0, invalid
+	}
+	return start, end, total, nil
+}
@@ -0,0 +1,321 @@
+package gatewayfile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// retentionKeyPrefix prefixes the Store keys of the files registered for retention.
+	retentionKeyPrefix = "retention:"
+	// retentionHoldKeyPrefix prefixes the Store keys of the legal holds, kept apart from the registrations so
+	// registering or forgetting a file never drops its hold.
+	retentionHoldKeyPrefix = "retention-hold:"
+)
+
+// ErrNotRetained is returned by Retention.Hold and Retention.Release for a file which is not registered.
+var ErrNotRetained = newError(codes.NotFound, "NOT_RETAINED", "file not registered for retention")
+
+// RetentionAction is what happens to a file once its retention expires.
+type RetentionAction string
+
+const (
+	RetentionDelete  RetentionAction = "delete"  // RetentionDelete deletes the file, see RetentionConfig.Delete.
+	RetentionArchive RetentionAction = "archive" // RetentionArchive archives the file, see RetentionConfig.Archive.
+)
+
+// RetentionPolicy is how long the files it's applied to are kept, and what happens to them afterward.
+type RetentionPolicy struct {
+	Name   string          // Name identifies the policy, e.g. "invoices". It's recorded with each file.
+	TTL    time.Duration   // TTL is how long the files are kept once registered.
+	Action RetentionAction // Action is applied on expiry, defaults to RetentionDelete.
+}
+
+// RetainedFile is a file registered for retention, see Retention.Register.
+type RetainedFile struct {
+	Destination string          `json:"destination"` // Destination is where the file is, see StreamedFile.
+	Policy      string          `json:"policy"`
+	Action      RetentionAction `json:"action"`
+	Registered  time.Time       `json:"registered"`
+	Expires     time.Time       `json:"expires"`
+	// Held reports whether the file is under legal hold, see Retention.Hold. It's kept while held, even expired.
+	Held bool `json:"-"`
+}
+
+// RetentionConfig is the configuration of a Retention.
+type RetentionConfig struct {
+	// Interval is the interval between sweeps, defaults to 1 hour.
+	Interval time.Duration
+	// Delete deletes an expired file, defaults to removing the local file at its destination.
+	// A file which doesn't exist anymore is not an error.
+	Delete func(ctx context.Context, file RetainedFile) error
+	// Archive archives an expired file whose action is RetentionArchive, e.g. moves it to cold storage.
+	// It's required by RetentionArchive, the file is left untouched once archived.
+	Archive func(ctx context.Context, file RetainedFile) error
+	// Exempt reports whether an expired file must be kept anyway, e.g. by asking an external legal hold system.
+	// It's asked again on the next sweeps. May be nil.
+	Exempt func(ctx context.Context, file RetainedFile) (bool, error)
+	// OnExpire is called with each expired file once it's deleted or archived, or failed to, e.g. to audit it.
+	// May be nil.
+	OnExpire func(file RetainedFile, err error)
+	// OnSweep is called after each sweep with its stats, e.g. to export metrics. May be nil.
+	OnSweep func(stats RetentionStats)
+}
+
+// RetentionStats are the stats of sweeps.
+type RetentionStats struct {
+	Deleted  int // Deleted is the number of expired files deleted.
+	Archived int // Archived is the number of expired files archived.
+	Held     int // Held is the number of expired files kept by a legal hold or RetentionConfig.Exempt.
+	Errors   int // Errors is the number of expired files which could not be deleted or archived.
+}
+
+// ListingStore is a Store which lists its keys, e.g. memstore and redisstore.
+type ListingStore interface {
+	Store
+	KeyLister
+}
+
+// Retention deletes or archives saved files once their retention policy expires, for compliance requirements.
+// Files are registered with Register or WithRetention, and recorded in a store, so the registrations survive
+// restarts and are shared by a fleet. Each sweep lists all the registrations, the expired files are deleted
+// or archived unless they're held. It's opt-in: start it with Run, e.g. go retention.Run(ctx).
+type Retention struct {
+	store ListingStore
+	cfg   RetentionConfig
+
+	mu    sync.Mutex
+	total RetentionStats
+}
+
+// NewRetention returns a new Retention recording the registrations in store.
+func NewRetention(store ListingStore, cfg RetentionConfig) *Retention {
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Hour
+	}
+	if cfg.Delete == nil {
+		cfg.Delete = func(_ context.Context, file RetainedFile) error {
+			return os.Remove(file.Destination)
+		}
+	}
+	return &Retention{store: store, cfg: cfg}
+}
+
+// WithRetention registers each file stored by StreamFormData or FormData.SaveAll with policy, see WithOnStored.
+// A registration failure is reported to onError, which may be nil.
+func WithRetention(r *Retention, policy RetentionPolicy, onError func(file *StreamedFile, err error)) FormDataOption {
+	return WithOnStored(func(ctx context.Context, file *StreamedFile) {
+		if err := r.Register(ctx, file.Destination, policy); err != nil && onError != nil {
+			onError(file, err)
+		}
+	})
+}
+
+// Register registers the file at destination with policy, replacing its previous registration if any.
+// A legal hold of the file is kept.
+func (r *Retention) Register(ctx context.Context, destination string, policy RetentionPolicy) error {
+	if policy.Action == "" {
+		policy.Action = RetentionDelete
+	}
+	if policy.Action == RetentionArchive && r.cfg.Archive == nil {
+		return errNoArchive(policy.Name)
+	}
+	now := time.Now()
+	return r.put(ctx, RetainedFile{
+		Destination: destination,
+		Policy:      policy.Name,
+		Action:      policy.Action,
+		Registered:  now,
+		Expires:     now.Add(policy.TTL),
+	})
+}
+
+// Get returns the registration of the file at destination, ok is false if it's not registered.
+func (r *Retention) Get(ctx context.Context, destination string) (file RetainedFile, ok bool, err error) {
+	data, ok, err := r.store.Get(ctx, retentionKeyPrefix+destination)
+	if err != nil || !ok {
+		return file, false, err
+	}
+	if err = json.Unmarshal(data, &file); err != nil {
+		return file, false, err
+	}
+	if file.Held, err = r.isHeld(ctx, destination); err != nil {
+		return file, false, err
+	}
+	return file, true, nil
+}
+
+// Forget unregisters the file at destination, e.g. once it's deleted by the service itself.
+// Its legal hold, if any, is kept until Release.
+func (r *Retention) Forget(ctx context.Context, destination string) error {
+	return r.store.Delete(ctx, retentionKeyPrefix+destination)
+}
+
+// Hold places the file at destination under legal hold: it's kept, even expired, until Release.
+// The hold is recorded apart from the registration, and checked again right before the file is deleted or
+// archived, so it's not lost to a concurrent Register or sweep. It fails with ErrNotRetained if the file is
+// not registered.
+func (r *Retention) Hold(ctx context.Context, destination string) error {
+	if _, ok, err := r.store.Get(ctx, retentionKeyPrefix+destination); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("%w: %s", ErrNotRetained, destination)
+	}
+	return r.store.Set(ctx, retentionHoldKeyPrefix+destination, []byte(time.Now().UTC().Format(time.RFC3339)), 0)
+}
+
+// Release releases the legal hold of the file at destination, it's deleted or archived by the next sweep
+// if it expired meanwhile. Releasing a file which is not held does nothing.
+func (r *Retention) Release(ctx context.Context, destination string) error {
+	return r.store.Delete(ctx, retentionHoldKeyPrefix+destination)
+}
+
+// isHeld reports whether the file at destination is under legal hold.
+func (r *Retention) isHeld(ctx context.Context, destination string) (bool, error) {
+	_, held, err := r.store.Get(ctx, retentionHoldKeyPrefix+destination)
+	return held, err
+}
+
+func (r *Retention) put(ctx context.Context, file RetainedFile) error {
+	file.Held = false
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	return r.store.Set(ctx, retentionKeyPrefix+file.Destination, data, 0)
+}
+
+// Run sweeps now, then every interval, until ctx is done. It returns the error of ctx.
+func (r *Retention) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		_, _ = r.Sweep(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep deletes or archives the expired files once, and returns what it did.
+// It fails if the registrations can't be listed, the failures of single files are counted in the stats.
+func (r *Retention) Sweep(ctx context.Context) (RetentionStats, error) {
+	var stats RetentionStats
+	keys, err := r.store.Keys(ctx, retentionKeyPrefix)
+	if err != nil {
+		return stats, err
+	}
+	now := time.Now()
+	for _, key := range keys {
+		file, ok, err := r.Get(ctx, strings.TrimPrefix(key, retentionKeyPrefix))
+		if err != nil {
+			stats.Errors++
+			continue
+		}
+		if !ok || now.Before(file.Expires) {
+			// forgotten meanwhile, or still retained.
+			continue
+		}
+		if held, err := r.held(ctx, file); err != nil || held {
+			if err != nil {
+				stats.Errors++
+			} else {
+				stats.Held++
+			}
+			continue
+		}
+		// a Hold or Register may have landed meanwhile, e.g. from another instance sharing the store.
+		current, ok, err := r.recheck(ctx, file)
+		if err != nil || !ok {
+			if err != nil {
+				stats.Errors++
+			} else if current.Held {
+				stats.Held++
+			}
+			continue
+		}
+		if err = r.expire(ctx, file); err != nil {
+			stats.Errors++
+		} else if file.Action == RetentionArchive {
+			stats.Archived++
+		} else {
+			stats.Deleted++
+		}
+		if r.cfg.OnExpire != nil {
+			r.cfg.OnExpire(file, err)
+		}
+	}
+
+	r.mu.Lock()
+	r.total.Deleted += stats.Deleted
+	r.total.Archived += stats.Archived
+	r.total.Held += stats.Held
+	r.total.Errors += stats.Errors
+	r.mu.Unlock()
+	if r.cfg.OnSweep != nil {
+		r.cfg.OnSweep(stats)
+	}
+	return stats, nil
+}
+
+// held reports whether the expired file must be kept.
+func (r *Retention) held(ctx context.Context, file RetainedFile) (bool, error) {
+	if file.Held {
+		return true, nil
+	}
+	if r.cfg.Exempt == nil {
+		return false, nil
+	}
+	return r.cfg.Exempt(ctx, file)
+}
+
+// recheck reads the registration and legal hold of the expired file again, right before it's deleted or
+// archived. ok is false if the file was held, registered again or forgotten since it was read.
+func (r *Retention) recheck(ctx context.Context, file RetainedFile) (current RetainedFile, ok bool, err error) {
+	if current, ok, err = r.Get(ctx, file.Destination); err != nil || !ok {
+		return current, false, err
+	}
+	return current, !current.Held && current.Registered.Equal(file.Registered), nil
+}
+
+// expire deletes or archives the expired file, and forgets it.
+func (r *Retention) expire(ctx context.Context, file RetainedFile) error {
+	var err error
+	switch file.Action {
+	case RetentionArchive:
+		if r.cfg.Archive == nil {
+			return errNoArchive(file.Policy)
+		}
+		err = r.cfg.Archive(ctx, file)
+	default:
+		if err = r.cfg.Delete(ctx, file); errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return r.Forget(ctx, file.Destination)
+}
+
+func errNoArchive(policy string) error {
+	return fmt.Errorf("retention policy %s archives without RetentionConfig.Archive", policy)
+}
+
+// Stats returns the total stats of the sweeps so far.
+func (r *Retention) Stats() RetentionStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.total
+}
@@ -0,0 +1,136 @@
+package gatewayfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	defaultRetryAttempts   = 3
+	defaultRetryBackoff    = 100 * time.Millisecond
+	defaultRetryMaxBackoff = 5 * time.Second
+)
+
+// RetryPolicy bounds the transparent retries of a failing source, see WithSourceRetry.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of retries per request, defaults to 3.
+	MaxAttempts int
+	// Backoff is the delay before the first retry, it's doubled after each attempt. Defaults to 100ms.
+	Backoff time.Duration
+	// MaxBackoff caps the delay between retries, defaults to 5s.
+	MaxBackoff time.Duration
+	// Retryable reports whether a read error is transient, defaults to any error but context cancellation.
+	Retryable func(err error) bool
+}
+
+// WithSourceRetry transparently resumes the content at the current offset if reading it fails mid-stream,
+// e.g. on a transient S3 500 or an NFS hiccup, instead of aborting the client's download.
+// With ServeProvider the content is reopened from the provider, and the retry fails if its ETag or size changed.
+// Otherwise, the same content is seeked to the current offset.
+func WithSourceRetry(policy RetryPolicy) ServeOption {
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = defaultRetryAttempts
+	}
+	if policy.Backoff <= 0 {
+		policy.Backoff = defaultRetryBackoff
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = defaultRetryMaxBackoff
+	}
+	if policy.Retryable == nil {
+		policy.Retryable = func(err error) bool {
+			return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+		}
+	}
+	return func(o *serveOptions) {
+		o.retry = &policy
+	}
+}
+
+// withReopen sets how WithSourceRetry reopens the content.
+func withReopen(reopen func(ctx context.Context) (io.ReadSeekCloser, error)) ServeOption {
+	return func(o *serveOptions) {
+		o.reopen = reopen
+	}
+}
+
+// retryReader is a io.ReadSeeker which resumes the content at the current offset on read errors.
+type retryReader struct {
+	ctx     context.Context
+	policy  *RetryPolicy
+	reopen  func(ctx context.Context) (io.ReadSeekCloser, error)
+	content io.ReadSeeker
+	offset  int64
+
+	attempts int
+	opened   io.Closer // the content opened by reopen, if any
+}
+
+func newRetryReader(
+	ctx context.Context, content io.ReadSeeker, policy *RetryPolicy,
+	reopen func(ctx context.Context) (io.ReadSeekCloser, error),
+) *retryReader {
+	return &retryReader{ctx: ctx, policy: policy, reopen: reopen, content: content}
+}
+
+func (r *retryReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.content.Read(p)
+		r.offset += int64(n)
+		if err == nil || errors.Is(err, io.EOF) || n > 0 {
+			return n, err
+		}
+		if r.attempts >= r.policy.MaxAttempts || !r.policy.Retryable(err) {
+			return n, err
+		}
+		if retryErr := r.retry(); retryErr != nil {
+			return n, fmt.Errorf("%w, retry failed: %w", err, retryErr)
+		}
+	}
+}
+
+func (r *retryReader) retry() error {
+	backoff := r.policy.Backoff << r.attempts
+	if backoff > r.policy.MaxBackoff || backoff <= 0 {
+		backoff = r.policy.MaxBackoff
+	}
+	r.attempts++
+
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-r.ctx.Done():
+		return r.ctx.Err()
+	case <-timer.C:
+	}
+
+	if r.reopen != nil {
+		content, err := r.reopen(r.ctx)
+		if err != nil {
+			return err
+		}
+		_ = r.Close()
+		r.content, r.opened = content, content
+	}
+	_, err := r.content.Seek(r.offset, io.SeekStart)
+	return err
+}
+
+func (r *retryReader) Seek(offset int64, whence int) (int64, error) {
+	n, err := r.content.Seek(offset, whence)
+	if err == nil {
+		r.offset = n
+	}
+	return n, err
+}
+
+// Close closes the content opened by reopen, the original content is owned by the caller.
+func (r *retryReader) Close() error {
+	if r.opened == nil {
+		return nil
+	}
+	return r.opened.Close()
+}
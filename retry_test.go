@@ -0,0 +1,181 @@
+package gatewayfile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+var errFlaky = errors.New("flaky source")
+
+// flakyContent fails the reads at some offsets, as many times as given, with err.
+type flakyContent struct {
+	*bytes.Reader
+	failAt map[int64]int
+	err    error
+	closed bool
+}
+
+func newFlakyContent(content []byte, err error, failAt map[int64]int) *flakyContent {
+	return &flakyContent{Reader: bytes.NewReader(content), failAt: failAt, err: err}
+}
+
+func (c *flakyContent) Read(p []byte) (int, error) {
+	offset, _ := c.Seek(0, io.SeekCurrent)
+	if c.failAt[offset] > 0 {
+		c.failAt[offset]--
+		return 0, c.err
+	}
+	for at, n := range c.failAt {
+		// stop before the next failure.
+		if n > 0 && at > offset && at-offset < int64(len(p)) {
+			p = p[:at-offset]
+		}
+	}
+	return c.Reader.Read(p)
+}
+
+func (c *flakyContent) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestRetryReader(t *testing.T) {
+	content := []byte("0123456789abcdefghij")
+	tests := []struct {
+		name        string
+		err         error
+		failAt      map[int64]int
+		maxAttempts int
+		reopen      bool
+		reopenErr   error
+		want        string
+		wantErr     error
+	}{
+		{name: "no failure", want: string(content)},
+		{name: "resumed", err: errFlaky, failAt: map[int64]int{5: 1}, want: string(content)},
+		{name: "at the start", err: errFlaky, failAt: map[int64]int{0: 1}, want: string(content)},
+		{name: "several offsets", err: errFlaky, failAt: map[int64]int{5: 1, 12: 1}, want: string(content)},
+		{
+			name:    "attempts per request",
+			err:     errFlaky,
+			failAt:  map[int64]int{5: 1, 12: 1, 19: 1},
+			want:    "0123456789abcdefghi",
+			wantErr: errFlaky,
+		},
+		{name: "attempts", err: errFlaky, failAt: map[int64]int{5: 3}, maxAttempts: 3, want: string(content)},
+		{
+			name:        "too many attempts",
+			err:         errFlaky,
+			failAt:      map[int64]int{5: 4},
+			maxAttempts: 3,
+			want:        "01234",
+			wantErr:     errFlaky,
+		},
+		{
+			name:    "not retryable",
+			err:     context.Canceled,
+			failAt:  map[int64]int{5: 1},
+			want:    "01234",
+			wantErr: context.Canceled,
+		},
+		{name: "reopened", err: errFlaky, failAt: map[int64]int{5: 1, 12: 1}, reopen: true, want: string(content)},
+		{
+			name:      "reopen failed",
+			err:       errFlaky,
+			failAt:    map[int64]int{5: 1},
+			reopen:    true,
+			reopenErr: errContentChanged,
+			want:      "01234",
+			wantErr:   errContentChanged,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.maxAttempts == 0 {
+				tt.maxAttempts = 2
+			}
+			o := &serveOptions{}
+			WithSourceRetry(RetryPolicy{MaxAttempts: tt.maxAttempts, Backoff: time.Millisecond})(o)
+			source := newFlakyContent(content, tt.err, tt.failAt)
+			var reopened []*flakyContent
+			var reopen func(ctx context.Context) (io.ReadSeekCloser, error)
+			if tt.reopen {
+				reopen = func(ctx context.Context) (io.ReadSeekCloser, error) {
+					if tt.reopenErr != nil {
+						return nil, tt.reopenErr
+					}
+					// the reopened content fails at the same offsets.
+					c := newFlakyContent(content, tt.err, source.failAt)
+					reopened = append(reopened, c)
+					return c, nil
+				}
+			}
+			r := newRetryReader(context.Background(), source, o.retry, reopen)
+			got, err := io.ReadAll(r)
+			if !errors.Is(err, tt.wantErr) || string(got) != tt.want {
+				t.Fatalf("got %q, %v, want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+			if errors.Is(tt.wantErr, errContentChanged) && !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want the read error too", err)
+			}
+
+			_ = r.Close()
+			if source.closed {
+				t.Fatal("the original content is closed, it's owned by the caller")
+			}
+			for i, c := range reopened {
+				if !c.closed {
+					t.Fatalf("the reopened content %d is not closed", i)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryReaderCanceledBackoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	o := &serveOptions{}
+	WithSourceRetry(RetryPolicy{Backoff: time.Hour})(o)
+	r := newRetryReader(ctx, newFlakyContent([]byte("abc"), errFlaky, map[int64]int{1: 1}), o.retry, nil)
+	got, err := io.ReadAll(r)
+	if string(got) != "a" || !errors.Is(err, errFlaky) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %q, %v, want the read error and the cancellation", got, err)
+	}
+}
+
+func TestServeContentSourceRetry(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	tests := []struct {
+		name  string
+		rng   string
+		start int
+		end   int
+	}{
+		{name: "full", start: 0, end: len(content)},
+		{name: "range", rng: "bytes=4000-7999", start: 4000, end: 8000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var incoming []string
+			if tt.rng != "" {
+				incoming = []string{"grpcgateway-range", tt.rng}
+			}
+			server := newFakeDownloadServer(incoming...)
+			failAt := map[int64]int{int64(tt.start) + 1234: 1, int64(tt.end) - 1: 1}
+			source := newFlakyContent(content, errFlaky, failAt)
+			err := ServeContent(server, source, "text/plain", "a.txt", time.Time{}, int64(len(content)),
+				WithSourceRetry(RetryPolicy{Backoff: time.Millisecond}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(server.body.Bytes(), content[tt.start:tt.end]) {
+				t.Fatalf("got %d bytes, want bytes %d-%d", server.body.Len(), tt.start, tt.end-1)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package gatewayfile
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// headerScanStatus is "clean" if all the scanned files of an upload are clean, "rejected" otherwise.
+	headerScanStatus = "x-scan-status"
+	// headerScanRejected describes a rejected file, e.g. `"eicar.com"; field="file"; threat="Eicar-Test-Signature"`.
+	headerScanRejected = "x-scan-rejected"
+)
+
+// ScanReport is the verdict of the Scanner on an uploaded file.
+type ScanReport struct {
+	File   ScanFile
+	Result ScanResult
+}
+
+// ScanSummary collects the ScanReports of the files of an upload, see WithScanSummary.
+type ScanSummary struct {
+	Reports []ScanReport // Reports are in upload order.
+}
+
+// WithScanSummary collects the verdicts of the Scanner into summary while the form is parsed,
+// so the handler can report them in its response message, or in the response headers with SetScanHeaders.
+// The upload fails on the first rejected file, the files after it are not scanned.
+func WithScanSummary(summary *ScanSummary) FormDataOption {
+	return func(o *formDataOptions) {
+		o.scanSummary = summary
+	}
+}
+
+// Clean reports whether all the scanned files are clean.
+func (s *ScanSummary) Clean() bool {
+	return len(s.Rejected()) == 0
+}
+
+// Rejected returns the reports of the files which are not clean.
+func (s *ScanSummary) Rejected() []ScanReport {
+	var rejected []ScanReport
+	for _, report := range s.Reports {
+		if !report.Result.Clean {
+			rejected = append(rejected, report)
+		}
+	}
+	return rejected
+}
+
+// SetScanHeaders sets the response headers of the upload RPC from summary: X-Scan-Status is "clean" or
+// "rejected", and each rejected file is described by an X-Scan-Rejected header, e.g.
+// `"eicar.com"; field="file"; threat="Eicar-Test-Signature"`. Like SetResponseStatus,
+// it must be called before the handler returns. Nothing is set if no file was scanned.
+func SetScanHeaders(ctx context.Context, summary *ScanSummary) error {
+	if len(summary.Reports) == 0 {
+		return nil
+	}
+	rejected := summary.Rejected()
+	md := metadata.Pairs(headerScanStatus, "clean")
+	if len(rejected) > 0 {
+		md.Set(headerScanStatus, "rejected")
+	}
+	for _, report := range rejected {
+		md.Append(headerScanRejected, report.header())
+	}
+	return grpc.SetHeader(ctx, md)
+}
+
+func (r ScanReport) header() string {
+	return fmt.Sprintf(
+		"%s; field=%s; threat=%s",
+		strconv.QuoteToASCII(r.File.Filename), strconv.QuoteToASCII(r.File.Field), strconv.QuoteToASCII(r.Result.Threat),
+	)
+}
+
+// ScanRejectionError is the error of an upload rejected by the Scanner, it wraps ErrFileRejected.
+// Its gRPC status has a BadRequest detail with the field and the threat of the file,
+// so error responses tell the client which file was rejected and why, e.g. as the invalid-params of
+// ProblemErrorHandler.
+type ScanRejectionError struct {
+	Report ScanReport
+}
+
+func (e *ScanRejectionError) Error() string {
+	return fmt.Sprintf("%s: file %s: %s", ErrFileRejected, e.Report.File.Filename, e.Report.Result.Threat)
+}
+
+// Is reports whether target is ErrFileRejected.
+func (e *ScanRejectionError) Is(target error) bool {
+	return target == ErrFileRejected
+}
+
+// GRPCStatus returns the status of ErrFileRejected, with the filename and the threat in the ErrorInfo metadata,
+// and a BadRequest detail.
+func (e *ScanRejectionError) GRPCStatus() *status.Status {
+	st := status.New(ErrFileRejected.Code, e.Error())
+	detailed, err := st.WithDetails(
+		&errdetails.ErrorInfo{
+			Reason:   ErrFileRejected.Reason,
+			Domain:   errorDomain,
+			Metadata: map[string]string{"filename": e.Report.File.Filename, "threat": e.Report.Result.Threat},
+		},
+		&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{{
+			Field:       e.Report.File.Field,
+			Reason:      ErrFileRejected.Reason,
+			Description: fmt.Sprintf("file %s: %s", e.Report.File.Filename, e.Report.Result.Threat),
+		}}},
+	)
+	if err != nil {
+		return st
+	}
+	return detailed
+}
@@ -0,0 +1,83 @@
+package gatewayfile
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"google.golang.org/grpc/codes"
+)
+
+// ErrFileRejected is returned when a Scanner rejects an uploaded file, it's answered with 422 Unprocessable Entity.
+var ErrFileRejected = newError(codes.InvalidArgument, "FILE_REJECTED", "file rejected")
+
+// Scanner scans the uploaded files before they're accepted, e.g. an antivirus or a DLP appliance.
+// The scanner/icap package implements it with an ICAP server.
+type Scanner interface {
+	// Scan reads the content of file, and reports whether it's clean.
+	// It may return before reading the whole content, the rest is discarded.
+	Scan(ctx context.Context, file ScanFile, content io.Reader) (ScanResult, error)
+}
+
+// ScanFile describes the file given to a Scanner.
+type ScanFile struct {
+	Field       string // Field is the form field of the file.
+	Filename    string
+	ContentType string
+}
+
+// ScanResult is the verdict of a Scanner.
+type ScanResult struct {
+	Clean  bool
+	Threat string // Threat names what was found when the file is not clean, e.g. "Eicar-Test-Signature".
+}
+
+// WithScanner streams each uploaded file through scanner while the form is parsed, and fails the upload with
+// ErrFileRejected if a file is not clean. The file is scanned while it's read, so the upload isn't delayed
+// by a second pass over the file, but the verdict is only known once the file was read whole.
+func WithScanner(scanner Scanner) FormDataOption {
+	return func(o *formDataOptions) {
+		o.scanner = scanner
+	}
+}
+
+// startScan returns a reader of r which streams what is read to the scanner of the options,
+// and the function waiting for its verdict once r was read. err is the result of reading r, returned as-is if
+// it's not nil. Without scanner, r is returned and the verdict is always clean.
+func (o *formDataOptions) startScan(
+	ctx context.Context, file ScanFile, r io.Reader,
+) (io.Reader, func(err error) error) {
+	if o.scanner == nil {
+		return r, func(err error) error { return err }
+	}
+	pReader, pWriter := io.Pipe()
+	type verdict struct {
+		result ScanResult
+		err    error
+	}
+	done := make(chan verdict, 1)
+	go func() {
+		result, err := o.scanner.Scan(ctx, file, pReader)
+		// the scanner may return early, drain the pipe so reading r doesn't block.
+		_, _ = io.Copy(io.Discard, pReader)
+		done <- verdict{result: result, err: err}
+	}()
+	return io.TeeReader(r, pWriter), func(err error) error {
+		_ = pWriter.CloseWithError(err)
+		v := <-done
+		switch {
+		case err != nil:
+			return err
+		case v.err != nil:
+			return fmt.Errorf("scan file %s failed %w", file.Filename, v.err)
+		}
+		report := ScanReport{File: file, Result: v.result}
+		if o.scanSummary != nil {
+			o.scanSummary.Reports = append(o.scanSummary.Reports, report)
+		}
+		if !v.result.Clean {
+			return &ScanRejectionError{Report: report}
+		}
+		return nil
+	}
+}
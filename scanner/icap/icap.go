@@ -0,0 +1,197 @@
+// Package icap implements gatewayfile.Scanner with an ICAP server (RFC 3507), so uploads are streamed through
+// enterprise antivirus or DLP appliances before being accepted:
+//
+//	scanner, err := icap.New("icap://icap.example.com:1344/avscan")
+//	if err != nil {
+//		return err
+//	}
+//	form, err := gatewayfile.NewFormData(server, sizeLimit, gatewayfile.WithScanner(scanner))
+//
+// Each file is sent in a RESPMOD (or REQMOD) request with a chunked body, on its own connection.
+// A 204 No Content answer means the file is clean, a 200 OK answer means the server blocked or modified it.
+package icap
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httputil"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	gatewayfile "github.com/black-06/grpc-gateway-file"
+)
+
+// Methods of the ICAP requests.
+const (
+	RESPMOD = "RESPMOD" // RESPMOD sends the file as the body of an HTTP response, e.g. a download.
+	REQMOD  = "REQMOD"  // REQMOD sends the file as the body of an HTTP request, e.g. an upload.
+)
+
+// defaultPort is the well-known ICAP port.
+const defaultPort = "1344"
+
+// threatHeaders are the ICAP response headers naming the threat found, by order of preference.
+var threatHeaders = []string{"X-Infection-Found", "X-Violations-Found", "X-Virus-ID", "X-Blocked-Reason"}
+
+// Scanner is a gatewayfile.Scanner backed by an ICAP server.
+type Scanner struct {
+	// URL is the ICAP service, e.g. icap://icap.example.com:1344/avscan.
+	URL *url.URL
+	// Method is RESPMOD or REQMOD, defaults to RESPMOD.
+	Method string
+	// Timeout is the maximum duration of a scan (0 = no timeout), on top of the deadline of the context.
+	Timeout time.Duration
+	// Dial opens the connections to the server, defaults to net.Dialer.DialContext.
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+var _ gatewayfile.Scanner = (*Scanner)(nil)
+
+// New returns a new Scanner of the ICAP service rawURL, with the RESPMOD method.
+func New(rawURL string) (*Scanner, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "icap" || u.Host == "" {
+		return nil, fmt.Errorf("invalid ICAP URL %s", rawURL)
+	}
+	return &Scanner{URL: u, Method: RESPMOD}, nil
+}
+
+// Scan sends content to the ICAP server, and reports whether the server let it through unmodified.
+func (s *Scanner) Scan(
+	ctx context.Context, file gatewayfile.ScanFile, content io.Reader,
+) (gatewayfile.ScanResult, error) {
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+	address := s.URL.Host
+	if s.URL.Port() == "" {
+		address = net.JoinHostPort(s.URL.Hostname(), defaultPort)
+	}
+	dial := s.Dial
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	conn, err := dial(ctx, "tcp", address)
+	if err != nil {
+		return gatewayfile.ScanResult{}, err
+	}
+	defer func() { _ = conn.Close() }()
+	// unblocks reads and writes when ctx is done.
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+
+	if err = s.writeRequest(conn, file, content); err != nil {
+		return gatewayfile.ScanResult{}, ctxErr(ctx, err)
+	}
+	result, err := readResponse(bufio.NewReader(conn))
+	return result, ctxErr(ctx, err)
+}
+
+// writeRequest writes the ICAP request, the file is encapsulated in an HTTP message with a chunked body.
+func (s *Scanner) writeRequest(w io.Writer, file gatewayfile.ScanFile, content io.Reader) error {
+	method := s.Method
+	if method == "" {
+		method = RESPMOD
+	}
+	contentType := file.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	var encapsulated, section string
+	name := "/" + url.PathEscape(path.Base("/"+file.Filename))
+	if method == REQMOD {
+		encapsulated = fmt.Sprintf("POST %s HTTP/1.1\r\nHost: %s\r\nContent-Type: %s\r\n\r\n",
+			name, s.URL.Hostname(), contentType)
+		section = fmt.Sprintf("req-hdr=0, req-body=%d", len(encapsulated))
+	} else {
+		request := fmt.Sprintf("GET %s HTTP/1.1\r\nHost: %s\r\n\r\n", name, s.URL.Hostname())
+		response := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: %s\r\nTransfer-Encoding: chunked\r\n\r\n", contentType)
+		encapsulated = request + response
+		section = fmt.Sprintf("req-hdr=0, res-hdr=%d, res-body=%d", len(request), len(encapsulated))
+	}
+
+	bw := bufio.NewWriter(w)
+	_, _ = fmt.Fprintf(bw, "%s %s ICAP/1.0\r\n", method, s.URL.String())
+	_, _ = fmt.Fprintf(bw, "Host: %s\r\n", s.URL.Host)
+	_, _ = fmt.Fprintf(bw, "Allow: 204\r\n")
+	_, _ = fmt.Fprintf(bw, "Connection: close\r\n")
+	_, _ = fmt.Fprintf(bw, "Encapsulated: %s\r\n\r\n", section)
+	_, _ = bw.WriteString(encapsulated)
+
+	chunked := httputil.NewChunkedWriter(bw)
+	if _, err := io.Copy(chunked, content); err != nil {
+		return err
+	}
+	if err := chunked.Close(); err != nil {
+		return err
+	}
+	// the empty trailer ending the chunked body.
+	_, _ = bw.WriteString("\r\n")
+	return bw.Flush()
+}
+
+// readResponse reads the status and the headers of the ICAP response, the encapsulated message is ignored.
+func readResponse(r *bufio.Reader) (gatewayfile.ScanResult, error) {
+	tp := textproto.NewReader(r)
+	line, err := tp.ReadLine()
+	if err != nil {
+		return gatewayfile.ScanResult{}, err
+	}
+	proto, rest, _ := strings.Cut(line, " ")
+	codeStr, reason, _ := strings.Cut(rest, " ")
+	code, err := strconv.Atoi(codeStr)
+	if !strings.HasPrefix(proto, "ICAP/") || err != nil {
+		return gatewayfile.ScanResult{}, fmt.Errorf("malformed ICAP status line %q", line)
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return gatewayfile.ScanResult{}, err
+	}
+
+	switch code {
+	case 204:
+		return gatewayfile.ScanResult{Clean: true}, nil
+	case 200:
+		result := gatewayfile.ScanResult{Threat: "modified by ICAP server"}
+		for _, key := range threatHeaders {
+			if v := header.Get(key); v != "" {
+				result.Threat = threat(v)
+				break
+			}
+		}
+		return result, nil
+	default:
+		return gatewayfile.ScanResult{}, fmt.Errorf("ICAP server answered %d %s", code, reason)
+	}
+}
+
+// threat returns the threat name of a threat header, e.g. "Type=0; Resolution=2; Threat=EICAR;" is "EICAR".
+func threat(value string) string {
+	for _, param := range strings.Split(value, ";") {
+		if name, v, ok := strings.Cut(strings.TrimSpace(param), "="); ok && strings.EqualFold(name, "Threat") {
+			return v
+		}
+	}
+	return strings.TrimSpace(value)
+}
+
+// ctxErr returns the error of ctx if it's done, since it's why the connection failed.
+func ctxErr(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
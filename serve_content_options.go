@@ -0,0 +1,62 @@
+package gatewayfile
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// ServeContentOptions describe the content served by ServeContentWithOptions, and how it's served.
+type ServeContentOptions struct {
+	ContentType string    // ContentType, detected from Name or the content if empty.
+	Name        string    // Name is used in Content-Disposition and to guess the content type, may be empty.
+	ModTime     time.Time // ModTime is used as Last-Modified, may be zero.
+	Size        int64     // Size of the content in bytes, measured by seeking the content if negative.
+
+	// Inline serves the content with an inline Content-Disposition instead of an attachment, see WithInline.
+	Inline bool
+	// CacheControl is emitted as Cache-Control, e.g. "private, max-age=3600". See also WithCacheProfile.
+	CacheControl string
+	// Expires is emitted as Expires, if not zero.
+	Expires time.Time
+	// Header are extra headers of the response, see WithRepresentationHeader.
+	// They should be exposed to cross-domain requests too, see CORSConfig.ExposedHeaders.
+	Header map[string]string
+	// Options are applied after the fields above, so they take precedence over them.
+	Options []ServeOption
+}
+
+// ServeContentWithOptions serves content like ServeContent, described by opts rather than positional arguments.
+func ServeContentWithOptions(server downloadServer, content io.ReadSeeker, opts ServeContentOptions) error {
+	size := opts.Size
+	if size < 0 {
+		var err error
+		if size, err = content.Seek(0, io.SeekEnd); err != nil {
+			return err
+		}
+		if _, err = content.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	return ServeContent(
+		server, content, opts.ContentType, opts.Name, opts.ModTime, size,
+		append(opts.serveOptions(), opts.Options...)...,
+	)
+}
+
+func (opts ServeContentOptions) serveOptions() []ServeOption {
+	var serveOpts []ServeOption
+	if opts.Inline {
+		serveOpts = append(serveOpts, WithInline())
+	}
+	if opts.CacheControl != "" {
+		serveOpts = append(serveOpts, withHeader(headerCacheControl, opts.CacheControl))
+	}
+	if !opts.Expires.IsZero() {
+		serveOpts = append(serveOpts, withHeader(headerExpires, opts.Expires.UTC().Format(http.TimeFormat)))
+	}
+	for _, key := range sortedKeys(opts.Header) {
+		serveOpts = append(serveOpts, WithRepresentationHeader(key, opts.Header[key]))
+	}
+	return serveOpts
+}
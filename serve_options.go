@@ -0,0 +1,241 @@
+package gatewayfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// ServeOption configures ServeFile and ServeContent.
+type ServeOption func(*serveOptions)
+
+type serveOptions struct {
+	ctx context.Context // context of the download stream
+
+	strictRange  bool
+	maxRanges    int
+	etag         string
+	autoETag     string // autoETag is used when etag is empty, unless noAutoETag.
+	noAutoETag   bool
+	etagStrategy ETagStrategy
+	strongResume bool
+	cacheProfile *CacheProfile
+	compression  *CompressionConfig
+	header       metadata.MD
+	directIO     bool
+	checksums    Digests
+	concurrency  Semaphore
+	stall        stallPolicy
+
+	explicitContentType bool
+	inline              bool
+	statusCode          int // statusCode replaces 200 for full responses, see WithStatusCode.
+	rangeNotSatisfiable RangeNotSatisfiableFunc
+	flush               FlushPolicy
+	chunkSize           int64
+	blockSize           int64
+	sidecarChecksums    bool
+	reprDigests         Digests
+
+	onProviderInfo func(info ContentInfo)
+	preSend        []PreSendFunc
+	progress       []ProgressFunc
+	retry          *RetryPolicy
+	reopen         func(ctx context.Context) (io.ReadSeekCloser, error)
+	rangeFallback  RangeFallback
+	currentSize    func(ctx context.Context) (size int64, changed bool, err error)
+	readerAt       io.ReaderAt // readerAt reads the parts of multi-range responses, see ServeReaderAt.
+
+	// wrapContents wrap the content, the first one is the innermost.
+	wrapContents []func(content io.ReadSeeker) io.ReadSeeker
+	// wrapWriters wrap the writer of the response body, the first one is the outermost.
+	wrapWriters []func(w io.Writer) io.Writer
+	// onRanges is called with the ranges served, none for a full response.
+	onRanges []func(ranges []httpRange, size int64)
+	// onDone is called after the response body was sent, err is the result of sending it.
+	onDone []func(server downloadServer, err error)
+	// onFinish is called when serving returns, whatever the outcome.
+	onFinish []func(ctx context.Context, record TransferRecord)
+}
+
+func newServeOptions(ctx context.Context, opts []ServeOption) *serveOptions {
+	o := &serveOptions{ctx: ctx}
+	inherited, _ := ctx.Value(serveOptionsKey{}).([]ServeOption) // see Options
+	for _, opt := range inherited {
+		opt(o)
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// wrapWriter returns w wrapped by the writer wrappers of the options.
+func (o *serveOptions) wrapWriter(w io.Writer) io.Writer {
+	for i := len(o.wrapWriters) - 1; i >= 0; i-- {
+		w = o.wrapWriters[i](w)
+	}
+	return w
+}
+
+// serve calls f, and reports the outcome to the onFinish callbacks. object names what is served.
+func (o *serveOptions) serve(server downloadServer, object string, f func(server downloadServer) error) error {
+	serve := func(server downloadServer) error {
+		release, err := o.acquire(server)
+		if release == nil {
+			return err
+		}
+		defer release()
+		return f(server)
+	}
+	// the recorder also tells done how much of the body was sent, see sendStreamError.
+	recorder := newTransferRecorder(o.withProgress(o.stall.guard(server)))
+	err := serve(recorder)
+	if len(o.onFinish) == 0 {
+		return err
+	}
+	record := recorder.record(TransferDownload, object, err)
+	for _, finish := range o.onFinish {
+		finish(server.Context(), record)
+	}
+	return err
+}
+
+// done calls the onDone callbacks and returns err.
+// If sending the body failed after the header was sent, while the client is still connected,
+// the failure is reported by trailers, see headerStreamError.
+func (o *serveOptions) done(server downloadServer, err error) error {
+	for _, f := range o.onDone {
+		f(server, err)
+	}
+	if err == nil || server.Context().Err() != nil || errors.Is(err, ErrClientStalled) {
+		// a stalled client wouldn't read the error either.
+		return err
+	}
+	if recorder, ok := server.(*transferRecorder); ok && recorder.status != 0 {
+		sendStreamError(server, recorder.bytes.Load(), err)
+	}
+	return err
+}
+
+// WithStrictRange enables the strict range validation mode.
+// Instead of silently serving them, it rejects requests whose ranges are malformed (400),
+// overlapping or descending, more than maxRanges (0 = unlimited), or larger than the content in total (416).
+// It's useful to shut down range-abuse probing.
+func WithStrictRange(maxRanges int) ServeOption {
+	return func(o *serveOptions) {
+		o.strictRange = true
+		o.maxRanges = maxRanges
+	}
+}
+
+// withHeader sets an outgoing header of the response.
+func withHeader(key, value string) ServeOption {
+	return func(o *serveOptions) {
+		if o.header == nil {
+			o.header = make(metadata.MD)
+		}
+		o.header.Set(key, value)
+	}
+}
+
+// WithETag sets the ETag of the content, it's emitted in the response and used to evaluate preconditions.
+// etag is quoted if it's not a valid entity-tag already, e.g. "abc" becomes `"abc"`.
+func WithETag(etag string) ServeOption {
+	return func(o *serveOptions) {
+		if valid, _ := scanETag(etag); valid == "" && etag != "" {
+			etag = strconv.Quote(etag)
+		}
+		o.etag = etag
+	}
+}
+
+// withAutoETag derives a weak ETag from the size and the modification time of the content,
+// it's used by the provider-based entrypoints when no ETag is given, see WithoutAutoETag.
+func withAutoETag(size int64, modTime time.Time) ServeOption {
+	return func(o *serveOptions) {
+		if !isZeroTime(modTime) {
+			o.autoETag = fmt.Sprintf(`W/"%x-%x"`, size, modTime.UnixNano())
+		}
+	}
+}
+
+// WithoutAutoETag disables the weak ETag derived from the size and the modification time of files and
+// provider contents without ETag. Weak ETags validate If-None-Match, but never match If-Range,
+// so resuming clients which send the ETag as If-Range get the full content.
+func WithoutAutoETag() ServeOption {
+	return func(o *serveOptions) {
+		o.noAutoETag = true
+	}
+}
+
+// WithStrongResume requires a strong ETag match through If-Range before honoring a Range request.
+// Otherwise, the full content is served, even if Last-Modified matches.
+// It protects clients from resuming against silently rotated content, it should be used with WithETag.
+func WithStrongResume() ServeOption {
+	return func(o *serveOptions) {
+		o.strongResume = true
+	}
+}
+
+// WithExplicitContentType trusts the given content type and never guesses it:
+// the name extension is not looked up, and the content is not sniffed, which costs an extra read and a seek,
+// and breaks sources which can't seek. application/octet-stream is emitted when the content type is empty.
+func WithExplicitContentType() ServeOption {
+	return func(o *serveOptions) {
+		o.explicitContentType = true
+	}
+}
+
+// WithInline serves the content with an inline Content-Disposition, so browsers and players display it
+// instead of downloading it as an attachment.
+func WithInline() ServeOption {
+	return func(o *serveOptions) {
+		o.inline = true
+	}
+}
+
+// WithStatusCode answers full responses with the given 2xx code instead of 200,
+// e.g. 203 Non-Authoritative Information for a transformed copy. Partial, not modified and error responses
+// keep their code. Invalid codes are ignored.
+func WithStatusCode(code int) ServeOption {
+	return func(o *serveOptions) {
+		if isSuccessCode(code) {
+			o.statusCode = code
+		}
+	}
+}
+
+// okCode returns the code of full responses, see WithStatusCode.
+func (o *serveOptions) okCode() int {
+	if o.statusCode != 0 {
+		return o.statusCode
+	}
+	return http.StatusOK
+}
+
+// WithContextPolicy applies the options returned by policy for the context of the download stream,
+// so per-request data already in the context, e.g. the authenticated user or its plan tier,
+// selects the chunk size, the rate limit or the cache headers of each download:
+//
+//	gatewayfile.WithContextPolicy(func(ctx context.Context) []gatewayfile.ServeOption {
+//		if tier(ctx) == "free" {
+//			return []gatewayfile.ServeOption{gatewayfile.WithRateLimit(freeLimiter)}
+//		}
+//		return nil
+//	})
+//
+// The options are applied in place of WithContextPolicy, the options after it take precedence over them.
+func WithContextPolicy(policy func(ctx context.Context) []ServeOption) ServeOption {
+	return func(o *serveOptions) {
+		for _, opt := range policy(o.ctx) {
+			opt(o)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package gatewayfile
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// ServeReader streams r until EOF, for contents produced on the fly whose size isn't known up front and which
+// can't seek, e.g. a database export. Ranges and preconditions are not supported: Range headers are ignored,
+// Accept-Ranges is "none" and the response has no Content-Length, it's chunked on HTTP/1.1.
+// The headers are flushed as soon as they're sent, so the client doesn't wait for the first bytes to see them.
+// If contentType is empty, it's guessed from the extension of name, or sniffed from the first bytes of r.
+// If r is an io.Closer, it's closed as soon as the client disconnects, to unblock a pending read.
+// A failure of r after the header was sent is reported as a mid-stream failure, see WithFileForwardResponseOption.
+func ServeReader(server downloadServer, r io.Reader, contentType, name string, opts ...ServeOption) error {
+	o := newServeOptions(server.Context(), opts)
+	if closer, ok := r.(io.Closer); ok {
+		closer = closeOnDone(server.Context(), closer)
+		defer func() { _ = closer.Close() }()
+	}
+	return o.serve(server, name, func(server downloadServer) error {
+		return serveReader(server, r, contentType, name, o)
+	})
+}
+
+func serveReader(server downloadServer, r io.Reader, contentType, name string, o *serveOptions) error {
+	info := DownloadInfo{Name: name, ContentType: contentType, Size: -1}
+	if err := o.checkPreSend(server.Context(), info); err != nil {
+		return err
+	}
+
+	outgoing := make(metadata.MD)
+	for key, values := range o.header {
+		outgoing.Set(key, values...)
+	}
+	if contentType == "" && o.explicitContentType {
+		contentType = MIMEOctetStream
+	}
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(name))
+	}
+	if contentType == "" {
+		buffered := bufio.NewReaderSize(r, 512)
+		// a short or failed peek sniffs what was read, the error is returned by the copy.
+		head, _ := buffered.Peek(512)
+		contentType = http.DetectContentType(head)
+		r = buffered
+	}
+	setContentType(outgoing, contentType)
+	if name != "" {
+		disposition := "attachment"
+		if o.inline {
+			disposition = "inline"
+		}
+		outgoing.Set(headerContentDisposition, contentDisposition(disposition, name))
+	}
+	outgoing.Set(headerAcceptRanges, "none")
+	outgoing.Set(headerFlushHeaders, "true")
+	outgoing.Set(headerCode, strconv.Itoa(o.okCode()))
+	if err := server.SendHeader(outgoing); err != nil {
+		return err
+	}
+	_, err := io.Copy(o.newWriter(server, contentType), r)
+	return o.done(server, err)
+}
@@ -1,6 +1,10 @@
 package gatewayfile
 
 import (
+	"errors"
+	"io"
+	"sync"
+
 	"google.golang.org/genproto/googleapis/api/httpbody"
 	"google.golang.org/grpc"
 )
@@ -18,7 +22,11 @@ func newUploadServerReader(server uploadServer, sizeLimit int64) *uploadServerRe
 }
 
 func newDownloadServerWriter(server downloadServer, contentType string) *downloadServerWriter {
-	return &downloadServerWriter{server: server, contentType: contentType, size: defaultBufSize}
+	return &downloadServerWriter{
+		server: server,
+		size:   defaultBufSize,
+		body:   httpbody.HttpBody{ContentType: contentType},
+	}
 }
 
 // uploadServer is a client-stream server, see grpc.ClientStreamingServer
@@ -27,37 +35,86 @@ type uploadServer interface {
 	Recv() (*httpbody.HttpBody, error)
 }
 
+// uploadServerReader reads the data of the messages of an uploadServer.
+// It reads straight from the received messages, there is no intermediate buffer.
 type uploadServerReader struct {
 	server uploadServer
-	buf    []byte
+	buf    []byte // unread data of the last received message
+	err    error  // sticky error of Recv or the size limit
 
-	sizeCurrent int64 // current size of the data in bytes
+	sizeCurrent int64 // size of the data consumed so far in bytes
 	sizeLimit   int64 // maximum size of the data in bytes (0 - unlimited)
 }
 
-func (reader *uploadServerReader) Read(dst []byte) (int, error) {
-	src := reader.buf
-	if len(reader.buf) == 0 {
+// fill receives messages until there is unread data, and returns the remaining size allowed by the limit.
+func (reader *uploadServerReader) fill() (int64, error) {
+	for len(reader.buf) == 0 {
+		if reader.err != nil {
+			return 0, reader.err
+		}
 		body, err := reader.server.Recv()
 		if err != nil {
+			reader.err = err
 			return 0, err
 		}
-		src = body.Data
+		reader.buf = body.GetData()
+	}
+	if reader.sizeLimit <= 0 {
+		return int64(len(reader.buf)), nil
 	}
-	rn := len(src)
-	if len(src) > len(dst) {
-		rn = len(dst)
+	remaining := reader.sizeLimit - reader.sizeCurrent
+	if remaining <= 0 {
+		// there is more data than allowed, exactly sizeLimit bytes are fine.
+		reader.err = ErrSizeLimitExceeded
+		return 0, reader.err
 	}
+	return remaining, nil
+}
 
-	if reader.sizeLimit > 0 {
-		if reader.sizeCurrent+int64(rn) > reader.sizeLimit {
-			return 0, ErrSizeLimitExceeded
-		}
-		reader.sizeCurrent += int64(rn)
+// consume marks the n first bytes of buf as read.
+func (reader *uploadServerReader) consume(n int) {
+	reader.buf = reader.buf[n:]
+	reader.sizeCurrent += int64(n)
+}
+
+func (reader *uploadServerReader) Read(dst []byte) (int, error) {
+	remaining, err := reader.fill()
+	if err != nil {
+		return 0, err
+	}
+	if int64(len(dst)) > remaining {
+		dst = dst[:remaining]
 	}
+	n := copy(dst, reader.buf)
+	reader.consume(n)
+	return n, nil
+}
 
-	reader.buf = src[rn:]
-	return copy(dst, src), nil
+// WriteTo writes the data of the messages to w as they're received, without copying them, see io.WriterTo.
+func (reader *uploadServerReader) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	for {
+		remaining, err := reader.fill()
+		if errors.Is(err, io.EOF) {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+		data := reader.buf
+		if int64(len(data)) > remaining {
+			data = data[:remaining]
+		}
+		n, err := w.Write(data)
+		reader.consume(n)
+		written += int64(n)
+		if err == nil && n < len(data) {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			return written, err
+		}
+	}
 }
 
 // downloadServer is a server-stream server, see grpc.ServerStreamingServer
@@ -67,9 +124,21 @@ type downloadServer interface {
 }
 
 type downloadServerWriter struct {
-	contentType string
-	server      downloadServer
-	size        int
+	server   downloadServer
+	size     int
+	eachRead bool // eachRead sends what each read returns, instead of full chunks, see FlushPolicy.
+
+	// body is reused by every Send. gRPC encodes the message before Send returns,
+	// so neither the message nor the data it points to are retained afterward.
+	body httpbody.HttpBody
+}
+
+// chunkPool pools the chunks of downloadServerWriter.ReadFrom.
+var chunkPool = sync.Pool{
+	New: func() any {
+		chunk := make([]byte, defaultBufSize)
+		return &chunk
+	},
 }
 
 func (writer *downloadServerWriter) Write(data []byte) (int, error) {
@@ -79,11 +148,7 @@ func (writer *downloadServerWriter) Write(data []byte) (int, error) {
 		if wn >= writer.size {
 			wn = writer.size
 		}
-		err := writer.server.Send(&httpbody.HttpBody{
-			ContentType: writer.contentType,
-			Data:        data[:wn],
-		})
-		if err != nil {
+		if err := writer.send(data[:wn]); err != nil {
 			return n, err
 		}
 		data = data[wn:]
@@ -91,3 +156,59 @@ func (writer *downloadServerWriter) Write(data []byte) (int, error) {
 	}
 	return n, nil
 }
+
+// ReadFrom reads r into a pooled chunk and sends it, chunk by chunk.
+// Unlike io.Copy, it sends full chunks and doesn't allocate per call: the chunk is handed to Send,
+// and recycled once Send returned, since gRPC is done with it by then.
+func (writer *downloadServerWriter) ReadFrom(r io.Reader) (int64, error) {
+	chunkPtr := chunkPool.Get().(*[]byte)
+	defer chunkPool.Put(chunkPtr)
+	chunk := *chunkPtr
+	if writer.size < len(chunk) {
+		chunk = chunk[:writer.size]
+	}
+
+	var n int64
+	for {
+		var (
+			rn  int
+			err error
+		)
+		if writer.eachRead {
+			rn, err = r.Read(chunk)
+		} else {
+			rn, err = fill(r, chunk)
+		}
+		if rn > 0 {
+			if sendErr := writer.send(chunk[:rn]); sendErr != nil {
+				return n, sendErr
+			}
+			n += int64(rn)
+		}
+		if errors.Is(err, io.EOF) {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// fill reads r into chunk until it's full. Unlike io.ReadFull, it returns the error of r as is, so a partial
+// chunk ending with io.EOF is the end of r, while an io.ErrUnexpectedEOF of r, e.g. a truncated gzip stream,
+// is an error.
+func fill(r io.Reader, chunk []byte) (n int, err error) {
+	for n < len(chunk) && err == nil {
+		var rn int
+		rn, err = r.Read(chunk[n:])
+		n += rn
+	}
+	return n, err
+}
+
+func (writer *downloadServerWriter) send(data []byte) error {
+	writer.body.Data = data
+	err := writer.server.Send(&writer.body)
+	writer.body.Data = nil
+	return err
+}
@@ -0,0 +1,281 @@
+package gatewayfile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"google.golang.org/grpc"
+)
+
+// fakeUploadServer receives messages of the given data, then err or io.EOF.
+type fakeUploadServer struct {
+	grpc.ServerStream
+	ctx      context.Context // ctx carries the incoming metadata, if not nil.
+	messages []*httpbody.HttpBody
+	err      error
+	recv     int // recv is the number of calls to Recv.
+}
+
+func newFakeUploadServer(data ...[]byte) *fakeUploadServer {
+	server := &fakeUploadServer{}
+	for _, d := range data {
+		server.messages = append(server.messages, &httpbody.HttpBody{Data: d})
+	}
+	return server
+}
+
+func (s *fakeUploadServer) Context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+func (s *fakeUploadServer) Recv() (*httpbody.HttpBody, error) {
+	s.recv++
+	if len(s.messages) == 0 {
+		if s.err != nil {
+			return nil, s.err
+		}
+		return nil, io.EOF
+	}
+	message := s.messages[0]
+	s.messages = s.messages[1:]
+	return message, nil
+}
+
+// split splits data into messages of size bytes.
+func split(data []byte, size int) [][]byte {
+	var messages [][]byte
+	for len(data) > size {
+		messages = append(messages, data[:size])
+		data = data[size:]
+	}
+	return append(messages, data)
+}
+
+func TestUploadServerReaderLimit(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789"), 10)
+	tests := []struct {
+		name      string
+		sizeLimit int64
+		wantN     int
+		wantErr   error
+	}{
+		{name: "unlimited", sizeLimit: 0, wantN: 100},
+		{name: "under", sizeLimit: 101, wantN: 100},
+		{name: "exact", sizeLimit: 100, wantN: 100},
+		{name: "over by one", sizeLimit: 99, wantN: 99, wantErr: ErrSizeLimitExceeded},
+		{name: "over by many", sizeLimit: 7, wantN: 7, wantErr: ErrSizeLimitExceeded},
+	}
+	reads := []struct {
+		name string
+		read func(r *uploadServerReader) ([]byte, error)
+	}{
+		{name: "Read", read: func(r *uploadServerReader) ([]byte, error) {
+			// OneByteReader hides WriteTo, and reads across the messages byte by byte.
+			return io.ReadAll(iotest.OneByteReader(r))
+		}},
+		{name: "WriteTo", read: func(r *uploadServerReader) ([]byte, error) {
+			var buf bytes.Buffer
+			_, err := r.WriteTo(&buf)
+			return buf.Bytes(), err
+		}},
+	}
+	for _, tt := range tests {
+		for _, read := range reads {
+			t.Run(tt.name+"/"+read.name, func(t *testing.T) {
+				reader := newUploadServerReader(newFakeUploadServer(split(body, 30)...), tt.sizeLimit)
+				got, err := read.read(reader)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+				if !bytes.Equal(got, body[:tt.wantN]) {
+					t.Fatalf("got %d bytes %q, want %d bytes", len(got), got, tt.wantN)
+				}
+			})
+		}
+	}
+}
+
+func TestUploadServerReaderStickyError(t *testing.T) {
+	recvErr := errors.New("stream broken")
+	server := newFakeUploadServer([]byte("abc"))
+	server.err = recvErr
+	reader := newUploadServerReader(server, 0)
+
+	buf := make([]byte, 10)
+	if n, err := reader.Read(buf); n != 3 || err != nil {
+		t.Fatalf("got %d, %v, want 3, nil", n, err)
+	}
+	for i := 0; i < 2; i++ {
+		if n, err := reader.Read(buf); n != 0 || !errors.Is(err, recvErr) {
+			t.Fatalf("got %d, %v, want 0, %v", n, err, recvErr)
+		}
+	}
+	if _, err := reader.WriteTo(io.Discard); !errors.Is(err, recvErr) {
+		t.Fatalf("got %v, want %v", err, recvErr)
+	}
+	if server.recv != 2 {
+		t.Fatalf("Recv called %d times, want 2", server.recv)
+	}
+
+	// the size limit is sticky too, even once more data is received.
+	reader = newUploadServerReader(newFakeUploadServer([]byte("abc"), []byte("def")), 2)
+	if _, err := io.ReadAll(reader); !errors.Is(err, ErrSizeLimitExceeded) {
+		t.Fatalf("got %v, want %v", err, ErrSizeLimitExceeded)
+	}
+	if n, err := reader.Read(buf); n != 0 || !errors.Is(err, ErrSizeLimitExceeded) {
+		t.Fatalf("got %d, %v, want 0, %v", n, err, ErrSizeLimitExceeded)
+	}
+}
+
+func TestUploadServerReaderSkipsEmptyMessages(t *testing.T) {
+	reader := newUploadServerReader(newFakeUploadServer(nil, []byte("ab"), []byte{}, []byte("c")), 0)
+	buf := make([]byte, 10)
+	for _, want := range []string{"ab", "c"} {
+		n, err := reader.Read(buf)
+		if err != nil || string(buf[:n]) != want {
+			t.Fatalf("got %q, %v, want %q, nil", buf[:n], err, want)
+		}
+	}
+	if n, err := reader.Read(buf); n != 0 || err != io.EOF {
+		t.Fatalf("got %d, %v, want 0, EOF", n, err)
+	}
+}
+
+func TestUploadServerReaderReadThenWriteTo(t *testing.T) {
+	body := []byte("0123456789abcdefghij")
+	tests := []struct {
+		name      string
+		first     int // first is the size of the Read before WriteTo.
+		sizeLimit int64
+		wantErr   error
+	}{
+		{name: "within message", first: 3},
+		{name: "message boundary", first: 8},
+		{name: "across the limit", first: 5, sizeLimit: 12, wantErr: ErrSizeLimitExceeded},
+		{name: "exact limit", first: 5, sizeLimit: 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := newUploadServerReader(newFakeUploadServer(split(body, 8)...), tt.sizeLimit)
+			first := make([]byte, tt.first)
+			if _, err := io.ReadFull(reader, first); err != nil {
+				t.Fatal(err)
+			}
+			var rest bytes.Buffer
+			n, err := reader.WriteTo(&rest)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			want := body
+			if tt.sizeLimit > 0 && int64(len(want)) > tt.sizeLimit {
+				want = want[:tt.sizeLimit]
+			}
+			got := append(first, rest.Bytes()...)
+			if n != int64(rest.Len()) || !bytes.Equal(got, want) {
+				t.Fatalf("got %q (WriteTo %d), want %q", got, n, want)
+			}
+		})
+	}
+}
+
+func TestUploadServerReaderShortWrite(t *testing.T) {
+	reader := newUploadServerReader(newFakeUploadServer([]byte("abcdef")), 0)
+	n, err := reader.WriteTo(shortWriter{})
+	if n != 3 || !errors.Is(err, io.ErrShortWrite) {
+		t.Fatalf("got %d, %v, want 3, %v", n, err, io.ErrShortWrite)
+	}
+	// the bytes which were not written are still readable.
+	rest, err := io.ReadAll(iotest.OneByteReader(reader))
+	if err != nil || string(rest) != "def" {
+		t.Fatalf("got %q, %v, want \"def\", nil", rest, err)
+	}
+}
+
+// shortWriter writes half of each write.
+type shortWriter struct{}
+
+func (shortWriter) Write(p []byte) (int, error) {
+	return len(p) / 2, nil
+}
+
+// baselineUploadServerReader is the reader uploadServerReader replaced, kept to benchmark against it.
+type baselineUploadServerReader struct {
+	server uploadServer
+	buf    []byte
+
+	sizeCurrent int64
+	sizeLimit   int64
+}
+
+func (reader *baselineUploadServerReader) Read(dst []byte) (int, error) {
+	src := reader.buf
+	if len(reader.buf) == 0 {
+		body, err := reader.server.Recv()
+		if err != nil {
+			return 0, err
+		}
+		src = body.Data
+	}
+	rn := len(src)
+	if len(src) > len(dst) {
+		rn = len(dst)
+	}
+	if reader.sizeLimit > 0 {
+		if reader.sizeCurrent+int64(rn) > reader.sizeLimit {
+			return 0, ErrSizeLimitExceeded
+		}
+		reader.sizeCurrent += int64(rn)
+	}
+	reader.buf = src[rn:]
+	return copy(dst, src), nil
+}
+
+// BenchmarkUploadServerReader reads 64 messages of 32 KB, the size of the chunks sent by the gateway.
+// The destination doesn't implement io.ReaderFrom, so io.Copy allocates a buffer unless the reader is an io.WriterTo.
+func BenchmarkUploadServerReader(b *testing.B) {
+	messages := split(bytes.Repeat([]byte{'x'}, 64*32<<10), 32<<10)
+	size := int64(64 * 32 << 10)
+	discard := struct{ io.Writer }{io.Discard}
+	benchmarks := []struct {
+		name string
+		read func(server uploadServer) (int64, error)
+	}{
+		{name: "baseline", read: func(server uploadServer) (int64, error) {
+			reader := &baselineUploadServerReader{server: server, sizeLimit: size}
+			return io.Copy(discard, reader)
+		}},
+		{name: "Read", read: func(server uploadServer) (int64, error) {
+			// hides WriteTo.
+			reader := struct{ io.Reader }{newUploadServerReader(server, size)}
+			return io.Copy(discard, reader)
+		}},
+		{name: "WriteTo", read: func(server uploadServer) (int64, error) {
+			return io.Copy(discard, newUploadServerReader(server, size))
+		}},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			queue := make([]*httpbody.HttpBody, len(messages))
+			for j := range messages {
+				queue[j] = &httpbody.HttpBody{Data: messages[j]}
+			}
+			server := &fakeUploadServer{}
+			b.SetBytes(size)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				server.messages = queue
+				if n, err := bm.read(server); n != size || err != nil {
+					b.Fatalf("got %d, %v, want %d, nil", n, err, size)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package gatewayfile
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// sidecarAlgorithms are the extensions of the checksum sidecar files, see WithSidecarChecksums.
+var sidecarAlgorithms = []struct {
+	ext       string
+	algorithm DigestAlgorithm
+}{
+	{".sha256", DigestSHA256},
+	{".sha512", DigestSHA512},
+	{".md5", DigestMD5},
+}
+
+// WithSidecarChecksums makes ServeFile look for checksum sidecar files next to the file, e.g. "app.tar.gz.sha256"
+// and "app.tar.gz.md5" as written by sha256sum and md5sum, and emit them as Repr-Digest and Content-MD5 headers,
+// so pre-hashed artifact repositories are served without hashing on the fly.
+// Sidecars older than the file are ignored, since they're likely stale.
+// Content-MD5 describes the body, so it's emitted for full responses only.
+func WithSidecarChecksums() ServeOption {
+	return func(o *serveOptions) {
+		o.sidecarChecksums = true
+	}
+}
+
+// readSidecarChecksums reads the checksum sidecar files of path, unreadable or malformed ones are ignored.
+func readSidecarChecksums(path string, modTime time.Time) Digests {
+	digests := make(Digests)
+	for _, sidecar := range sidecarAlgorithms {
+		info, err := os.Stat(path + sidecar.ext)
+		if err != nil || info.Size() > 4096 || info.ModTime().Before(modTime) {
+			continue
+		}
+		data, err := os.ReadFile(path + sidecar.ext)
+		if err != nil {
+			continue
+		}
+		// "<hex digest>  <filename>", the filename is optional.
+		fields := bytes.Fields(data)
+		if len(fields) == 0 {
+			continue
+		}
+		if sum, err := hex.DecodeString(string(fields[0])); err == nil && len(sum) == sidecar.algorithm.New().Size() {
+			digests[sidecar.algorithm] = sum
+		}
+	}
+	return digests
+}
+
+// withReprDigests sets the digests of the whole representation, see WithSidecarChecksums.
+func withReprDigests(digests Digests) ServeOption {
+	return func(o *serveOptions) {
+		o.reprDigests = digests
+	}
+}
+
+// setReprDigests emits the representation digests, and Content-MD5 if full is true.
+func setReprDigests(outgoing metadata.MD, digests Digests, full bool) {
+	if len(digests) == 0 {
+		return
+	}
+	outgoing.Set(headerReprDigest, digests.String())
+	if sum, ok := digests[DigestMD5]; ok && full {
+		outgoing.Set(headerContentMD5, base64.StdEncoding.EncodeToString(sum))
+	}
+}
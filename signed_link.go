@@ -0,0 +1,165 @@
+package gatewayfile
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+var (
+	// ErrInvalidLink is returned for a link token which is malformed or not signed by the LinkSigner.
+	ErrInvalidLink = newError(codes.PermissionDenied, "INVALID_LINK", "invalid link")
+	// ErrLinkExpired is returned for a link used after its expiry, it's answered with 410 Gone.
+	ErrLinkExpired = newError(codes.NotFound, "LINK_EXPIRED", "link expired")
+	// ErrLinkExhausted is returned for a link used more than its maximum number of uses, or revoked.
+	// It's answered with 410 Gone.
+	ErrLinkExhausted = newError(codes.NotFound, "LINK_EXHAUSTED", "link already used")
+)
+
+// Counter is implemented by the stores which increment counters atomically, e.g. memstore and redisstore.
+// Without it, the uses of limited-use links are counted with Get and Set, so concurrent downloads through
+// the same link may exceed its limit.
+type Counter interface {
+	// Incr increments the counter key by one and returns its new value.
+	// A counter which doesn't exist is created at 0 first, and expires after ttl (0 = never).
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+}
+
+// Link is what a link token signed by a LinkSigner gives access to.
+type Link struct {
+	ID      string    `json:"i"`           // ID identifies the link, generated by Sign.
+	Object  string    `json:"o"`           // Object is what the link gives access to, e.g. a path or an object key.
+	Expires time.Time `json:"e"`           // Expires is when the link expires.
+	MaxUses int       `json:"u,omitempty"` // MaxUses is the number of uses allowed, 0 = unlimited, 1 = one-time link.
+}
+
+// linkUsesKeyPrefix prefixes the Store keys of the use counters of limited-use links.
+const linkUsesKeyPrefix = "link-uses:"
+
+// LinkSigner signs download links with an HMAC-SHA256 key, so they can be handed out without authentication,
+// e.g. in emails. Links are opaque tokens, passed as a path or query parameter of the download RPC.
+// Limited-use links count their uses in a Store, and are answered with 410 Gone once exhausted:
+//
+//	link, err := signer.Use(server.Context(), req.GetToken())
+//	if err != nil {
+//		return err
+//	}
+//	return gatewayfile.ServeFile(server, "", link.Object)
+type LinkSigner struct {
+	key   []byte
+	store Store
+}
+
+// NewLinkSigner returns a LinkSigner signing with key, which should be at least 32 random bytes.
+// store counts the uses of limited-use links, it may be nil if no link is limited.
+func NewLinkSigner(key []byte, store Store) *LinkSigner {
+	return &LinkSigner{key: key, store: store}
+}
+
+// Sign returns the token of a link to object, valid for ttl and maxUses uses (0 = unlimited).
+func (s *LinkSigner) Sign(object string, ttl time.Duration, maxUses int) (string, error) {
+	if maxUses > 0 && s.store == nil {
+		return "", fmt.Errorf("limited-use links need a store")
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	link := Link{
+		ID:      hex.EncodeToString(id),
+		Object:  object,
+		Expires: time.Now().Add(ttl).Truncate(time.Second),
+		MaxUses: max(maxUses, 0),
+	}
+	payload, err := json.Marshal(link)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(s.sign(encoded)), nil
+}
+
+// Verify returns the link of token if it's signed by s and not expired, without using it.
+func (s *LinkSigner) Verify(token string) (Link, error) {
+	var link Link
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return link, ErrInvalidLink
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, s.sign(encoded)) {
+		return link, ErrInvalidLink
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return link, ErrInvalidLink
+	}
+	if err = json.Unmarshal(payload, &link); err != nil {
+		return link, ErrInvalidLink
+	}
+	if !time.Now().Before(link.Expires) {
+		return link, ErrLinkExpired
+	}
+	return link, nil
+}
+
+// Use verifies token like Verify, and counts one use of a limited-use link.
+// It fails with ErrLinkExhausted once the link was used MaxUses times. Each download counts, including
+// the Range requests resuming an interrupted download, so large files deserve a few uses more.
+func (s *LinkSigner) Use(ctx context.Context, token string) (Link, error) {
+	link, err := s.Verify(token)
+	if err != nil || link.MaxUses == 0 {
+		return link, err
+	}
+	uses, err := s.incr(ctx, linkUsesKeyPrefix+link.ID, time.Until(link.Expires))
+	if err != nil {
+		return link, err
+	}
+	if uses > int64(link.MaxUses) {
+		return link, ErrLinkExhausted
+	}
+	return link, nil
+}
+
+// Revoke invalidates a limited-use link before it expires or is exhausted.
+func (s *LinkSigner) Revoke(ctx context.Context, token string) error {
+	link, err := s.Verify(token)
+	if err != nil {
+		return err
+	}
+	if link.MaxUses == 0 {
+		return fmt.Errorf("only limited-use links can be revoked")
+	}
+	return s.store.Set(ctx, linkUsesKeyPrefix+link.ID, []byte(strconv.Itoa(math.MaxInt32)), time.Until(link.Expires))
+}
+
+func (s *LinkSigner) sign(encoded string) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
+
+// incr increments the counter key, atomically if the store is a Counter.
+func (s *LinkSigner) incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	if counter, ok := s.store.(Counter); ok {
+		return counter.Incr(ctx, key, ttl)
+	}
+	value, _, err := s.store.Get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := strconv.ParseInt(string(value), 10, 64)
+	n++
+	return n, s.store.Set(ctx, key, []byte(strconv.FormatInt(n, 10)), ttl)
+}
@@ -0,0 +1,50 @@
+package gatewayfile
+
+import (
+	"context"
+	"io"
+)
+
+// SoftLimitEvent describes an upload which went past the soft limit of WithSoftSizeLimit.
+type SoftLimitEvent struct {
+	Limit    int64 // Limit is the soft limit.
+	Size     int64 // Size is the size of the body received so far, just past the limit.
+	Expected int64 // Expected is the expected size of the upload, 0 if it's unknown, see WithDiskSpaceCheck.
+}
+
+// WithSoftSizeLimit calls f once when the body of an upload goes past limit bytes, while the upload continues
+// up to the hard limit, the sizeLimit of NewFormData, StreamFormData... past which it fails with
+// ErrSizeLimitExceeded. It's meant to log or count the uploads a lower hard limit would reject, before lowering it.
+// f is called by the goroutine reading the body, it should not block.
+func WithSoftSizeLimit(limit int64, f func(ctx context.Context, event SoftLimitEvent)) FormDataOption {
+	return func(o *formDataOptions) {
+		if limit <= 0 || f == nil {
+			return
+		}
+		o.wrapReaders = append(o.wrapReaders, func(ctx context.Context, r io.Reader) io.Reader {
+			return &softLimitReader{reader: r, ctx: ctx, limit: limit, manifest: o.manifest, f: f}
+		})
+	}
+}
+
+// softLimitReader calls f once more than limit bytes were read.
+type softLimitReader struct {
+	reader   io.Reader
+	ctx      context.Context
+	limit    int64
+	manifest *UploadManifest
+	f        func(ctx context.Context, event SoftLimitEvent)
+
+	read    int64
+	crossed bool
+}
+
+func (r *softLimitReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	if r.read > r.limit && !r.crossed {
+		r.crossed = true
+		r.f(r.ctx, SoftLimitEvent{Limit: r.limit, Size: r.read, Expected: expectedUploadSize(r.ctx, r.manifest)})
+	}
+	return n, err
+}
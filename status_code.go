@@ -0,0 +1,53 @@
+package gatewayfile
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// SetResponseStatus sets the 2xx status code of the response of a unary RPC, e.g. an upload, instead of 200,
+// and its Location header if location isn't empty: 201 Created with the URL of the stored file,
+// or 202 Accepted when the upload is processed asynchronously. Like grpc.SetHeader, it must be called before
+// the handler returns. They're written to the HTTP response by WithFileForwardResponseOption.
+// Downloads use WithStatusCode or DeferredWriter.SetStatusCode instead.
+func SetResponseStatus(ctx context.Context, code int, location string) error {
+	if !isSuccessCode(code) {
+		return fmt.Errorf("invalid success status code %d", code)
+	}
+	md := metadata.Pairs(headerCode, strconv.Itoa(code))
+	if location != "" {
+		md.Set(headerLocation, location)
+	}
+	return grpc.SetHeader(ctx, md)
+}
+
+// writeUnaryHeader writes the upload offset or committed range, location and status code
+// set by the handler of a unary RPC.
+// The other headers are left to the gateway, which already wrote the Content-Type of the marshaled message.
+func writeUnaryHeader(writer http.ResponseWriter, md metadata.MD) error {
+	for _, header := range []string{headerUploadOffset, headerCommittedRange, headerLocation, headerScanStatus} {
+		if v := pick(md, header); v != "" {
+			writer.Header().Set(header, v)
+		}
+	}
+	for _, v := range md.Get(headerScanRejected) {
+		writer.Header().Add(headerScanRejected, v)
+	}
+	if codeStr := pick(md, headerCode); codeStr != "" {
+		code, err := strconv.Atoi(codeStr)
+		if err != nil {
+			return err
+		}
+		writer.WriteHeader(code)
+	}
+	return nil
+}
+
+func isSuccessCode(code int) bool {
+	return code >= http.StatusOK && code < http.StatusMultipleChoices
+}
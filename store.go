@@ -0,0 +1,25 @@
+package gatewayfile
+
+import (
+	"context"
+	"time"
+)
+
+// Store is a key-value store with expiration, for the state which must outlive a request:
+// signed URLs, idempotency keys, upload sessions, quotas, declared upload manifests...
+// Implementations must be safe for concurrent use. The store/memstore package implements it in memory
+// for a single instance, the store/redisstore package on Redis for a fleet.
+type Store interface {
+	// Get returns the value of key, ok is false if the key doesn't exist or expired.
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	// Set sets the value of key, it expires after ttl (0 = never).
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete deletes key, deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// KeyLister is implemented by the stores which list their keys, e.g. memstore and redisstore. See Retention.
+type KeyLister interface {
+	// Keys returns the keys starting with prefix which didn't expire, in no particular order.
+	Keys(ctx context.Context, prefix string) ([]string, error)
+}
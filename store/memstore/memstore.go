@@ -0,0 +1,128 @@
+// Package memstore implements gatewayfile.Store in memory, for a single instance or for tests.
+package memstore
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gatewayfile "github.com/black-06/grpc-gateway-file"
+)
+
+// sweepInterval is the minimum interval between two sweeps of the expired keys.
+const sweepInterval = time.Minute
+
+// Store is an in-memory gatewayfile.Store. Expired keys are dropped lazily, and swept on Set.
+type Store struct {
+	mu        sync.Mutex
+	entries   map[string]entry
+	lastSweep time.Time
+}
+
+type entry struct {
+	value   []byte
+	expires time.Time // zero = never
+}
+
+func (e entry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+var (
+	_ gatewayfile.Store     = (*Store)(nil)
+	_ gatewayfile.Counter   = (*Store)(nil)
+	_ gatewayfile.KeyLister = (*Store)(nil)
+)
+
+// New returns a new empty Store.
+func New() *Store {
+	return &Store{entries: make(map[string]entry)}
+}
+
+// Get returns the value of key, ok is false if the key doesn't exist or expired.
+func (s *Store) Get(_ context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if e.expired(time.Now()) {
+		delete(s.entries, key)
+		return nil, false, nil
+	}
+	return append([]byte(nil), e.value...), true, nil
+}
+
+// Set sets the value of key, it expires after ttl (0 = never).
+func (s *Store) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	e := entry{value: append([]byte(nil), value...)}
+	if ttl > 0 {
+		e.expires = now.Add(ttl)
+	}
+	s.entries[key] = e
+
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		s.lastSweep = now
+		for k, e := range s.entries {
+			if e.expired(now) {
+				delete(s.entries, k)
+			}
+		}
+	}
+	return nil
+}
+
+// Delete deletes key.
+func (s *Store) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+// Incr increments the counter key by one and returns its new value, a new counter expires after ttl (0 = never).
+func (s *Store) Incr(_ context.Context, key string, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	e, ok := s.entries[key]
+	if !ok || e.expired(now) {
+		e = entry{value: []byte("0")}
+		if ttl > 0 {
+			e.expires = now.Add(ttl)
+		}
+	}
+	n, err := strconv.ParseInt(string(e.value), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	n++
+	e.value = []byte(strconv.FormatInt(n, 10))
+	s.entries[key] = e
+	return n, nil
+}
+
+// Keys returns the keys starting with prefix which didn't expire, in no particular order.
+func (s *Store) Keys(_ context.Context, prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var keys []string
+	for key, e := range s.entries {
+		if strings.HasPrefix(key, prefix) && !e.expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
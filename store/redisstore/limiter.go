@@ -0,0 +1,188 @@
+package redisstore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	gatewayfile "github.com/black-06/grpc-gateway-file"
+)
+
+// limiterScript is a token bucket holding up to burst tokens, refilled at rate tokens per second.
+// Tokens are borrowed: the bucket goes negative, and the reply is how long the caller waits in microseconds
+// until the debt is paid back, so the callers of all instances are served in order.
+// The clock is the one of Redis, so the instances don't need synchronized clocks.
+const limiterScript = `
+local rate, burst, n = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local t = redis.call('TIME')
+local now = t[1] * 1000000 + t[2]
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens, ts = tonumber(state[1]) or burst, tonumber(state[2]) or now
+tokens = math.min(burst, tokens + (now - ts) * rate / 1000000) - n
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) * 1000 / rate) + 1000)
+if tokens >= 0 then
+	return 0
+end
+return math.ceil(-tokens * 1000000 / rate)
+`
+
+// Limiter is a gatewayfile.Limiter on Redis, so a bandwidth cap holds across all the instances of a fleet,
+// e.g. with gatewayfile.WithRateLimit. Each wait is a round trip to Redis, the reads and writes are split
+// into chunks of at most burst bytes.
+type Limiter struct {
+	client Doer
+	key    string
+	rate   int64
+	burst  int64
+}
+
+var _ gatewayfile.Limiter = (*Limiter)(nil)
+
+// NewLimiter returns a new Limiter of rate bytes per second shared by all the instances using key,
+// burst is the maximum number of bytes granted at once, defaults to rate.
+func NewLimiter(client Doer, key string, rate, burst int64) *Limiter {
+	if burst <= 0 {
+		burst = rate
+	}
+	return &Limiter{client: client, key: key, rate: max(rate, 1), burst: max(burst, 1)}
+}
+
+// WaitN blocks until n tokens are available, or ctx is done.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	reply, err := l.client.Do(ctx, "EVAL", limiterScript, 1, l.key, l.rate, l.burst, n)
+	if err != nil {
+		return err
+	}
+	wait, err := integer(reply)
+	if err != nil || wait <= 0 {
+		return err
+	}
+	timer := time.NewTimer(time.Duration(wait) * time.Microsecond)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Burst returns the maximum number of bytes granted at once.
+func (l *Limiter) Burst() int {
+	return int(l.burst)
+}
+
+// semaphoreScript acquires a slot of a sorted set of leases scored by their expiry in milliseconds,
+// after removing the expired ones. It replies 1 if the slot was acquired, 0 if they're all taken.
+const semaphoreScript = `
+local max, lease, id = tonumber(ARGV[1]), tonumber(ARGV[2]), ARGV[3]
+local t = redis.call('TIME')
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+if redis.call('ZSCORE', KEYS[1], id) or redis.call('ZCARD', KEYS[1]) < max then
+	redis.call('ZADD', KEYS[1], now + lease, id)
+	redis.call('PEXPIRE', KEYS[1], lease)
+	return 1
+end
+return 0
+`
+
+const (
+	defaultSemaphoreLease = 30 * time.Second
+	semaphorePoll         = 100 * time.Millisecond
+)
+
+// SemaphoreConfig is the configuration of a Semaphore.
+type SemaphoreConfig struct {
+	// MaxConcurrent is the maximum number of concurrent transfers of all the instances.
+	MaxConcurrent int
+	// MaxWait is the maximum time a transfer waits for a slot, polling Redis. 0 rejects it immediately.
+	MaxWait time.Duration
+	// Lease is how long a slot is held without being renewed, defaults to 30s. Slots are renewed while
+	// they're held, so the slots of a crashed instance are released after Lease.
+	Lease time.Duration
+}
+
+// Semaphore is a gatewayfile.Semaphore on Redis, so a concurrency cap holds across all the instances of a fleet,
+// see gatewayfile.WithSemaphore. It's answered with 503 Service Unavailable when it's saturated.
+type Semaphore struct {
+	client Doer
+	key    string
+	cfg    SemaphoreConfig
+}
+
+var _ gatewayfile.Semaphore = (*Semaphore)(nil)
+
+// NewSemaphore returns a new Semaphore shared by all the instances using key.
+func NewSemaphore(client Doer, key string, cfg SemaphoreConfig) *Semaphore {
+	if cfg.Lease <= 0 {
+		cfg.Lease = defaultSemaphoreLease
+	}
+	return &Semaphore{client: client, key: key, cfg: cfg}
+}
+
+// Acquire waits for a slot and returns the function releasing it.
+// It returns gatewayfile.ErrOverloaded if no slot is available within MaxWait, or the error of ctx if it's done.
+func (s *Semaphore) Acquire(ctx context.Context) (release func(), err error) {
+	var buf [16]byte
+	_, _ = rand.Read(buf[:])
+	id := hex.EncodeToString(buf[:])
+
+	deadline := time.Now().Add(s.cfg.MaxWait)
+	for {
+		ok, err := s.acquire(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			break
+		}
+		if !time.Now().Before(deadline) {
+			return nil, gatewayfile.ErrOverloaded
+		}
+		timer := time.NewTimer(min(semaphorePoll, time.Until(deadline)))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+
+	// renews the lease until released, the release isn't bound to the context of the transfer.
+	renewCtx, stop := context.WithCancel(context.WithoutCancel(ctx))
+	go func() {
+		ticker := time.NewTicker(s.cfg.Lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_, _ = s.acquire(renewCtx, id)
+			case <-renewCtx.Done():
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			stop()
+			_, _ = s.client.Do(context.WithoutCancel(ctx), "ZREM", s.key, id)
+		})
+	}, nil
+}
+
+// acquire acquires or renews the slot id.
+func (s *Semaphore) acquire(ctx context.Context, id string) (bool, error) {
+	reply, err := s.client.Do(
+		ctx, "EVAL", semaphoreScript, 1, s.key, s.cfg.MaxConcurrent, s.cfg.Lease.Milliseconds(), id,
+	)
+	if err != nil {
+		return false, err
+	}
+	acquired, err := integer(reply)
+	return acquired == 1, err
+}
@@ -0,0 +1,163 @@
+// Package redisstore implements gatewayfile.Store, gatewayfile.Limiter and gatewayfile.Semaphore on Redis,
+// so the state and the limits are shared by all instances of a fleet.
+//
+// It doesn't depend on a Redis client library, any client can be plugged in through Doer. E.g. with go-redis:
+//
+//	store := redisstore.New(redisstore.DoerFunc(func(ctx context.Context, args ...any) (any, error) {
+//		v, err := rdb.Do(ctx, args...).Result()
+//		if errors.Is(err, redis.Nil) {
+//			return nil, nil
+//		}
+//		return v, err
+//	}), "gatewayfile:")
+package redisstore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	gatewayfile "github.com/black-06/grpc-gateway-file"
+)
+
+// Doer executes a Redis command, e.g. Do(ctx, "GET", "key").
+// A nil reply, e.g. GET of a missing key, must be returned as a nil value and a nil error.
+type Doer interface {
+	Do(ctx context.Context, args ...any) (any, error)
+}
+
+// DoerFunc is an adapter to allow the use of ordinary functions as Doer.
+type DoerFunc func(ctx context.Context, args ...any) (any, error)
+
+// Do calls f(ctx, args...).
+func (f DoerFunc) Do(ctx context.Context, args ...any) (any, error) {
+	return f(ctx, args...)
+}
+
+// Store is a gatewayfile.Store on Redis.
+type Store struct {
+	client Doer
+	prefix string
+}
+
+var (
+	_ gatewayfile.Store     = (*Store)(nil)
+	_ gatewayfile.Counter   = (*Store)(nil)
+	_ gatewayfile.KeyLister = (*Store)(nil)
+)
+
+// New returns a new Store, prefix is prepended to all keys.
+func New(client Doer, prefix string) *Store {
+	return &Store{client: client, prefix: prefix}
+}
+
+// Get returns the value of key, ok is false if the key doesn't exist or expired.
+func (s *Store) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	reply, err := s.client.Do(ctx, "GET", s.prefix+key)
+	if err != nil {
+		return nil, false, err
+	}
+	switch v := reply.(type) {
+	case nil:
+		return nil, false, nil
+	case []byte:
+		return v, true, nil
+	case string:
+		return []byte(v), true, nil
+	default:
+		return nil, false, fmt.Errorf("unexpected reply type %T", reply)
+	}
+}
+
+// Set sets the value of key, it expires after ttl (0 = never).
+func (s *Store) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	args := []any{"SET", s.prefix + key, value}
+	if ttl > 0 {
+		args = append(args, "PX", max(ttl.Milliseconds(), 1))
+	}
+	_, err := s.client.Do(ctx, args...)
+	return err
+}
+
+// Delete deletes key.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	_, err := s.client.Do(ctx, "DEL", s.prefix+key)
+	return err
+}
+
+// Incr increments the counter key by one and returns its new value, a new counter expires after ttl (0 = never).
+func (s *Store) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	if ttl > 0 {
+		// creates the counter with its expiry, INCR keeps it.
+		if _, err := s.client.Do(ctx, "SET", s.prefix+key, 0, "PX", max(ttl.Milliseconds(), 1), "NX"); err != nil {
+			return 0, err
+		}
+	}
+	reply, err := s.client.Do(ctx, "INCR", s.prefix+key)
+	if err != nil {
+		return 0, err
+	}
+	return integer(reply)
+}
+
+// Keys returns the keys starting with prefix which didn't expire, in no particular order.
+// It iterates with SCAN, so it doesn't block Redis, but a key set or deleted meanwhile may be missed.
+func (s *Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	pattern := globEscaper.Replace(s.prefix+prefix) + "*"
+	var keys []string
+	for cursor := "0"; ; {
+		reply, err := s.client.Do(ctx, "SCAN", cursor, "MATCH", pattern, "COUNT", 1000)
+		if err != nil {
+			return nil, err
+		}
+		page, ok := reply.([]any)
+		if !ok || len(page) != 2 {
+			return nil, fmt.Errorf("unexpected SCAN reply %T", reply)
+		}
+		if cursor, err = bulkString(page[0]); err != nil {
+			return nil, err
+		}
+		names, ok := page[1].([]any)
+		if !ok {
+			return nil, fmt.Errorf("unexpected SCAN reply %T", page[1])
+		}
+		for _, name := range names {
+			key, err := bulkString(name)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, strings.TrimPrefix(key, s.prefix))
+		}
+		if cursor == "0" {
+			return keys, nil
+		}
+	}
+}
+
+// globEscaper escapes the special characters of the glob-style patterns of Redis.
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// bulkString converts a bulk string reply.
+func bulkString(reply any) (string, error) {
+	switch v := reply.(type) {
+	case []byte:
+		return string(v), nil
+	case string:
+		return v, nil
+	default:
+		return "", fmt.Errorf("unexpected reply type %T", reply)
+	}
+}
+
+// integer converts an integer reply.
+func integer(reply any) (int64, error) {
+	switch v := reply.(type) {
+	case int64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	default:
+		return 0, fmt.Errorf("unexpected reply type %T", reply)
+	}
+}
@@ -0,0 +1,70 @@
+package gatewayfile
+
+import (
+	"net/http"
+	"net/textproto"
+	"strconv"
+
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// The status code is committed once the body started, so a download failing mid-body can't change it.
+// Instead, the failure is reported by the X-Stream-Error and X-Stream-Truncated-Length trailers,
+// so clients can distinguish a truncated body from a complete one. They're set as gRPC trailers for gRPC clients,
+// and as HTTP trailers by WithFileForwardResponseOption. HTTP/1.1 clients only receive HTTP trailers when the
+// response is chunked, i.e. has no Content-Length, otherwise the truncation is visible from the short body.
+const (
+	headerStreamError           = "x-stream-error"
+	headerStreamTruncatedLength = "x-stream-truncated-length"
+)
+
+// streamErrorReason is the reason of the ErrorInfo carried by the stream error marker message.
+const streamErrorReason = "STREAM_TRUNCATED"
+
+// sendStreamError reports a mid-stream failure after sent bytes of the body, see headerStreamError.
+// The HTTP trailers are carried to the forward response option by an empty HttpBody marker message,
+// since the gateway doesn't forward the trailers of server streams.
+func sendStreamError(server downloadServer, sent int64, err error) {
+	text := status.Convert(err).Message()
+	length := strconv.FormatInt(sent, 10)
+	server.SetTrailer(metadata.Pairs(headerStreamError, text, headerStreamTruncatedLength, length))
+
+	marker, anyErr := anypb.New(&errdetails.ErrorInfo{
+		Reason: streamErrorReason,
+		Domain: errorDomain,
+		Metadata: map[string]string{
+			headerStreamError:           text,
+			headerStreamTruncatedLength: length,
+		},
+	})
+	if anyErr != nil {
+		return
+	}
+	_ = server.Send(&httpbody.HttpBody{Extensions: []*anypb.Any{marker}})
+}
+
+// setStreamErrorTrailers sets the HTTP trailers of the message if it's a stream error marker.
+func setStreamErrorTrailers(writer http.ResponseWriter, body *httpbody.HttpBody) {
+	for key, value := range streamErrorTrailers(body) {
+		writer.Header().Set(http.TrailerPrefix+textproto.CanonicalMIMEHeaderKey(key), value)
+	}
+}
+
+// streamErrorTrailers returns the trailers carried by the message if it's a stream error marker, nil otherwise.
+func streamErrorTrailers(body *httpbody.HttpBody) map[string]string {
+	if len(body.GetData()) > 0 || len(body.GetExtensions()) != 1 {
+		return nil
+	}
+	var info errdetails.ErrorInfo
+	if err := body.GetExtensions()[0].UnmarshalTo(&info); err != nil {
+		return nil
+	}
+	if info.GetReason() != streamErrorReason || info.GetDomain() != errorDomain {
+		return nil
+	}
+	return info.GetMetadata()
+}
@@ -0,0 +1,31 @@
+//go:build linux
+
+package gatewayfile
+
+import (
+	"errors"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// createTempFile creates an anonymous O_TMPFILE file in dir, or a regular temporary file
+// if the filesystem doesn't support O_TMPFILE.
+func createTempFile(dir string) (file *os.File, anonymous bool, err error) {
+	file, err = os.OpenFile(dir, unix.O_TMPFILE|os.O_RDWR, 0o600)
+	if err == nil {
+		return file, true, nil
+	}
+	if !errors.Is(err, unix.EOPNOTSUPP) && !errors.Is(err, unix.EISDIR) && !errors.Is(err, unix.EINVAL) {
+		return nil, false, err
+	}
+	file, err = os.CreateTemp(dir, "gatewayfile-")
+	return file, false, err
+}
+
+// linkTempFile gives a name to an anonymous file, linkat of /proc/self/fd doesn't need CAP_DAC_READ_SEARCH.
+func linkTempFile(file *os.File, path string) error {
+	procPath := "/proc/self/fd/" + strconv.Itoa(int(file.Fd()))
+	return unix.Linkat(unix.AT_FDCWD, procPath, unix.AT_FDCWD, path, unix.AT_SYMLINK_FOLLOW)
+}
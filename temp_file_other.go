@@ -0,0 +1,17 @@
+//go:build !linux
+
+package gatewayfile
+
+import (
+	"errors"
+	"os"
+)
+
+func createTempFile(dir string) (*os.File, bool, error) {
+	file, err := os.CreateTemp(dir, "gatewayfile-")
+	return file, false, err
+}
+
+func linkTempFile(*os.File, string) error {
+	return errors.New("anonymous files are not supported")
+}
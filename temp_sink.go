@@ -0,0 +1,236 @@
+package gatewayfile
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// TempSink is a PartSink which spills the file parts to temporary files until they're saved.
+// On Linux, they're anonymous O_TMPFILE files: they have no name until Save links them to their path,
+// so a crashed upload never leaves orphaned temporary files, and saving within the filesystem is atomic.
+// Elsewhere, or if the filesystem doesn't support O_TMPFILE, they're regular temporary files.
+// Close must be called once the saved files are saved, to discard the others.
+type TempSink struct {
+	dir   string
+	block cipher.Block // block encrypts the files if not nil, see NewEncryptedTempSink.
+
+	mu    sync.Mutex
+	next  int
+	files map[string]*tempFile
+}
+
+type tempFile struct {
+	*os.File
+	anonymous bool
+	content   io.ReaderAt // content reads the file decrypted.
+}
+
+// NewTempSink returns a new TempSink creating its files in dir, os.TempDir() if empty.
+// dir should be on the filesystem of the saved files, so Save is a link, not a copy.
+func NewTempSink(dir string) *TempSink {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return &TempSink{dir: dir, files: make(map[string]*tempFile)}
+}
+
+// NewEncryptedTempSink returns a TempSink which encrypts its files with AES-CTR, under an ephemeral key which
+// only lives in memory, so sensitive uploads never hit the disk in plaintext, even transiently.
+// Open decrypts them, Save writes them decrypted to their path, always by a copy.
+// NewFormData spills the files of large forms in plaintext unless WithoutDiskSpill, sensitive uploads
+// should be streamed to this sink with StreamFormData instead.
+func NewEncryptedTempSink(dir string) (*TempSink, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	s := NewTempSink(dir)
+	s.block = block
+	return s, nil
+}
+
+// Create creates a temporary file for the part, the destination is an opaque handle for Open and Save.
+func (s *TempSink) Create(context.Context, *multipart.Part) (io.WriteCloser, string, error) {
+	file, anonymous, err := createTempFile(s.dir)
+	if err != nil {
+		return nil, "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next++
+	destination := "temp:" + strconv.Itoa(s.next)
+	temp := &tempFile{File: file, anonymous: anonymous, content: file}
+	s.files[destination] = temp
+	if s.block == nil {
+		// the file stays open until it's saved or discarded.
+		return nopWriteCloser{file}, destination, nil
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err = rand.Read(iv); err != nil {
+		delete(s.files, destination)
+		_ = temp.discard()
+		return nil, "", err
+	}
+	temp.content = &ctrReaderAt{reader: file, block: s.block, iv: iv}
+	return nopWriteCloser{cipher.StreamWriter{S: cipher.NewCTR(s.block, iv), W: file}}, destination, nil
+}
+
+// Remove discards the temporary file.
+func (s *TempSink) Remove(_ context.Context, destination string) error {
+	file, err := s.take(destination)
+	if err != nil {
+		return err
+	}
+	return file.discard()
+}
+
+// Open returns a reader of the temporary file, valid until it's saved or discarded.
+func (s *TempSink) Open(destination string) (*io.SectionReader, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, ok := s.files[destination]
+	if !ok {
+		return nil, fmt.Errorf("unknown destination %s", destination)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return io.NewSectionReader(file.content, 0, info.Size()), nil
+}
+
+// Save saves the temporary file to path, by a link within the filesystem, by a copy otherwise.
+// It never replaces an existing path, it fails with an fs.ErrExist error instead.
+func (s *TempSink) Save(destination, path string) error {
+	file, err := s.take(destination)
+	if err != nil {
+		return err
+	}
+	if s.block != nil {
+		err = copyTempFile(file, path)
+		_ = file.discard()
+		return err
+	}
+	if file.anonymous {
+		err = linkTempFile(file.File, path)
+	} else if err = file.Close(); err == nil {
+		// a link, unlike a rename, doesn't replace an existing path, like the copy.
+		// The temporary name is removed by discard, whether it's saved or not.
+		if err = os.Link(file.Name(), path); err != nil && !errors.Is(err, fs.ErrExist) {
+			// e.g. another filesystem, or one without hard links.
+			var reopened *os.File
+			if reopened, err = os.Open(file.Name()); err == nil {
+				file.File, file.content = reopened, reopened
+				err = copyTempFile(file, path)
+			}
+		}
+	}
+	if errors.Is(err, syscall.EXDEV) {
+		err = copyTempFile(file, path)
+	}
+	_ = file.discard()
+	return err
+}
+
+// Close discards the temporary files which were not saved.
+func (s *TempSink) Close() error {
+	s.mu.Lock()
+	files := s.files
+	s.files = make(map[string]*tempFile)
+	s.mu.Unlock()
+
+	var errs []error
+	for _, file := range files {
+		errs = append(errs, file.discard())
+	}
+	return errors.Join(errs...)
+}
+
+func (s *TempSink) take(destination string) (*tempFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, ok := s.files[destination]
+	if !ok {
+		return nil, fmt.Errorf("unknown destination %s", destination)
+	}
+	delete(s.files, destination)
+	return file, nil
+}
+
+// discard closes the file, and removes it if it has a name.
+func (f *tempFile) discard() error {
+	err := f.Close()
+	if errors.Is(err, os.ErrClosed) {
+		err = nil
+	}
+	if !f.anonymous {
+		if removeErr := os.Remove(f.Name()); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+			err = removeErr
+		}
+	}
+	return err
+}
+
+// copyTempFile copies the content of file to path.
+func copyTempFile(file *tempFile, path string) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	output, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(output, io.NewSectionReader(file.content, 0, info.Size())); err != nil {
+		_ = output.Close()
+		_ = os.Remove(path)
+		return err
+	}
+	return output.Close()
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// ctrReaderAt decrypts an AES-CTR encrypted file at any offset, from the counter of the block of the offset.
+type ctrReaderAt struct {
+	reader io.ReaderAt
+	block  cipher.Block
+	iv     []byte
+}
+
+func (r *ctrReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.reader.ReadAt(p, off)
+	// the counter is the IV incremented as a big-endian integer, like cipher.NewCTR does.
+	counter := make([]byte, aes.BlockSize)
+	copy(counter, r.iv)
+	carry := uint64(off / aes.BlockSize)
+	for i := len(counter) - 1; i >= 0 && carry > 0; i-- {
+		sum := uint64(counter[i]) + carry&0xff
+		counter[i] = byte(sum)
+		carry = carry>>8 + sum>>8
+	}
+	stream := cipher.NewCTR(r.block, counter)
+	skip := make([]byte, off%aes.BlockSize)
+	stream.XORKeyStream(skip, skip)
+	stream.XORKeyStream(p[:n], p[:n])
+	return n, err
+}
@@ -0,0 +1,179 @@
+package gatewayfile
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTempSink(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1000)
+	sinks := []struct {
+		name    string
+		newSink func(dir string) (*TempSink, error)
+	}{
+		{name: "plain", newSink: func(dir string) (*TempSink, error) { return NewTempSink(dir), nil }},
+		{name: "encrypted", newSink: NewEncryptedTempSink},
+	}
+	tests := []struct {
+		name     string
+		existing bool // existing saves to a path which exists.
+		remove   bool // remove removes the file instead of saving it.
+		wantErr  error
+	}{
+		{name: "saved"},
+		{name: "existing path", existing: true, wantErr: fs.ErrExist},
+		{name: "removed", remove: true},
+	}
+	for _, sink := range sinks {
+		for _, tt := range tests {
+			t.Run(sink.name+"/"+tt.name, func(t *testing.T) {
+				tempDir, saveDir := t.TempDir(), t.TempDir()
+				s, err := sink.newSink(tempDir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				w, destination, err := s.Create(context.Background(), nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err = w.Write(content); err != nil {
+					t.Fatal(err)
+				}
+				_ = w.Close()
+
+				r, err := s.Open(destination)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, content) {
+					t.Fatalf("Open read %d bytes, %v, want the content", len(got), err)
+				}
+				stored := make([]byte, len(content))
+				if _, err = s.files[destination].File.ReadAt(stored, 0); err != nil {
+					t.Fatal(err)
+				}
+				if encrypted := s.block != nil; bytes.Equal(stored, content) == encrypted {
+					t.Fatalf("the file is stored in plaintext: %v, want %v", !encrypted, encrypted)
+				}
+
+				path := filepath.Join(saveDir, "saved.bin")
+				if tt.existing {
+					if err = os.WriteFile(path, []byte("old"), 0o644); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if tt.remove {
+					err = s.Remove(context.Background(), destination)
+				} else {
+					err = s.Save(destination, path)
+				}
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+				want := content
+				switch {
+				case tt.existing:
+					want = []byte("old")
+				case tt.remove:
+					want = nil
+				}
+				got, err := os.ReadFile(path)
+				if !bytes.Equal(got, want) || (want == nil) != errors.Is(err, fs.ErrNotExist) {
+					t.Fatalf("%s has %d bytes, %v, want %d bytes", path, len(got), err, len(want))
+				}
+				if _, err = s.Open(destination); err == nil {
+					t.Fatal("the file can be opened once saved or removed")
+				}
+
+				if err = s.Close(); err != nil {
+					t.Fatal(err)
+				}
+				if entries, _ := os.ReadDir(tempDir); len(entries) > 0 {
+					t.Fatalf("%d temporary files left", len(entries))
+				}
+			})
+		}
+	}
+}
+
+func TestTempSinkCloseDiscards(t *testing.T) {
+	dir := t.TempDir()
+	s := NewTempSink(dir)
+	for i := 0; i < 3; i++ {
+		w, _, err := s.Create(context.Background(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write([]byte("data"))
+		_ = w.Close()
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) > 0 {
+		t.Fatalf("%d temporary files left", len(entries))
+	}
+	if len(s.files) > 0 {
+		t.Fatalf("%d files still tracked", len(s.files))
+	}
+}
+
+func TestCTRReaderAt(t *testing.T) {
+	block, err := aes.NewCipher(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := make([]byte, 5000)
+	for i := range plaintext {
+		plaintext[i] = byte(i * 7)
+	}
+	ivs := []struct {
+		name string
+		iv   []byte
+	}{
+		{name: "zero", iv: make([]byte, aes.BlockSize)},
+		// the counter carries across bytes, and wraps around like cipher.NewCTR.
+		{name: "carry", iv: append(bytes.Repeat([]byte{1}, 8), bytes.Repeat([]byte{0xff}, 8)...)},
+		{name: "wrap", iv: bytes.Repeat([]byte{0xff}, aes.BlockSize)},
+	}
+	tests := []struct {
+		off    int64
+		length int
+		wantN  int
+	}{
+		{off: 0, length: 5000, wantN: 5000},
+		{off: 1, length: 10, wantN: 10},
+		{off: 15, length: 2, wantN: 2},
+		{off: 16, length: 16, wantN: 16},
+		{off: 17, length: 100, wantN: 100},
+		{off: 4095, length: 300, wantN: 300},
+		{off: 4990, length: 20, wantN: 10},
+		{off: 5000, length: 1, wantN: 0},
+	}
+	for _, iv := range ivs {
+		ciphertext := make([]byte, len(plaintext))
+		cipher.NewCTR(block, iv.iv).XORKeyStream(ciphertext, plaintext)
+		r := &ctrReaderAt{reader: bytes.NewReader(ciphertext), block: block, iv: iv.iv}
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s/%d+%d", iv.name, tt.off, tt.length), func(t *testing.T) {
+				p := make([]byte, tt.length)
+				n, err := r.ReadAt(p, tt.off)
+				if n != tt.wantN || (n < tt.length) != (err == io.EOF) {
+					t.Fatalf("got %d, %v, want %d", n, err, tt.wantN)
+				}
+				if want := plaintext[tt.off : tt.off+int64(n)]; !bytes.Equal(p[:n], want) {
+					t.Fatalf("got %x, want %x", p[:n], want)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package gatewayfile
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"google.golang.org/grpc/codes"
+)
+
+// ErrUploadTooSlow is returned when an upload is slower than the floor of WithMinUploadRate.
+// It's answered with 408 Request Timeout, see HTTPErrorHandler.
+var ErrUploadTooSlow = newError(codes.DeadlineExceeded, "UPLOAD_TOO_SLOW", "upload too slow")
+
+// ErrClientStalled is returned when a download is aborted because the client doesn't read it fast enough,
+// see WithSendTimeout and WithMinDownloadRate.
+var ErrClientStalled = newError(codes.DeadlineExceeded, "CLIENT_STALLED", "client stalled")
+
+// WithMinUploadRate aborts the upload with ErrUploadTooSlow when less than bytesPerSecond were received
+// during a grace period, e.g. a trickling (slowloris) client, instead of holding a goroutine and temporary files
+// for as long as the client wants. The rate is measured over consecutive grace periods, from the first read,
+// and the upload is aborted when the period elapses, even if a read is blocked waiting for the client.
+func WithMinUploadRate(bytesPerSecond int64, grace time.Duration) FormDataOption {
+	return func(o *formDataOptions) {
+		if bytesPerSecond <= 0 || grace <= 0 {
+			return
+		}
+		o.wrapReaders = append(o.wrapReaders, func(_ context.Context, r io.Reader) io.Reader {
+			return &minRateReader{
+				reader:  r,
+				minimum: int64(float64(bytesPerSecond) * grace.Seconds()),
+				grace:   grace,
+			}
+		})
+	}
+}
+
+// minRateReader reads in a goroutine, so a read blocked by a slow client can be abandoned.
+// The abandoned read returns when the handler returns and the stream is canceled.
+type minRateReader struct {
+	reader  io.Reader
+	minimum int64 // minimum bytes per grace period
+	grace   time.Duration
+
+	deadline time.Time // end of the current grace period
+	received int64     // bytes received during the current grace period
+	buf      []byte
+	pending  chan readResult // result of the read in progress, nil if none
+	err      error
+}
+
+type readResult struct {
+	n   int
+	err error
+}
+
+func (r *minRateReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.deadline.IsZero() {
+		r.deadline = time.Now().Add(r.grace)
+	}
+	if r.pending == nil {
+		if cap(r.buf) < len(p) {
+			r.buf = make([]byte, len(p))
+		}
+		buf := r.buf[:len(p)]
+		r.pending = make(chan readResult, 1)
+		go func(pending chan<- readResult) {
+			n, err := r.reader.Read(buf)
+			pending <- readResult{n: n, err: err}
+		}(r.pending)
+	}
+
+	timer := time.NewTimer(time.Until(r.deadline))
+	defer timer.Stop()
+	for {
+		select {
+		case result := <-r.pending:
+			r.pending = nil
+			n := copy(p, r.buf[:result.n])
+			r.received += int64(n)
+			if time.Now().After(r.deadline) {
+				r.err = r.check()
+			}
+			if result.err != nil {
+				r.err = result.err
+			}
+			return n, result.err
+		case <-timer.C:
+			if r.err = r.check(); r.err != nil {
+				// the read in progress is abandoned, it owns buf.
+				return 0, r.err
+			}
+			timer.Reset(time.Until(r.deadline))
+		}
+	}
+}
+
+// check ends the current grace period, it fails if not enough bytes were received.
+func (r *minRateReader) check() error {
+	if r.received < r.minimum {
+		return fmt.Errorf("%w: %d bytes received in %s", ErrUploadTooSlow, r.received, r.grace)
+	}
+	r.received = 0
+	r.deadline = time.Now().Add(r.grace)
+	return nil
+}
+
+// WithSendTimeout aborts the download with ErrClientStalled when sending a chunk of the body blocks longer than
+// timeout, i.e. the client stopped reading and the flow-control window is full. The source is closed when
+// serving returns, and the abort is reported to the onFinish callbacks, e.g. WithAudit, like any failure.
+func WithSendTimeout(timeout time.Duration) ServeOption {
+	return func(o *serveOptions) {
+		o.stall.timeout = timeout
+	}
+}
+
+// WithMinDownloadRate aborts the download with ErrClientStalled when the client reads less than bytesPerSecond.
+// The rate is measured over the time spent waiting for the client, in consecutive periods of grace,
+// so a slow source doesn't count against the client. See WithSendTimeout.
+func WithMinDownloadRate(bytesPerSecond int64, grace time.Duration) ServeOption {
+	return func(o *serveOptions) {
+		if bytesPerSecond <= 0 || grace <= 0 {
+			o.stall.minimum, o.stall.grace = 0, 0
+			return
+		}
+		o.stall.minimum = int64(float64(bytesPerSecond) * grace.Seconds())
+		o.stall.grace = grace
+	}
+}
+
+// stallPolicy is the policy of stallServer, its zero value never aborts.
+type stallPolicy struct {
+	timeout time.Duration // maximum duration of a Send, 0 - unlimited
+	minimum int64         // minimum bytes sent per grace period of blocking
+	grace   time.Duration
+}
+
+func (p stallPolicy) guard(server downloadServer) downloadServer {
+	if p.timeout <= 0 && p.grace <= 0 {
+		return server
+	}
+	return &stallServer{downloadServer: server, policy: p}
+}
+
+// stallServer sends in a goroutine, so a Send blocked by a stalled client can be abandoned.
+// The abandoned Send returns when the handler returns and the stream is canceled.
+type stallServer struct {
+	downloadServer
+	policy stallPolicy
+
+	blocked time.Duration // time spent in Send during the current grace period
+	sent    int64         // bytes sent during the current grace period
+	err     error
+}
+
+func (s *stallServer) Send(body *httpbody.HttpBody) error {
+	if s.err != nil {
+		return s.err
+	}
+	start := time.Now()
+	result := make(chan error, 1)
+	// an abandoned Send may still read the message, while the writer reuses its data (see chunkPool).
+	message := &httpbody.HttpBody{
+		ContentType: body.GetContentType(),
+		Data:        bytes.Clone(body.GetData()),
+		Extensions:  body.GetExtensions(),
+	}
+	go func() { result <- s.downloadServer.Send(message) }()
+
+	var timeout, grace <-chan time.Time
+	if s.policy.timeout > 0 {
+		timer := time.NewTimer(s.policy.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		var graceTimer *time.Timer
+		if s.policy.grace > 0 {
+			graceTimer = time.NewTimer(s.policy.grace - s.blocked - time.Since(start))
+			grace = graceTimer.C
+		}
+		select {
+		case err := <-result:
+			stopTimer(graceTimer)
+			s.account(time.Since(start), int64(len(body.GetData())))
+			return err
+		case <-timeout:
+			stopTimer(graceTimer)
+			s.err = fmt.Errorf("%w: send blocked for %s", ErrClientStalled, s.policy.timeout)
+			return s.err
+		case <-grace:
+			// the period ended while blocked, the bytes of the pending Send don't count.
+			s.account(time.Since(start), 0)
+			if s.err != nil {
+				return s.err
+			}
+			start = time.Now()
+		}
+	}
+}
+
+// account adds a Send to the current grace period, and checks the period if it's over.
+func (s *stallServer) account(elapsed time.Duration, n int64) {
+	if s.policy.grace <= 0 {
+		return
+	}
+	s.blocked += elapsed
+	s.sent += n
+	if s.blocked < s.policy.grace {
+		return
+	}
+	if s.sent < s.policy.minimum {
+		s.err = fmt.Errorf("%w: %d bytes read in %s", ErrClientStalled, s.sent, s.blocked)
+		return
+	}
+	s.blocked, s.sent = 0, 0
+}
+
+func stopTimer(timer *time.Timer) {
+	if timer != nil {
+		timer.Stop()
+	}
+}
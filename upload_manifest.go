@@ -0,0 +1,147 @@
+package gatewayfile
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+// ErrManifestMismatch is returned when the uploaded files don't match the declared UploadManifest:
+// an undeclared file, a size or digest mismatch, or a declared file which was not uploaded.
+var ErrManifestMismatch = newError(codes.InvalidArgument, "MANIFEST_MISMATCH", "upload does not match manifest")
+
+// UploadManifest declares the files an upload is expected to contain, e.g. for multi-part dataset ingestion.
+// Clients usually declare it in a first request, the service stores it, then passes it to WithUploadManifest
+// when the files arrive. It's JSON-encodable, digests are encoded as base64:
+//
+//	{"files": [{"field": "data", "name": "part-0.csv", "size": 1024, "digests": {"sha-256": "X48E9q..."}}]}
+type UploadManifest struct {
+	Files []DeclaredFile `json:"files"`
+}
+
+// DeclaredFile is a file declared by an UploadManifest.
+type DeclaredFile struct {
+	Field   string  `json:"field,omitempty"`   // Field is the form field of the file, any field if empty.
+	Name    string  `json:"name"`              // Name is the file name.
+	Size    int64   `json:"size"`              // Size is the size in bytes, not verified if negative.
+	Digests Digests `json:"digests,omitempty"` // Digests are verified for each algorithm, see DigestAlgorithm.
+}
+
+// WithUploadManifest verifies the uploaded files against the manifest while the form is parsed.
+// The upload is rejected with ErrManifestMismatch as soon as a file is not declared, is larger than declared
+// or has a different digest, and at the end if a declared file is missing. Each declared file matches one upload.
+func WithUploadManifest(manifest UploadManifest) FormDataOption {
+	return func(o *formDataOptions) {
+		o.manifest = &manifest
+	}
+}
+
+// manifestVerifier tracks which declared files were uploaded, a nil verifier accepts everything.
+type manifestVerifier struct {
+	pending []*DeclaredFile
+}
+
+func newManifestVerifier(manifest *UploadManifest) *manifestVerifier {
+	if manifest == nil {
+		return nil
+	}
+	v := &manifestVerifier{pending: make([]*DeclaredFile, len(manifest.Files))}
+	for i := range manifest.Files {
+		v.pending[i] = &manifest.Files[i]
+	}
+	return v
+}
+
+// begin returns the declared file matching the part, and removes it from the pending files.
+func (v *manifestVerifier) begin(part *multipart.Part) (*DeclaredFile, error) {
+	if v == nil {
+		return nil, nil
+	}
+	for i, file := range v.pending {
+		if file.Name == part.FileName() && (file.Field == "" || file.Field == part.FormName()) {
+			v.pending = append(v.pending[:i], v.pending[i+1:]...)
+			return file, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: undeclared file %s in field %s", ErrManifestMismatch, part.FileName(), part.FormName())
+}
+
+// algorithms returns the digest algorithms to compute for the declared file.
+func (v *manifestVerifier) algorithms(declared *DeclaredFile) []DigestAlgorithm {
+	if declared == nil {
+		return nil
+	}
+	algorithms := make([]DigestAlgorithm, 0, len(declared.Digests))
+	for algorithm := range declared.Digests {
+		algorithms = append(algorithms, algorithm)
+	}
+	return algorithms
+}
+
+// limit stops reading one byte past the declared size, so oversized files are rejected without reading them whole.
+func (v *manifestVerifier) limit(declared *DeclaredFile, r io.Reader) io.Reader {
+	if declared == nil || declared.Size < 0 {
+		return r
+	}
+	return io.LimitReader(r, declared.Size+1)
+}
+
+// end verifies the size and digests of the file once it was read.
+func (v *manifestVerifier) end(declared *DeclaredFile, size int64, digests Digests) error {
+	if declared == nil {
+		return nil
+	}
+	if declared.Size >= 0 && size != declared.Size {
+		return fmt.Errorf("%w: file %s is not %d bytes", ErrManifestMismatch, declared.Name, declared.Size)
+	}
+	for algorithm, sum := range declared.Digests {
+		if !bytes.Equal(digests[algorithm], sum) {
+			return fmt.Errorf("%w: file %s has a different %s digest", ErrManifestMismatch, declared.Name, algorithm)
+		}
+	}
+	return nil
+}
+
+// finish verifies that all declared files were uploaded.
+func (v *manifestVerifier) finish() error {
+	if v == nil || len(v.pending) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: missing file %s", ErrManifestMismatch, v.pending[0].Name)
+}
+
+// uploadManifestKeyPrefix prefixes the Store keys of declared manifests.
+const uploadManifestKeyPrefix = "upload-manifest:"
+
+// DeclareUploadManifest stores the manifest declared by a client under id, until it's loaded or ttl expires.
+func DeclareUploadManifest(
+	ctx context.Context, store Store, id string, manifest UploadManifest, ttl time.Duration,
+) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	return store.Set(ctx, uploadManifestKeyPrefix+id, data, ttl)
+}
+
+// LoadUploadManifest loads and deletes the manifest declared under id, so it's used by one upload only.
+// ok is false if no manifest was declared under id, or it expired.
+func LoadUploadManifest(ctx context.Context, store Store, id string) (manifest UploadManifest, ok bool, err error) {
+	data, ok, err := store.Get(ctx, uploadManifestKeyPrefix+id)
+	if err != nil || !ok {
+		return manifest, false, err
+	}
+	if err = store.Delete(ctx, uploadManifestKeyPrefix+id); err != nil {
+		return manifest, false, err
+	}
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		return manifest, false, err
+	}
+	return manifest, true, nil
+}
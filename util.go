@@ -1,5 +1,12 @@
 package gatewayfile
 
+import (
+	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/metadata"
+)
+
 func pick[T any](m map[string][]T, key string) (t T) {
 	if len(m) == 0 {
 		return t
@@ -10,3 +17,10 @@ func pick[T any](m map[string][]T, key string) (t T) {
 	}
 	return values[0]
 }
+
+// pickHeader returns the first value of the request header key from the incoming metadata.
+// The gateway stores the headers matched by WithFileIncomingHeaderMatcher with runtime.MetadataPrefix
+// and lower-cased keys, so the canonical header name can't be used directly.
+func pickHeader(incoming metadata.MD, key string) string {
+	return pick(incoming, strings.ToLower(runtime.MetadataPrefix+key))
+}
@@ -0,0 +1,104 @@
+package gatewayfile
+
+import (
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// zipName is the attachment name of the archives served by ServeZipGlob.
+const zipName = "archive.zip"
+
+// ServeZipGlob streams the files of fsys matching the glob patterns (see fs.Glob) as a zip archive,
+// e.g. for "download all attachments" endpoints. Directories are skipped, files matched by several patterns
+// are archived once, in lexical order. The archive is streamed while it's built, so the response has no
+// Content-Length and doesn't support ranges. It's answered with 404 if no file matches.
+func ServeZipGlob(server downloadServer, fsys fs.FS, patterns []string, opts ...ServeOption) error {
+	o := newServeOptions(server.Context(), opts)
+	return o.serve(server, zipName, func(server downloadServer) error {
+		return serveZipGlob(server, fsys, patterns, o)
+	})
+}
+
+func serveZipGlob(server downloadServer, fsys fs.FS, patterns []string, o *serveOptions) error {
+	var names []string
+	for _, pattern := range patterns {
+		matches, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %s: %w", pattern, err)
+		}
+		names = append(names, matches...)
+	}
+	slices.Sort(names)
+	names = slices.Compact(names)
+
+	files := make([]string, 0, len(names))
+	for _, name := range names {
+		if info, err := fs.Stat(fsys, name); err == nil && info.Mode().IsRegular() {
+			files = append(files, name)
+		}
+	}
+
+	outgoing := make(metadata.MD)
+	for key, values := range o.header {
+		outgoing.Set(key, values...)
+	}
+	if len(files) == 0 {
+		return serveError(server, outgoing, "no file matched", http.StatusNotFound)
+	}
+	setContentType(outgoing, "application/zip")
+	outgoing.Set(headerContentDisposition, contentDisposition("attachment", zipName))
+	outgoing.Set(headerCode, strconv.Itoa(o.okCode()))
+	if err := server.SendHeader(outgoing); err != nil {
+		return err
+	}
+
+	// the zip writer writes headers in small pieces, buffer them into full messages.
+	writer := bufio.NewWriterSize(o.newWriter(server, "application/zip"), defaultBufSize)
+	zw := zip.NewWriter(writer)
+	err := func() error {
+		for _, name := range files {
+			if err := addZipFile(zw, fsys, name); err != nil {
+				return err
+			}
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		return writer.Flush()
+	}()
+	return o.done(server, err)
+}
+
+func addZipFile(zw *zip.Writer, fsys fs.FS, name string) error {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+
+	dst, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, file)
+	return err
+}